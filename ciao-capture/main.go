@@ -0,0 +1,198 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/01org/ciao/ssntp"
+)
+
+var (
+	caCert    = flag.String("cacert", "", "CA certificate")
+	cert      = flag.String("cert", "", "Client certificate")
+	serverURI = flag.String("server", "", "SSNTP server URI to replay the capture against")
+	direction = flag.String("direction", "tx", "Direction of the frames to replay [tx, rx]")
+	speed     = flag.Float64("speed", 0, "Replay speed factor, 0 replays as fast as possible")
+	wait      = flag.Duration("wait", 5*time.Second, "Time to wait for replies once the capture is replayed")
+	output    = flag.String("output", "", "Record the replay session frames to this capture file")
+	peer      = flag.String("peer", "", "Only replay or print the frames exchanged with this peer UUID")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "ciao-capture is a command line tool for SSNTP captures")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Usage:")
+		fmt.Fprintln(os.Stderr, "\tciao-capture [flags] command capture-file")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Where commands are:")
+		fmt.Fprintln(os.Stderr, "\tprint")
+		fmt.Fprintln(os.Stderr, "\treplay")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Flags:")
+		flag.PrintDefaults()
+	}
+}
+
+type replayNotifier struct{}
+
+func (ntf replayNotifier) ConnectNotify() {}
+
+func (ntf replayNotifier) DisconnectNotify() {
+	fmt.Fprintln(os.Stderr, "Disconnected from SSNTP server")
+}
+
+func (ntf replayNotifier) StatusNotify(status ssntp.Status, frame *ssntp.Frame) {}
+
+func (ntf replayNotifier) CommandNotify(command ssntp.Command, frame *ssntp.Frame) {}
+
+func (ntf replayNotifier) EventNotify(event ssntp.Event, frame *ssntp.Frame) {}
+
+func (ntf replayNotifier) ErrorNotify(error ssntp.Error, frame *ssntp.Frame) {}
+
+func readCapture(path string) ([]ssntp.CaptureRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ssntp.ReadCapture(f)
+}
+
+func filterCapture(records []ssntp.CaptureRecord, peer string) []ssntp.CaptureRecord {
+	if peer == "" {
+		return records
+	}
+
+	var filtered []ssntp.CaptureRecord
+	for _, r := range records {
+		if r.Peer == peer {
+			filtered = append(filtered, r)
+		}
+	}
+
+	return filtered
+}
+
+func printCapture(records []ssntp.CaptureRecord) {
+	for _, r := range records {
+		arrow := "<-"
+		if r.Direction == ssntp.CaptureTx {
+			arrow = "->"
+		}
+
+		fmt.Printf("%s %s %s %s (%s) %s\n", r.Timestamp.Format(time.RFC3339Nano),
+			r.UUID, arrow, r.Peer, r.PeerRole, r.Name)
+
+		if r.Origin != r.UUID && r.Origin != r.Peer {
+			fmt.Printf("\tOrigin: %s\n", r.Origin)
+		}
+
+		if r.CorrelationID != 0 {
+			fmt.Printf("\tCorrelation ID: %d\n", r.CorrelationID)
+		}
+
+		if r.Payload != "" {
+			payload := strings.TrimRight(r.Payload, "\n")
+			fmt.Printf("\t%s\n", strings.Replace(payload, "\n", "\n\t", -1))
+		}
+	}
+}
+
+func replayCapture(records []ssntp.CaptureRecord) error {
+	var capture io.Writer
+
+	if *serverURI == "" {
+		return fmt.Errorf("Missing SSNTP server URI")
+	}
+
+	if *direction != string(ssntp.CaptureTx) && *direction != string(ssntp.CaptureRx) {
+		return fmt.Errorf("Invalid direction %s", *direction)
+	}
+
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		capture = f
+	}
+
+	config := &ssntp.Config{
+		URI:     *serverURI,
+		CAcert:  *caCert,
+		Cert:    *cert,
+		Capture: capture,
+	}
+
+	var client ssntp.Client
+	err := client.Dial(config, replayNotifier{})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	err = client.Replay(records, ssntp.CaptureDirection(*direction), *speed)
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(*wait)
+
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	if len(flag.Args()) != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	records, err := readCapture(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read capture: %s\n", err)
+		os.Exit(1)
+	}
+
+	records = filterCapture(records, *peer)
+
+	switch flag.Arg(0) {
+	case "print":
+		printCapture(records)
+	case "replay":
+		err = replayCapture(records)
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
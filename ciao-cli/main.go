@@ -502,7 +502,7 @@ func createTenantInstance(tenant string, workload string, instances int, label s
 	var server payloads.ComputeCreateServer
 	var servers payloads.ComputeServers
 
-	server.Server.Name = label
+	server.Server.TraceLabel = label
 	server.Server.Workload = workload
 	server.Server.MaxInstances = instances
 	server.Server.MinInstances = 1
@@ -661,9 +661,9 @@ func startStopInstance(tenant, instance string, stop bool) {
 		fatalf("Missing required -instance parameter")
 	}
 
-	actionBytes := []byte(osStart)
+	actionBytes := []byte(`{"` + osStart + `":null}`)
 	if stop == true {
-		actionBytes = []byte(osStop)
+		actionBytes = []byte(`{"` + osStop + `":null}`)
 	}
 
 	body := bytes.NewReader(actionBytes)
@@ -22,6 +22,7 @@ import (
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
 	"github.com/golang/glog"
+	netcontext "golang.org/x/net/context"
 	"gopkg.in/yaml.v2"
 )
 
@@ -92,6 +93,13 @@ func (client *ssntpClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 		}
 		client.context.ds.HandleTraceReport(trace)
 
+		if client.context.spans != nil {
+			err = client.context.spans.Export(trace)
+			if err != nil {
+				glog.Warningf("Unable to export traces: %v", err)
+			}
+		}
+
 	case ssntp.NodeConnected:
 		var nodeConnected payloads.NodeConnected
 		err := yaml.Unmarshal(payload, &nodeConnected)
@@ -145,6 +153,17 @@ func (client *ssntpClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
 			return
 		}
 		client.context.ds.RestartFailure(failure.InstanceUUID, failure.Reason)
+	case ssntp.InstanceActionFailure:
+		var failure payloads.ErrorInstanceActionFailure
+		err := yaml.Unmarshal(payload, &failure)
+		if err != nil {
+			glog.Warning("Error unmarshalling InstanceActionFailure")
+			return
+		}
+		client.context.ds.InstanceActionFailure(failure.InstanceUUID, failure.Action, failure.Reason)
+		if failure.Action == ssntp.RESIZE.String() {
+			client.context.ds.ResizeFailure(failure.InstanceUUID)
+		}
 	}
 	glog.V(1).Info(string(payload))
 }
@@ -156,7 +175,7 @@ func newSSNTPClient(context *controller, config *ssntp.Config) (*ssntpClient, er
 	return client, err
 }
 
-func (client *ssntpClient) StartTracedWorkload(config string, startTime time.Time, label string) error {
+func (client *ssntpClient) StartTracedWorkload(ctx netcontext.Context, config string, startTime time.Time, label string) error {
 	glog.V(1).Info("START TRACED config:")
 	glog.V(1).Info(config)
 
@@ -166,21 +185,21 @@ func (client *ssntpClient) StartTracedWorkload(config string, startTime time.Tim
 		Label:     []byte(label),
 	}
 
-	_, err := client.ssntp.SendTracedCommand(ssntp.START, []byte(config), traceConfig)
+	_, err := client.ssntp.SendTracedCommandContext(ctx, ssntp.START, []byte(config), traceConfig)
 
 	return err
 }
 
-func (client *ssntpClient) StartWorkload(config string) error {
+func (client *ssntpClient) StartWorkload(ctx netcontext.Context, config string) error {
 	glog.V(1).Info("START config:")
 	glog.V(1).Info(config)
 
-	_, err := client.ssntp.SendCommand(ssntp.START, []byte(config))
+	_, err := client.ssntp.SendCommandContext(ctx, ssntp.START, []byte(config))
 
 	return err
 }
 
-func (client *ssntpClient) DeleteInstance(instanceID string, nodeID string) error {
+func (client *ssntpClient) DeleteInstance(ctx netcontext.Context, instanceID string, nodeID string) error {
 	stopCmd := payloads.StopCmd{
 		InstanceUUID:      instanceID,
 		WorkloadAgentUUID: nodeID,
@@ -198,12 +217,12 @@ func (client *ssntpClient) DeleteInstance(instanceID string, nodeID string) erro
 	glog.Info("DELETE instance_id: ", instanceID, "node_id ", nodeID)
 	glog.V(1).Info(string(y))
 
-	_, err = client.ssntp.SendCommand(ssntp.DELETE, y)
+	_, err = client.ssntp.SendCommandContext(ctx, ssntp.DELETE, y)
 
 	return err
 }
 
-func (client *ssntpClient) StopInstance(instanceID string, nodeID string) error {
+func (client *ssntpClient) StopInstance(ctx netcontext.Context, instanceID string, nodeID string) error {
 	stopCmd := payloads.StopCmd{
 		InstanceUUID:      instanceID,
 		WorkloadAgentUUID: nodeID,
@@ -221,12 +240,12 @@ func (client *ssntpClient) StopInstance(instanceID string, nodeID string) error
 	glog.Info("STOP instance_id: ", instanceID, "node_id ", nodeID)
 	glog.V(1).Info(string(y))
 
-	_, err = client.ssntp.SendCommand(ssntp.STOP, y)
+	_, err = client.ssntp.SendCommandContext(ctx, ssntp.STOP, y)
 
 	return err
 }
 
-func (client *ssntpClient) RestartInstance(instanceID string, nodeID string) error {
+func (client *ssntpClient) RestartInstance(ctx netcontext.Context, instanceID string, nodeID string) error {
 	restartCmd := payloads.RestartCmd{
 		InstanceUUID:      instanceID,
 		WorkloadAgentUUID: nodeID,
@@ -244,12 +263,106 @@ func (client *ssntpClient) RestartInstance(instanceID string, nodeID string) err
 	glog.Info("RESTART instance: ", instanceID)
 	glog.V(1).Info(string(y))
 
-	_, err = client.ssntp.SendCommand(ssntp.RESTART, y)
+	_, err = client.ssntp.SendCommandContext(ctx, ssntp.RESTART, y)
 
 	return err
 }
 
-func (client *ssntpClient) EvacuateNode(nodeID string) error {
+func (client *ssntpClient) sendInstanceCommand(ctx netcontext.Context, command ssntp.Command, instanceID string, payload interface{}) error {
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info(command, " instance: ", instanceID)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendCommandContext(ctx, command, y)
+
+	return err
+}
+
+func (client *ssntpClient) RebootInstance(ctx netcontext.Context, instanceID string, nodeID string, rebootType payloads.RebootType) error {
+	payload := payloads.Reboot{
+		Reboot: payloads.RebootCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+			Type:              rebootType,
+		},
+	}
+
+	return client.sendInstanceCommand(ctx, ssntp.REBOOT, instanceID, payload)
+}
+
+func (client *ssntpClient) PauseInstance(ctx netcontext.Context, instanceID string, nodeID string) error {
+	payload := payloads.Pause{
+		Pause: payloads.StopCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+		},
+	}
+
+	return client.sendInstanceCommand(ctx, ssntp.PAUSE, instanceID, payload)
+}
+
+func (client *ssntpClient) UnpauseInstance(ctx netcontext.Context, instanceID string, nodeID string) error {
+	payload := payloads.Unpause{
+		Unpause: payloads.StopCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+		},
+	}
+
+	return client.sendInstanceCommand(ctx, ssntp.UNPAUSE, instanceID, payload)
+}
+
+func (client *ssntpClient) SuspendInstance(ctx netcontext.Context, instanceID string, nodeID string) error {
+	payload := payloads.Suspend{
+		Suspend: payloads.StopCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+		},
+	}
+
+	return client.sendInstanceCommand(ctx, ssntp.SUSPEND, instanceID, payload)
+}
+
+func (client *ssntpClient) ResumeInstance(ctx netcontext.Context, instanceID string, nodeID string) error {
+	payload := payloads.Resume{
+		Resume: payloads.StopCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+		},
+	}
+
+	return client.sendInstanceCommand(ctx, ssntp.RESUME, instanceID, payload)
+}
+
+func (client *ssntpClient) RebuildInstance(ctx netcontext.Context, instanceID string, nodeID string, imageID string) error {
+	payload := payloads.Rebuild{
+		Rebuild: payloads.RebuildCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+			ImageUUID:         imageID,
+		},
+	}
+
+	return client.sendInstanceCommand(ctx, ssntp.REBUILD, instanceID, payload)
+}
+
+func (client *ssntpClient) ResizeInstance(ctx netcontext.Context, instanceID string, nodeID string, resources []payloads.RequestedResource) error {
+	payload := payloads.Resize{
+		Resize: payloads.ResizeCmd{
+			InstanceUUID:       instanceID,
+			WorkloadAgentUUID:  nodeID,
+			RequestedResources: resources,
+		},
+	}
+
+	return client.sendInstanceCommand(ctx, ssntp.RESIZE, instanceID, payload)
+}
+
+func (client *ssntpClient) EvacuateNode(ctx netcontext.Context, nodeID string) error {
 	evacuateCmd := payloads.EvacuateCmd{
 		WorkloadAgentUUID: nodeID,
 	}
@@ -266,7 +379,7 @@ func (client *ssntpClient) EvacuateNode(nodeID string) error {
 	glog.Info("EVACUATE node: ", nodeID)
 	glog.V(1).Info(string(y))
 
-	_, err = client.ssntp.SendCommand(ssntp.EVACUATE, y)
+	_, err = client.ssntp.SendCommandContext(ctx, ssntp.EVACUATE, y)
 
 	return err
 }
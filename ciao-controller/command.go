@@ -22,12 +22,29 @@ import (
 	"time"
 
 	"github.com/01org/ciao/ciao-controller/types"
+	"github.com/01org/ciao/payloads"
 	"github.com/golang/glog"
+	netcontext "golang.org/x/net/context"
 )
 
+// sendTimeout bounds the time spent sending a command to the scheduler.
+const sendTimeout = 30 * time.Second
+
+// send runs an ssntpClient command with a sendTimeout bounded context.
+func (c *controller) send(command func(ctx netcontext.Context) error) {
+	ctx, cancel := netcontext.WithTimeout(netcontext.Background(), sendTimeout)
+	defer cancel()
+
+	if err := command(ctx); err != nil {
+		glog.Warningf("Could not send command to the scheduler: %v", err)
+	}
+}
+
 func (c *controller) evacuateNode(nodeID string) error {
 	// should I bother to see if nodeID is valid?
-	go c.client.EvacuateNode(nodeID)
+	go c.send(func(ctx netcontext.Context) error {
+		return c.client.EvacuateNode(ctx, nodeID)
+	})
 	return nil
 }
 
@@ -47,7 +64,9 @@ func (c *controller) restartInstance(instanceID string) error {
 		return errors.New("You may only restart paused instances")
 	}
 
-	go c.client.RestartInstance(instanceID, i.NodeID)
+	go c.send(func(ctx netcontext.Context) error {
+		return c.client.RestartInstance(ctx, instanceID, i.NodeID)
+	})
 	return nil
 }
 
@@ -66,7 +85,201 @@ func (c *controller) stopInstance(instanceID string) error {
 		return errors.New("You may not stop a pending instance")
 	}
 
-	go c.client.StopInstance(instanceID, i.NodeID)
+	go c.send(func(ctx netcontext.Context) error {
+		return c.client.StopInstance(ctx, instanceID, i.NodeID)
+	})
+	return nil
+}
+
+// actionInstance returns the instance on which an action is requested,
+// provided that it has been assigned to a node and that its current state
+// is one of the states from which the action may be performed.
+func (c *controller) actionInstance(instanceID string, action string, states ...string) (*types.Instance, error) {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if i.NodeID == "" {
+		return nil, errors.New("Instance Not Assigned to Node")
+	}
+
+	for _, state := range states {
+		if i.State == state {
+			return i, nil
+		}
+	}
+
+	return nil, fmt.Errorf("You may not %s a %s instance", action, i.State)
+}
+
+func (c *controller) rebootInstance(instanceID string, rebootType payloads.RebootType) error {
+	states := []string{payloads.Running}
+	if rebootType == payloads.HardReboot {
+		states = append(states, payloads.Paused)
+	}
+
+	i, err := c.actionInstance(instanceID, "reboot", states...)
+	if err != nil {
+		return err
+	}
+
+	err = c.ds.UpdateInstanceState(instanceID, payloads.ComputeStatusRebooting)
+	if err != nil {
+		return err
+	}
+
+	go c.send(func(ctx netcontext.Context) error {
+		return c.client.RebootInstance(ctx, instanceID, i.NodeID, rebootType)
+	})
+	return nil
+}
+
+func (c *controller) pauseInstance(instanceID string) error {
+	i, err := c.actionInstance(instanceID, "pause", payloads.Running)
+	if err != nil {
+		return err
+	}
+
+	err = c.ds.UpdateInstanceState(instanceID, payloads.ComputeStatusPausing)
+	if err != nil {
+		return err
+	}
+
+	go c.send(func(ctx netcontext.Context) error {
+		return c.client.PauseInstance(ctx, instanceID, i.NodeID)
+	})
+	return nil
+}
+
+func (c *controller) unpauseInstance(instanceID string) error {
+	i, err := c.actionInstance(instanceID, "unpause", payloads.Paused)
+	if err != nil {
+		return err
+	}
+
+	err = c.ds.UpdateInstanceState(instanceID, payloads.ComputeStatusUnpausing)
+	if err != nil {
+		return err
+	}
+
+	go c.send(func(ctx netcontext.Context) error {
+		return c.client.UnpauseInstance(ctx, instanceID, i.NodeID)
+	})
+	return nil
+}
+
+func (c *controller) suspendInstance(instanceID string) error {
+	i, err := c.actionInstance(instanceID, "suspend", payloads.Running)
+	if err != nil {
+		return err
+	}
+
+	err = c.ds.UpdateInstanceState(instanceID, payloads.ComputeStatusSuspending)
+	if err != nil {
+		return err
+	}
+
+	go c.send(func(ctx netcontext.Context) error {
+		return c.client.SuspendInstance(ctx, instanceID, i.NodeID)
+	})
+	return nil
+}
+
+func (c *controller) resumeInstance(instanceID string) error {
+	i, err := c.actionInstance(instanceID, "resume", payloads.Suspended)
+	if err != nil {
+		return err
+	}
+
+	err = c.ds.UpdateInstanceState(instanceID, payloads.ComputeStatusResuming)
+	if err != nil {
+		return err
+	}
+
+	go c.send(func(ctx netcontext.Context) error {
+		return c.client.ResumeInstance(ctx, instanceID, i.NodeID)
+	})
+	return nil
+}
+
+func (c *controller) rebuildInstance(instanceID string, imageID string) error {
+	if imageID == "" {
+		return errors.New("Missing image to rebuild from")
+	}
+
+	i, err := c.actionInstance(instanceID, "rebuild", payloads.Running, payloads.Exited)
+	if err != nil {
+		return err
+	}
+
+	err = c.ds.UpdateInstanceState(instanceID, payloads.ComputeStatusRebuilding)
+	if err != nil {
+		return err
+	}
+
+	go c.send(func(ctx netcontext.Context) error {
+		return c.client.RebuildInstance(ctx, instanceID, i.NodeID, imageID)
+	})
+	return nil
+}
+
+func (c *controller) resizeInstance(instanceID string, workloadID string) error {
+	i, err := c.actionInstance(instanceID, "resize", payloads.Running, payloads.Exited)
+	if err != nil {
+		return err
+	}
+
+	if i.WorkloadID == workloadID {
+		return errors.New("Instance already uses this flavor")
+	}
+
+	current, err := c.ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		return err
+	}
+
+	wl, err := c.ds.GetWorkload(workloadID)
+	if err != nil {
+		return err
+	}
+
+	if isCNCIWorkload(wl) || wl.VMType != current.VMType {
+		return errors.New("Instance may not be resized to this flavor")
+	}
+
+	usage := make(map[string]int)
+	for _, r := range wl.Defaults {
+		usage[string(r.Type)] = r.Value
+	}
+
+	tenant, err := c.ds.GetTenant(i.TenantID)
+	if err != nil {
+		return err
+	}
+
+	for _, res := range tenant.Resources {
+		if res.Rtype == 1 {
+			continue
+		}
+		if res.OverLimit(usage[res.Rname] - i.Usage[res.Rname]) {
+			return errors.New("Over Tenant Limits")
+		}
+	}
+
+	err = c.ds.ResizeInstance(instanceID, workloadID, usage)
+	if err != nil {
+		return err
+	}
+
+	err = c.ds.UpdateInstanceState(instanceID, payloads.ComputeStatusResizing)
+	if err != nil {
+		return err
+	}
+
+	go c.send(func(ctx netcontext.Context) error {
+		return c.client.ResizeInstance(ctx, instanceID, i.NodeID, wl.Defaults)
+	})
 	return nil
 }
 
@@ -81,7 +294,9 @@ func (c *controller) deleteInstance(instanceID string) error {
 		return errors.New("Instance Not Assigned to Node")
 	}
 
-	go c.client.DeleteInstance(instanceID, i.NodeID)
+	go c.send(func(ctx netcontext.Context) error {
+		return c.client.DeleteInstance(ctx, instanceID, i.NodeID)
+	})
 	return nil
 }
 
@@ -121,7 +336,8 @@ func (c *controller) confirmTenant(tenantID string) error {
 	return nil
 }
 
-func (c *controller) startWorkload(workloadID string, tenantID string, instances int, trace bool, label string) ([]*types.Instance, error) {
+func (c *controller) startWorkload(workloadID string, tenantID string, instances int, trace bool,
+	label string, name string, publicKey string, metadata map[string]string, tags []string) ([]*types.Instance, error) {
 	var e error
 
 	if instances == 0 {
@@ -144,7 +360,15 @@ func (c *controller) startWorkload(workloadID string, tenantID string, instances
 
 	for i := 0; i < instances; i++ {
 		startTime := time.Now()
-		instance, err := newInstance(c, tenantID, wl)
+
+		// Nova numbers the names of servers created in a single
+		// request.
+		instanceName := name
+		if name != "" && instances > 1 {
+			instanceName = fmt.Sprintf("%s-%d", name, i+1)
+		}
+
+		instance, err := newInstance(c, tenantID, wl, instanceName, publicKey, metadata, tags)
 		if err != nil {
 			glog.V(2).Info("error newInstance")
 			e = err
@@ -164,9 +388,16 @@ func (c *controller) startWorkload(workloadID string, tenantID string, instances
 
 			newInstances = append(newInstances, &instance.Instance)
 			if trace == false {
-				go c.client.StartWorkload(instance.newConfig.config)
+				config := instance.newConfig.config
+				go c.send(func(ctx netcontext.Context) error {
+					return c.client.StartWorkload(ctx, config)
+				})
 			} else {
-				go c.client.StartTracedWorkload(instance.newConfig.config, instance.startTime, label)
+				config := instance.newConfig.config
+				startTime := instance.startTime
+				go c.send(func(ctx netcontext.Context) error {
+					return c.client.StartTracedWorkload(ctx, config, startTime, label)
+				})
 			}
 		} else {
 			instance.Clean()
@@ -193,7 +424,7 @@ func (c *controller) launchCNCI(tenantID string) error {
 
 	c.ds.AddTenantChan(ch, tenantID)
 
-	_, err = c.startWorkload(workloadID, tenantID, 1, false, "")
+	_, err = c.startWorkload(workloadID, tenantID, 1, false, "", "", "", nil, nil)
 	if err != nil {
 		return err
 	}
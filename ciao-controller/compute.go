@@ -18,19 +18,23 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httputil"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	datastore "github.com/01org/ciao/ciao-controller/internal/datastore"
 	"github.com/01org/ciao/ciao-controller/types"
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
+	"github.com/docker/distribution/uuid"
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
 )
@@ -43,14 +47,45 @@ const (
 	computeActionStart action = iota
 	computeActionStop
 	computeActionDelete
+	computeActionReboot
+	computeActionPause
+	computeActionUnpause
+	computeActionSuspend
+	computeActionResume
+	computeActionRebuild
+	computeActionResize
+	computeActionConfirmResize
 )
 
+// serverActions maps the name of each supported server action, i.e., the
+// single member of a servers/{server}/action request body, to its action.
+var serverActions = map[string]action{
+	"os-start":      computeActionStart,
+	"os-stop":       computeActionStop,
+	"reboot":        computeActionReboot,
+	"pause":         computeActionPause,
+	"unpause":       computeActionUnpause,
+	"suspend":       computeActionSuspend,
+	"resume":        computeActionResume,
+	"rebuild":       computeActionRebuild,
+	"resize":        computeActionResize,
+	"confirmResize": computeActionConfirmResize,
+}
+
 type pagerFilterType uint8
 
 const (
 	none           pagerFilterType = 0
 	workloadFilter                 = 0x1
 	statusFilter                   = 0x2
+	nameFilter                     = 0x4
+	tagsFilter                     = 0x8
+)
+
+const (
+	maxMetadataLength = 255
+	maxTagLength      = 60
+	maxServerTags     = 50
 )
 
 type pager interface {
@@ -58,9 +93,18 @@ type pager interface {
 	nextPage(filterType pagerFilterType, filter string, r *http.Request) ([]byte, error)
 }
 
+// serverFilters holds the values of the status, name and tags
+// filters of a servers request.
+type serverFilters struct {
+	status string
+	name   *regexp.Regexp
+	tags   []string
+}
+
 type serverPager struct {
 	context   *controller
 	instances []*types.Instance
+	serverFilters
 }
 
 func dumpRequestBody(r *http.Request, body bool) {
@@ -150,9 +194,68 @@ func (pager *serverPager) getInstances(filterType pagerFilterType, filter string
 }
 
 func (pager *serverPager) filter(filterType pagerFilterType, filter string, instance *types.Instance) bool {
-	switch filterType {
-	case workloadFilter:
-		if instance.WorkloadID != filter {
+	if filterType&workloadFilter != 0 && instance.WorkloadID != filter {
+		return true
+	}
+
+	return !pager.match(filterType, instance)
+}
+
+// parseServerFilters reads the status, name and tags filters from the
+// query of a servers request.  As in Nova, name is a regular expression
+// and an instance must carry every one of a comma separated list of tags.
+func parseServerFilters(r *http.Request) (pagerFilterType, serverFilters, error) {
+	var filters serverFilters
+	filterType := none
+	values := r.URL.Query()
+
+	if status := values.Get("status"); status != "" {
+		filterType |= statusFilter
+		filters.status = status
+	}
+
+	if name := values.Get("name"); name != "" {
+		re, err := regexp.Compile(name)
+		if err != nil {
+			return none, filters, fmt.Errorf("Invalid name filter: %v", err)
+		}
+		filterType |= nameFilter
+		filters.name = re
+	}
+
+	if tags := values.Get("tags"); tags != "" {
+		filterType |= tagsFilter
+		filters.tags = strings.Split(tags, ",")
+	}
+
+	return filterType, filters, nil
+}
+
+// match returns true if an instance passes the status, name and tags
+// filters set in filterType.
+func (filters *serverFilters) match(filterType pagerFilterType, instance *types.Instance) bool {
+	if filterType&statusFilter != 0 && !strings.EqualFold(instance.State, filters.status) {
+		return false
+	}
+
+	if filterType&nameFilter != 0 && !filters.name.MatchString(instance.Name) {
+		return false
+	}
+
+	if filterType&tagsFilter != 0 {
+		for _, tag := range filters.tags {
+			if !hasTag(instance.Tags, tag) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
 			return true
 		}
 	}
@@ -430,6 +533,9 @@ func instanceToServer(context *controller, instance *types.Instance) (payloads.S
 		HostID:   instance.NodeID,
 		ID:       instance.ID,
 		TenantID: instance.TenantID,
+		Name:     instance.Name,
+		Metadata: instance.Metadata,
+		Tags:     instance.Tags,
 		Flavor: payloads.Flavor{
 			ID: instance.WorkloadID,
 		},
@@ -661,6 +767,224 @@ func showFlavorDetails(w http.ResponseWriter, r *http.Request, context *controll
 	w.Write(b)
 }
 
+// flavorResources lists the resource types that may be assigned to a flavor
+var flavorResources = map[payloads.Resource]bool{
+	payloads.VCPUs:       true,
+	payloads.MemMB:       true,
+	payloads.DiskMB:      true,
+	payloads.NetworkNode: true,
+}
+
+func flavorToWorkload(flavor *payloads.CiaoFlavor) (types.Workload, error) {
+	wl := types.Workload{
+		ID:          flavor.ID,
+		Description: flavor.Name,
+		FWType:      flavor.FWType,
+		VMType:      flavor.VMType,
+		ImageID:     flavor.ImageID,
+		ImageName:   flavor.ImageName,
+		Config:      flavor.Config,
+	}
+
+	if wl.Description == "" {
+		return wl, fmt.Errorf("Missing flavor name")
+	}
+
+	switch wl.VMType {
+	case payloads.QEMU:
+		if wl.ImageID == "" {
+			return wl, fmt.Errorf("Missing image_id")
+		}
+	case payloads.Docker:
+		if wl.ImageName == "" {
+			return wl, fmt.Errorf("Missing image_name")
+		}
+	default:
+		return wl, fmt.Errorf("Invalid vm_type %s", wl.VMType)
+	}
+
+	if wl.FWType != "" && wl.FWType != string(payloads.Legacy) && wl.FWType != string(payloads.EFI) {
+		return wl, fmt.Errorf("Invalid fw_type %s", wl.FWType)
+	}
+
+	if strings.TrimSpace(wl.Config) == "" {
+		return wl, fmt.Errorf("Missing cloud-init config")
+	}
+
+	seen := make(map[payloads.Resource]bool)
+	for _, r := range flavor.Resources {
+		if !flavorResources[r.Type] {
+			return wl, fmt.Errorf("Invalid resource type %s", r.Type)
+		}
+
+		if seen[r.Type] {
+			return wl, fmt.Errorf("Duplicate resource type %s", r.Type)
+		}
+		seen[r.Type] = true
+
+		if r.Value < 0 {
+			return wl, fmt.Errorf("Invalid value for resource %s", r.Type)
+		}
+
+		wl.Defaults = append(wl.Defaults, payloads.RequestedResource{
+			Type:      r.Type,
+			Value:     r.Value,
+			Mandatory: true,
+		})
+	}
+
+	if !seen[payloads.VCPUs] || !seen[payloads.MemMB] {
+		return wl, fmt.Errorf("Flavor must specify %s and %s", payloads.VCPUs, payloads.MemMB)
+	}
+
+	return wl, nil
+}
+
+func readFlavorRequest(r *http.Request) (*payloads.CiaoFlavor, error) {
+	var request payloads.CiaoCreateFlavor
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &request.Flavor, nil
+}
+
+func writeFlavorDetails(w http.ResponseWriter, workload *types.Workload, status int) {
+	var flavor payloads.ComputeFlavorDetails
+
+	details, err := buildFlavorDetails(workload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	flavor.Flavor = details
+
+	b, err := json.Marshal(flavor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// workloadErrorStatus returns the HTTP status code for an error returned
+// by the datastore when changing a workload.
+func workloadErrorStatus(err error) int {
+	switch err {
+	case datastore.ErrNoWorkload:
+		return http.StatusNotFound
+	case datastore.ErrWorkloadExists, datastore.ErrWorkloadInUse:
+		return http.StatusConflict
+	case datastore.ErrCNCIWorkload:
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
+
+func createFlavor(w http.ResponseWriter, r *http.Request, context *controller) {
+	dumpRequestBody(r, true)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	flavor, err := readFlavorRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if flavor.ID == "" {
+		flavor.ID = uuid.Generate().String()
+	} else if _, err := uuid.Parse(flavor.ID); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid flavor id %s", flavor.ID), http.StatusBadRequest)
+		return
+	}
+
+	workload, err := flavorToWorkload(flavor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = context.ds.AddWorkload(workload)
+	if err != nil {
+		http.Error(w, err.Error(), workloadErrorStatus(err))
+		return
+	}
+
+	writeFlavorDetails(w, &workload, http.StatusCreated)
+}
+
+func updateFlavor(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	workloadID := vars["flavor"]
+
+	dumpRequestBody(r, true)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	flavor, err := readFlavorRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flavor.ID = workloadID
+
+	workload, err := flavorToWorkload(flavor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = context.ds.UpdateWorkload(workload)
+	if err != nil {
+		http.Error(w, err.Error(), workloadErrorStatus(err))
+		return
+	}
+
+	writeFlavorDetails(w, &workload, http.StatusOK)
+}
+
+func deleteFlavor(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	workloadID := vars["flavor"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	err := context.ds.DeleteWorkload(workloadID)
+	if err != nil {
+		http.Error(w, err.Error(), workloadErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
 const (
 	instances int = 1
 	vcpu          = 2
@@ -827,15 +1151,21 @@ func listServerDetails(w http.ResponseWriter, r *http.Request, context *controll
 
 	sort.Sort(types.SortedInstancesByID(instances))
 
+	filterType, filters, err := parseServerFilters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	pager := serverPager{
-		context:   context,
-		instances: instances,
+		context:       context,
+		instances:     instances,
+		serverFilters: filters,
 	}
 
-	filterType := none
 	filter := ""
 	if workload != "" {
-		filterType = workloadFilter
+		filterType |= workloadFilter
 		filter = workload
 	}
 
@@ -884,13 +1214,42 @@ func createServer(w http.ResponseWriter, r *http.Request, context *controller) {
 		nInstances = server.Server.MinInstances
 	}
 
+	err = validateMetadata(server.Server.Metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = validateTags(server.Server.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	trace := false
-	label := ""
-	if server.Server.Name != "" {
+	label := server.Server.TraceLabel
+	if label != "" {
 		trace = true
-		label = server.Server.Name
 	}
-	instances, err := context.startWorkload(server.Server.Workload, tenant, nInstances, trace, label)
+
+	publicKey := ""
+	if server.Server.KeyName != "" {
+		userID, err := keyPairUser(context, r)
+		if err != nil {
+			http.Error(w, err.Error(), keyPairErrorStatus(err))
+			return
+		}
+
+		kp, err := context.ds.GetKeyPair(tenant, userID, server.Server.KeyName)
+		if err != nil {
+			http.Error(w, "Invalid key_name provided", http.StatusBadRequest)
+			return
+		}
+		publicKey = kp.PublicKey
+	}
+
+	instances, err := context.startWorkload(server.Server.Workload, tenant, nInstances, trace, label,
+		server.Server.Name, publicKey, server.Server.Metadata, server.Server.Tags)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -917,63 +1276,730 @@ func createServer(w http.ResponseWriter, r *http.Request, context *controller) {
 	w.Write(b)
 }
 
-type instanceAction func(string) error
+// errUserIDForbidden is returned when a non admin token names another
+// user in a key pair request.
+var errUserIDForbidden = errors.New("Only administrators may specify user_id")
 
-func tenantServersAction(w http.ResponseWriter, r *http.Request, context *controller) {
-	var servers payloads.CiaoServersAction
-	var actionFunc instanceAction
-	var statusFilter string
+// keyPairErrorStatus returns the HTTP status reporting a key pair error.
+func keyPairErrorStatus(err error) int {
+	switch err {
+	case errUserIDForbidden:
+		return http.StatusForbidden
+	case datastore.ErrNoKeyPair:
+		return http.StatusNotFound
+	case datastore.ErrKeyPairExists:
+		return http.StatusConflict
+	}
 
-	dumpRequestBody(r, true)
+	return http.StatusInternalServerError
+}
+
+// keyPairUser returns the ID of the user whose key pairs a request
+// operates on.  This is the owner of the token, unless an administrator
+// names another user with the user_id query parameter.
+func keyPairUser(context *controller, r *http.Request) (string, error) {
+	userID := r.URL.Query().Get("user_id")
+	if userID != "" {
+		if adminToken(context, r) == false {
+			return "", errUserIDForbidden
+		}
+		return userID, nil
+	}
+
+	token := r.Header.Get("X-Auth-Token")
+	return context.id.tokenUser(token)
+}
+
+func keyPairToPayload(kp *types.KeyPair) payloads.KeyPair {
+	return payloads.KeyPair{
+		Name:        kp.Name,
+		PublicKey:   kp.PublicKey,
+		Fingerprint: kp.Fingerprint,
+		UserID:      kp.UserID,
+		CreatedAt:   kp.CreatedAt,
+	}
+}
+
+func writeKeyPair(w http.ResponseWriter, keyPair payloads.KeyPair) {
+	b, err := json.Marshal(payloads.ComputeKeyPair{KeyPair: keyPair})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func listKeyPairs(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	dumpRequest(r)
 
 	if validateToken(context, r) == false {
 		http.Error(w, "Invalid token", http.StatusInternalServerError)
 		return
 	}
 
-	defer r.Body.Close()
-
-	body, err := ioutil.ReadAll(r.Body)
+	userID, err := keyPairUser(context, r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), keyPairErrorStatus(err))
 		return
 	}
 
-	err = json.Unmarshal(body, &servers)
+	keyPairs, err := context.ds.GetKeyPairs(tenant, userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if servers.Action == "os-start" {
-		actionFunc = context.restartInstance
-		statusFilter = payloads.ComputeStatusStopped
-	} else if servers.Action == "os-stop" {
-		actionFunc = context.stopInstance
-		statusFilter = payloads.ComputeStatusRunning
-	} else if servers.Action == "os-delete" {
-		actionFunc = context.deleteInstance
-		statusFilter = ""
-	} else {
-		http.Error(w, "Unsupported action", http.StatusServiceUnavailable)
-		return
+	response := payloads.ComputeKeyPairs{
+		KeyPairs: []payloads.ComputeKeyPair{},
 	}
 
-	if len(servers.ServerIDs) > 0 {
-		/* TODO Check that instance belongs to the right tenant */
-		for _, instance := range servers.ServerIDs {
-			actionFunc(instance)
+	for _, kp := range keyPairs {
+		keyPair := payloads.KeyPair{
+			Name:        kp.Name,
+			PublicKey:   kp.PublicKey,
+			Fingerprint: kp.Fingerprint,
 		}
-	} else {
-		vars := mux.Vars(r)
-		tenant := vars["tenant"]
+		response.KeyPairs = append(response.KeyPairs, payloads.ComputeKeyPair{KeyPair: keyPair})
+	}
 
-		/* We want to act on all relevant instances */
-		instances, err := context.ds.GetAllInstancesFromTenant(tenant)
-		if err != nil {
-			http.Error(w, "No instances for tenant", http.StatusInternalServerError)
-			return
-		}
+	b, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func createKeyPair(w http.ResponseWriter, r *http.Request, context *controller) {
+	var request payloads.ComputeCreateKeyPair
+	var privateKey string
+
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	dumpRequestBody(r, true)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := request.KeyPair.Name
+	if !keyPairNameRegexp.MatchString(name) {
+		http.Error(w, "Invalid key pair name", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := keyPairUser(context, r)
+	if err != nil {
+		http.Error(w, err.Error(), keyPairErrorStatus(err))
+		return
+	}
+
+	publicKey := strings.TrimSpace(request.KeyPair.PublicKey)
+	if publicKey == "" {
+		privateKey, publicKey, err = generateKeyPair("Generated by ciao")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	blob, err := parsePublicKey(publicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	kp := types.KeyPair{
+		TenantID:    tenant,
+		UserID:      userID,
+		Name:        name,
+		PublicKey:   publicKey,
+		Fingerprint: keyFingerprint(blob),
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	err = context.ds.AddKeyPair(kp)
+	if err != nil {
+		http.Error(w, err.Error(), keyPairErrorStatus(err))
+		return
+	}
+
+	keyPair := keyPairToPayload(&kp)
+	keyPair.PrivateKey = privateKey
+	writeKeyPair(w, keyPair)
+}
+
+func showKeyPair(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	name := vars["keypair"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := keyPairUser(context, r)
+	if err != nil {
+		http.Error(w, err.Error(), keyPairErrorStatus(err))
+		return
+	}
+
+	kp, err := context.ds.GetKeyPair(tenant, userID, name)
+	if err != nil {
+		http.Error(w, err.Error(), keyPairErrorStatus(err))
+		return
+	}
+
+	writeKeyPair(w, keyPairToPayload(kp))
+}
+
+func deleteKeyPair(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	name := vars["keypair"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := keyPairUser(context, r)
+	if err != nil {
+		http.Error(w, err.Error(), keyPairErrorStatus(err))
+		return
+	}
+
+	err = context.ds.DeleteKeyPair(tenant, userID, name)
+	if err != nil {
+		http.Error(w, err.Error(), keyPairErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type instanceAction func(string) error
+
+// validateMetadata checks that the keys and values of server metadata
+// respect the Nova length limits.
+func validateMetadata(metadata map[string]string) error {
+	for key, value := range metadata {
+		if len(key) == 0 || len(key) > maxMetadataLength {
+			return fmt.Errorf("Invalid metadata key %q", key)
+		}
+
+		if len(value) > maxMetadataLength {
+			return fmt.Errorf("Metadata value for %q too long", key)
+		}
+	}
+
+	return nil
+}
+
+func validateTag(tag string) error {
+	if len(tag) == 0 || len(tag) > maxTagLength || strings.ContainsAny(tag, "/,") {
+		return fmt.Errorf("Invalid tag %q", tag)
+	}
+
+	return nil
+}
+
+func validateTags(tags []string) error {
+	if len(tags) > maxServerTags {
+		return fmt.Errorf("Too many tags, the maximum is %d", maxServerTags)
+	}
+
+	for _, tag := range tags {
+		err := validateTag(tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tenantInstance returns the instance named in a request, provided it
+// belongs to the tenant of the request.
+func tenantInstance(context *controller, r *http.Request) (*types.Instance, error) {
+	vars := mux.Vars(r)
+
+	instance, err := context.ds.GetInstance(vars["server"])
+	if err != nil {
+		return nil, err
+	}
+
+	if instance.TenantID != vars["tenant"] {
+		return nil, fmt.Errorf("Instance %s not found", vars["server"])
+	}
+
+	return instance, nil
+}
+
+func writeServerResponse(w http.ResponseWriter, v interface{}, status int) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func listServerMetadata(w http.ResponseWriter, r *http.Request, context *controller) {
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	instance, err := tenantInstance(context, r)
+	if err != nil {
+		http.Error(w, "Instance not available", http.StatusNotFound)
+		return
+	}
+
+	metadata := payloads.ComputeServerMetadata{
+		Metadata: instance.Metadata,
+	}
+
+	writeServerResponse(w, metadata, http.StatusOK)
+}
+
+// setServerMetadata replaces the metadata of an instance, or merges new
+// key/value pairs into it when replace is false.
+func setServerMetadata(w http.ResponseWriter, r *http.Request, context *controller, replace bool) {
+	var req payloads.ComputeServerMetadata
+
+	dumpRequestBody(r, true)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	instance, err := tenantInstance(context, r)
+	if err != nil {
+		http.Error(w, "Instance not available", http.StatusNotFound)
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil || req.Metadata == nil {
+		http.Error(w, "Invalid metadata", http.StatusBadRequest)
+		return
+	}
+
+	err = validateMetadata(req.Metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metadata := req.Metadata
+	if replace {
+		err = context.ds.SetInstanceMetadata(instance.ID, metadata)
+	} else {
+		metadata, err = context.ds.MergeInstanceMetadata(instance.ID, metadata)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeServerResponse(w, payloads.ComputeServerMetadata{Metadata: metadata}, http.StatusOK)
+}
+
+func showServerMetadataItem(w http.ResponseWriter, r *http.Request, context *controller) {
+	key := mux.Vars(r)["key"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	instance, err := tenantInstance(context, r)
+	if err != nil {
+		http.Error(w, "Instance not available", http.StatusNotFound)
+		return
+	}
+
+	value, ok := instance.Metadata[key]
+	if !ok {
+		http.Error(w, "Metadata item not found", http.StatusNotFound)
+		return
+	}
+
+	item := payloads.ComputeServerMetadataItem{
+		Meta: map[string]string{key: value},
+	}
+
+	writeServerResponse(w, item, http.StatusOK)
+}
+
+func setServerMetadataItem(w http.ResponseWriter, r *http.Request, context *controller) {
+	key := mux.Vars(r)["key"]
+	var req payloads.ComputeServerMetadataItem
+
+	dumpRequestBody(r, true)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	instance, err := tenantInstance(context, r)
+	if err != nil {
+		http.Error(w, "Instance not available", http.StatusNotFound)
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil || len(req.Meta) != 1 {
+		http.Error(w, "Invalid metadata item", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := req.Meta[key]; !ok {
+		http.Error(w, "Request body and URI mismatch", http.StatusBadRequest)
+		return
+	}
+
+	err = validateMetadata(req.Meta)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = context.ds.MergeInstanceMetadata(instance.ID, req.Meta)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeServerResponse(w, req, http.StatusOK)
+}
+
+func deleteServerMetadataItem(w http.ResponseWriter, r *http.Request, context *controller) {
+	key := mux.Vars(r)["key"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	instance, err := tenantInstance(context, r)
+	if err != nil {
+		http.Error(w, "Instance not available", http.StatusNotFound)
+		return
+	}
+
+	err = context.ds.DeleteInstanceMetadata(instance.ID, key)
+	if err == datastore.ErrNoInstanceMetadata {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listServerTags(w http.ResponseWriter, r *http.Request, context *controller) {
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	instance, err := tenantInstance(context, r)
+	if err != nil {
+		http.Error(w, "Instance not available", http.StatusNotFound)
+		return
+	}
+
+	writeServerResponse(w, payloads.ComputeServerTags{Tags: instance.Tags}, http.StatusOK)
+}
+
+func replaceServerTags(w http.ResponseWriter, r *http.Request, context *controller) {
+	var req payloads.ComputeServerTags
+
+	dumpRequestBody(r, true)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	instance, err := tenantInstance(context, r)
+	if err != nil {
+		http.Error(w, "Instance not available", http.StatusNotFound)
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil || req.Tags == nil {
+		http.Error(w, "Invalid tags", http.StatusBadRequest)
+		return
+	}
+
+	err = validateTags(req.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = context.ds.SetInstanceTags(instance.ID, req.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	instance, err = context.ds.GetInstance(instance.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeServerResponse(w, payloads.ComputeServerTags{Tags: instance.Tags}, http.StatusOK)
+}
+
+func deleteServerTags(w http.ResponseWriter, r *http.Request, context *controller) {
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	instance, err := tenantInstance(context, r)
+	if err != nil {
+		http.Error(w, "Instance not available", http.StatusNotFound)
+		return
+	}
+
+	err = context.ds.SetInstanceTags(instance.ID, []string{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func checkServerTag(w http.ResponseWriter, r *http.Request, context *controller) {
+	tag := mux.Vars(r)["tag"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	instance, err := tenantInstance(context, r)
+	if err != nil {
+		http.Error(w, "Instance not available", http.StatusNotFound)
+		return
+	}
+
+	if !hasTag(instance.Tags, tag) {
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func addServerTag(w http.ResponseWriter, r *http.Request, context *controller) {
+	tag := mux.Vars(r)["tag"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	instance, err := tenantInstance(context, r)
+	if err != nil {
+		http.Error(w, "Instance not available", http.StatusNotFound)
+		return
+	}
+
+	err = validateTag(tag)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	added, err := context.ds.AddInstanceTag(instance.ID, tag, maxServerTags)
+	if err == datastore.ErrTooManyInstanceTags {
+		http.Error(w, fmt.Sprintf("Too many tags, the maximum is %d", maxServerTags), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !added {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func deleteServerTag(w http.ResponseWriter, r *http.Request, context *controller) {
+	tag := mux.Vars(r)["tag"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	instance, err := tenantInstance(context, r)
+	if err != nil {
+		http.Error(w, "Instance not available", http.StatusNotFound)
+		return
+	}
+
+	err = context.ds.DeleteInstanceTag(instance.ID, tag)
+	if err == datastore.ErrNoInstanceTag {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func tenantServersAction(w http.ResponseWriter, r *http.Request, context *controller) {
+	var servers payloads.CiaoServersAction
+	var actionFunc instanceAction
+	var statusFilter string
+
+	dumpRequestBody(r, true)
+
+	if validateToken(context, r) == false {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = json.Unmarshal(body, &servers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filterType, filters, err := parseServerFilters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if servers.Action == "os-start" {
+		actionFunc = context.restartInstance
+		statusFilter = payloads.ComputeStatusStopped
+	} else if servers.Action == "os-stop" {
+		actionFunc = context.stopInstance
+		statusFilter = payloads.ComputeStatusRunning
+	} else if servers.Action == "os-delete" {
+		actionFunc = context.deleteInstance
+		statusFilter = ""
+	} else {
+		http.Error(w, "Unsupported action", http.StatusServiceUnavailable)
+		return
+	}
+
+	if len(servers.ServerIDs) > 0 {
+		/* TODO Check that instance belongs to the right tenant */
+		for _, instance := range servers.ServerIDs {
+			actionFunc(instance)
+		}
+	} else {
+		vars := mux.Vars(r)
+		tenant := vars["tenant"]
+
+		/* We want to act on all relevant instances */
+		instances, err := context.ds.GetAllInstancesFromTenant(tenant)
+		if err != nil {
+			http.Error(w, "No instances for tenant", http.StatusInternalServerError)
+			return
+		}
 
 		fmt.Printf("Tenant %s has %d instances\n", tenant, len(instances))
 
@@ -982,6 +2008,10 @@ func tenantServersAction(w http.ResponseWriter, r *http.Request, context *contro
 				continue
 			}
 
+			if !filters.match(filterType, instance) {
+				continue
+			}
+
 			fmt.Printf("Action on %s\n", instance.ID)
 			actionFunc(instance.ID)
 		}
@@ -990,11 +2020,41 @@ func tenantServersAction(w http.ResponseWriter, r *http.Request, context *contro
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// parseServerAction returns the name and arguments of a server action
+// request.  Nova requests are JSON objects with a single member named
+// after the action.  Bodies that are not JSON objects are matched against
+// the os-start and os-stop actions, as ciao clients used to send these
+// actions as bare strings.
+func parseServerAction(body []byte) (string, json.RawMessage, error) {
+	var request map[string]json.RawMessage
+
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		bodyString := string(body)
+		if strings.Contains(bodyString, "os-start") {
+			return "os-start", nil, nil
+		} else if strings.Contains(bodyString, "os-stop") {
+			return "os-stop", nil, nil
+		}
+
+		return "", nil, errors.New("Invalid action request")
+	}
+
+	if len(request) != 1 {
+		return "", nil, errors.New("Invalid action request")
+	}
+
+	for name, args := range request {
+		return name, args, nil
+	}
+
+	return "", nil, errors.New("Invalid action request")
+}
+
 func serverAction(w http.ResponseWriter, r *http.Request, context *controller) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
 	instance := vars["server"]
-	var action action
 
 	dumpRequestBody(r, true)
 
@@ -1023,13 +2083,14 @@ func serverAction(w http.ResponseWriter, r *http.Request, context *controller) {
 		return
 	}
 
-	bodyString := string(body)
+	name, args, err := parseServerAction(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	if strings.Contains(bodyString, "os-start") {
-		action = computeActionStart
-	} else if strings.Contains(bodyString, "os-stop") {
-		action = computeActionStop
-	} else {
+	action, ok := serverActions[name]
+	if !ok {
 		http.Error(w, "Unsupported action", http.StatusServiceUnavailable)
 		return
 	}
@@ -1039,9 +2100,53 @@ func serverAction(w http.ResponseWriter, r *http.Request, context *controller) {
 		err = context.restartInstance(instance)
 	case computeActionStop:
 		err = context.stopInstance(instance)
+	case computeActionReboot:
+		var reboot payloads.ServerActionReboot
+		err = json.Unmarshal(args, &reboot)
+		if err != nil {
+			break
+		}
+
+		rebootType := payloads.RebootType(strings.ToLower(reboot.Type))
+		switch rebootType {
+		case payloads.SoftReboot, payloads.HardReboot:
+			err = context.rebootInstance(instance, rebootType)
+		default:
+			http.Error(w, "Invalid reboot type", http.StatusBadRequest)
+			return
+		}
+	case computeActionPause:
+		err = context.pauseInstance(instance)
+	case computeActionUnpause:
+		err = context.unpauseInstance(instance)
+	case computeActionSuspend:
+		err = context.suspendInstance(instance)
+	case computeActionResume:
+		err = context.resumeInstance(instance)
+	case computeActionRebuild:
+		var rebuild payloads.ServerActionRebuild
+		err = json.Unmarshal(args, &rebuild)
+		if err == nil {
+			err = context.rebuildInstance(instance, rebuild.ImageRef)
+		}
+	case computeActionResize:
+		var resize payloads.ServerActionResize
+		err = json.Unmarshal(args, &resize)
+		if err == nil {
+			err = context.resizeInstance(instance, resize.FlavorRef)
+		}
+	case computeActionConfirmResize:
+		err = context.ds.ConfirmResize(instance)
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 	}
 
-	if err != nil {
+	if err == datastore.ErrNoResize {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -1500,6 +2605,82 @@ func createComputeAPI(context *controller) {
 		listServerDetails(w, r, context)
 	}).Methods("GET")
 
+	r.HandleFunc("/v2.1/flavors", func(w http.ResponseWriter, r *http.Request) {
+		createFlavor(w, r, context)
+	}).Methods("POST")
+
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/metadata", func(w http.ResponseWriter, r *http.Request) {
+		listServerMetadata(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/metadata", func(w http.ResponseWriter, r *http.Request) {
+		setServerMetadata(w, r, context, false)
+	}).Methods("POST")
+
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/metadata", func(w http.ResponseWriter, r *http.Request) {
+		setServerMetadata(w, r, context, true)
+	}).Methods("PUT")
+
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/metadata/{key}", func(w http.ResponseWriter, r *http.Request) {
+		showServerMetadataItem(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/metadata/{key}", func(w http.ResponseWriter, r *http.Request) {
+		setServerMetadataItem(w, r, context)
+	}).Methods("PUT")
+
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/metadata/{key}", func(w http.ResponseWriter, r *http.Request) {
+		deleteServerMetadataItem(w, r, context)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/tags", func(w http.ResponseWriter, r *http.Request) {
+		listServerTags(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/tags", func(w http.ResponseWriter, r *http.Request) {
+		replaceServerTags(w, r, context)
+	}).Methods("PUT")
+
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/tags", func(w http.ResponseWriter, r *http.Request) {
+		deleteServerTags(w, r, context)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/tags/{tag}", func(w http.ResponseWriter, r *http.Request) {
+		checkServerTag(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/tags/{tag}", func(w http.ResponseWriter, r *http.Request) {
+		addServerTag(w, r, context)
+	}).Methods("PUT")
+
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/tags/{tag}", func(w http.ResponseWriter, r *http.Request) {
+		deleteServerTag(w, r, context)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/v2.1/{tenant}/os-keypairs", func(w http.ResponseWriter, r *http.Request) {
+		listKeyPairs(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/os-keypairs", func(w http.ResponseWriter, r *http.Request) {
+		createKeyPair(w, r, context)
+	}).Methods("POST")
+
+	r.HandleFunc("/v2.1/{tenant}/os-keypairs/{keypair}", func(w http.ResponseWriter, r *http.Request) {
+		showKeyPair(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/os-keypairs/{keypair}", func(w http.ResponseWriter, r *http.Request) {
+		deleteKeyPair(w, r, context)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/v2.1/flavors/{flavor}", func(w http.ResponseWriter, r *http.Request) {
+		updateFlavor(w, r, context)
+	}).Methods("PUT")
+
+	r.HandleFunc("/v2.1/flavors/{flavor}", func(w http.ResponseWriter, r *http.Request) {
+		deleteFlavor(w, r, context)
+	}).Methods("DELETE")
+
 	r.HandleFunc("/v2.1/tenants", func(w http.ResponseWriter, r *http.Request) {
 		listTenants(w, r, context)
 	}).Methods("GET")
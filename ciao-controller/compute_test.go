@@ -17,6 +17,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -29,6 +30,7 @@ import (
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
 	"github.com/01org/ciao/testutil"
+	"github.com/docker/distribution/uuid"
 )
 
 func testHTTPRequest(t *testing.T, method string, URL string, expectedResponse int, data []byte) []byte {
@@ -336,7 +338,7 @@ func TestServersActionStop(t *testing.T) {
 }
 
 func TestServerActionStop(t *testing.T) {
-	action := "os-stop"
+	action := `{"os-stop":null}`
 
 	tenant, err := context.ds.GetTenant(computeTestUser)
 	if err != nil {
@@ -359,10 +361,13 @@ func TestServerActionStop(t *testing.T) {
 
 	url := computeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
 	_ = testHTTPRequest(t, "POST", url, http.StatusAccepted, []byte(action))
+
+	// bare action bodies sent by older clients are still accepted
+	_ = testHTTPRequest(t, "POST", url, http.StatusAccepted, []byte("os-stop"))
 }
 
 func TestServerActionStart(t *testing.T) {
-	action := "os-start"
+	action := `{"os-start":null}`
 
 	tenant, err := context.ds.GetTenant(computeTestUser)
 	if err != nil {
@@ -412,6 +417,257 @@ func TestServerActionStart(t *testing.T) {
 	_ = testHTTPRequest(t, "POST", url, http.StatusAccepted, []byte(action))
 }
 
+func testServerActionCommand(t *testing.T, tenantID string, serverID string, cmd ssntp.Command, action string) {
+	c := make(chan testutil.CmdResult)
+	server.AddCmdChan(cmd, c)
+
+	url := computeURL + "/v2.1/" + tenantID + "/servers/" + serverID + "/action"
+	_ = testHTTPRequest(t, "POST", url, http.StatusAccepted, []byte(action))
+
+	select {
+	case result := <-c:
+		if result.Err != nil {
+			t.Fatal("Error parsing command yaml")
+		}
+
+		if result.InstanceUUID != serverID {
+			t.Fatal("Did not get correct Instance ID")
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for %s command", cmd)
+	}
+}
+
+func TestServerActionPauseUnpause(t *testing.T) {
+	tenant, err := context.ds.GetTenant(computeTestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(0, ssntp.AGENT)
+	defer client.Ssntp.Close()
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	client.SendStats()
+
+	time.Sleep(1 * time.Second)
+
+	url := computeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
+
+	// a running instance cannot be unpaused
+	_ = testHTTPRequest(t, "POST", url, http.StatusInternalServerError, []byte(`{"unpause":null}`))
+
+	testServerActionCommand(t, tenant.ID, servers.Servers[0].ID, ssntp.PAUSE, `{"pause":null}`)
+
+	i, err := context.ds.GetInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.State != payloads.ComputeStatusPausing {
+		t.Fatalf("expected state %s, got %s", payloads.ComputeStatusPausing, i.State)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	client.SendStats()
+
+	time.Sleep(1 * time.Second)
+
+	i, err = context.ds.GetInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.State != payloads.Paused {
+		t.Fatalf("expected state %s, got %s", payloads.Paused, i.State)
+	}
+
+	testServerActionCommand(t, tenant.ID, servers.Servers[0].ID, ssntp.UNPAUSE, `{"unpause":null}`)
+}
+
+func TestServerActionSuspendResume(t *testing.T) {
+	tenant, err := context.ds.GetTenant(computeTestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(0, ssntp.AGENT)
+	defer client.Ssntp.Close()
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	client.SendStats()
+
+	time.Sleep(1 * time.Second)
+
+	testServerActionCommand(t, tenant.ID, servers.Servers[0].ID, ssntp.SUSPEND, `{"suspend":null}`)
+
+	time.Sleep(1 * time.Second)
+
+	client.SendStats()
+
+	time.Sleep(1 * time.Second)
+
+	testServerActionCommand(t, tenant.ID, servers.Servers[0].ID, ssntp.RESUME, `{"resume":null}`)
+}
+
+func TestServerActionReboot(t *testing.T) {
+	tenant, err := context.ds.GetTenant(computeTestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(0, ssntp.AGENT)
+	defer client.Ssntp.Close()
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	client.SendStats()
+
+	time.Sleep(1 * time.Second)
+
+	url := computeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte(`{"reboot":{"type":"WARM"}}`))
+
+	testServerActionCommand(t, tenant.ID, servers.Servers[0].ID, ssntp.REBOOT, `{"reboot":{"type":"HARD"}}`)
+
+	i, err := context.ds.GetInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.State != payloads.ComputeStatusRebooting {
+		t.Fatalf("expected state %s, got %s", payloads.ComputeStatusRebooting, i.State)
+	}
+}
+
+func TestServerActionRebuild(t *testing.T) {
+	tenant, err := context.ds.GetTenant(computeTestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(0, ssntp.AGENT)
+	defer client.Ssntp.Close()
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	client.SendStats()
+
+	time.Sleep(1 * time.Second)
+
+	action := `{"rebuild":{"imageRef":"df3768da-31f5-4ba6-82f0-127a1a705169"}}`
+	testServerActionCommand(t, tenant.ID, servers.Servers[0].ID, ssntp.REBUILD, action)
+}
+
+func TestServerActionResize(t *testing.T) {
+	tenant, err := context.ds.GetTenant(computeTestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(0, ssntp.AGENT)
+	defer client.Ssntp.Close()
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	client.SendStats()
+
+	time.Sleep(1 * time.Second)
+
+	i, err := context.ds.GetInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := context.ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := context.ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var flavor string
+	for _, wl := range wls {
+		if wl.ID != current.ID && wl.VMType == current.VMType && !isCNCIWorkload(wl) {
+			flavor = wl.ID
+			break
+		}
+	}
+
+	if flavor == "" {
+		t.Fatal("No flavor to resize to")
+	}
+
+	action := `{"resize":{"flavorRef":"` + flavor + `"}}`
+	testServerActionCommand(t, tenant.ID, servers.Servers[0].ID, ssntp.RESIZE, action)
+
+	i, err = context.ds.GetInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.WorkloadID != flavor {
+		t.Fatalf("expected workload %s, got %s", flavor, i.WorkloadID)
+	}
+
+	url := computeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
+	_ = testHTTPRequest(t, "POST", url, http.StatusNoContent, []byte(`{"confirmResize":null}`))
+	_ = testHTTPRequest(t, "POST", url, http.StatusConflict, []byte(`{"confirmResize":null}`))
+}
+
+func TestServerActionInvalid(t *testing.T) {
+	tenant, err := context.ds.GetTenant(computeTestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(0, ssntp.AGENT)
+	defer client.Ssntp.Close()
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	url := computeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
+
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte("shelve"))
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte(`{"pause":null,"suspend":null}`))
+	_ = testHTTPRequest(t, "POST", url, http.StatusServiceUnavailable, []byte(`{"shelve":null}`))
+}
+
 func TestListFlavors(t *testing.T) {
 	tenant, err := context.ds.GetTenant(computeTestUser)
 	if err != nil {
@@ -499,6 +755,544 @@ func TestShowFlavorDetails(t *testing.T) {
 	}
 }
 
+func testFlavorRequest(ID string) payloads.CiaoCreateFlavor {
+	return payloads.CiaoCreateFlavor{
+		Flavor: payloads.CiaoFlavor{
+			ID:        ID,
+			Name:      "test flavor",
+			FWType:    string(payloads.Legacy),
+			VMType:    payloads.QEMU,
+			ImageID:   "73a86d7e-93c0-480e-9c41-ab42f69b7799",
+			ImageName: "",
+			Config:    "#cloud-config\n",
+			Resources: []payloads.CiaoFlavorResource{
+				{Type: payloads.VCPUs, Value: 2},
+				{Type: payloads.MemMB, Value: 512},
+			},
+		},
+	}
+}
+
+func TestCreateFlavor(t *testing.T) {
+	req := testFlavorRequest("")
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := testHTTPRequest(t, "POST", computeURL+"/v2.1/flavors", http.StatusCreated, b)
+
+	var f payloads.ComputeFlavorDetails
+
+	err = json.Unmarshal(body, &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Flavor.ID == "" || f.Flavor.Name != req.Flavor.Name ||
+		f.Flavor.Vcpus != 2 || f.Flavor.RAM != 512 {
+		t.Fatal("Flavor details not correct")
+	}
+
+	wl, err := context.ds.GetWorkload(f.Flavor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wl.Config != req.Flavor.Config {
+		t.Fatal("Flavor config not stored")
+	}
+
+	testHTTPRequest(t, "DELETE", computeURL+"/v2.1/flavors/"+f.Flavor.ID, http.StatusAccepted, nil)
+
+	_, err = context.ds.GetWorkload(f.Flavor.ID)
+	if err == nil {
+		t.Fatal("Flavor not deleted")
+	}
+}
+
+func TestUpdateFlavor(t *testing.T) {
+	ID := uuid.Generate().String()
+	req := testFlavorRequest(ID)
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "POST", computeURL+"/v2.1/flavors", http.StatusCreated, b)
+
+	req.Flavor.Resources[1].Value = 1024
+	b, err = json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := testHTTPRequest(t, "PUT", computeURL+"/v2.1/flavors/"+ID, http.StatusOK, b)
+
+	var f payloads.ComputeFlavorDetails
+
+	err = json.Unmarshal(body, &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Flavor.RAM != 1024 {
+		t.Fatal("Flavor not updated")
+	}
+
+	testHTTPRequest(t, "DELETE", computeURL+"/v2.1/flavors/"+ID, http.StatusAccepted, nil)
+}
+
+func TestCreateFlavorInvalid(t *testing.T) {
+	invalid := []func(*payloads.CiaoFlavor){
+		func(f *payloads.CiaoFlavor) { f.Name = "" },
+		func(f *payloads.CiaoFlavor) { f.ID = "../../etc/foo" },
+		func(f *payloads.CiaoFlavor) { f.VMType = "lxc" },
+		func(f *payloads.CiaoFlavor) { f.ImageID = "" },
+		func(f *payloads.CiaoFlavor) { f.Config = "" },
+		func(f *payloads.CiaoFlavor) { f.Resources = f.Resources[:1] },
+		func(f *payloads.CiaoFlavor) {
+			f.Resources = append(f.Resources, payloads.CiaoFlavorResource{Type: payloads.VCPUs, Value: 4})
+		},
+		func(f *payloads.CiaoFlavor) {
+			f.Resources = append(f.Resources, payloads.CiaoFlavorResource{Type: "gpus", Value: 1})
+		},
+	}
+
+	for _, fn := range invalid {
+		req := testFlavorRequest("")
+		fn(&req.Flavor)
+
+		b, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testHTTPRequest(t, "POST", computeURL+"/v2.1/flavors", http.StatusBadRequest, b)
+	}
+}
+
+func TestFlavorNotFound(t *testing.T) {
+	url := computeURL + "/v2.1/flavors/" + uuid.Generate().String()
+
+	b, err := json.Marshal(testFlavorRequest(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "PUT", url, http.StatusNotFound, b)
+	testHTTPRequest(t, "DELETE", url, http.StatusNotFound, nil)
+}
+
+func TestFlavorExists(t *testing.T) {
+	ID := uuid.Generate().String()
+
+	b, err := json.Marshal(testFlavorRequest(ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "POST", computeURL+"/v2.1/flavors", http.StatusCreated, b)
+	testHTTPRequest(t, "POST", computeURL+"/v2.1/flavors", http.StatusConflict, b)
+	testHTTPRequest(t, "DELETE", computeURL+"/v2.1/flavors/"+ID, http.StatusAccepted, nil)
+}
+
+func TestFlavorInUse(t *testing.T) {
+	ID := uuid.Generate().String()
+	url := computeURL + "/v2.1/flavors/" + ID
+	req := testFlavorRequest(ID)
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "POST", computeURL+"/v2.1/flavors", http.StatusCreated, b)
+
+	wl, err := context.ds.GetWorkload(ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := newInstance(context, computeTestUser, wl, "", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = instance.Add()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the name may change, the resources may not
+	req.Flavor.Name = "updated flavor"
+	b, err = json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "PUT", url, http.StatusOK, b)
+
+	req.Flavor.Resources[1].Value = 1024
+	b, err = json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "PUT", url, http.StatusConflict, b)
+	testHTTPRequest(t, "DELETE", url, http.StatusConflict, nil)
+
+	err = context.ds.DeleteInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "PUT", url, http.StatusOK, b)
+	testHTTPRequest(t, "DELETE", url, http.StatusAccepted, nil)
+}
+
+func TestCNCIFlavorForbidden(t *testing.T) {
+	ID, err := context.ds.GetCNCIWorkloadID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := computeURL + "/v2.1/flavors/" + ID
+
+	b, err := json.Marshal(testFlavorRequest(ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "PUT", url, http.StatusForbidden, b)
+	testHTTPRequest(t, "DELETE", url, http.StatusForbidden, nil)
+}
+
+func testCreateKeyPair(t *testing.T, name string, publicKey string, expectedResponse int) payloads.KeyPair {
+	var req payloads.ComputeCreateKeyPair
+	req.KeyPair.Name = name
+	req.KeyPair.PublicKey = publicKey
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := computeURL + "/v2.1/" + computeTestUser + "/os-keypairs"
+	body := testHTTPRequest(t, "POST", url, expectedResponse, b)
+
+	var kp payloads.ComputeKeyPair
+	if expectedResponse == http.StatusOK {
+		err = json.Unmarshal(body, &kp)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return kp.KeyPair
+}
+
+func TestCreateKeyPair(t *testing.T) {
+	tURL := computeURL + "/v2.1/" + computeTestUser + "/os-keypairs"
+
+	kp := testCreateKeyPair(t, "generated", "", http.StatusOK)
+	if kp.Name != "generated" || kp.PrivateKey == "" || kp.PublicKey == "" || kp.Fingerprint == "" {
+		t.Fatal("Key pair not generated")
+	}
+
+	body := testHTTPRequest(t, "GET", tURL, http.StatusOK, nil)
+
+	var keyPairs payloads.ComputeKeyPairs
+	err := json.Unmarshal(body, &keyPairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, k := range keyPairs.KeyPairs {
+		if k.KeyPair.Name == kp.Name && k.KeyPair.PublicKey == kp.PublicKey {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatal("Key pair not listed")
+	}
+
+	body = testHTTPRequest(t, "GET", tURL+"/"+kp.Name, http.StatusOK, nil)
+
+	var shown payloads.ComputeKeyPair
+	err = json.Unmarshal(body, &shown)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shown.KeyPair.Fingerprint != kp.Fingerprint || shown.KeyPair.PrivateKey != "" {
+		t.Fatal("Key pair details not correct")
+	}
+
+	testHTTPRequest(t, "DELETE", tURL+"/"+kp.Name, http.StatusAccepted, nil)
+	testHTTPRequest(t, "GET", tURL+"/"+kp.Name, http.StatusNotFound, nil)
+}
+
+func TestImportKeyPair(t *testing.T) {
+	kp := testCreateKeyPair(t, "imported", testPublicKey, http.StatusOK)
+	if kp.Fingerprint != testPublicKeyFingerprint || kp.PrivateKey != "" {
+		t.Fatal("Key pair not imported")
+	}
+
+	testCreateKeyPair(t, "imported", testPublicKey, http.StatusConflict)
+	testCreateKeyPair(t, "invalid key", "ssh-rsa not-a-key", http.StatusBadRequest)
+	testCreateKeyPair(t, "invalid/name", testPublicKey, http.StatusBadRequest)
+
+	url := computeURL + "/v2.1/" + computeTestUser + "/os-keypairs/" + kp.Name
+	testHTTPRequest(t, "DELETE", url, http.StatusAccepted, nil)
+}
+
+func TestCreateServerKeyName(t *testing.T) {
+	kp := testCreateKeyPair(t, "server key", testPublicKey, http.StatusOK)
+
+	wls, err := context.ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No valid workloads")
+	}
+
+	url := computeURL + "/v2.1/" + computeTestUser + "/servers"
+
+	var server payloads.ComputeCreateServer
+	server.Server.MaxInstances = 1
+	server.Server.Workload = wls[0].ID
+	server.Server.KeyName = kp.Name
+
+	b, err := json.Marshal(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "POST", url, http.StatusAccepted, b)
+
+	server.Server.KeyName = "missing key"
+	b, err = json.Marshal(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "POST", url, http.StatusBadRequest, b)
+
+	kpURL := computeURL + "/v2.1/" + computeTestUser + "/os-keypairs/" + kp.Name
+	testHTTPRequest(t, "DELETE", kpURL, http.StatusAccepted, nil)
+}
+
+func TestServerMetadata(t *testing.T) {
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal("failed to create server")
+	}
+
+	url := computeURL + "/v2.1/" + computeTestUser + "/servers/" + servers.Servers[0].ID + "/metadata"
+
+	req := payloads.ComputeServerMetadata{
+		Metadata: map[string]string{"role": "web", "owner": "ops"},
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "PUT", url, http.StatusOK, b)
+
+	req.Metadata = map[string]string{"role": "db"}
+	b, err = json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "POST", url, http.StatusOK, b)
+
+	body := testHTTPRequest(t, "GET", url, http.StatusOK, nil)
+
+	var md payloads.ComputeServerMetadata
+	err = json.Unmarshal(body, &md)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"role": "db", "owner": "ops"}
+	if !reflect.DeepEqual(md.Metadata, expected) {
+		t.Fatalf("expected metadata %v, got %v", expected, md.Metadata)
+	}
+
+	item := payloads.ComputeServerMetadataItem{
+		Meta: map[string]string{"owner": "dev"},
+	}
+	b, err = json.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "PUT", url+"/owner", http.StatusOK, b)
+	testHTTPRequest(t, "PUT", url+"/role", http.StatusBadRequest, b)
+
+	body = testHTTPRequest(t, "GET", url+"/owner", http.StatusOK, nil)
+	err = json.Unmarshal(body, &item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Meta["owner"] != "dev" {
+		t.Fatalf("expected owner dev, got %s", item.Meta["owner"])
+	}
+
+	testHTTPRequest(t, "DELETE", url+"/owner", http.StatusNoContent, nil)
+	testHTTPRequest(t, "GET", url+"/owner", http.StatusNotFound, nil)
+	testHTTPRequest(t, "DELETE", url+"/owner", http.StatusNotFound, nil)
+}
+
+func TestServerTags(t *testing.T) {
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal("failed to create server")
+	}
+
+	url := computeURL + "/v2.1/" + computeTestUser + "/servers/" + servers.Servers[0].ID + "/tags"
+
+	req := payloads.ComputeServerTags{
+		Tags: []string{"prod", "blue"},
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "PUT", url, http.StatusOK, b)
+
+	testHTTPRequest(t, "PUT", url+"/green", http.StatusCreated, nil)
+	testHTTPRequest(t, "PUT", url+"/green", http.StatusNoContent, nil)
+	testHTTPRequest(t, "GET", url+"/green", http.StatusNoContent, nil)
+	testHTTPRequest(t, "DELETE", url+"/blue", http.StatusNoContent, nil)
+	testHTTPRequest(t, "GET", url+"/blue", http.StatusNotFound, nil)
+
+	body := testHTTPRequest(t, "GET", url, http.StatusOK, nil)
+
+	var tags payloads.ComputeServerTags
+	err = json.Unmarshal(body, &tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"green", "prod"}
+	if !reflect.DeepEqual(tags.Tags, expected) {
+		t.Fatalf("expected tags %v, got %v", expected, tags.Tags)
+	}
+
+	req.Tags = []string{"a/b"}
+	b, err = json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "PUT", url, http.StatusBadRequest, b)
+
+	testHTTPRequest(t, "DELETE", url, http.StatusNoContent, nil)
+
+	body = testHTTPRequest(t, "GET", url, http.StatusOK, nil)
+	err = json.Unmarshal(body, &tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tags.Tags) != 0 {
+		t.Fatalf("expected no tags, got %v", tags.Tags)
+	}
+}
+
+func TestListServerDetailsFilters(t *testing.T) {
+	wls, err := context.ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No valid workloads")
+	}
+
+	name := "filter-" + uuid.Generate().String()
+
+	var server payloads.ComputeCreateServer
+	server.Server.Name = name
+	server.Server.MaxInstances = 2
+	server.Server.Workload = wls[0].ID
+	server.Server.Metadata = map[string]string{"role": "web"}
+	server.Server.Tags = []string{"filtered", "web"}
+
+	b, err := json.Marshal(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := computeURL + "/v2.1/" + computeTestUser + "/servers"
+
+	body := testHTTPRequest(t, "POST", url, http.StatusAccepted, b)
+
+	var created payloads.ComputeServers
+	err = json.Unmarshal(body, &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(created.Servers) != 2 {
+		t.Fatalf("expected 2 servers, got %d", len(created.Servers))
+	}
+
+	for i, s := range created.Servers {
+		expected := fmt.Sprintf("%s-%d", name, i+1)
+		if s.Name != expected {
+			t.Errorf("expected name %s, got %s", expected, s.Name)
+		}
+
+		if s.Metadata["role"] != "web" {
+			t.Errorf("expected role web, got %v", s.Metadata)
+		}
+	}
+
+	testFilter := func(query string, expected int) {
+		body := testHTTPRequest(t, "GET", url+"/detail?"+query, http.StatusOK, nil)
+
+		var s payloads.ComputeServers
+		err := json.Unmarshal(body, &s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(s.Servers) != expected {
+			t.Fatalf("%s: expected %d servers, got %d", query, expected, len(s.Servers))
+		}
+	}
+
+	testFilter("name=^"+name+"-1$", 1)
+	testFilter("name="+name, 2)
+	testFilter("name="+name+"&tags=web,filtered", 2)
+	testFilter("name="+name+"&tags=web,missing", 0)
+	testFilter("name="+name+"&status=PENDING", 2)
+	testFilter("name="+name+"&status=running", 0)
+
+	testHTTPRequest(t, "GET", url+"/detail?name=(", http.StatusBadRequest, nil)
+
+	server.Server.Tags = []string{"a,b"}
+	b, err = json.Marshal(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHTTPRequest(t, "POST", url, http.StatusBadRequest, b)
+}
+
 func TestListTenantResources(t *testing.T) {
 	var usage payloads.CiaoUsageHistory
 
@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"strconv"
@@ -27,6 +28,7 @@ import (
 	"time"
 
 	datastore "github.com/01org/ciao/ciao-controller/internal/datastore"
+	"github.com/01org/ciao/ciao-controller/internal/imagestore"
 	"github.com/01org/ciao/ciao-controller/types"
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
@@ -111,6 +113,10 @@ func startTestServer(server *testutil.SsntpTestServer) {
 				Operand: ssntp.RestartFailure,
 				Dest:    ssntp.Controller,
 			},
+			{
+				Operand: ssntp.InstanceActionFailure,
+				Dest:    ssntp.Controller,
+			},
 			{
 				Operand:        ssntp.START,
 				CommandForward: server,
@@ -195,7 +201,7 @@ func BenchmarkStartSingleWorkload(b *testing.B) {
 
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		_, err = context.startWorkload(wls[0].ID, tuuid.String(), 1, false, "")
+		_, err = context.startWorkload(wls[0].ID, tuuid.String(), 1, false, "", "", "", nil, nil)
 		if err != nil {
 			b.Error(err)
 		}
@@ -230,7 +236,7 @@ func BenchmarkStart1000Workload(b *testing.B) {
 
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		_, err = context.startWorkload(wls[0].ID, tuuid.String(), 1000, false, "")
+		_, err = context.startWorkload(wls[0].ID, tuuid.String(), 1000, false, "", "", "", nil, nil)
 		if err != nil {
 			b.Error(err)
 		}
@@ -255,7 +261,7 @@ func BenchmarkNewConfig(b *testing.B) {
 
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		_, err := newConfig(context, wls[0], id.String(), tenant.ID)
+		_, err := newConfig(context, wls[0], id.String(), tenant.ID, "")
 		if err != nil {
 			b.Error(err)
 		}
@@ -281,7 +287,7 @@ func TestTenantWithinBounds(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = context.startWorkload(wls[0].ID, tenant.ID, 1, false, "")
+	_, err = context.startWorkload(wls[0].ID, tenant.ID, 1, false, "", "", "", nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -305,7 +311,7 @@ func TestTenantOutOfBounds(t *testing.T) {
 	}
 
 	/* try to send 2 workload start commands */
-	_, err = context.startWorkload(wls[0].ID, tenant.ID, 2, false, "")
+	_, err = context.startWorkload(wls[0].ID, tenant.ID, 2, false, "", "", "", nil, nil)
 	if err == nil {
 		t.Errorf("Not tracking limits correctly")
 	}
@@ -755,6 +761,65 @@ func TestRestartFailure(t *testing.T) {
 	t.Error("Did not find failure message in Log")
 }
 
+func TestInstanceActionFailure(t *testing.T) {
+	context.ds.ClearLog()
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Ssntp.Close()
+
+	client.ActionFail = true
+	client.ActionFailReason = payloads.ActionNoInstance
+
+	time.Sleep(1 * time.Second)
+
+	client.SendStats()
+
+	time.Sleep(1 * time.Second)
+
+	c := make(chan testutil.CmdResult)
+	server.AddCmdChan(ssntp.PAUSE, c)
+
+	err := context.pauseInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case result := <-c:
+		if result.Err != nil {
+			t.Fatal("Error parsing command yaml")
+		}
+
+		if result.InstanceUUID != instances[0].ID {
+			t.Fatal("Did not get correct Instance ID")
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for PAUSE command")
+	}
+
+	time.Sleep(1 * time.Second)
+
+	client.Ssntp.Close()
+
+	// the response to an instance action failure is to log the failure
+	entries, err := context.ds.GetEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedMsg := fmt.Sprintf("Pause Failure %s: %s", instances[0].ID, client.ActionFailReason.String())
+
+	for i := range entries {
+		if entries[i].Message == expectedMsg {
+			return
+		}
+	}
+	t.Error("Did not find failure message in Log")
+}
+
 func TestNoNetwork(t *testing.T) {
 	nn := true
 
@@ -782,7 +847,7 @@ func testStartTracedWorkload(t *testing.T) *testutil.SsntpTestClient {
 	c := make(chan testutil.CmdResult)
 	client.AddCmdChan(ssntp.START, c)
 
-	instances, err := context.startWorkload(wls[0].ID, tenant.ID, 1, true, "testtrace1")
+	instances, err := context.startWorkload(wls[0].ID, tenant.ID, 1, true, "testtrace1", "", "", nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -826,7 +891,7 @@ func testStartWorkload(t *testing.T, num int, fail bool, reason payloads.StartFa
 	client.StartFail = fail
 	client.StartFailReason = reason
 
-	instances, err := context.startWorkload(wls[0].ID, tenant.ID, num, false, "")
+	instances, err := context.startWorkload(wls[0].ID, tenant.ID, num, false, "", "", "", nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -868,7 +933,7 @@ func testStartWorkloadLaunchCNCI(t *testing.T, num int) (*testutil.SsntpTestClie
 	var instances []*types.Instance
 
 	go func() {
-		instances, err = context.startWorkload(wls[0].ID, id, 1, false, "")
+		instances, err = context.startWorkload(wls[0].ID, id, 1, false, "", "", "", nil, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -920,6 +985,7 @@ var testClients []*testutil.SsntpTestClient
 var context *controller
 var server testutil.SsntpTestServer
 var computeURL string
+var imageURL string
 var testIdentityURL string
 
 const computeTestUser = "f452bbc7-5076-44d5-922c-3b9d2ce1503f"
@@ -928,6 +994,7 @@ func TestMain(m *testing.M) {
 	flag.Parse()
 
 	computeURL = "https://localhost:" + strconv.Itoa(*computeAPIPort)
+	imageURL = "https://localhost:" + strconv.Itoa(*imageAPIPort)
 
 	// create fake ssntp server
 	startTestServer(&server)
@@ -948,6 +1015,17 @@ func TestMain(m *testing.M) {
 		os.Exit(1)
 	}
 
+	imagesDir, err := ioutil.TempDir("", "ciao-controller-images")
+	if err != nil {
+		os.Exit(1)
+	}
+
+	context.images = new(imagestore.ImageStore)
+	err = context.images.Init(imagesDir)
+	if err != nil {
+		os.Exit(1)
+	}
+
 	config := &ssntp.Config{
 		URI:    "localhost",
 		CAcert: *caCert,
@@ -983,6 +1061,7 @@ func TestMain(m *testing.M) {
 
 	_, _ = addComputeTestTenant()
 	go createComputeAPI(context)
+	go createImageAPI(context)
 
 	time.Sleep(1 * time.Second)
 
@@ -997,6 +1076,7 @@ func TestMain(m *testing.M) {
 	os.Remove("./ciao-controller-test-tdb.db")
 	os.Remove("./ciao-controller-test-tdb.db-shm")
 	os.Remove("./ciao-controller-test-tdb.db-wal")
+	os.RemoveAll(imagesDir)
 
 	os.Exit(code)
 }
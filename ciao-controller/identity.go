@@ -42,6 +42,12 @@ type Project struct {
 	Name string `mapstructure:"name"`
 }
 
+// User holds user information extracted from the keystone response.
+type User struct {
+	ID   string `mapstructure:"id"`
+	Name string `mapstructure:"name"`
+}
+
 // RoleEntry contains the name of a role extracted from the keystone response.
 type RoleEntry struct {
 	Name string `mapstructure:"name"`
@@ -108,6 +114,32 @@ func (r getResult) extractProject() (*Project, error) {
 	}, nil
 }
 
+// extractUser
+// Returns the user the token was issued to.
+func (r getResult) extractUser() (*User, error) {
+	if r.Err != nil {
+		glog.V(2).Info(r.Err)
+		return nil, r.Err
+	}
+
+	var response struct {
+		Token struct {
+			ValidUser User `mapstructure:"user"`
+		} `mapstructure:"token"`
+	}
+
+	err := mapstructure.Decode(r.Body, &response)
+	if err != nil {
+		glog.V(2).Info(err)
+		return nil, err
+	}
+
+	return &User{
+		ID:   response.Token.ValidUser.ID,
+		Name: response.Token.ValidUser.Name,
+	}, nil
+}
+
 func (r getResult) extractServices() (*Services, error) {
 	if r.Err != nil {
 		glog.V(2).Info(r.Err)
@@ -217,6 +249,40 @@ func (i *identity) validateProjectRole(token string, project string, role string
 	return false
 }
 
+// tokenUser returns the ID of the user a token was issued to.
+func (i *identity) tokenUser(token string) (string, error) {
+	r := v3tokens.Get(i.scV3, token)
+	result := getResult{r}
+
+	u, err := result.extractUser()
+	if err != nil {
+		return "", err
+	}
+
+	if u.ID == "" {
+		return "", errors.New("Token has no user")
+	}
+
+	return u.ID, nil
+}
+
+// tokenProject returns the ID of the project a token is scoped to.
+func (i *identity) tokenProject(token string) (string, error) {
+	r := v3tokens.Get(i.scV3, token)
+	result := getResult{r}
+
+	p, err := result.extractProject()
+	if err != nil {
+		return "", err
+	}
+
+	if p.ID == "" {
+		return "", errors.New("Token has no project")
+	}
+
+	return p.ID, nil
+}
+
 func newIdentityClient(config identityConfig) (*identity, error) {
 	opt := gophercloud.AuthOptions{
 		IdentityEndpoint: config.endpoint + "/v3/",
@@ -0,0 +1,470 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/01org/ciao/ciao-controller/internal/imagestore"
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/docker/distribution/uuid"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+const openstackImageAPIPort = 9292
+
+var diskFormats = map[payloads.DiskFormat]bool{
+	payloads.Raw:   true,
+	payloads.QCow2: true,
+	payloads.ISO:   true,
+	payloads.VHD:   true,
+	payloads.VMDK:  true,
+	payloads.VDI:   true,
+}
+
+var containerFormats = map[payloads.ContainerFormat]bool{
+	payloads.Bare:            true,
+	payloads.OVF:             true,
+	payloads.OVA:             true,
+	payloads.DockerContainer: true,
+}
+
+func imageErrorStatus(err error) int {
+	switch err {
+	case imagestore.ErrNoImage:
+		return http.StatusNotFound
+	case imagestore.ErrImageExists, imagestore.ErrImageUploaded:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+// agentCertificate returns true if the request was made with a verified
+// SSNTP client certificate from a launcher, which may then download
+// images without a keystone token.
+func agentCertificate(r *http.Request) bool {
+	if r.TLS == nil {
+		return false
+	}
+
+	for _, chain := range r.TLS.VerifiedChains {
+		if len(chain) == 0 {
+			continue
+		}
+
+		for _, oid := range chain[0].UnknownExtKeyUsage {
+			if oid.Equal(ssntp.RoleAgentOID) || oid.Equal(ssntp.RoleNetAgentOID) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// imageAccess describes the images a request may access.  As in Glance,
+// administrators may access every image, while other tokens may only
+// read public images and the images owned by their project.
+type imageAccess struct {
+	admin  bool
+	tenant string
+}
+
+// imageRequestAccess validates the token of an image API request and
+// returns the access it grants.
+func imageRequestAccess(context *controller, r *http.Request) (imageAccess, bool) {
+	var access imageAccess
+
+	token := r.Header["X-Auth-Token"]
+	if token == nil {
+		return access, false
+	}
+
+	tenant, err := context.id.tokenProject(token[0])
+	if err != nil {
+		return access, false
+	}
+
+	access.tenant = tenant
+	access.admin = adminToken(context, r)
+
+	return access, true
+}
+
+func (access imageAccess) canRead(image payloads.ImageDetails) bool {
+	return access.admin || image.Visibility == payloads.ImagePublic || image.Owner == access.tenant
+}
+
+func (access imageAccess) canModify(image payloads.ImageDetails) bool {
+	return access.admin || image.Owner == access.tenant
+}
+
+// getImageAccess returns an image if the request may read it.  Images
+// that may not be read are reported as missing.
+func getImageAccess(context *controller, access imageAccess, imageID string) (payloads.ImageDetails, error) {
+	image, err := context.images.GetImage(imageID)
+	if err != nil {
+		return image, err
+	}
+
+	if !access.canRead(image) {
+		return payloads.ImageDetails{}, imagestore.ErrNoImage
+	}
+
+	return image, nil
+}
+
+func imageLinks(image *payloads.ImageDetails) {
+	image.Self = "/v2/images/" + image.ID
+	image.File = image.Self + "/file"
+	image.Schema = "/v2/schemas/image"
+}
+
+func writeImageDetails(w http.ResponseWriter, image payloads.ImageDetails, status int) {
+	imageLinks(&image)
+
+	b, err := json.Marshal(image)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func requestToImage(request *payloads.ImageCreateRequest) (payloads.ImageDetails, error) {
+	image := payloads.ImageDetails{
+		ID:              request.ID,
+		Name:            request.Name,
+		Visibility:      request.Visibility,
+		DiskFormat:      request.DiskFormat,
+		ContainerFormat: request.ContainerFormat,
+		MinDisk:         request.MinDisk,
+		MinRAM:          request.MinRAM,
+		Protected:       request.Protected,
+		Tags:            request.Tags,
+	}
+
+	if image.ID == "" {
+		image.ID = uuid.Generate().String()
+	} else if _, err := uuid.Parse(image.ID); err != nil {
+		return image, fmt.Errorf("Invalid image id %s", image.ID)
+	}
+
+	switch image.Visibility {
+	case "":
+		image.Visibility = payloads.ImagePrivate
+	case payloads.ImagePublic, payloads.ImagePrivate:
+	default:
+		return image, fmt.Errorf("Invalid visibility %s", image.Visibility)
+	}
+
+	if image.DiskFormat != "" && !diskFormats[image.DiskFormat] {
+		return image, fmt.Errorf("Invalid disk_format %s", image.DiskFormat)
+	}
+
+	if image.ContainerFormat != "" && !containerFormats[image.ContainerFormat] {
+		return image, fmt.Errorf("Invalid container_format %s", image.ContainerFormat)
+	}
+
+	if image.MinDisk < 0 || image.MinRAM < 0 {
+		return image, fmt.Errorf("Invalid min_disk or min_ram")
+	}
+
+	if image.Tags == nil {
+		image.Tags = []string{}
+	}
+
+	return image, nil
+}
+
+func createImage(w http.ResponseWriter, r *http.Request, context *controller) {
+	var request payloads.ImageCreateRequest
+
+	dumpRequestBody(r, true)
+
+	access, ok := imageRequestAccess(context, r)
+	if !ok {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	image, err := requestToImage(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if image.Visibility == payloads.ImagePublic && !access.admin {
+		http.Error(w, "Only administrators may create public images", http.StatusForbidden)
+		return
+	}
+
+	image.Owner = access.tenant
+
+	image, err = context.images.CreateImage(image)
+	if err != nil {
+		http.Error(w, err.Error(), imageErrorStatus(err))
+		return
+	}
+
+	writeImageDetails(w, image, http.StatusCreated)
+}
+
+func listImages(w http.ResponseWriter, r *http.Request, context *controller) {
+	dumpRequest(r)
+
+	access, ok := imageRequestAccess(context, r)
+	if !ok {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	images := payloads.ImageList{
+		Images: []payloads.ImageDetails{},
+		Schema: "/v2/schemas/images",
+		First:  "/v2/images",
+	}
+
+	for _, image := range context.images.GetImages() {
+		if access.canRead(image) {
+			images.Images = append(images.Images, image)
+		}
+	}
+
+	for i := range images.Images {
+		imageLinks(&images.Images[i])
+	}
+
+	b, err := json.Marshal(images)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func showImage(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	imageID := vars["image"]
+
+	dumpRequest(r)
+
+	access, ok := imageRequestAccess(context, r)
+	if !ok {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	image, err := getImageAccess(context, access, imageID)
+	if err != nil {
+		http.Error(w, err.Error(), imageErrorStatus(err))
+		return
+	}
+
+	writeImageDetails(w, image, http.StatusOK)
+}
+
+func deleteImage(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	imageID := vars["image"]
+
+	dumpRequest(r)
+
+	access, ok := imageRequestAccess(context, r)
+	if !ok {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	image, err := getImageAccess(context, access, imageID)
+	if err != nil {
+		http.Error(w, err.Error(), imageErrorStatus(err))
+		return
+	}
+
+	if !access.canModify(image) {
+		http.Error(w, "Image not owned by project", http.StatusForbidden)
+		return
+	}
+
+	if image.Protected {
+		http.Error(w, "Image is protected", http.StatusForbidden)
+		return
+	}
+
+	err = context.images.DeleteImage(imageID)
+	if err != nil {
+		http.Error(w, err.Error(), imageErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func uploadImage(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	imageID := vars["image"]
+
+	dumpRequest(r)
+
+	access, ok := imageRequestAccess(context, r)
+	if !ok {
+		http.Error(w, "Invalid token", http.StatusInternalServerError)
+		return
+	}
+
+	defer r.Body.Close()
+
+	image, err := getImageAccess(context, access, imageID)
+	if err != nil {
+		http.Error(w, err.Error(), imageErrorStatus(err))
+		return
+	}
+
+	if !access.canModify(image) {
+		http.Error(w, "Image not owned by project", http.StatusForbidden)
+		return
+	}
+
+	_, err = context.images.UploadImage(imageID, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), imageErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func downloadImage(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	imageID := vars["image"]
+
+	dumpRequest(r)
+
+	if agentCertificate(r) == false {
+		access, ok := imageRequestAccess(context, r)
+		if !ok {
+			http.Error(w, "Invalid token", http.StatusInternalServerError)
+			return
+		}
+
+		_, err := getImageAccess(context, access, imageID)
+		if err != nil {
+			http.Error(w, err.Error(), imageErrorStatus(err))
+			return
+		}
+	}
+
+	f, image, err := context.images.OpenImage(imageID)
+	if err == imagestore.ErrNoImageData {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), imageErrorStatus(err))
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-MD5", image.Checksum)
+	http.ServeContent(w, r, "", image.UpdatedAt, f)
+}
+
+func imageTLSConfig() *tls.Config {
+	config := &tls.Config{}
+
+	caPEM, err := ioutil.ReadFile(*caCert)
+	if err != nil {
+		glog.Warningf("Launchers will not be able to download images: %v", err)
+		return config
+	}
+
+	pool := x509.NewCertPool()
+	if pool.AppendCertsFromPEM(caPEM) == false {
+		glog.Warningf("Launchers will not be able to download images: invalid CA %s", *caCert)
+		return config
+	}
+
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return config
+}
+
+func createImageAPI(context *controller) {
+	r := mux.NewRouter()
+
+	r.HandleFunc("/v2/images", func(w http.ResponseWriter, r *http.Request) {
+		createImage(w, r, context)
+	}).Methods("POST")
+
+	r.HandleFunc("/v2/images", func(w http.ResponseWriter, r *http.Request) {
+		listImages(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+		showImage(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+		deleteImage(w, r, context)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/v2/images/{image}/file", func(w http.ResponseWriter, r *http.Request) {
+		uploadImage(w, r, context)
+	}).Methods("PUT")
+
+	r.HandleFunc("/v2/images/{image}/file", func(w http.ResponseWriter, r *http.Request) {
+		downloadImage(w, r, context)
+	}).Methods("GET")
+
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%d", *imageAPIPort),
+		Handler:   r,
+		TLSConfig: imageTLSConfig(),
+	}
+
+	log.Fatal(server.ListenAndServeTLS(*httpsCAcert, *httpsKey))
+}
@@ -0,0 +1,173 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/01org/ciao/payloads"
+)
+
+func testCreateImage(t *testing.T) payloads.ImageDetails {
+	req := payloads.ImageCreateRequest{
+		Name:            "test image",
+		DiskFormat:      payloads.QCow2,
+		ContainerFormat: payloads.Bare,
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := testHTTPRequest(t, "POST", imageURL+"/v2/images", http.StatusCreated, b)
+
+	var image payloads.ImageDetails
+	err = json.Unmarshal(body, &image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image.ID == "" || image.Name != req.Name || image.Status != payloads.ImageQueued ||
+		image.Visibility != payloads.ImagePrivate || image.File != "/v2/images/"+image.ID+"/file" {
+		t.Fatal("Image details not correct")
+	}
+
+	return image
+}
+
+func TestCreateImage(t *testing.T) {
+	image := testCreateImage(t)
+
+	body := testHTTPRequest(t, "GET", imageURL+"/v2/images/"+image.ID, http.StatusOK, nil)
+
+	var shown payloads.ImageDetails
+	err := json.Unmarshal(body, &shown)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shown.ID != image.ID || shown.DiskFormat != payloads.QCow2 || shown.Owner != computeTestUser {
+		t.Fatal("Image details not correct")
+	}
+
+	body = testHTTPRequest(t, "GET", imageURL+"/v2/images", http.StatusOK, nil)
+
+	var images payloads.ImageList
+	err = json.Unmarshal(body, &images)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, i := range images.Images {
+		if i.ID == image.ID {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatal("Image not listed")
+	}
+
+	testHTTPRequest(t, "DELETE", imageURL+"/v2/images/"+image.ID, http.StatusNoContent, nil)
+	testHTTPRequest(t, "GET", imageURL+"/v2/images/"+image.ID, http.StatusNotFound, nil)
+}
+
+func TestCreateImageInvalid(t *testing.T) {
+	invalid := []payloads.ImageCreateRequest{
+		{ID: "not-a-uuid"},
+		{DiskFormat: "floppy"},
+		{ContainerFormat: "zip"},
+		{Visibility: "shared"},
+	}
+
+	for _, req := range invalid {
+		b, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testHTTPRequest(t, "POST", imageURL+"/v2/images", http.StatusBadRequest, b)
+	}
+}
+
+func TestUploadImage(t *testing.T) {
+	image := testCreateImage(t)
+	imageFile := imageURL + "/v2/images/" + image.ID + "/file"
+
+	testHTTPRequest(t, "GET", imageFile, http.StatusNoContent, nil)
+
+	data := []byte("not really a qcow2 image")
+	testHTTPRequest(t, "PUT", imageFile, http.StatusNoContent, data)
+	testHTTPRequest(t, "PUT", imageFile, http.StatusConflict, data)
+
+	body := testHTTPRequest(t, "GET", imageURL+"/v2/images/"+image.ID, http.StatusOK, nil)
+
+	var shown payloads.ImageDetails
+	err := json.Unmarshal(body, &shown)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := md5.Sum(data)
+	if shown.Status != payloads.ImageActive || shown.Size != int64(len(data)) ||
+		shown.Checksum != hex.EncodeToString(sum[:]) {
+		t.Fatal("Image not updated after upload")
+	}
+
+	body = testHTTPRequest(t, "GET", imageFile, http.StatusOK, nil)
+	if string(body) != string(data) {
+		t.Fatal("Downloaded image data not correct")
+	}
+
+	testHTTPRequest(t, "DELETE", imageURL+"/v2/images/"+image.ID, http.StatusNoContent, nil)
+}
+
+func TestImageAccess(t *testing.T) {
+	tenant := imageAccess{tenant: "tenant1"}
+	admin := imageAccess{admin: true, tenant: "admin"}
+
+	tests := []struct {
+		image     payloads.ImageDetails
+		canRead   bool
+		canModify bool
+	}{
+		{payloads.ImageDetails{Owner: "tenant1", Visibility: payloads.ImagePrivate}, true, true},
+		{payloads.ImageDetails{Owner: "tenant2", Visibility: payloads.ImagePrivate}, false, false},
+		{payloads.ImageDetails{Owner: "tenant2", Visibility: payloads.ImagePublic}, true, false},
+		{payloads.ImageDetails{Visibility: payloads.ImagePrivate}, false, false},
+	}
+
+	for i, test := range tests {
+		if tenant.canRead(test.image) != test.canRead {
+			t.Errorf("%d: expected canRead %v", i, test.canRead)
+		}
+
+		if tenant.canModify(test.image) != test.canModify {
+			t.Errorf("%d: expected canModify %v", i, test.canModify)
+		}
+
+		if !admin.canRead(test.image) || !admin.canModify(test.image) {
+			t.Errorf("%d: admin denied access", i)
+		}
+	}
+}
@@ -18,8 +18,10 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/01org/ciao/ciao-controller/types"
@@ -54,16 +56,22 @@ func isCNCIWorkload(workload *types.Workload) bool {
 	return false
 }
 
-func newInstance(context *controller, tenantID string, workload *types.Workload) (*instance, error) {
+func newInstance(context *controller, tenantID string, workload *types.Workload,
+	name string, publicKey string, metadata map[string]string, tags []string) (*instance, error) {
 	id := uuid.Generate()
 
-	config, err := newConfig(context, workload, id.String(), tenantID)
+	config, err := newConfig(context, workload, id.String(), tenantID, publicKey)
 	if err != nil {
 		return nil, err
 	}
 
 	usage := config.GetResources()
 
+	md := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		md[key] = value
+	}
+
 	newInstance := types.Instance{
 		TenantID:   tenantID,
 		WorkloadID: workload.ID,
@@ -73,6 +81,9 @@ func newInstance(context *controller, tenantID string, workload *types.Workload)
 		IPAddress:  config.ip,
 		MACAddress: config.mac,
 		Usage:      usage,
+		Name:       name,
+		Metadata:   md,
+		Tags:       append([]string{}, tags...),
 	}
 
 	i := &instance{
@@ -87,7 +98,10 @@ func newInstance(context *controller, tenantID string, workload *types.Workload)
 func (i *instance) Add() error {
 	if i.CNCI == false {
 		ds := i.context.ds
-		ds.AddInstance(&i.Instance)
+		err := ds.AddInstance(&i.Instance)
+		if err != nil {
+			return err
+		}
 	} else {
 		i.context.ds.AddTenantCNCI(i.TenantID, i.ID, i.MACAddress)
 	}
@@ -144,7 +158,108 @@ func (c *config) GetResources() map[string]int {
 	return resources
 }
 
-func newConfig(context *controller, wl *types.Workload, instanceID string, tenantID string) (config, error) {
+// appendAuthorizedKey appends publicKey to a list of SSH authorized keys
+// read from a cloud-init configuration.
+func appendAuthorizedKey(keys interface{}, publicKey string) ([]interface{}, error) {
+	list, ok := keys.([]interface{})
+	if !ok && keys != nil {
+		return nil, errors.New("Invalid SSH authorized keys in workload configuration")
+	}
+
+	return append(list, publicKey), nil
+}
+
+// addUserAuthorizedKeys adds publicKey to the SSH authorized keys of each
+// entry of a cloud-init users list.  The "default" entry, which stands
+// for the default user, is left alone as that user gets the top level
+// ssh_authorized_keys.
+func addUserAuthorizedKeys(users interface{}, publicKey string) error {
+	list, ok := users.([]interface{})
+	if !ok {
+		return errors.New("Invalid users in workload configuration")
+	}
+
+	for i := range list {
+		user, ok := list[i].(yaml.MapSlice)
+		if !ok {
+			continue
+		}
+
+		found := false
+		for j := range user {
+			if user[j].Key != "ssh-authorized-keys" && user[j].Key != "ssh_authorized_keys" {
+				continue
+			}
+
+			keys, err := appendAuthorizedKey(user[j].Value, publicKey)
+			if err != nil {
+				return err
+			}
+
+			user[j].Value = keys
+			found = true
+		}
+
+		if !found {
+			list[i] = append(user, yaml.MapItem{
+				Key:   "ssh-authorized-keys",
+				Value: []string{publicKey},
+			})
+		}
+	}
+
+	return nil
+}
+
+// addAuthorizedKey adds publicKey to the SSH authorized keys of the default
+// user and of the users defined in the cloud-init configuration of a
+// workload.
+func addAuthorizedKey(baseConfig string, publicKey string) (string, error) {
+	body := strings.TrimSpace(baseConfig)
+	body = strings.TrimPrefix(body, "---")
+	body = strings.TrimSuffix(body, "...")
+
+	var cloudConfig yaml.MapSlice
+	err := yaml.Unmarshal([]byte(body), &cloudConfig)
+	if err != nil {
+		return "", err
+	}
+
+	found := false
+	for i := range cloudConfig {
+		switch cloudConfig[i].Key {
+		case "ssh_authorized_keys":
+			keys, err := appendAuthorizedKey(cloudConfig[i].Value, publicKey)
+			if err != nil {
+				return "", err
+			}
+
+			cloudConfig[i].Value = keys
+			found = true
+		case "users":
+			err := addUserAuthorizedKeys(cloudConfig[i].Value, publicKey)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if !found {
+		cloudConfig = append(cloudConfig, yaml.MapItem{
+			Key:   "ssh_authorized_keys",
+			Value: []string{publicKey},
+		})
+	}
+
+	y, err := yaml.Marshal(cloudConfig)
+	if err != nil {
+		return "", err
+	}
+
+	return "---\n#cloud-config\n" + string(y) + "...\n", nil
+}
+
+func newConfig(context *controller, wl *types.Workload, instanceID string, tenantID string, publicKey string) (config, error) {
 	type UserData struct {
 		UUID     string `json:"uuid"`
 		Hostname string `json:"hostname"`
@@ -158,6 +273,15 @@ func newConfig(context *controller, wl *types.Workload, instanceID string, tenan
 	imageID := wl.ImageID
 	fwType := wl.FWType
 
+	if publicKey != "" {
+		var err error
+
+		baseConfig, err = addAuthorizedKey(baseConfig, publicKey)
+		if err != nil {
+			return config, err
+		}
+	}
+
 	tenant, err := context.ds.GetTenant(tenantID)
 	if err != nil {
 		fmt.Println("unable to get tenant")
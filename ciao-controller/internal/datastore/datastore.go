@@ -26,11 +26,13 @@ import (
 	"fmt"
 	"net"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/01org/ciao/ciao-controller/types"
 	"github.com/01org/ciao/payloads"
+	"github.com/docker/distribution/uuid"
 	"github.com/golang/glog"
 )
 
@@ -79,6 +81,14 @@ type persistentStore interface {
 	getCNCIWorkloadID() (id string, err error)
 	getWorkloadNoCache(id string) (*workload, error)
 	getWorkloadsNoCache() ([]*workload, error)
+	addWorkload(wl *workload) (err error)
+	updateWorkload(wl *workload) (err error)
+	deleteWorkload(id string) (err error)
+
+	// interfaces related to key pairs
+	addKeyPair(kp *types.KeyPair) (err error)
+	getKeyPairs(tenantID string, userID string) (keyPairs []*types.KeyPair, err error)
+	deleteKeyPair(tenantID string, userID string, name string) (err error)
 
 	// interfaces related to tenants
 	addLimit(tenantID string, resourceID int, limit int) (err error)
@@ -91,7 +101,10 @@ type persistentStore interface {
 
 	// interfaces related to instances
 	getInstances() (instances []*types.Instance, err error)
+	updateInstanceMetadata(instanceID string, metadata map[string]string) (err error)
+	updateInstanceTags(instanceID string, tags []string) (err error)
 	addInstance(instance *types.Instance) (err error)
+	updateInstance(instance *types.Instance) (err error)
 	removeInstance(instanceID string) (err error)
 
 	// interfaces related to statistics
@@ -130,6 +143,14 @@ type Datastore struct {
 	instances     map[string]*types.Instance
 	instancesLock *sync.RWMutex
 
+	// serializes changes to instance metadata and tags
+	instanceMetadataLock *sync.Mutex
+
+	// flavor and usage of instances before their last resize,
+	// restored if the launcher fails to resize them.
+	// protected by instancesLock.
+	resizes map[string]resize
+
 	tenantUsage     map[string][]payloads.CiaoUsage
 	tenantUsageLock *sync.RWMutex
 }
@@ -166,6 +187,8 @@ func (ds *Datastore) Init(config Config) error {
 	// cache all our instances prior to getting tenants
 	ds.instancesLock = &sync.RWMutex{}
 	ds.instances = make(map[string]*types.Instance)
+	ds.resizes = make(map[string]resize)
+	ds.instanceMetadataLock = &sync.Mutex{}
 
 	instances, err := ds.db.getInstances()
 	if err != nil {
@@ -400,6 +423,219 @@ func (ds *Datastore) GetWorkloads() ([]*types.Workload, error) {
 	return workloads, nil
 }
 
+// AddWorkload stores a new workload.  The ID of the workload must not
+// already be in use.
+func (ds *Datastore) AddWorkload(w types.Workload) error {
+	if _, err := uuid.Parse(w.ID); err != nil {
+		return fmt.Errorf("Invalid workload ID %s", w.ID)
+	}
+
+	if _, err := ds.getWorkload(w.ID); err == nil {
+		return ErrWorkloadExists
+	}
+
+	wl := &workload{Workload: w}
+	err := ds.db.addWorkload(wl)
+	if err != nil {
+		return err
+	}
+
+	ds.workloadsLock.Lock()
+	ds.workloads[wl.ID] = wl
+	ds.workloadsLock.Unlock()
+
+	return nil
+}
+
+// workloadExists checks the cache for a workload.
+func (ds *Datastore) workloadExists(id string) bool {
+	ds.workloadsLock.RLock()
+	_, ok := ds.workloads[id]
+	ds.workloadsLock.RUnlock()
+
+	return ok
+}
+
+// workloadInUse checks whether there are instances of a workload,
+// including instances that may be restored to it if their resize fails.
+// The caller must hold instancesLock.
+func (ds *Datastore) workloadInUse(id string) bool {
+	for _, i := range ds.instances {
+		if i.WorkloadID == id {
+			return true
+		}
+	}
+
+	for _, r := range ds.resizes {
+		if r.workloadID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameInstanceSettings checks whether two versions of a workload start
+// instances with the same firmware, hypervisor, image and resources.
+func sameInstanceSettings(a *types.Workload, b *types.Workload) bool {
+	if a.FWType != b.FWType || a.VMType != b.VMType ||
+		a.ImageID != b.ImageID || a.ImageName != b.ImageName ||
+		len(a.Defaults) != len(b.Defaults) {
+		return false
+	}
+
+	// the order of the resources does not matter
+	resources := make(map[payloads.RequestedResource]int)
+	for _, r := range a.Defaults {
+		resources[r]++
+	}
+	for _, r := range b.Defaults {
+		if resources[r] == 0 {
+			return false
+		}
+		resources[r]--
+	}
+
+	return true
+}
+
+// UpdateWorkload replaces the description, image, cloud-init configuration
+// and default resources of an existing workload.  Only the description and
+// the configuration may be changed while there are instances of the
+// workload, as these instances are restarted and accounted for with the
+// image and resources they were created with.
+func (ds *Datastore) UpdateWorkload(w types.Workload) error {
+	old, err := ds.getWorkload(w.ID)
+	if err != nil {
+		return ErrNoWorkload
+	}
+
+	if w.ID == ds.cnciWorkloadID {
+		return ErrCNCIWorkload
+	}
+
+	// hold off new instances of the workload until it is updated
+	ds.instancesLock.RLock()
+	defer ds.instancesLock.RUnlock()
+
+	if !sameInstanceSettings(&old.Workload, &w) && ds.workloadInUse(w.ID) {
+		return ErrWorkloadInUse
+	}
+
+	wl := &workload{Workload: w}
+	err = ds.db.updateWorkload(wl)
+	if err != nil {
+		return err
+	}
+
+	ds.workloadsLock.Lock()
+	ds.workloads[wl.ID] = wl
+	ds.workloadsLock.Unlock()
+
+	return nil
+}
+
+// DeleteWorkload removes a workload.  A workload cannot be deleted while
+// there are instances of it.
+func (ds *Datastore) DeleteWorkload(id string) error {
+	_, err := ds.getWorkload(id)
+	if err != nil {
+		return ErrNoWorkload
+	}
+
+	if id == ds.cnciWorkloadID {
+		return ErrCNCIWorkload
+	}
+
+	// hold off new instances of the workload until it is deleted
+	ds.instancesLock.RLock()
+	defer ds.instancesLock.RUnlock()
+
+	if ds.workloadInUse(id) {
+		return ErrWorkloadInUse
+	}
+
+	err = ds.db.deleteWorkload(id)
+	if err != nil {
+		return err
+	}
+
+	ds.workloadsLock.Lock()
+	delete(ds.workloads, id)
+	ds.workloadsLock.Unlock()
+
+	return nil
+}
+
+var (
+	// ErrNoWorkload is returned when a workload is not found.
+	ErrNoWorkload = errors.New("Workload Not Found")
+
+	// ErrWorkloadExists is returned when adding a workload with the
+	// ID of an existing workload.
+	ErrWorkloadExists = errors.New("Workload already exists")
+
+	// ErrWorkloadInUse is returned when deleting a workload, or changing
+	// how it starts instances, while there are instances of it.
+	ErrWorkloadInUse = errors.New("Workload in use")
+
+	// ErrCNCIWorkload is returned when modifying or deleting the CNCI
+	// workload.
+	ErrCNCIWorkload = errors.New("CNCI Workload may not be modified or deleted")
+)
+
+var (
+	// ErrNoKeyPair is returned when a key pair is not found.
+	ErrNoKeyPair = errors.New("Key pair not found")
+
+	// ErrKeyPairExists is returned when adding a key pair with a
+	// name already used by the same user.
+	ErrKeyPairExists = errors.New("Key pair already exists")
+)
+
+// AddKeyPair stores a new key pair.  Key pair names are unique per user
+// of a tenant.
+func (ds *Datastore) AddKeyPair(kp types.KeyPair) error {
+	if _, err := ds.GetKeyPair(kp.TenantID, kp.UserID, kp.Name); err == nil {
+		return ErrKeyPairExists
+	}
+
+	return ds.db.addKeyPair(&kp)
+}
+
+// GetKeyPairs returns the key pairs of a user of a tenant, or of all
+// the users of the tenant if userID is empty.
+func (ds *Datastore) GetKeyPairs(tenantID string, userID string) ([]*types.KeyPair, error) {
+	return ds.db.getKeyPairs(tenantID, userID)
+}
+
+// GetKeyPair returns the key pair with the given name owned by a user
+// of a tenant.
+func (ds *Datastore) GetKeyPair(tenantID string, userID string, name string) (*types.KeyPair, error) {
+	keyPairs, err := ds.db.getKeyPairs(tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kp := range keyPairs {
+		if kp.Name == name {
+			return kp, nil
+		}
+	}
+
+	return nil, ErrNoKeyPair
+}
+
+// DeleteKeyPair removes the key pair with the given name owned by a user
+// of a tenant.  Instances already started with the key are not affected.
+func (ds *Datastore) DeleteKeyPair(tenantID string, userID string, name string) error {
+	if _, err := ds.GetKeyPair(tenantID, userID, name); err != nil {
+		return err
+	}
+
+	return ds.db.deleteKeyPair(tenantID, userID, name)
+}
+
 // AddCNCIIP will associate a new IP address with an existing CNCI
 // via the mac address
 func (ds *Datastore) AddCNCIIP(cnciMAC string, ip string) error {
@@ -685,6 +921,220 @@ func (ds *Datastore) GetInstance(id string) (*types.Instance, error) {
 	return value, nil
 }
 
+// updateCachedInstance applies update to the cached copies of an instance.
+// The tenant cache may hold a different copy of an instance read from the
+// database at start up.
+func (ds *Datastore) updateCachedInstance(instanceID string, update func(*types.Instance)) {
+	ds.instancesLock.Lock()
+	i := ds.instances[instanceID]
+	if i != nil {
+		update(i)
+	}
+	ds.instancesLock.Unlock()
+
+	if i == nil {
+		return
+	}
+
+	ds.tenantsLock.Lock()
+	if t := ds.tenants[i.TenantID]; t != nil {
+		if ti := t.instances[instanceID]; ti != nil && ti != i {
+			update(ti)
+		}
+	}
+	ds.tenantsLock.Unlock()
+}
+
+var (
+	// ErrNoInstanceMetadata is returned when an instance metadata
+	// item is not found.
+	ErrNoInstanceMetadata = errors.New("Metadata item not found")
+
+	// ErrNoInstanceTag is returned when an instance tag is not found.
+	ErrNoInstanceTag = errors.New("Tag not found")
+
+	// ErrTooManyInstanceTags is returned when adding a tag to an
+	// instance that already has the maximum number of tags.
+	ErrTooManyInstanceTags = errors.New("Too many tags")
+)
+
+// uniqueTags returns a sorted copy of tags without duplicates.
+func uniqueTags(tags []string) []string {
+	seen := make(map[string]bool)
+	t := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !seen[tag] {
+			seen[tag] = true
+			t = append(t, tag)
+		}
+	}
+	sort.Strings(t)
+
+	return t
+}
+
+// updateInstanceMetadata computes the new metadata of an instance
+// from its current metadata with update, then stores it.  Updates are
+// serialized so that concurrent changes are not lost.
+func (ds *Datastore) updateInstanceMetadata(instanceID string,
+	update func(current map[string]string) (map[string]string, error)) (map[string]string, error) {
+	ds.instanceMetadataLock.Lock()
+	defer ds.instanceMetadataLock.Unlock()
+
+	i, err := ds.GetInstance(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := update(i.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ds.db.updateInstanceMetadata(instanceID, md)
+	if err != nil {
+		return nil, err
+	}
+
+	ds.updateCachedInstance(instanceID, func(i *types.Instance) {
+		i.Metadata = md
+	})
+
+	return md, nil
+}
+
+// SetInstanceMetadata replaces the metadata key/value pairs of an instance.
+func (ds *Datastore) SetInstanceMetadata(instanceID string, metadata map[string]string) error {
+	_, err := ds.updateInstanceMetadata(instanceID, func(map[string]string) (map[string]string, error) {
+		md := make(map[string]string, len(metadata))
+		for key, value := range metadata {
+			md[key] = value
+		}
+		return md, nil
+	})
+
+	return err
+}
+
+// MergeInstanceMetadata adds metadata key/value pairs to an instance,
+// replacing the values of existing keys.  It returns the resulting
+// metadata of the instance.
+func (ds *Datastore) MergeInstanceMetadata(instanceID string, metadata map[string]string) (map[string]string, error) {
+	return ds.updateInstanceMetadata(instanceID, func(current map[string]string) (map[string]string, error) {
+		md := make(map[string]string, len(current)+len(metadata))
+		for key, value := range current {
+			md[key] = value
+		}
+		for key, value := range metadata {
+			md[key] = value
+		}
+		return md, nil
+	})
+}
+
+// DeleteInstanceMetadata removes a metadata key from an instance.
+func (ds *Datastore) DeleteInstanceMetadata(instanceID string, key string) error {
+	_, err := ds.updateInstanceMetadata(instanceID, func(current map[string]string) (map[string]string, error) {
+		if _, ok := current[key]; !ok {
+			return nil, ErrNoInstanceMetadata
+		}
+
+		md := make(map[string]string, len(current))
+		for k, v := range current {
+			if k != key {
+				md[k] = v
+			}
+		}
+		return md, nil
+	})
+
+	return err
+}
+
+// updateInstanceTags computes the new tags of an instance from its
+// current tags with update, then stores them.  Updates are serialized
+// so that concurrent changes are not lost.
+func (ds *Datastore) updateInstanceTags(instanceID string,
+	update func(current []string) ([]string, error)) ([]string, error) {
+	ds.instanceMetadataLock.Lock()
+	defer ds.instanceMetadataLock.Unlock()
+
+	i, err := ds.GetInstance(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := update(i.Tags)
+	if err != nil {
+		return nil, err
+	}
+	tags = uniqueTags(tags)
+
+	err = ds.db.updateInstanceTags(instanceID, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	ds.updateCachedInstance(instanceID, func(i *types.Instance) {
+		i.Tags = tags
+	})
+
+	return tags, nil
+}
+
+// SetInstanceTags replaces the tags of an instance.  Duplicate tags
+// are ignored.
+func (ds *Datastore) SetInstanceTags(instanceID string, tags []string) error {
+	_, err := ds.updateInstanceTags(instanceID, func([]string) ([]string, error) {
+		return tags, nil
+	})
+
+	return err
+}
+
+// AddInstanceTag adds a tag to an instance, unless the instance already
+// has max tags.  It returns false if the instance already had the tag.
+func (ds *Datastore) AddInstanceTag(instanceID string, tag string, max int) (bool, error) {
+	added := false
+
+	_, err := ds.updateInstanceTags(instanceID, func(current []string) ([]string, error) {
+		for _, t := range current {
+			if t == tag {
+				return current, nil
+			}
+		}
+
+		if len(current) >= max {
+			return nil, ErrTooManyInstanceTags
+		}
+
+		added = true
+		return append([]string{tag}, current...), nil
+	})
+
+	return added, err
+}
+
+// DeleteInstanceTag removes a tag from an instance.
+func (ds *Datastore) DeleteInstanceTag(instanceID string, tag string) error {
+	_, err := ds.updateInstanceTags(instanceID, func(current []string) ([]string, error) {
+		tags := make([]string, 0, len(current))
+		for _, t := range current {
+			if t != tag {
+				tags = append(tags, t)
+			}
+		}
+
+		if len(tags) == len(current) {
+			return nil, ErrNoInstanceTag
+		}
+
+		return tags, nil
+	})
+
+	return err
+}
+
 // GetAllInstancesFromTenant will retrieve all instances belonging to a specific tenant
 func (ds *Datastore) GetAllInstancesFromTenant(tenantID string) ([]*types.Instance, error) {
 	var instances []*types.Instance
@@ -728,9 +1178,27 @@ func (ds *Datastore) GetAllInstancesByNode(nodeID string) ([]*types.Instance, er
 // AddInstance will store a new instance in the datastore.
 // The instance will be updated both in the cache and in the database
 func (ds *Datastore) AddInstance(instance *types.Instance) error {
-	// add to cache
+	if instance.Metadata == nil {
+		instance.Metadata = make(map[string]string)
+	}
+	instance.Tags = uniqueTags(instance.Tags)
+
+	// the workload cannot be deleted while instancesLock is held
 	ds.instancesLock.Lock()
 
+	if !ds.workloadExists(instance.WorkloadID) {
+		ds.instancesLock.Unlock()
+		return ErrNoWorkload
+	}
+
+	// the instance, its name, metadata and tags are stored together
+	err := ds.db.addInstance(instance)
+	if err != nil {
+		ds.instancesLock.Unlock()
+		return err
+	}
+
+	// add to cache
 	ds.instances[instance.ID] = instance
 
 	instanceStat := payloads.CiaoServerStats{
@@ -773,9 +1241,6 @@ func (ds *Datastore) AddInstance(instance *types.Instance) error {
 
 	ds.tenantsLock.Unlock()
 
-	// update database asynchronously
-	go ds.db.addInstance(instance)
-
 	return nil
 }
 
@@ -806,6 +1271,137 @@ func (ds *Datastore) StopFailure(instanceID string, reason payloads.StopFailureR
 	return nil
 }
 
+// InstanceActionFailure logs the failure of an instance action, e.g.,
+// PAUSE, in the datastore.
+func (ds *Datastore) InstanceActionFailure(instanceID string, action string, reason payloads.InstanceActionFailureReason) error {
+	i, err := ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("%s Failure %s: %s", strings.Title(strings.ToLower(action)), instanceID, reason.String())
+
+	ds.db.logEvent(i.TenantID, string(userError), msg)
+
+	return nil
+}
+
+// ResizeFailure restores the workload and resource usage an instance had
+// before its last resize, once the launcher has reported that the instance
+// could not be resized.
+func (ds *Datastore) ResizeFailure(instanceID string) error {
+	ds.instancesLock.Lock()
+	old, ok := ds.resizes[instanceID]
+	delete(ds.resizes, instanceID)
+	ds.instancesLock.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	_, err := ds.resizeInstance(instanceID, old.workloadID, old.usage)
+
+	return err
+}
+
+// ErrNoResize is returned when confirming the resize of an instance
+// that has no resize to confirm.
+var ErrNoResize = errors.New("Instance has no resize to confirm")
+
+// ConfirmResize confirms the last resize of an instance.  The previous
+// workload and usage of the instance are forgotten, so a later resize
+// failure report can no longer restore them.
+func (ds *Datastore) ConfirmResize(instanceID string) error {
+	ds.instancesLock.Lock()
+	defer ds.instancesLock.Unlock()
+
+	if _, ok := ds.resizes[instanceID]; !ok {
+		return ErrNoResize
+	}
+
+	delete(ds.resizes, instanceID)
+
+	return nil
+}
+
+// UpdateInstanceState sets the state of an instance in the cache.  It is
+// used to report transitional states, e.g., rebooting, until the next
+// statistics for the instance are received from its node.
+func (ds *Datastore) UpdateInstanceState(instanceID string, state string) error {
+	ds.instancesLock.Lock()
+	defer ds.instancesLock.Unlock()
+
+	i, ok := ds.instances[instanceID]
+	if !ok {
+		return errors.New("Instance Not Found")
+	}
+
+	i.State = state
+
+	return nil
+}
+
+type resize struct {
+	workloadID string
+	usage      map[string]int
+}
+
+// ResizeInstance assigns a new workload and resource usage to an instance.
+// The tenant usage is adjusted accordingly and the instance is updated both
+// in the cache and in the database.  The previous workload and usage are
+// restored if the launcher reports that the resize failed.
+func (ds *Datastore) ResizeInstance(instanceID string, workloadID string, usage map[string]int) error {
+	old, err := ds.resizeInstance(instanceID, workloadID, usage)
+	if err != nil {
+		return err
+	}
+
+	ds.instancesLock.Lock()
+	ds.resizes[instanceID] = old
+	ds.instancesLock.Unlock()
+
+	return nil
+}
+
+func (ds *Datastore) resizeInstance(instanceID string, workloadID string, usage map[string]int) (resize, error) {
+	ds.instancesLock.Lock()
+
+	i, ok := ds.instances[instanceID]
+	if !ok {
+		ds.instancesLock.Unlock()
+		return resize{}, errors.New("Instance Not Found")
+	}
+
+	if !ds.workloadExists(workloadID) {
+		ds.instancesLock.Unlock()
+		return resize{}, ErrNoWorkload
+	}
+
+	old := resize{
+		workloadID: i.WorkloadID,
+		usage:      i.Usage,
+	}
+	oldUsage := i.Usage
+	i.WorkloadID = workloadID
+	i.Usage = usage
+
+	ds.instancesLock.Unlock()
+
+	ds.tenantsLock.Lock()
+
+	tenant := ds.tenants[i.TenantID]
+	if tenant != nil {
+		for j := range tenant.Resources {
+			name := tenant.Resources[j].Rname
+			tenant.Resources[j].Usage += usage[name] - oldUsage[name]
+		}
+	}
+
+	ds.tenantsLock.Unlock()
+
+	return old, ds.db.updateInstance(i)
+}
+
 // StartFailure will clean up after a failure to start an instance.
 // If an instance was a CNCI, this function will remove the CNCI instance
 // for this tenant. If the instance was a normal tenant instance, the
@@ -890,6 +1486,7 @@ func (ds *Datastore) deleteInstance(instanceID string) error {
 	ds.instancesLock.Lock()
 	i := ds.instances[instanceID]
 	delete(ds.instances, instanceID)
+	delete(ds.resizes, instanceID)
 	ds.instancesLock.Unlock()
 
 	ds.tenantsLock.Lock()
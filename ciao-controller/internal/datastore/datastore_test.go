@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -1001,6 +1002,156 @@ func TestNonOverlappingTenantIP(t *testing.T) {
 	}
 }
 
+func newTestWorkload() types.Workload {
+	return types.Workload{
+		ID:          uuid.Generate().String(),
+		Description: "Test Workload",
+		FWType:      payloads.Legacy,
+		VMType:      payloads.QEMU,
+		ImageID:     uuid.Generate().String(),
+		Config:      "#cloud-config\n",
+		Defaults: []payloads.RequestedResource{
+			{Type: payloads.VCPUs, Value: 2, Mandatory: true},
+			{Type: payloads.MemMB, Value: 256, Mandatory: true},
+		},
+	}
+}
+
+func TestAddWorkload(t *testing.T) {
+	wl := newTestWorkload()
+
+	err := ds.AddWorkload(wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.AddWorkload(wl)
+	if err == nil {
+		t.Error("Duplicate workload added")
+	}
+
+	invalid := newTestWorkload()
+	invalid.ID = "../" + invalid.ID
+	err = ds.AddWorkload(invalid)
+	if err == nil {
+		t.Error("Workload with invalid ID added")
+	}
+
+	// check that the workload was persisted correctly
+	w, err := ds.db.getWorkloadNoCache(wl.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Description != wl.Description || w.ImageID != wl.ImageID ||
+		w.Config != wl.Config || len(w.Defaults) != len(wl.Defaults) {
+		t.Error("Workload not stored correctly")
+	}
+
+	err = ds.DeleteWorkload(wl.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.GetWorkload(wl.ID)
+	if err == nil {
+		t.Error("Workload not deleted")
+	}
+}
+
+func TestUpdateWorkload(t *testing.T) {
+	wl := newTestWorkload()
+
+	err := ds.AddWorkload(wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wl.Description = "Updated Workload"
+	wl.Config = "#cloud-config\nruncmd:\n"
+	wl.Defaults = []payloads.RequestedResource{
+		{Type: payloads.VCPUs, Value: 4, Mandatory: true},
+		{Type: payloads.MemMB, Value: 512, Mandatory: true},
+		{Type: payloads.DiskMB, Value: 1024, Mandatory: true},
+	}
+
+	err = ds.UpdateWorkload(wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ds.db.getWorkloadNoCache(wl.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Description != wl.Description || w.Config != wl.Config ||
+		len(w.Defaults) != len(wl.Defaults) {
+		t.Error("Workload not updated correctly")
+	}
+
+	err = ds.DeleteWorkload(wl.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.UpdateWorkload(wl)
+	if err != ErrNoWorkload {
+		t.Errorf("expected %v, got %v", ErrNoWorkload, err)
+	}
+
+	err = ds.DeleteWorkload(wl.ID)
+	if err != ErrNoWorkload {
+		t.Errorf("expected %v, got %v", ErrNoWorkload, err)
+	}
+}
+
+func TestDeleteWorkloadInUse(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wl := newTestWorkload()
+
+	err = ds.AddWorkload(wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, &wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeleteWorkload(wl.ID)
+	if err == nil {
+		t.Error("Workload in use deleted")
+	}
+
+	err = ds.DeleteInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeleteWorkload(wl.ID)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeleteCNCIWorkload(t *testing.T) {
+	id, err := ds.GetCNCIWorkloadID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeleteWorkload(id)
+	if err == nil {
+		t.Error("CNCI workload deleted")
+	}
+}
+
 func TestGetCNCIWorkloadID(t *testing.T) {
 	_, err := ds.db.getCNCIWorkloadID()
 	if err != nil {
@@ -1008,6 +1159,71 @@ func TestGetCNCIWorkloadID(t *testing.T) {
 	}
 }
 
+func TestKeyPairs(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kp := types.KeyPair{
+		TenantID:    tenant.ID,
+		UserID:      "user1",
+		Name:        "key1",
+		PublicKey:   "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAAgQDB test@ciao",
+		Fingerprint: "00:11:22:33:44:55:66:77:88:99:aa:bb:cc:dd:ee:ff",
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	err = ds.AddKeyPair(kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.AddKeyPair(kp)
+	if err != ErrKeyPairExists {
+		t.Fatalf("expected %v, got %v", ErrKeyPairExists, err)
+	}
+
+	kp.UserID = "user2"
+	err = ds.AddKeyPair(kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPairs, err := ds.GetKeyPairs(tenant.ID, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keyPairs) != 1 || keyPairs[0].PublicKey != kp.PublicKey {
+		t.Fatal("Incorrect key pairs returned for user")
+	}
+
+	keyPairs, err = ds.GetKeyPairs(tenant.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keyPairs) != 2 {
+		t.Fatal("Incorrect key pairs returned for tenant")
+	}
+
+	err = ds.DeleteKeyPair(tenant.ID, "user1", kp.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.GetKeyPair(tenant.ID, "user1", kp.Name)
+	if err != ErrNoKeyPair {
+		t.Fatalf("expected %v, got %v", ErrNoKeyPair, err)
+	}
+
+	err = ds.DeleteKeyPair(tenant.ID, "user1", kp.Name)
+	if err != ErrNoKeyPair {
+		t.Fatalf("expected %v, got %v", ErrNoKeyPair, err)
+	}
+}
+
 func TestAddLimit(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
@@ -1373,6 +1589,452 @@ func TestStopFailure(t *testing.T) {
 	}
 }
 
+func TestInstanceActionFailure(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Error(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Error(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(1 * time.Second)
+	reason := payloads.ActionNoInstance
+
+	err = ds.InstanceActionFailure(instance.ID, "PAUSE", reason)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateInstanceState(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Error(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Error(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = ds.UpdateInstanceState(instance.ID, payloads.ComputeStatusRebooting)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.State != payloads.ComputeStatusRebooting {
+		t.Errorf("expected state %s, got %s", payloads.ComputeStatusRebooting, i.State)
+	}
+
+	err = ds.UpdateInstanceState(uuid.Generate().String(), payloads.Running)
+	if err == nil {
+		t.Error("state of unknown instance updated")
+	}
+}
+
+func TestInstanceMetadataAndTags(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := map[string]string{"role": "web", "owner": "ops"}
+	err = ds.SetInstanceMetadata(instance.ID, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.SetInstanceTags(instance.ID, []string{"prod", "blue", "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := []string{"blue", "prod"}
+	if !reflect.DeepEqual(i.Metadata, metadata) {
+		t.Errorf("expected metadata %v, got %v", metadata, i.Metadata)
+	}
+
+	if !reflect.DeepEqual(i.Tags, tags) {
+		t.Errorf("expected tags %v, got %v", tags, i.Tags)
+	}
+
+	stored := []*types.Instance{{ID: instance.ID}}
+	err = ds.db.(*sqliteDB).getInstanceMetadataAndTags(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(stored[0].Metadata, metadata) {
+		t.Errorf("expected stored metadata %v, got %v", metadata, stored[0].Metadata)
+	}
+
+	if !reflect.DeepEqual(stored[0].Tags, tags) {
+		t.Errorf("expected stored tags %v, got %v", tags, stored[0].Tags)
+	}
+
+	err = ds.SetInstanceTags(uuid.Generate().String(), tags)
+	if err == nil {
+		t.Error("tags of unknown instance updated")
+	}
+}
+
+func TestAddInstanceMetadataAndTags(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, err := ds.AllocateTenantIP(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := map[string]string{"role": "db"}
+	instance := &types.Instance{
+		TenantID:   tenant.ID,
+		WorkloadID: wls[0].ID,
+		State:      payloads.Pending,
+		ID:         uuid.Generate().String(),
+		IPAddress:  ip.String(),
+		MACAddress: newTenantHardwareAddr(ip).String(),
+		Usage:      map[string]int{},
+		Name:       "db-1",
+		Metadata:   metadata,
+		Tags:       []string{"prod", "blue", "prod"},
+	}
+
+	err = ds.AddInstance(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the instance must be stored with its metadata and tags
+	// by the time AddInstance returns.
+	stored := []*types.Instance{{ID: instance.ID}}
+	err = ds.db.(*sqliteDB).getInstanceMetadataAndTags(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := []string{"blue", "prod"}
+	if !reflect.DeepEqual(stored[0].Metadata, metadata) {
+		t.Errorf("expected stored metadata %v, got %v", metadata, stored[0].Metadata)
+	}
+
+	if !reflect.DeepEqual(stored[0].Tags, tags) {
+		t.Errorf("expected stored tags %v, got %v", tags, stored[0].Tags)
+	}
+}
+
+func TestUpdateInstanceMetadataAndTags(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.MergeInstanceMetadata(instance.ID, map[string]string{"role": "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := ds.MergeInstanceMetadata(instance.ID, map[string]string{"owner": "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := map[string]string{"role": "web", "owner": "ops"}
+	if !reflect.DeepEqual(md, metadata) {
+		t.Errorf("expected metadata %v, got %v", metadata, md)
+	}
+
+	err = ds.DeleteInstanceMetadata(instance.ID, "role")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeleteInstanceMetadata(instance.ID, "role")
+	if err != ErrNoInstanceMetadata {
+		t.Errorf("expected %v, got %v", ErrNoInstanceMetadata, err)
+	}
+
+	for _, tag := range []string{"prod", "blue"} {
+		added, err := ds.AddInstanceTag(instance.ID, tag, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !added {
+			t.Errorf("tag %s not added", tag)
+		}
+	}
+
+	added, err := ds.AddInstanceTag(instance.ID, "prod", 2)
+	if err != nil || added {
+		t.Errorf("existing tag added again: %v", err)
+	}
+
+	_, err = ds.AddInstanceTag(instance.ID, "green", 2)
+	if err != ErrTooManyInstanceTags {
+		t.Errorf("expected %v, got %v", ErrTooManyInstanceTags, err)
+	}
+
+	err = ds.DeleteInstanceTag(instance.ID, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeleteInstanceTag(instance.ID, "prod")
+	if err != ErrNoInstanceTag {
+		t.Errorf("expected %v, got %v", ErrNoInstanceTag, err)
+	}
+
+	i, err := ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata = map[string]string{"owner": "ops"}
+	if !reflect.DeepEqual(i.Metadata, metadata) {
+		t.Errorf("expected metadata %v, got %v", metadata, i.Metadata)
+	}
+
+	tags := []string{"blue"}
+	if !reflect.DeepEqual(i.Tags, tags) {
+		t.Errorf("expected tags %v, got %v", tags, i.Tags)
+	}
+}
+
+func TestResizeInstance(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Error(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Error(err)
+	}
+
+	tenantBefore, err := ds.getTenant(tenant.ID)
+	if err != nil {
+		t.Error(err)
+	}
+
+	resourcesBefore := make(map[string]int)
+	for i := range tenantBefore.Resources {
+		r := tenantBefore.Resources[i]
+		resourcesBefore[r.Rname] = r.Usage
+	}
+
+	oldUsage := instance.Usage
+	usage := make(map[string]int)
+	for name, val := range oldUsage {
+		usage[name] = val * 2
+	}
+
+	time.Sleep(1 * time.Second)
+
+	err = ds.ResizeInstance(instance.ID, wls[0].ID, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenantAfter, err := ds.getTenant(tenant.ID)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// make sure usage was increased by the difference between flavors
+	for i := range tenantAfter.Resources {
+		r := tenantAfter.Resources[i]
+		before := resourcesBefore[r.Rname]
+		delta := usage[r.Rname] - oldUsage[r.Rname]
+
+		if r.Usage != before+delta {
+			t.Errorf("%s usage not updated", r.Rname)
+		}
+	}
+}
+
+func TestResizeInstanceFailure(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	tenantBefore, err := ds.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resourcesBefore := make(map[string]int)
+	for _, r := range tenantBefore.Resources {
+		resourcesBefore[r.Rname] = r.Usage
+	}
+
+	oldUsage := instance.Usage
+	usage := make(map[string]int)
+	for name, val := range oldUsage {
+		usage[name] = val * 2
+	}
+
+	newWorkload := wls[len(wls)-1].ID
+	err = ds.ResizeInstance(instance.ID, newWorkload, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.ResizeFailure(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.WorkloadID != wls[0].ID {
+		t.Errorf("expected workload %s, got %s", wls[0].ID, i.WorkloadID)
+	}
+
+	if !reflect.DeepEqual(i.Usage, oldUsage) {
+		t.Errorf("expected usage %v, got %v", oldUsage, i.Usage)
+	}
+
+	tenantAfter, err := ds.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range tenantAfter.Resources {
+		if r.Usage != resourcesBefore[r.Rname] {
+			t.Errorf("%s usage not restored", r.Rname)
+		}
+	}
+}
+
+func TestConfirmResize(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.ConfirmResize(instance.ID)
+	if err != ErrNoResize {
+		t.Errorf("expected %v, got %v", ErrNoResize, err)
+	}
+
+	newWorkload := wls[len(wls)-1].ID
+	err = ds.ResizeInstance(instance.ID, newWorkload, instance.Usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.ConfirmResize(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a confirmed resize is not rolled back
+	err = ds.ResizeFailure(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.WorkloadID != newWorkload {
+		t.Errorf("expected workload %s, got %s", newWorkload, i.WorkloadID)
+	}
+}
+
 func TestStartFailureFullCloud(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
@@ -112,6 +112,26 @@ func (d logData) Init() error {
 	return d.ds.exec(d.db, cmd)
 }
 
+type keyPairData struct {
+	namedData
+}
+
+func (d keyPairData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS keypairs
+		(
+		tenant_id string,
+		user_id string,
+		name string,
+		public_key string,
+		fingerprint string,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		foreign key(tenant_id) references tenants(id),
+		unique(tenant_id, user_id, name)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
 type subnetData struct {
 	namedData
 }
@@ -178,11 +198,51 @@ func (d instanceData) Init() error {
 		workload_id string,
 		mac_address string,
 		ip string,
+		name string DEFAULT '',
 		foreign key(tenant_id) references tenants(id),
 		foreign key(workload_id) references workload_template(id),
 		unique(tenant_id, ip, mac_address)
 		);`
 
+	err := d.ds.exec(d.db, cmd)
+	if err != nil {
+		return err
+	}
+
+	// databases created before servers had names lack the name column
+	return d.ds.addColumn(d.db, "instances", "name", "string DEFAULT ''")
+}
+
+type instanceMetadataData struct {
+	namedData
+}
+
+func (d instanceMetadataData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS instance_metadata
+		(
+		instance_id string,
+		key string,
+		value string,
+		foreign key(instance_id) references instances(id),
+		unique(instance_id, key)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type instanceTagData struct {
+	namedData
+}
+
+func (d instanceTagData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS instance_tags
+		(
+		instance_id string,
+		tag string,
+		foreign key(instance_id) references instances(id),
+		unique(instance_id, tag)
+		);`
+
 	return d.ds.exec(d.db, cmd)
 }
 
@@ -467,6 +527,36 @@ func (ds *sqliteDB) exec(db *sql.DB, cmd string) error {
 	return err
 }
 
+// addColumn adds a column to a table if it does not have it yet.
+func (ds *sqliteDB) addColumn(db *sql.DB, table string, column string, definition string) error {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue interface{}
+
+		err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk)
+		if err != nil {
+			return err
+		}
+
+		if name == column {
+			return nil
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	return ds.exec(db, "ALTER TABLE "+table+" ADD COLUMN "+column+" "+definition)
+}
+
 func (ds *sqliteDB) create(tableName string, record ...interface{}) error {
 	// get database location of this table
 	db := ds.getTableDB(tableName)
@@ -527,12 +617,15 @@ func getPersistentStore(config Config) (persistentStore, error) {
 		tenantData{namedData{ds: ds, name: "tenants", db: ds.db}},
 		limitsData{namedData{ds: ds, name: "limits", db: ds.db}},
 		instanceData{namedData{ds: ds, name: "instances", db: ds.db}},
+		instanceMetadataData{namedData{ds: ds, name: "instance_metadata", db: ds.db}},
+		instanceTagData{namedData{ds: ds, name: "instance_tags", db: ds.db}},
 		workloadTemplateData{namedData{ds: ds, name: "workload_template", db: ds.db}},
 		workloadResourceData{namedData{ds: ds, name: "workload_resources", db: ds.db}},
 		usageData{namedData{ds: ds, name: "usage", db: ds.db}},
 		nodeStatisticsData{namedData{ds: ds, name: "node_statistics", db: ds.tdb}},
 		logData{namedData{ds: ds, name: "log", db: ds.tdb}},
 		subnetData{namedData{ds: ds, name: "tenant_network", db: ds.db}},
+		keyPairData{namedData{ds: ds, name: "keypairs", db: ds.db}},
 		instanceStatisticsData{namedData{ds: ds, name: "instance_statistics", db: ds.tdb}},
 		frameStatisticsData{namedData{ds: ds, name: "frame_statistics", db: ds.tdb}},
 		traceData{namedData{ds: ds, name: "trace_data", db: ds.tdb}},
@@ -961,6 +1054,242 @@ func (ds *sqliteDB) getWorkloadsNoCache() ([]*workload, error) {
 	return workloads, nil
 }
 
+func (ds *sqliteDB) addWorkloadResources(tx *sql.Tx, wl *workload) error {
+	cmd := `INSERT INTO workload_resources
+		(workload_id, resource_id, default_value, estimated_value, mandatory)
+		SELECT ?, resources.id, ?, ?, ?
+		FROM resources
+		WHERE name = ?`
+
+	for _, r := range wl.Defaults {
+		_, err := tx.Exec(cmd, wl.ID, r.Value, r.Value, r.Mandatory, string(r.Type))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeWorkloadConfig stores the cloud-init configuration of a workload
+// created through the API in a temporary file in the workloads path.
+// The file is only given its final name by commitWorkloadConfig, once
+// the workload is stored in the database.  Seeded workloads may share
+// configuration files so these are never overwritten.
+func (ds *sqliteDB) writeWorkloadConfig(wl *workload) (string, error) {
+	wl.filename = wl.ID + ".yaml"
+
+	f, err := ioutil.TempFile(ds.workloadsPath, ".workload")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.Write([]byte(wl.Config))
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// commitWorkloadConfig moves a configuration file written by
+// writeWorkloadConfig to its final location, or removes it if the
+// workload could not be stored.
+func (ds *sqliteDB) commitWorkloadConfig(wl *workload, tmp string, err error) error {
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, fmt.Sprintf("%s/%s", ds.workloadsPath, wl.filename))
+}
+
+func (ds *sqliteDB) addWorkload(wl *workload) error {
+	datastore := ds.getTableDB("workload_template")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tmp, err := ds.writeWorkloadConfig(wl)
+	if err != nil {
+		return err
+	}
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		return ds.commitWorkloadConfig(wl, tmp, err)
+	}
+
+	cmd := `INSERT INTO workload_template
+		(id, description, filename, fw_type, vm_type, image_id, image_name, internal)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 0)`
+
+	_, err = tx.Exec(cmd, wl.ID, wl.Description, wl.filename, wl.FWType,
+		string(wl.VMType), wl.ImageID, wl.ImageName)
+	if err == nil {
+		err = ds.addWorkloadResources(tx, wl)
+	}
+
+	if err != nil {
+		tx.Rollback()
+	} else {
+		err = tx.Commit()
+	}
+
+	return ds.commitWorkloadConfig(wl, tmp, err)
+}
+
+func (ds *sqliteDB) updateWorkload(wl *workload) error {
+	datastore := ds.getTableDB("workload_template")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tmp, err := ds.writeWorkloadConfig(wl)
+	if err != nil {
+		return err
+	}
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		return ds.commitWorkloadConfig(wl, tmp, err)
+	}
+
+	cmd := `UPDATE workload_template
+		SET description = ?, filename = ?, fw_type = ?, vm_type = ?,
+		    image_id = ?, image_name = ?
+		WHERE id = ?`
+
+	_, err = tx.Exec(cmd, wl.Description, wl.filename, wl.FWType,
+		string(wl.VMType), wl.ImageID, wl.ImageName, wl.ID)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM workload_resources WHERE workload_id = ?", wl.ID)
+	}
+	if err == nil {
+		err = ds.addWorkloadResources(tx, wl)
+	}
+
+	if err != nil {
+		tx.Rollback()
+	} else {
+		err = tx.Commit()
+	}
+
+	return ds.commitWorkloadConfig(wl, tmp, err)
+}
+
+func (ds *sqliteDB) deleteWorkload(id string) error {
+	datastore := ds.getTableDB("workload_template")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	var filename string
+	err := datastore.QueryRow("SELECT filename FROM workload_template WHERE id = ?", id).Scan(&filename)
+	if err != nil {
+		return err
+	}
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM workload_resources WHERE workload_id = ?", id)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM workload_template WHERE id = ?", id)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// only remove configuration files that were written by the API
+	if filename == id+".yaml" {
+		path := fmt.Sprintf("%s/%s", ds.workloadsPath, filename)
+		err = os.Remove(path)
+		if err != nil {
+			glog.Warningf("Unable to remove workload config %s: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+func (ds *sqliteDB) addKeyPair(kp *types.KeyPair) error {
+	datastore := ds.getTableDB("keypairs")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	cmd := `INSERT INTO keypairs
+		(tenant_id, user_id, name, public_key, fingerprint, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`
+
+	_, err := datastore.Exec(cmd, kp.TenantID, kp.UserID, kp.Name,
+		kp.PublicKey, kp.Fingerprint, kp.CreatedAt)
+
+	return err
+}
+
+func (ds *sqliteDB) getKeyPairs(tenantID string, userID string) ([]*types.KeyPair, error) {
+	datastore := ds.getTableDB("keypairs")
+
+	query := `SELECT tenant_id, user_id, name, public_key, fingerprint, created_at
+		  FROM keypairs
+		  WHERE tenant_id = ?`
+	args := []interface{}{tenantID}
+
+	if userID != "" {
+		query += " AND user_id = ?"
+		args = append(args, userID)
+	}
+
+	rows, err := datastore.Query(query+" ORDER BY name", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keyPairs := make([]*types.KeyPair, 0)
+	for rows.Next() {
+		var kp types.KeyPair
+		err = rows.Scan(&kp.TenantID, &kp.UserID, &kp.Name, &kp.PublicKey,
+			&kp.Fingerprint, &kp.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keyPairs = append(keyPairs, &kp)
+	}
+
+	return keyPairs, rows.Err()
+}
+
+func (ds *sqliteDB) deleteKeyPair(tenantID string, userID string, name string) error {
+	datastore := ds.getTableDB("keypairs")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	_, err := datastore.Exec("DELETE FROM keypairs WHERE tenant_id = ? AND user_id = ? AND name = ?",
+		tenantID, userID, name)
+
+	return err
+}
+
 func (ds *sqliteDB) updateTenant(t *tenant) error {
 	db := ds.getTableDB("tenants")
 
@@ -1199,7 +1528,8 @@ func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
 		latest.ssh_port as ssh_port,
 		IFNULL(latest.node_id, "Not Assigned") as node_id,
 		mac_address,
-		ip
+		ip,
+		IFNULL(name, "") as name
 	FROM instances
 	LEFT JOIN latest
 	ON instances.id = latest.instance_id
@@ -1218,7 +1548,7 @@ func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
 
 		var sshPort sql.NullInt64
 
-		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &i.WorkloadID, &i.SSHIP, &sshPort, &i.NodeID, &i.MACAddress, &i.IPAddress)
+		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &i.WorkloadID, &i.SSHIP, &sshPort, &i.NodeID, &i.MACAddress, &i.IPAddress, &i.Name)
 		if err != nil {
 			tx.Rollback()
 			ds.tdbLock.RUnlock()
@@ -1258,6 +1588,11 @@ func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
 
 	ds.tdbLock.RUnlock()
 
+	err = ds.getInstanceMetadataAndTags(instances)
+	if err != nil {
+		return nil, err
+	}
+
 	return instances, nil
 }
 
@@ -1292,7 +1627,8 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 		workload_id,
 		latest.node_id,
 		mac_address,
-		ip
+		ip,
+		IFNULL(name, "") as name
 	FROM instances
 	LEFT JOIN latest
 	ON instances.id = latest.instance_id
@@ -1315,7 +1651,7 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 
 		i := &types.Instance{}
 
-		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &sshIP, &sshPort, &i.WorkloadID, &nodeID, &i.MACAddress, &i.IPAddress)
+		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &sshIP, &sshPort, &i.WorkloadID, &nodeID, &i.MACAddress, &i.IPAddress, &i.Name)
 		if err != nil {
 			tx.Rollback()
 			ds.tdbLock.RUnlock()
@@ -1361,21 +1697,180 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 
 	ds.tdbLock.RUnlock()
 
+	list := make([]*types.Instance, 0, len(instances))
+	for _, i := range instances {
+		list = append(list, i)
+	}
+
+	err = ds.getInstanceMetadataAndTags(list)
+	if err != nil {
+		return nil, err
+	}
+
 	return instances, nil
 }
 
+// addInstance stores an instance along with its resource usage, metadata
+// and tags in a single transaction.
 func (ds *sqliteDB) addInstance(instance *types.Instance) error {
+	datastore := ds.getTableDB("instances")
+
 	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		return err
+	}
 
-	err := ds.create("instances", instance.ID, instance.TenantID, instance.WorkloadID, instance.MACAddress, instance.IPAddress)
+	cmd := `INSERT or IGNORE INTO instances
+		(id, tenant_id, workload_id, mac_address, ip, name)
+		VALUES (?, ?, ?, ?, ?, ?)`
 
-	ds.dbLock.Unlock()
+	_, err = tx.Exec(cmd, instance.ID, instance.TenantID, instance.WorkloadID,
+		instance.MACAddress, instance.IPAddress, instance.Name)
 
+	usageCmd := `INSERT INTO usage (instance_id, resource_id, value)
+		SELECT ?, resources.id, ?
+		FROM resources
+		WHERE name = ?`
+
+	for key, val := range instance.Usage {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec(usageCmd, instance.ID, val, key)
+	}
+
+	for key, value := range instance.Metadata {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec("INSERT INTO instance_metadata (instance_id, key, value) VALUES (?, ?, ?)",
+			instance.ID, key, value)
+	}
+
+	for _, tag := range instance.Tags {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec("INSERT INTO instance_tags (instance_id, tag) VALUES (?, ?)", instance.ID, tag)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ds *sqliteDB) updateInstanceMetadata(instanceID string, metadata map[string]string) error {
+	datastore := ds.getTableDB("instance_metadata")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := datastore.Begin()
 	if err != nil {
 		return err
 	}
 
-	return ds.addUsage(instance.ID, instance.Usage)
+	_, err = tx.Exec("DELETE FROM instance_metadata WHERE instance_id = ?", instanceID)
+	for key, value := range metadata {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec("INSERT INTO instance_metadata (instance_id, key, value) VALUES (?, ?, ?)",
+			instanceID, key, value)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ds *sqliteDB) updateInstanceTags(instanceID string, tags []string) error {
+	datastore := ds.getTableDB("instance_tags")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM instance_tags WHERE instance_id = ?", instanceID)
+	for _, tag := range tags {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec("INSERT INTO instance_tags (instance_id, tag) VALUES (?, ?)", instanceID, tag)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getInstanceMetadataAndTags fills in the metadata and tags of the
+// instances read from the database.
+func (ds *sqliteDB) getInstanceMetadataAndTags(instances []*types.Instance) error {
+	byID := make(map[string]*types.Instance)
+	for _, i := range instances {
+		i.Metadata = make(map[string]string)
+		i.Tags = []string{}
+		byID[i.ID] = i
+	}
+
+	rows, err := ds.getTableDB("instance_metadata").Query("SELECT instance_id, key, value FROM instance_metadata")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, key, value string
+		err = rows.Scan(&id, &key, &value)
+		if err != nil {
+			return err
+		}
+
+		if i := byID[id]; i != nil {
+			i.Metadata[key] = value
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	tagRows, err := ds.getTableDB("instance_tags").Query("SELECT instance_id, tag FROM instance_tags ORDER BY tag")
+	if err != nil {
+		return err
+	}
+	defer tagRows.Close()
+
+	for tagRows.Next() {
+		var id, tag string
+		err = tagRows.Scan(&id, &tag)
+		if err != nil {
+			return err
+		}
+
+		if i := byID[id]; i != nil {
+			i.Tags = append(i.Tags, tag)
+		}
+	}
+
+	return tagRows.Err()
 }
 
 func (ds *sqliteDB) removeInstance(instanceID string) error {
@@ -1403,6 +1898,20 @@ func (ds *sqliteDB) removeInstance(instanceID string) error {
 		return err
 	}
 
+	_, err = tx.Exec("DELETE FROM instance_metadata WHERE instance_id = ?", instanceID)
+	if err != nil {
+		tx.Rollback()
+		ds.dbLock.Unlock()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM instance_tags WHERE instance_id = ?", instanceID)
+	if err != nil {
+		tx.Rollback()
+		ds.dbLock.Unlock()
+		return err
+	}
+
 	tx.Commit()
 
 	ds.dbLock.Unlock()
@@ -1410,8 +1919,8 @@ func (ds *sqliteDB) removeInstance(instanceID string) error {
 	return err
 }
 
-func (ds *sqliteDB) addUsage(instanceID string, usage map[string]int) error {
-	datastore := ds.getTableDB("usage")
+func (ds *sqliteDB) updateInstance(instance *types.Instance) error {
+	datastore := ds.getTableDB("instances")
 
 	ds.dbLock.Lock()
 
@@ -1421,34 +1930,39 @@ func (ds *sqliteDB) addUsage(instanceID string, usage map[string]int) error {
 		return err
 	}
 
-	cmd := `INSERT INTO usage (instance_id, resource_id, value)
-		SELECT ?, resources.id, ?
-		FROM resources
-		WHERE name = ?`
-
-	stmt, err := tx.Prepare(cmd)
+	_, err = tx.Exec("UPDATE instances SET workload_id = ? WHERE id = ?", instance.WorkloadID, instance.ID)
 	if err != nil {
 		tx.Rollback()
-		ds.tdbLock.Unlock()
+		ds.dbLock.Unlock()
 		return err
 	}
 
-	defer stmt.Close()
+	_, err = tx.Exec("DELETE FROM usage WHERE instance_id = ?", instance.ID)
+	if err != nil {
+		tx.Rollback()
+		ds.dbLock.Unlock()
+		return err
+	}
 
-	for key, val := range usage {
-		_, err := stmt.Exec(instanceID, val, key)
+	cmd := `INSERT INTO usage (instance_id, resource_id, value)
+		SELECT ?, resources.id, ?
+		FROM resources
+		WHERE name = ?`
 
+	for key, val := range instance.Usage {
+		_, err = tx.Exec(cmd, instance.ID, val, key)
 		if err != nil {
-			glog.V(2).Info(err)
-			// but keep going
+			tx.Rollback()
+			ds.dbLock.Unlock()
+			return err
 		}
 	}
 
-	tx.Commit()
+	err = tx.Commit()
 
 	ds.dbLock.Unlock()
 
-	return nil
+	return err
 }
 
 func (ds *sqliteDB) addNodeStatDB(stat payloads.Stat) error {
@@ -0,0 +1,334 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+// Package imagestore stores images for the ciao controller image service.
+// Each image is kept in a local directory as a data file named after the
+// image ID, next to a json file holding the image metadata.
+package imagestore
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+var (
+	// ErrNoImage is returned when an image is not found.
+	ErrNoImage = errors.New("Image not found")
+
+	// ErrImageExists is returned when creating an image with an ID
+	// already in use.
+	ErrImageExists = errors.New("Image already exists")
+
+	// ErrImageUploaded is returned when uploading data to an image
+	// which already has data, or is receiving it.
+	ErrImageUploaded = errors.New("Image data already uploaded")
+
+	// ErrNoImageData is returned when opening an image with no data.
+	ErrNoImageData = errors.New("Image has no data")
+)
+
+const (
+	metadataExt = ".json"
+	partialExt  = ".part"
+)
+
+// ImageStore keeps track of the images stored in a local directory.
+type ImageStore struct {
+	sync.RWMutex
+	path   string
+	images map[string]*payloads.ImageDetails
+}
+
+// Init loads the metadata of all images stored in path, creating
+// the directory if it does not exist.
+func (is *ImageStore) Init(path string) error {
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return err
+	}
+
+	is.path = path
+	is.images = make(map[string]*payloads.ImageDetails)
+
+	files, err := filepath.Glob(filepath.Join(path, "*"+metadataExt))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+
+		var image payloads.ImageDetails
+		err = json.Unmarshal(data, &image)
+		if err != nil {
+			glog.Warningf("Ignoring invalid image metadata %s: %v", f, err)
+			continue
+		}
+
+		if image.ID != strings.TrimSuffix(filepath.Base(f), metadataExt) {
+			glog.Warningf("Ignoring image metadata %s: ID mismatch", f)
+			continue
+		}
+
+		// uploads interrupted by a restart need to be started again
+		if image.Status == payloads.ImageSaving {
+			_ = os.Remove(is.dataPath(image.ID) + partialExt)
+			image.Status = payloads.ImageQueued
+			err = is.writeMetadata(&image)
+			if err != nil {
+				return err
+			}
+		}
+
+		is.images[image.ID] = &image
+	}
+
+	return nil
+}
+
+func (is *ImageStore) dataPath(ID string) string {
+	return filepath.Join(is.path, ID)
+}
+
+func (is *ImageStore) metadataPath(ID string) string {
+	return filepath.Join(is.path, ID+metadataExt)
+}
+
+func (is *ImageStore) writeMetadata(image *payloads.ImageDetails) error {
+	data, err := json.Marshal(image)
+	if err != nil {
+		return err
+	}
+
+	tmp := is.metadataPath(image.ID) + partialExt
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, is.metadataPath(image.ID))
+}
+
+// CreateImage adds a new image with no data. The image ID must be
+// set by the caller.
+func (is *ImageStore) CreateImage(image payloads.ImageDetails) (payloads.ImageDetails, error) {
+	is.Lock()
+	defer is.Unlock()
+
+	if is.images[image.ID] != nil {
+		return payloads.ImageDetails{}, ErrImageExists
+	}
+
+	now := time.Now().UTC()
+	image.Status = payloads.ImageQueued
+	image.Checksum = ""
+	image.Size = 0
+	image.CreatedAt = now
+	image.UpdatedAt = now
+
+	err := is.writeMetadata(&image)
+	if err != nil {
+		return payloads.ImageDetails{}, err
+	}
+
+	is.images[image.ID] = &image
+
+	return image, nil
+}
+
+// GetImage returns the image with the given ID.
+func (is *ImageStore) GetImage(ID string) (payloads.ImageDetails, error) {
+	is.RLock()
+	defer is.RUnlock()
+
+	image := is.images[ID]
+	if image == nil {
+		return payloads.ImageDetails{}, ErrNoImage
+	}
+
+	return *image, nil
+}
+
+type byCreation []payloads.ImageDetails
+
+func (s byCreation) Len() int      { return len(s) }
+func (s byCreation) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCreation) Less(i, j int) bool {
+	if s[i].CreatedAt.Equal(s[j].CreatedAt) {
+		return s[i].ID < s[j].ID
+	}
+	return s[i].CreatedAt.Before(s[j].CreatedAt)
+}
+
+// GetImages returns all the images, oldest first.
+func (is *ImageStore) GetImages() []payloads.ImageDetails {
+	is.RLock()
+	images := make([]payloads.ImageDetails, 0, len(is.images))
+	for _, image := range is.images {
+		images = append(images, *image)
+	}
+	is.RUnlock()
+
+	sort.Sort(byCreation(images))
+
+	return images
+}
+
+// UploadImage stores the data read from r as the contents of the image
+// with the given ID, and computes its size and md5 checksum. Data can
+// only be uploaded once.
+func (is *ImageStore) UploadImage(ID string, r io.Reader) (payloads.ImageDetails, error) {
+	is.Lock()
+	image := is.images[ID]
+	if image == nil {
+		is.Unlock()
+		return payloads.ImageDetails{}, ErrNoImage
+	}
+
+	if image.Status != payloads.ImageQueued {
+		is.Unlock()
+		return payloads.ImageDetails{}, ErrImageUploaded
+	}
+
+	image.Status = payloads.ImageSaving
+	image.UpdatedAt = time.Now().UTC()
+	err := is.writeMetadata(image)
+	is.Unlock()
+	if err != nil {
+		return payloads.ImageDetails{}, err
+	}
+
+	size, checksum, err := is.writeData(ID, r)
+
+	is.Lock()
+	defer is.Unlock()
+
+	// the image may have been deleted while we were uploading
+	if is.images[ID] != image {
+		_ = os.Remove(is.dataPath(ID))
+		return payloads.ImageDetails{}, ErrNoImage
+	}
+
+	if err == nil {
+		image.Status = payloads.ImageActive
+		image.Size = size
+		image.Checksum = checksum
+	} else {
+		image.Status = payloads.ImageQueued
+	}
+	image.UpdatedAt = time.Now().UTC()
+
+	mErr := is.writeMetadata(image)
+	if err != nil {
+		return payloads.ImageDetails{}, err
+	}
+
+	return *image, mErr
+}
+
+func (is *ImageStore) writeData(ID string, r io.Reader) (int64, string, error) {
+	tmp := is.dataPath(ID) + partialExt
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, "", err
+	}
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), r)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	cErr := f.Close()
+	if err == nil {
+		err = cErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, is.dataPath(ID))
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, "", err
+	}
+
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// OpenImage opens the data of the image with the given ID for reading.
+// The caller must close the returned file.
+func (is *ImageStore) OpenImage(ID string) (*os.File, payloads.ImageDetails, error) {
+	is.RLock()
+	defer is.RUnlock()
+
+	image := is.images[ID]
+	if image == nil {
+		return nil, payloads.ImageDetails{}, ErrNoImage
+	}
+
+	if image.Status != payloads.ImageActive {
+		return nil, payloads.ImageDetails{}, ErrNoImageData
+	}
+
+	f, err := os.Open(is.dataPath(ID))
+	if err != nil {
+		return nil, payloads.ImageDetails{}, err
+	}
+
+	return f, *image, nil
+}
+
+// DeleteImage removes the image with the given ID and its data.
+func (is *ImageStore) DeleteImage(ID string) error {
+	is.Lock()
+	defer is.Unlock()
+
+	if is.images[ID] == nil {
+		return ErrNoImage
+	}
+
+	err := os.Remove(is.metadataPath(ID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	delete(is.images, ID)
+
+	err = os.Remove(is.dataPath(ID))
+	if err != nil && !os.IsNotExist(err) {
+		glog.Warningf("Unable to remove image data %s: %v", ID, err)
+	}
+
+	return nil
+}
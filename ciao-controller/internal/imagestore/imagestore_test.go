@@ -0,0 +1,212 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package imagestore
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/docker/distribution/uuid"
+)
+
+func testImageStore(t *testing.T) (*ImageStore, string) {
+	dir, err := ioutil.TempDir("", "ciao-imagestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	is := new(ImageStore)
+	err = is.Init(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return is, dir
+}
+
+func testCreateImage(t *testing.T, is *ImageStore) payloads.ImageDetails {
+	image := payloads.ImageDetails{
+		ID:              uuid.Generate().String(),
+		Name:            "test image",
+		Visibility:      payloads.ImagePublic,
+		DiskFormat:      payloads.QCow2,
+		ContainerFormat: payloads.Bare,
+	}
+
+	created, err := is.CreateImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created.Status != payloads.ImageQueued {
+		t.Fatalf("expected status %s, got %s", payloads.ImageQueued, created.Status)
+	}
+
+	return created
+}
+
+func TestCreateImage(t *testing.T) {
+	is, dir := testImageStore(t)
+	defer os.RemoveAll(dir)
+
+	image := testCreateImage(t, is)
+
+	_, err := is.CreateImage(image)
+	if err != ErrImageExists {
+		t.Fatalf("expected %v, got %v", ErrImageExists, err)
+	}
+
+	got, err := is.GetImage(image.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Name != image.Name || got.DiskFormat != image.DiskFormat {
+		t.Fatal("Image details not correct")
+	}
+
+	testCreateImage(t, is)
+
+	images := is.GetImages()
+	if len(images) != 2 || images[0].ID != image.ID {
+		t.Fatal("Incorrect list of images returned")
+	}
+}
+
+func TestUploadImage(t *testing.T) {
+	is, dir := testImageStore(t)
+	defer os.RemoveAll(dir)
+
+	image := testCreateImage(t, is)
+
+	_, _, err := is.OpenImage(image.ID)
+	if err != ErrNoImageData {
+		t.Fatalf("expected %v, got %v", ErrNoImageData, err)
+	}
+
+	data := []byte("not really a qcow2 image")
+	sum := md5.Sum(data)
+
+	image, err = is.UploadImage(image.ID, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image.Status != payloads.ImageActive || image.Size != int64(len(data)) ||
+		image.Checksum != hex.EncodeToString(sum[:]) {
+		t.Fatal("Image not updated after upload")
+	}
+
+	_, err = is.UploadImage(image.ID, bytes.NewReader(data))
+	if err != ErrImageUploaded {
+		t.Fatalf("expected %v, got %v", ErrImageUploaded, err)
+	}
+
+	f, _, err := is.OpenImage(image.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stored, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(stored, data) {
+		t.Fatal("Image data not correct")
+	}
+}
+
+func TestDeleteImage(t *testing.T) {
+	is, dir := testImageStore(t)
+	defer os.RemoveAll(dir)
+
+	image := testCreateImage(t, is)
+
+	_, err := is.UploadImage(image.ID, bytes.NewReader([]byte("data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = is.DeleteImage(image.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = is.GetImage(image.ID)
+	if err != ErrNoImage {
+		t.Fatalf("expected %v, got %v", ErrNoImage, err)
+	}
+
+	err = is.DeleteImage(image.ID)
+	if err != ErrNoImage {
+		t.Fatalf("expected %v, got %v", ErrNoImage, err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 0 {
+		t.Fatal("Image files not removed")
+	}
+}
+
+func TestReloadImages(t *testing.T) {
+	is, dir := testImageStore(t)
+	defer os.RemoveAll(dir)
+
+	uploaded := testCreateImage(t, is)
+	uploaded, err := is.UploadImage(uploaded.ID, bytes.NewReader([]byte("data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued := testCreateImage(t, is)
+
+	is = new(ImageStore)
+	err = is.Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image, err := is.GetImage(uploaded.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image.Status != payloads.ImageActive || image.Checksum != uploaded.Checksum {
+		t.Fatal("Uploaded image not restored")
+	}
+
+	image, err = is.GetImage(queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image.Status != payloads.ImageQueued {
+		t.Fatal("Queued image not restored")
+	}
+}
@@ -0,0 +1,133 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+const keyPairBits = 2048
+
+var keyPairNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9 _.@-]{1,255}$`)
+
+var publicKeyTypes = map[string]bool{
+	"ssh-rsa":             true,
+	"ssh-dss":             true,
+	"ssh-ed25519":         true,
+	"ecdsa-sha2-nistp256": true,
+	"ecdsa-sha2-nistp384": true,
+	"ecdsa-sha2-nistp521": true,
+}
+
+// sshString appends data to buf in the SSH wire format, i.e., prefixed
+// with its length as a 32 bit big endian integer.
+func sshString(buf *bytes.Buffer, data []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	buf.Write(length[:])
+	buf.Write(data)
+}
+
+// sshMPInt appends a positive integer to buf in the SSH mpint format.
+func sshMPInt(buf *bytes.Buffer, n *big.Int) {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	sshString(buf, b)
+}
+
+// parsePublicKey checks that key is an OpenSSH authorized_keys entry,
+// "type base64-blob [comment]", and returns its blob.
+func parsePublicKey(key string) ([]byte, error) {
+	fields := strings.Fields(key)
+	if len(fields) < 2 {
+		return nil, errors.New("Public key is not in OpenSSH format")
+	}
+
+	if !publicKeyTypes[fields[0]] {
+		return nil, fmt.Errorf("Unsupported public key type %s", fields[0])
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid public key encoding: %v", err)
+	}
+
+	// the blob starts with the key type
+	if len(blob) < 4 {
+		return nil, errors.New("Public key too short")
+	}
+
+	length := binary.BigEndian.Uint32(blob)
+	if uint64(length) > uint64(len(blob)-4) || string(blob[4:4+length]) != fields[0] {
+		return nil, errors.New("Public key type mismatch")
+	}
+
+	return blob, nil
+}
+
+// keyFingerprint returns the md5 fingerprint of a public key blob in the
+// colon separated hex format reported by the compute API.
+func keyFingerprint(blob []byte) string {
+	sum := md5.Sum(blob)
+
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// generateKeyPair creates a new RSA key pair and returns its private key
+// in PEM format and its public key in OpenSSH format.
+func generateKeyPair(comment string) (privateKey string, publicKey string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyPairBits)
+	if err != nil {
+		return "", "", err
+	}
+
+	block := &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}
+
+	var blob bytes.Buffer
+	sshString(&blob, []byte("ssh-rsa"))
+	sshMPInt(&blob, big.NewInt(int64(key.PublicKey.E)))
+	sshMPInt(&blob, key.PublicKey.N)
+
+	publicKey = "ssh-rsa " + base64.StdEncoding.EncodeToString(blob.Bytes())
+	if comment != "" {
+		publicKey += " " + comment
+	}
+
+	return string(pem.EncodeToMemory(block)), publicKey, nil
+}
@@ -18,11 +18,13 @@ package main
 
 import (
 	"flag"
+	"net/http"
 	"os"
 	"strconv"
 	"sync"
 
 	datastore "github.com/01org/ciao/ciao-controller/internal/datastore"
+	"github.com/01org/ciao/ciao-controller/internal/imagestore"
 	"github.com/01org/ciao/ssntp"
 	"github.com/01org/ciao/testutil"
 	"github.com/golang/glog"
@@ -31,7 +33,9 @@ import (
 type controller struct {
 	client *ssntpClient
 	ds     *datastore.Datastore
+	images *imagestore.ImageStore
 	id     *identity
+	spans  *ssntp.SpanExporter
 }
 
 const defaultControllerCert = "/etc/pki/ciao/cert-Controller-localhost.pem"
@@ -48,13 +52,19 @@ var identityURL = flag.String("identity", "identity:35357", "Keystone URL")
 var serviceUser = flag.String("username", "csr", "Openstack Service Username")
 var servicePassword = flag.String("password", "", "Openstack Service Username")
 var computeAPIPort = flag.Int("computeport", openstackComputeAPIPort, "Openstack Compute API port")
+var imageAPIPort = flag.Int("imageport", openstackImageAPIPort, "Openstack Image API port")
 var httpsCAcert = flag.String("httpscert", "/etc/pki/ciao/ciao-controller-cacert.pem", "HTTPS CA certificate")
 var httpsKey = flag.String("httpskey", "/etc/pki/ciao/ciao-controller-key.pem", "HTTPS cert key")
 var tablesInitPath = flag.String("tables_init_path", "./tables", "path to csv files")
 var workloadsPath = flag.String("workloads_path", "./workloads", "path to yaml files")
+var imagesPath = flag.String("images_path", "./images", "path to image service files")
 var noNetwork = flag.Bool("nonetwork", false, "Debug with no networking")
 var persistentDatastoreLocation = flag.String("database_path", "./ciao-controller.db", "path to persistent database")
 var transientDatastoreLocation = flag.String("stats_path", "/tmp/ciao-controller-stats.db", "path to stats database")
+var metricsAddr = flag.String("metrics", "", "Serve SSNTP metrics on this HTTP address, e.g. :9101")
+var traceExport = flag.String("trace-export", "", "Export SSNTP frame traces to this file or HTTP collector URL")
+var traceFormat = flag.String("trace-format", "zipkin", "SSNTP frame traces export format, zipkin or otlp")
+var compressionThreshold = flag.Int("compression-threshold", 0, "Compress SSNTP payloads of at least this many bytes, 0 to disable")
 var logDir = "/var/lib/ciao/logs/controller"
 
 func init() {
@@ -96,11 +106,29 @@ func main() {
 		return
 	}
 
+	context.images = new(imagestore.ImageStore)
+	err = context.images.Init(*imagesPath)
+	if err != nil {
+		glog.Fatalf("unable to Init image store: %s", err)
+		return
+	}
+
+	if *traceExport != "" {
+		context.spans, err = ssntp.NewSpanExporter(ssntp.SpanFormat(*traceFormat), *traceExport)
+		if err != nil {
+			glog.Fatalf("unable to export traces to %s: %s", *traceExport, err)
+			return
+		}
+		defer context.spans.Close()
+	}
+
 	config := &ssntp.Config{
 		URI:    *serverURL,
 		CAcert: *caCert,
 		Cert:   *cert,
 		Log:    ssntp.Log,
+
+		CompressionThreshold: *compressionThreshold,
 	}
 
 	context.client, err = newSSNTPClient(context, config)
@@ -141,10 +169,27 @@ func main() {
 		return
 	}
 
+	if *metricsAddr != "" {
+		go serveMetrics(context)
+	}
+
 	wg.Add(1)
 	go createComputeAPI(context)
+	go createImageAPI(context)
 
 	wg.Wait()
 	context.ds.Exit()
 	context.client.Disconnect()
 }
+
+// serveMetrics exposes the controller SSNTP session metrics in the
+// Prometheus text format on the /metrics path.
+func serveMetrics(context *controller) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", context.client.ssntp.MetricsHandler())
+
+	err := http.ListenAndServe(*metricsAddr, mux)
+	if err != nil {
+		glog.Errorf("Unable to serve metrics on %s: %v", *metricsAddr, err)
+	}
+}
@@ -37,17 +37,20 @@ type Workload struct {
 
 // Instance contains information about an instance of a workload.
 type Instance struct {
-	ID         string         `json:"instance_id"`
-	TenantID   string         `json:"tenant_id"`
-	State      string         `json:"instance_state"`
-	WorkloadID string         `json:"workload_id"`
-	NodeID     string         `json:"node_id"`
-	MACAddress string         `json:"mac_address"`
-	IPAddress  string         `json:"ip_address"`
-	SSHIP      string         `json:"ssh_ip"`
-	SSHPort    int            `json:"ssh_port"`
-	CNCI       bool           `json:"-"`
-	Usage      map[string]int `json:"-"`
+	ID         string            `json:"instance_id"`
+	TenantID   string            `json:"tenant_id"`
+	State      string            `json:"instance_state"`
+	WorkloadID string            `json:"workload_id"`
+	NodeID     string            `json:"node_id"`
+	MACAddress string            `json:"mac_address"`
+	IPAddress  string            `json:"ip_address"`
+	SSHIP      string            `json:"ssh_ip"`
+	SSHPort    int               `json:"ssh_port"`
+	Name       string            `json:"name"`
+	Metadata   map[string]string `json:"metadata"`
+	Tags       []string          `json:"tags"`
+	CNCI       bool              `json:"-"`
+	Usage      map[string]int    `json:"-"`
 }
 
 // SortedInstancesByID implements sort.Interface for Instance by ID string
@@ -98,6 +101,17 @@ type LogEntry struct {
 	Message   string    `json:"message"`
 }
 
+// KeyPair stores the public part of an SSH key pair owned by a user
+// of a tenant.
+type KeyPair struct {
+	TenantID    string    `json:"tenant_id"`
+	UserID      string    `json:"user_id"`
+	Name        string    `json:"name"`
+	PublicKey   string    `json:"public_key"`
+	Fingerprint string    `json:"fingerprint"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
 // NodeStats stores statistics for individual nodes in the cluster.
 type NodeStats struct {
 	NodeID          string    `json:"node_id"`
@@ -18,6 +18,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"path"
@@ -35,6 +36,10 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// dockerStopTimeout is the number of seconds a container is given to shut
+// down cleanly during a soft reboot before it is killed.
+const dockerStopTimeout = 10
+
 var dockerClient struct {
 	sync.Mutex
 	cli *client.Client
@@ -221,6 +226,10 @@ func (d *docker) createImage(bridge string, userData, metaData []byte) error {
 	return nil
 }
 
+func (d *docker) rebuildImage() error {
+	return fmt.Errorf("Rebuild is not supported for containers")
+}
+
 func (d *docker) deleteImage() error {
 	if d.dockerID == "" {
 		return nil
@@ -311,11 +320,31 @@ DONE:
 				cancelFunc()
 				_ = <-lostContainerCh
 				break DONE
-			} else if cmd == virtualizerStopCmd {
+			}
+
+			switch cmd {
+			case virtualizerStopCmd:
 				err := cli.ContainerKill(context.Background(), dockerID, "KILL")
 				if err != nil {
 					glog.Errorf("Unable to stop instance %s:%s", instance, dockerID)
 				}
+			case virtualizerPowerdownCmd:
+				err := cli.ContainerStop(context.Background(), dockerID, dockerStopTimeout)
+				if err != nil {
+					glog.Errorf("Unable to powerdown instance %s:%s", instance, dockerID)
+				}
+			case virtualizerPauseCmd:
+				err := cli.ContainerPause(context.Background(), dockerID)
+				if err != nil {
+					glog.Errorf("Unable to pause instance %s:%s", instance, dockerID)
+				}
+			case virtualizerUnpauseCmd:
+				err := cli.ContainerUnpause(context.Background(), dockerID)
+				if err != nil {
+					glog.Errorf("Unable to unpause instance %s:%s", instance, dockerID)
+				}
+			default:
+				glog.Warningf("Unsupported command %s for instance %s:%s", cmd, instance, dockerID)
 			}
 		}
 	}
@@ -17,6 +17,17 @@
 package main
 
 import (
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
 	"sync"
 
 	"github.com/golang/glog"
@@ -67,3 +78,151 @@ func getMinImageSize(vm *qemu, imagePath string) (minSizeMB int, err error) {
 
 	return info.minSizeMB, info.err
 }
+
+type imageDownload struct {
+	done chan struct{}
+	err  error
+}
+
+var downloadsMap struct {
+	sync.Mutex
+	images map[string]*imageDownload
+}
+
+var imageClient struct {
+	sync.Once
+	client *http.Client
+	err    error
+}
+
+func init() {
+	downloadsMap.images = make(map[string]*imageDownload)
+}
+
+// The image service authenticates launchers with their SSNTP client
+// certificate, so we use it for the HTTPS connection as well.
+func getImageClient() (*http.Client, error) {
+	imageClient.Do(func() {
+		certPEM, err := ioutil.ReadFile(clientCertPath)
+		if err != nil {
+			imageClient.err = err
+			return
+		}
+
+		cert, err := tls.X509KeyPair(certPEM, certPEM)
+		if err != nil {
+			imageClient.err = err
+			return
+		}
+
+		config := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
+
+		if imageCACertPath != "" {
+			caPEM, err := ioutil.ReadFile(imageCACertPath)
+			if err != nil {
+				imageClient.err = err
+				return
+			}
+
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(caPEM) {
+				imageClient.err = fmt.Errorf("Invalid image service CA %s", imageCACertPath)
+				return
+			}
+		}
+
+		imageClient.client = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: config},
+		}
+	})
+
+	return imageClient.client, imageClient.err
+}
+
+// fetchImage downloads a backing image from the image service into
+// imagesPath. Instances started concurrently with the same missing image
+// wait for a single download.
+func fetchImage(imageID string) error {
+	if imageServiceURL == "" {
+		return fmt.Errorf("No image service configured")
+	}
+
+	downloadsMap.Lock()
+	dl := downloadsMap.images[imageID]
+	if dl != nil {
+		downloadsMap.Unlock()
+		<-dl.done
+		return dl.err
+	}
+
+	dl = &imageDownload{done: make(chan struct{})}
+	downloadsMap.images[imageID] = dl
+	downloadsMap.Unlock()
+
+	client, err := getImageClient()
+	if err == nil {
+		err = downloadImage(client, imageServiceURL, imageID, imagesPath)
+	}
+	dl.err = err
+
+	downloadsMap.Lock()
+	delete(downloadsMap.images, imageID)
+	downloadsMap.Unlock()
+	close(dl.done)
+
+	return dl.err
+}
+
+func downloadImage(client *http.Client, serviceURL, imageID, dir string) error {
+	if imageID == "" || strings.HasPrefix(imageID, ".") || path.Base(imageID) != imageID {
+		return fmt.Errorf("Invalid image ID %s", imageID)
+	}
+
+	url := strings.TrimSuffix(serviceURL, "/") + "/v2/images/" + imageID + "/file"
+	glog.Infof("Downloading backing image from %s", url)
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unable to download image %s: %s", imageID, resp.Status)
+	}
+
+	f, err := ioutil.TempFile(dir, "."+imageID)
+	if err != nil {
+		return err
+	}
+
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), resp.Body)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	cErr := f.Close()
+	if err == nil {
+		err = cErr
+	}
+
+	checksum := resp.Header.Get("Content-MD5")
+	if err == nil && checksum != "" && checksum != hex.EncodeToString(hash.Sum(nil)) {
+		err = fmt.Errorf("Checksum mismatch for image %s", imageID)
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), path.Join(dir, imageID))
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	glog.Infof("Backing image %s downloaded", imageID)
+
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+const testImageID = "73a86d7e-93c0-480e-9c41-ab42f69b7799"
+
+var testImageData = []byte("not really a qcow2 image")
+
+func testImageServer(checksum string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/images/"+testImageID+"/file" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-MD5", checksum)
+		_, _ = w.Write(testImageData)
+	}))
+}
+
+func testDownloadImage(t *testing.T, checksum, imageID string) (string, error) {
+	ts := testImageServer(checksum)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "ciao-launcher-images")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, downloadImage(http.DefaultClient, ts.URL, imageID, dir)
+}
+
+func TestDownloadImage(t *testing.T) {
+	sum := md5.Sum(testImageData)
+
+	dir, err := testDownloadImage(t, hex.EncodeToString(sum[:]), testImageID)
+	defer func() { _ = os.RemoveAll(dir) }()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dir, testImageID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != string(testImageData) {
+		t.Fatal("Downloaded image data not correct")
+	}
+}
+
+func TestDownloadImageFailure(t *testing.T) {
+	sum := md5.Sum(testImageData)
+
+	tests := []struct {
+		checksum string
+		imageID  string
+	}{
+		{"0123456789abcdef0123456789abcdef", testImageID},
+		{hex.EncodeToString(sum[:]), "00000000-93c0-480e-9c41-ab42f69b7799"},
+		{hex.EncodeToString(sum[:]), "../" + testImageID},
+	}
+
+	for _, test := range tests {
+		dir, err := testDownloadImage(t, test.checksum, test.imageID)
+		if err == nil {
+			t.Errorf("Download of %s should have failed", test.imageID)
+		}
+
+		files, _ := ioutil.ReadDir(dir)
+		if len(files) != 0 {
+			t.Errorf("Files left behind after failed download of %s", test.imageID)
+		}
+
+		_ = os.RemoveAll(dir)
+	}
+}
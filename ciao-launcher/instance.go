@@ -17,6 +17,7 @@
 package main
 
 import (
+	"fmt"
 	"path"
 	"sync"
 	"time"
@@ -44,6 +45,8 @@ type instanceData struct {
 	shuttingDown   bool
 	rcvStamp       time.Time
 	st             *startTimes
+	paused         bool
+	pendingAction  interface{}
 }
 
 type insStartCmd struct {
@@ -60,6 +63,20 @@ type insDeleteCmd struct {
 }
 type insStopCmd struct{}
 type insMonitorCmd struct{}
+type insRebootCmd struct {
+	hard bool
+}
+type insPauseCmd struct{}
+type insUnpauseCmd struct{}
+type insSuspendCmd struct{}
+type insResumeCmd struct{}
+type insRebuildCmd struct {
+	image string
+}
+type insResizeCmd struct {
+	cpus int
+	mem  int
+}
 
 /*
 This functions asks the server loop to kill the instance.  An instance
@@ -153,6 +170,7 @@ func (id *instanceData) restartCommand(cmd *insRestartCmd) {
 		restartErr.send(id.ac.conn, id.instance)
 		return
 	}
+	id.clearSuspended()
 
 	id.connectedCh = make(chan struct{})
 	id.monitorCloseCh = make(chan struct{})
@@ -180,9 +198,265 @@ func (id *instanceData) stopCommand(cmd *insStopCmd) {
 		return
 	}
 	glog.Infof("Powerdown %s", id.instance)
+	id.pendingAction = nil
 	id.monitorCh <- virtualizerStopCmd
 }
 
+func (id *instanceData) actionError(action ssntp.Command, code payloads.InstanceActionFailureReason, err error) {
+	actionErr := &instanceActionError{err, code, action}
+	glog.Errorf("Unable to %s instance[%s]: %v", action, string(code), err)
+	actionErr.send(id.ac.conn, id.instance)
+}
+
+// canPerformAction checks whether the instance is able to accept a new
+// action.  Only one action that requires the instance to be shut down
+// can be in progress at any one time.
+func (id *instanceData) canPerformAction(action ssntp.Command) bool {
+	if id.shuttingDown {
+		id.actionError(action, payloads.ActionNoInstance, nil)
+		return false
+	}
+
+	if id.pendingAction != nil {
+		err := fmt.Errorf("Another action is already in progress")
+		id.actionError(action, payloads.ActionInvalidState, err)
+		return false
+	}
+
+	return true
+}
+
+func (id *instanceData) clearSuspended() {
+	if !id.cfg.Suspended {
+		return
+	}
+
+	id.cfg.Suspended = false
+	err := saveVMConfig(id.instanceDir, id.cfg)
+	if err != nil {
+		glog.Warningf("Unable to store state of instance %s: %v", id.instance, err)
+	}
+}
+
+// restartVM boots an instance that has been shut down as part of an action,
+// e.g., a reboot.  It returns true if the instance was successfully booted.
+func (id *instanceData) restartVM(action ssntp.Command) bool {
+	restartErr := processRestart(id.instanceDir, id.vm, id.ac.conn, id.cfg)
+	if restartErr != nil {
+		id.actionError(action, payloads.ActionFailure, restartErr.err)
+		return false
+	}
+	id.clearSuspended()
+
+	id.connectedCh = make(chan struct{})
+	id.monitorCloseCh = make(chan struct{})
+	id.monitorCh = id.vm.monitorVM(id.monitorCloseCh, id.connectedCh, &id.instanceWg, false)
+	return true
+}
+
+func (id *instanceData) rebootCommand(cmd *insRebootCmd) {
+	if id.shuttingDown {
+		id.actionError(ssntp.REBOOT, payloads.ActionNoInstance, nil)
+		return
+	}
+
+	// A pending soft reboot can be overridden by a hard reboot in case
+	// the guest ignores the powerdown request.
+	if _, rebooting := id.pendingAction.(*insRebootCmd); !rebooting &&
+		!id.canPerformAction(ssntp.REBOOT) {
+		return
+	}
+
+	if id.monitorCh == nil || (id.paused && !cmd.hard) {
+		err := fmt.Errorf("Instance is not running")
+		id.actionError(ssntp.REBOOT, payloads.ActionInvalidState, err)
+		return
+	}
+
+	id.pendingAction = cmd
+	if cmd.hard {
+		glog.Infof("Hard reboot %s", id.instance)
+		id.monitorCh <- virtualizerStopCmd
+	} else {
+		glog.Infof("Soft reboot %s", id.instance)
+		id.monitorCh <- virtualizerPowerdownCmd
+	}
+}
+
+func (id *instanceData) pauseCommand(cmd *insPauseCmd) {
+	if !id.canPerformAction(ssntp.PAUSE) {
+		return
+	}
+
+	if id.monitorCh == nil || id.paused {
+		err := fmt.Errorf("Instance is not running")
+		id.actionError(ssntp.PAUSE, payloads.ActionInvalidState, err)
+		return
+	}
+
+	glog.Infof("Pause %s", id.instance)
+	id.monitorCh <- virtualizerPauseCmd
+	id.paused = true
+	id.ovsCh <- &ovsStateChange{id.instance, ovsPaused}
+}
+
+func (id *instanceData) unpauseCommand(cmd *insUnpauseCmd) {
+	if !id.canPerformAction(ssntp.UNPAUSE) {
+		return
+	}
+
+	if id.monitorCh == nil || !id.paused {
+		err := fmt.Errorf("Instance is not paused")
+		id.actionError(ssntp.UNPAUSE, payloads.ActionInvalidState, err)
+		return
+	}
+
+	glog.Infof("Unpause %s", id.instance)
+	id.monitorCh <- virtualizerUnpauseCmd
+	id.paused = false
+	id.ovsCh <- &ovsStateChange{id.instance, ovsRunning}
+}
+
+func (id *instanceData) suspendCommand(cmd *insSuspendCmd) {
+	if !id.canPerformAction(ssntp.SUSPEND) {
+		return
+	}
+
+	if id.cfg.Container {
+		err := fmt.Errorf("Suspend is not supported for containers")
+		id.actionError(ssntp.SUSPEND, payloads.ActionFailure, err)
+		return
+	}
+
+	if id.monitorCh == nil {
+		err := fmt.Errorf("Instance is not running")
+		id.actionError(ssntp.SUSPEND, payloads.ActionInvalidState, err)
+		return
+	}
+
+	glog.Infof("Suspend %s", id.instance)
+	id.pendingAction = cmd
+	id.monitorCh <- virtualizerSuspendCmd
+}
+
+func (id *instanceData) resumeCommand(cmd *insResumeCmd) {
+	if !id.canPerformAction(ssntp.RESUME) {
+		return
+	}
+
+	if id.monitorCh != nil || !id.cfg.Suspended {
+		err := fmt.Errorf("Instance is not suspended")
+		id.actionError(ssntp.RESUME, payloads.ActionInvalidState, err)
+		return
+	}
+
+	glog.Infof("Resume %s", id.instance)
+	id.restartVM(ssntp.RESUME)
+}
+
+// rebuild recreates the rootfs of a stopped instance from a new image.
+// The previous image is restored in the instance's configuration if this
+// fails.
+func (id *instanceData) rebuild(cmd *insRebuildCmd) bool {
+	oldImage := id.cfg.Image
+	id.cfg.Image = cmd.image
+
+	err := ensureBackingImage(id.vm)
+	if err == nil {
+		err = id.vm.rebuildImage()
+	}
+	if err != nil {
+		id.cfg.Image = oldImage
+		id.actionError(ssntp.REBUILD, payloads.ActionImageFailure, err)
+		return false
+	}
+
+	id.cfg.Suspended = false
+	err = saveVMConfig(id.instanceDir, id.cfg)
+	if err != nil {
+		glog.Warningf("Unable to store state of instance %s: %v", id.instance, err)
+	}
+
+	return true
+}
+
+func (id *instanceData) rebuildCommand(cmd *insRebuildCmd) {
+	if !id.canPerformAction(ssntp.REBUILD) {
+		return
+	}
+
+	glog.Infof("Rebuild %s from image %s", id.instance, cmd.image)
+	if id.monitorCh == nil {
+		if id.rebuild(cmd) {
+			id.ovsCh <- &ovsStateChange{id.instance, ovsStopped}
+		}
+		return
+	}
+
+	id.pendingAction = cmd
+	id.monitorCh <- virtualizerStopCmd
+}
+
+func (id *instanceData) resizeCommand(cmd *insResizeCmd) {
+	if !id.canPerformAction(ssntp.RESIZE) {
+		return
+	}
+
+	cpus, mem := id.cfg.Cpus, id.cfg.Mem
+	if cmd.cpus > 0 {
+		cpus = cmd.cpus
+	}
+	if cmd.mem > 0 {
+		mem = cmd.mem
+	}
+
+	resultCh := make(chan bool)
+	id.ovsCh <- &ovsResizeCmd{id.instance, cpus, mem, resultCh}
+	if !<-resultCh {
+		err := fmt.Errorf("Not enough resources to resize instance")
+		id.actionError(ssntp.RESIZE, payloads.ActionFullComputeNode, err)
+		return
+	}
+
+	glog.Infof("Resize %s: CPUs %d Mem %d", id.instance, cpus, mem)
+	id.cfg.Cpus, id.cfg.Mem = cpus, mem
+	err := saveVMConfig(id.instanceDir, id.cfg)
+	if err != nil {
+		glog.Warningf("Unable to store state of instance %s: %v", id.instance, err)
+	}
+
+	if id.monitorCh == nil {
+		return
+	}
+
+	// The new resources are picked up when the instance is restarted
+	id.pendingAction = cmd
+	id.monitorCh <- virtualizerStopCmd
+}
+
+// completeAction is called once an instance has shut down as part of an
+// action.  It returns true if the instance has been restarted.
+func (id *instanceData) completeAction(action interface{}) bool {
+	switch cmd := action.(type) {
+	case *insSuspendCmd:
+		id.cfg.Suspended = true
+		err := saveVMConfig(id.instanceDir, id.cfg)
+		if err != nil {
+			glog.Warningf("Unable to store state of instance %s: %v", id.instance, err)
+		}
+	case *insRebootCmd:
+		return id.restartVM(ssntp.REBOOT)
+	case *insRebuildCmd:
+		if id.rebuild(cmd) {
+			return id.restartVM(ssntp.REBUILD)
+		}
+	case *insResizeCmd:
+		return id.restartVM(ssntp.RESIZE)
+	}
+
+	return false
+}
+
 func (id *instanceData) deleteCommand(cmd *insDeleteCmd) bool {
 	if id.shuttingDown && !cmd.suicide {
 		deleteErr := &deleteError{nil, payloads.DeleteNoInstance}
@@ -248,6 +522,20 @@ func (id *instanceData) instanceCommand(cmd interface{}) bool {
 		if id.deleteCommand(cmd) {
 			return false
 		}
+	case *insRebootCmd:
+		id.rebootCommand(cmd)
+	case *insPauseCmd:
+		id.pauseCommand(cmd)
+	case *insUnpauseCmd:
+		id.unpauseCommand(cmd)
+	case *insSuspendCmd:
+		id.suspendCommand(cmd)
+	case *insResumeCmd:
+		id.resumeCommand(cmd)
+	case *insRebuildCmd:
+		id.rebuildCommand(cmd)
+	case *insResizeCmd:
+		id.resizeCommand(cmd)
 	default:
 		glog.Warning("Unknown command")
 	}
@@ -287,8 +575,17 @@ DONE:
 			close(id.monitorCh)
 			id.monitorCh = nil
 			id.statsTimer = nil
-			id.ovsCh <- &ovsStateChange{id.instance, ovsStopped}
+			id.paused = false
 			id.st = nil
+			action := id.pendingAction
+			id.pendingAction = nil
+			if !id.completeAction(action) {
+				state := ovsStopped
+				if id.cfg.Suspended {
+					state = ovsSuspended
+				}
+				id.ovsCh <- &ovsStateChange{id.instance, state}
+			}
 		case <-id.connectedCh:
 			id.logStartTrace()
 			id.connectedCh = nil
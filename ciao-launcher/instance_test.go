@@ -54,6 +54,7 @@ type instanceTestState struct {
 	stf             payloads.ErrorStartFailure
 	df              payloads.ErrorDeleteFailure
 	rf              payloads.ErrorRestartFailure
+	af              payloads.ErrorInstanceActionFailure
 	connect         bool
 	monitorCh       chan string
 	errorCh         chan struct{}
@@ -78,6 +79,10 @@ func (v *instanceTestState) createImage(bridge string, userData, metaData []byte
 	return nil
 }
 
+func (v *instanceTestState) rebuildImage() error {
+	return nil
+}
+
 func (v *instanceTestState) deleteImage() error {
 	return nil
 }
@@ -138,6 +143,11 @@ func (v *instanceTestState) SendError(error ssntp.Error, payload []byte) (int, e
 		if err != nil {
 			v.t.Fatalf("Failed to unmarshall restart error %v", err)
 		}
+	case ssntp.InstanceActionFailure:
+		err := yaml.Unmarshal(payload, &v.af)
+		if err != nil {
+			v.t.Fatalf("Failed to unmarshall instance action error %v", err)
+		}
 	}
 
 	if v.errorCh != nil {
@@ -879,3 +889,208 @@ func TestStartRunningInstance(t *testing.T) {
 
 	wg.Wait()
 }
+
+func (v *instanceTestState) sendActionCmd(t *testing.T, cmdCh chan<- interface{}, cmd interface{}) bool {
+	v.errorCh = make(chan struct{})
+	select {
+	case cmdCh <- cmd:
+	case <-time.After(time.Second):
+		t.Error("Timed out sending action command")
+		return false
+	}
+	return true
+}
+
+func (v *instanceTestState) expectMonitorCmd(t *testing.T, expected string) bool {
+	select {
+	case monCmd := <-v.monitorCh:
+		if monCmd != expected {
+			t.Errorf("Invalid monitor command found %s, expected %s", monCmd, expected)
+			return false
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for monitor command %s", expected)
+		return false
+	}
+	return true
+}
+
+func (v *instanceTestState) expectActionError(t *testing.T,
+	reason payloads.InstanceActionFailureReason) bool {
+	select {
+	case <-v.errorCh:
+		v.errorCh = nil
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting on error channel")
+		return false
+	}
+
+	if v.af.Reason != reason {
+		t.Errorf("Incorrect error returned. Reported %s, expected %s",
+			string(v.af.Reason), string(reason))
+		return false
+	}
+	return true
+}
+
+// Check that a running instance can be paused and unpaused.
+//
+// We start the instance loop and start an instance.  We then send a pause
+// command followed by an unpause command before deleting the instance.
+//
+// The instance should start correctly.  The pause and unpause commands
+// should be passed to the virtualizer and should generate the appropriate
+// state changes.  The instance should then be deleted correctly and the
+// instanceLoop should exit cleanly.
+func TestPauseUnpause(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	if !state.sendActionCmd(t, cmdCh, &insPauseCmd{}) ||
+		!state.expectMonitorCmd(t, virtualizerPauseCmd) ||
+		!waitForStateChange(t, ovsPaused, ovsCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh)
+	}
+
+	if !state.sendActionCmd(t, cmdCh, &insUnpauseCmd{}) ||
+		!state.expectMonitorCmd(t, virtualizerUnpauseCmd) ||
+		!waitForStateChange(t, ovsRunning, ovsCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh)
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh)
+	}
+
+	wg.Wait()
+}
+
+// Check we get an error when unpausing an instance that is not paused.
+//
+// We start the instance loop and start an instance.  We then send an unpause
+// command and delete the instance.
+//
+// The instance should start correctly.  The unpause command should fail
+// with an invalid_state error.  The instance should then be deleted correctly
+// and the instanceLoop should exit cleanly.
+func TestUnpauseNotPaused(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	if !state.sendActionCmd(t, cmdCh, &insUnpauseCmd{}) ||
+		!state.expectActionError(t, payloads.ActionInvalidState) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh)
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh)
+	}
+
+	wg.Wait()
+}
+
+// Test pausing an instance that failed to start and is suiciding.
+//
+// We start the instance loop and then try to start an instance. This should cause
+// a suicide command to get sent to the acCmd channel.  We then send a pause
+// command to the instance.  This command should fail.  We then send the suicide
+// command received from the acCmd channel, which should succeed.
+//
+// The instanceLoop should start, the start command and the pause command
+// should fail.  The delete (suicide) should succeed and the loop should
+// exit.
+func TestPauseNoInstance(t *testing.T) {
+	state := sendCommandDuringSuicide(t, &insPauseCmd{})
+	if state.af.Reason != payloads.ActionNoInstance {
+		t.Errorf("Incorrect error returned. Reported %s, expected %s",
+			string(state.af.Reason), string(payloads.ActionNoInstance))
+	}
+}
+
+// Check that a running instance can be hard rebooted.
+//
+// We start the instance loop and start an instance.  We then send a hard
+// reboot command and simulate the instance shutting down once it has
+// received the stop command.  Finally, we delete the instance.
+//
+// The instance should start correctly.  The reboot should cause a stop
+// command to be sent to the virtualizer and the instance should be restarted
+// once it has shut down, without a stopped state change being reported.
+// The instance should then be deleted correctly and the instanceLoop should
+// exit cleanly.
+func TestHardReboot(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	if !state.sendActionCmd(t, cmdCh, &insRebootCmd{hard: true}) ||
+		!state.expectMonitorCmd(t, virtualizerStopCmd) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh)
+	}
+
+	close(state.monitorClosedCh)
+
+	if !waitForStateChange(t, ovsRunning, ovsCh) ||
+		!state.expectStatsUpdate(t, ovsCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh)
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh)
+	}
+
+	wg.Wait()
+}
+
+// Check we get an error when resizing an instance on a full node.
+//
+// We start the instance loop and start an instance.  We then send a resize
+// command and have the overseer reject the new resources.  Finally we
+// delete the instance.
+//
+// The instance should start correctly.  The resize command should fail
+// with a full_cn error and the configuration of the instance should be
+// unchanged.  The instance should then be deleted correctly and the
+// instanceLoop should exit cleanly.
+func TestResizeFull(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	if !state.sendActionCmd(t, cmdCh, &insResizeCmd{cpus: 4, mem: 4096}) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh)
+	}
+
+	select {
+	case ovsCmd := <-ovsCh:
+		resizeCmd, ok := ovsCmd.(*ovsResizeCmd)
+		if !ok {
+			t.Error("Unexpected commands received on ovsCh")
+			cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh)
+		}
+		if resizeCmd.cpus != 4 || resizeCmd.mem != 4096 {
+			t.Errorf("Incorrect resources requested: CPUs %d Mem %d",
+				resizeCmd.cpus, resizeCmd.mem)
+		}
+		resizeCmd.resultCh <- false
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for ovsResizeCmd")
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh)
+	}
+
+	if !state.expectActionError(t, payloads.ActionFullComputeNode) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh)
+	}
+
+	if cfg.Cpus != standardCfg.Cpus || cfg.Mem != standardCfg.Mem {
+		t.Errorf("Instance configuration modified by failed resize")
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh)
+	}
+
+	wg.Wait()
+}
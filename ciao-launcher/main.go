@@ -86,6 +86,8 @@ func (f *uiFlag) Enabled() bool {
 var serverURL string
 var serverCertPath string
 var clientCertPath string
+var imageServiceURL string
+var imageCACertPath string
 var computeNet string
 var mgmtNet string
 var networking networkFlag = "none"
@@ -93,12 +95,15 @@ var hardReset bool
 var diskLimit bool
 var memLimit bool
 var simulate bool
+var compressionThreshold int
 var maxInstances = int(math.MaxInt32)
 
 func init() {
 	flag.StringVar(&serverURL, "server", "", "URL of SSNTP server")
 	flag.StringVar(&serverCertPath, "cacert", "/etc/pki/ciao/CAcert-server-localhost.pem", "Client certificate")
 	flag.StringVar(&clientCertPath, "cert", "/etc/pki/ciao/cert-client-localhost.pem", "CA certificate")
+	flag.StringVar(&imageServiceURL, "image-service", "", "URL of the image service to download missing backing images from")
+	flag.StringVar(&imageCACertPath, "image-cacert", "", "CA certificate of the image service")
 	flag.StringVar(&computeNet, "compute-net", "", "Compute Subnet")
 	flag.StringVar(&mgmtNet, "mgmt-net", "", "Management Subnet")
 	flag.Var(&networking, "network", "Can be none, cn (compute node) or nn (network node)")
@@ -106,6 +111,7 @@ func init() {
 	flag.BoolVar(&diskLimit, "disk-limit", true, "Use disk usage limits")
 	flag.BoolVar(&memLimit, "mem-limit", true, "Use memory usage limits")
 	flag.BoolVar(&simulate, "simulation", false, "Launcher simulation")
+	flag.IntVar(&compressionThreshold, "compression-threshold", 0, "Compress SSNTP payloads of at least this many bytes, 0 to disable")
 }
 
 const (
@@ -226,6 +232,20 @@ func (client *agentClient) CommandNotify(cmd ssntp.Command, frame *ssntp.Frame)
 			return
 		}
 		client.cmdCh <- &cmdWrapper{instance, &insDeleteCmd{}}
+	case ssntp.REBOOT, ssntp.PAUSE, ssntp.UNPAUSE, ssntp.SUSPEND,
+		ssntp.RESUME, ssntp.REBUILD, ssntp.RESIZE:
+		instance, insCmd, payloadErr := parseInstanceActionPayload(cmd, payload)
+		if payloadErr != nil {
+			actionError := &instanceActionError{
+				payloadErr.err,
+				payloads.InstanceActionFailureReason(payloadErr.code),
+				cmd,
+			}
+			actionError.send(client.conn, "")
+			glog.Errorf("Unable to parse YAML: %v", payloadErr.err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, insCmd}
 	}
 }
 
@@ -250,6 +270,24 @@ func insState(instance string, ovsCh chan<- interface{}) ovsGetResult {
 	return <-targetCh
 }
 
+func instanceAction(cmd interface{}) ssntp.Command {
+	switch cmd.(type) {
+	case *insRebootCmd:
+		return ssntp.REBOOT
+	case *insPauseCmd:
+		return ssntp.PAUSE
+	case *insUnpauseCmd:
+		return ssntp.UNPAUSE
+	case *insSuspendCmd:
+		return ssntp.SUSPEND
+	case *insResumeCmd:
+		return ssntp.RESUME
+	case *insRebuildCmd:
+		return ssntp.REBUILD
+	}
+	return ssntp.RESIZE
+}
+
 func processCommand(conn serverConn, cmd *cmdWrapper, ovsCh chan<- interface{}) {
 	var target chan<- interface{}
 	var delCmd *insDeleteCmd
@@ -297,6 +335,15 @@ func processCommand(conn serverConn, cmd *cmdWrapper, ovsCh chan<- interface{})
 			re.send(conn, cmd.instance)
 			return
 		}
+	case *insRebootCmd, *insPauseCmd, *insUnpauseCmd, *insSuspendCmd,
+		*insResumeCmd, *insRebuildCmd, *insResizeCmd:
+		target = insCmdChannel(cmd.instance, ovsCh)
+		if target == nil {
+			glog.Errorf("Instance %s does not exist", cmd.instance)
+			ae := instanceActionError{nil, payloads.ActionNoInstance, instanceAction(insCmd)}
+			ae.send(conn, cmd.instance)
+			return
+		}
 	default:
 		target = insCmdChannel(cmd.instance, ovsCh)
 	}
@@ -338,7 +385,7 @@ func connectToServer(doneCh chan struct{}, statusCh chan struct{}) {
 	glog.Infof("Agent Role: %s", role.String())
 
 	cfg := &ssntp.Config{URI: serverURL, CAcert: serverCertPath, Cert: clientCertPath,
-		Log: ssntp.Log}
+		Log: ssntp.Log, CompressionThreshold: compressionThreshold}
 	client := &agentClient{
 		conn:  &ssntpConn{},
 		cmdCh: make(chan *cmdWrapper),
@@ -61,6 +61,13 @@ type ovsRemoveCmd struct {
 	errCh    chan<- error
 }
 
+type ovsResizeCmd struct {
+	instance string
+	cpus     int
+	mem      int
+	resultCh chan<- bool
+}
+
 type ovsStateChange struct {
 	instance string
 	state    ovsRunningState
@@ -86,6 +93,8 @@ const (
 	ovsPending ovsRunningState = iota
 	ovsRunning
 	ovsStopped
+	ovsPaused
+	ovsSuspended
 )
 
 const (
@@ -401,6 +410,10 @@ func (ovs *overseer) sendStats(cns *cnStats, status ssntp.Status) {
 			s.Instances[i].State = payloads.Running
 		} else if state.running == ovsStopped {
 			s.Instances[i].State = payloads.Exited
+		} else if state.running == ovsPaused {
+			s.Instances[i].State = payloads.Paused
+		} else if state.running == ovsSuspended {
+			s.Instances[i].State = payloads.Suspended
 		} else {
 			s.Instances[i].State = payloads.Pending
 		}
@@ -560,6 +573,29 @@ func (ovs *overseer) processRemoveCommand(cmd *ovsRemoveCmd) {
 	cmd.errCh <- nil
 }
 
+func (ovs *overseer) processResizeCommand(cmd *ovsResizeCmd) {
+	glog.Infof("Overseer: resizing %s", cmd.instance)
+	target := ovs.instances[cmd.instance]
+	if target == nil {
+		cmd.resultCh <- false
+		return
+	}
+
+	memDelta := cmd.mem - target.maxMemoryMB
+	if memDelta > 0 && memLimit && ovs.memoryAvailable-memDelta < memLWM {
+		glog.Warningf("Not enough memory to resize %s: MemAvail %d Requested %d",
+			cmd.instance, ovs.memoryAvailable, memDelta)
+		cmd.resultCh <- false
+		return
+	}
+
+	ovs.vcpusAllocated += cmd.cpus - target.maxVCPUs
+	ovs.memoryAllocated += memDelta
+	target.maxVCPUs = cmd.cpus
+	target.maxMemoryMB = cmd.mem
+	cmd.resultCh <- true
+}
+
 func (ovs *overseer) processStatusCommand(cmd *ovsStatusCmd) {
 	glog.Info("Overseer: Recieved Status Command")
 	if !ovs.ac.conn.isConnected() {
@@ -617,6 +653,8 @@ func (ovs *overseer) processCommand(cmd interface{}) {
 		ovs.processAddCommand(cmd)
 	case *ovsRemoveCmd:
 		ovs.processRemoveCommand(cmd)
+	case *ovsResizeCmd:
+		ovs.processResizeCommand(cmd)
 	case *ovsStatusCmd:
 		ovs.processStatusCommand(cmd)
 	case *ovsStatsStatusCmd:
@@ -29,6 +29,7 @@ import (
 
 	"github.com/01org/ciao/networking/libsnnet"
 	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
 	"github.com/golang/glog"
 	"gopkg.in/yaml.v2"
 )
@@ -55,6 +56,7 @@ type vmConfig struct {
 	ConcUUID    string
 	VnicUUID    string
 	SSHPort     int
+	Suspended   bool
 }
 
 type extractedDoc struct {
@@ -229,6 +231,15 @@ func generateDeleteError(instance string, deleteErr *deleteError) (out []byte, e
 	return yaml.Marshal(df)
 }
 
+func generateInstanceActionError(instance string, actionErr *instanceActionError) (out []byte, err error) {
+	af := &payloads.ErrorInstanceActionFailure{
+		InstanceUUID: instance,
+		Action:       actionErr.action.String(),
+		Reason:       actionErr.code,
+	}
+	return yaml.Marshal(af)
+}
+
 func generateNetEventPayload(ssntpEvent *libsnnet.SsntpEventInfo, agentUUID string) ([]byte, error) {
 	var event interface{}
 	var eventData *payloads.TenantAddedEvent
@@ -307,6 +318,107 @@ func parseStopPayload(data []byte) (string, *payloadError) {
 	return instance, nil
 }
 
+func parseInstanceActionPayload(cmd ssntp.Command, data []byte) (string, interface{}, *payloadError) {
+	var instance string
+	var insCmd interface{}
+	var err error
+
+	switch cmd {
+	case ssntp.REBOOT:
+		var clouddata payloads.Reboot
+		err = yaml.Unmarshal(data, &clouddata)
+		instance = clouddata.Reboot.InstanceUUID
+		rebootType := clouddata.Reboot.Type
+		if err == nil && rebootType != payloads.SoftReboot && rebootType != payloads.HardReboot {
+			err = fmt.Errorf("Invalid reboot type received: %s", rebootType)
+			return "", nil, &payloadError{err, payloads.ActionInvalidData}
+		}
+		insCmd = &insRebootCmd{hard: rebootType == payloads.HardReboot}
+	case ssntp.PAUSE:
+		var clouddata payloads.Pause
+		err = yaml.Unmarshal(data, &clouddata)
+		instance = clouddata.Pause.InstanceUUID
+		insCmd = &insPauseCmd{}
+	case ssntp.UNPAUSE:
+		var clouddata payloads.Unpause
+		err = yaml.Unmarshal(data, &clouddata)
+		instance = clouddata.Unpause.InstanceUUID
+		insCmd = &insUnpauseCmd{}
+	case ssntp.SUSPEND:
+		var clouddata payloads.Suspend
+		err = yaml.Unmarshal(data, &clouddata)
+		instance = clouddata.Suspend.InstanceUUID
+		insCmd = &insSuspendCmd{}
+	case ssntp.RESUME:
+		var clouddata payloads.Resume
+		err = yaml.Unmarshal(data, &clouddata)
+		instance = clouddata.Resume.InstanceUUID
+		insCmd = &insResumeCmd{}
+	case ssntp.REBUILD:
+		var clouddata payloads.Rebuild
+		err = yaml.Unmarshal(data, &clouddata)
+		instance = clouddata.Rebuild.InstanceUUID
+		image := strings.TrimSpace(clouddata.Rebuild.ImageUUID)
+		if err == nil && image == "" {
+			err = fmt.Errorf("No image specified")
+			return "", nil, &payloadError{err, payloads.ActionInvalidData}
+		}
+		insCmd = &insRebuildCmd{image: image}
+	case ssntp.RESIZE:
+		var clouddata payloads.Resize
+		err = yaml.Unmarshal(data, &clouddata)
+		instance = clouddata.Resize.InstanceUUID
+		resizeCmd := &insResizeCmd{}
+		for _, r := range clouddata.Resize.RequestedResources {
+			switch r.Type {
+			case payloads.VCPUs:
+				resizeCmd.cpus = r.Value
+			case payloads.MemMB:
+				resizeCmd.mem = r.Value
+			}
+		}
+		insCmd = resizeCmd
+	default:
+		err = fmt.Errorf("Unsupported instance action: %s", cmd)
+		return "", nil, &payloadError{err, payloads.ActionInvalidPayload}
+	}
+
+	if err != nil {
+		glog.Errorf("YAML error: %v", err)
+		return "", nil, &payloadError{err, payloads.ActionInvalidPayload}
+	}
+
+	instance = strings.TrimSpace(instance)
+	if !uuidRegexp.MatchString(instance) {
+		err = fmt.Errorf("Invalid instance id received: %s", instance)
+		return "", nil, &payloadError{err, payloads.ActionInvalidData}
+	}
+	return instance, insCmd, nil
+}
+
+func saveVMConfig(instanceDir string, cfg *vmConfig) error {
+	cfgFilePath := path.Join(instanceDir, instanceState)
+	cfgFile, err := os.OpenFile(cfgFilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		glog.Errorf("Unable to open instance file %s", cfgFilePath)
+		return err
+	}
+
+	enc := gob.NewEncoder(cfgFile)
+	err = enc.Encode(cfg)
+	closeErr := cfgFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		glog.Error("Unable to store state info")
+		return err
+	}
+
+	return nil
+}
+
 func loadVMConfig(instanceDir string) (*vmConfig, error) {
 	cfgFilePath := path.Join(instanceDir, instanceState)
 	cfgFile, err := os.Open(cfgFilePath)
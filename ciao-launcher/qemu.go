@@ -37,11 +37,12 @@ import (
 )
 
 const (
-	qemuEfiFw  = "/usr/share/qemu/OVMF.fd"
-	seedImage  = "seed.iso"
-	ciaoImage  = "ciao.iso"
-	imagesPath = "/var/lib/ciao/images"
-	vcTries    = 10
+	qemuEfiFw      = "/usr/share/qemu/OVMF.fd"
+	seedImage      = "seed.iso"
+	ciaoImage      = "ciao.iso"
+	imagesPath     = "/var/lib/ciao/images"
+	vcTries        = 10
+	qemuSuspendTag = "ciao-suspend"
 )
 
 var virtualSizeRegexp *regexp.Regexp
@@ -244,7 +245,9 @@ func (q *qemu) createRootfs() error {
 func (q *qemu) checkBackingImage() error {
 	backingImage := path.Join(imagesPath, q.cfg.Image)
 	_, err := os.Stat(backingImage)
-	if err != nil {
+	if os.IsNotExist(err) && imageServiceURL != "" {
+		return errImageNotFound
+	} else if err != nil {
 		return fmt.Errorf("Backing Image does not exist: %v", err)
 	}
 
@@ -264,7 +267,12 @@ func (q *qemu) checkBackingImage() error {
 }
 
 func (q *qemu) downloadBackingImage() error {
-	return fmt.Errorf("Not supported yet!")
+	err := fetchImage(q.cfg.Image)
+	if err != nil {
+		return err
+	}
+
+	return q.checkBackingImage()
 }
 
 func (q *qemu) createImage(bridge string, userData, metaData []byte) error {
@@ -284,6 +292,16 @@ func (q *qemu) createImage(bridge string, userData, metaData []byte) error {
 	return q.createRootfs()
 }
 
+func (q *qemu) rebuildImage() error {
+	vmImage := path.Join(q.instanceDir, "image.qcow2")
+	err := os.Remove(vmImage)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Unable to remove old rootfs: %v", err)
+	}
+
+	return q.createRootfs()
+}
+
 func (q *qemu) deleteImage() error {
 	return nil
 }
@@ -527,6 +545,10 @@ func (q *qemu) startVM(vnicName, ipAddress string) error {
 		params = append(params, "-bios", qemuEfiFw)
 	}
 
+	if q.cfg.Suspended {
+		params = append(params, "-loadvm", qemuSuspendTag)
+	}
+
 	var err error
 
 	if !launchWithUI.Enabled() {
@@ -630,7 +652,8 @@ DONE:
 					quitting = true
 				}
 			}
-			if cmd == virtualizerStopCmd {
+			switch cmd {
+			case virtualizerStopCmd:
 				glog.Info("Sending STOP")
 				_, err := fmt.Fprintln(conn, "{ \"execute\": \"quit\" }")
 				if err != nil {
@@ -638,6 +661,35 @@ DONE:
 				} else {
 					waitForShutdown = true
 				}
+			case virtualizerPowerdownCmd:
+				glog.Info("Sending POWERDOWN")
+				_, err := fmt.Fprintln(conn, "{ \"execute\": \"system_powerdown\" }")
+				if err != nil {
+					glog.Errorf("Unable to send system_powerdown to %s: %v\n", instance, err)
+				}
+			case virtualizerPauseCmd:
+				glog.Info("Sending PAUSE")
+				_, err := fmt.Fprintln(conn, "{ \"execute\": \"stop\" }")
+				if err != nil {
+					glog.Errorf("Unable to send pause command to %s: %v\n", instance, err)
+				}
+			case virtualizerUnpauseCmd:
+				glog.Info("Sending UNPAUSE")
+				_, err := fmt.Fprintln(conn, "{ \"execute\": \"cont\" }")
+				if err != nil {
+					glog.Errorf("Unable to send unpause command to %s: %v\n", instance, err)
+				}
+			case virtualizerSuspendCmd:
+				glog.Info("Sending SUSPEND")
+				_, err := fmt.Fprintf(conn, "{ \"execute\": \"human-monitor-command\", \"arguments\": { \"command-line\": \"savevm %s\" } }\n", qemuSuspendTag)
+				if err == nil {
+					_, err = fmt.Fprintln(conn, "{ \"execute\": \"quit\" }")
+				}
+				if err != nil {
+					glog.Errorf("Unable to send suspend command to %s: %v\n", instance, err)
+				} else {
+					waitForShutdown = true
+				}
 			}
 		case event, ok := <-eventCh:
 			if !ok {
@@ -58,6 +58,10 @@ func (s *simulation) createImage(bridge string, userData, metaData []byte) error
 	return nil
 }
 
+func (s *simulation) rebuildImage() error {
+	return nil
+}
+
 func (s *simulation) deleteImage() error {
 	return nil
 }
@@ -80,7 +84,8 @@ VM:
 				s.monitorCh = nil
 				break VM
 			}
-			if cmd == virtualizerStopCmd {
+			if cmd == virtualizerStopCmd || cmd == virtualizerPowerdownCmd ||
+				cmd == virtualizerSuspendCmd {
 				break VM
 			}
 		case <-s.killCh:
@@ -22,8 +22,12 @@ import (
 )
 
 const (
-	virtualizerStartCmd = "START"
-	virtualizerStopCmd  = "STOP"
+	virtualizerStartCmd     = "START"
+	virtualizerStopCmd      = "STOP"
+	virtualizerPowerdownCmd = "POWERDOWN"
+	virtualizerPauseCmd     = "PAUSE"
+	virtualizerUnpauseCmd   = "UNPAUSE"
+	virtualizerSuspendCmd   = "SUSPEND"
 )
 
 var errImageNotFound = errors.New("Image Not Found")
@@ -61,6 +65,12 @@ type virtualizer interface {
 	// metaData: cloudinit metaData payload
 	createImage(bridge string, userData, metaData []byte) error
 
+	// Recreates the rootfs of an existing instance from the backing image
+	// currently specified in the instance's configuration.  This method is
+	// only called when the instance is not running.  Any data written to
+	// the old rootfs is lost.
+	rebuildImage() error
+
 	// Deletes any state related to the instance that is not stored in the
 	// instance directory.  State stored in the instance directory will be automatically,
 	// deleted by the instance go routine.
@@ -92,7 +102,13 @@ type virtualizer interface {
 	// shortly.
 	//
 	// Returns a channel.  The instance go routine uses this channel for two purposes:
-	// 1. It sends commands down the channel, e.g., stop VM.
+	// 1. It sends commands down the channel, e.g., stop VM.  In addition to
+	//    virtualizerStopCmd, which must terminate the instance immediately,
+	//    virtualizerPowerdownCmd should ask the guest to shut itself down,
+	//    virtualizerPauseCmd and virtualizerUnpauseCmd should freeze and thaw
+	//    the instance, and virtualizerSuspendCmd should save the state of the
+	//    instance, so that it can be restored by the next call to startVM,
+	//    before terminating it.
 	// 2. It closes the channel when it is itself asked to shutdown.  When the channel is
 	//    closed, any go routines returned by monitor vm should shutdown.
 	monitorVM(closedCh chan struct{}, connectedCh chan struct{},
@@ -132,6 +132,12 @@ restart, or be stopped and updated and restarted, and launcher agents
 will simply reconnect and keep on continually updating the scheduler of
 any changes in their node statistics.
 
+Several schedulers can be federated for high availability.  Each of them
+dials its peers as an SSNTP client, shares the clients connected to it and
+forwards frames to the scheduler owning their destination node.  Ciao
+clients configured with several scheduler URIs can then connect to any of
+them, and fail over when the one they are connected to goes away.
+
 Fairness
 
 Ciao-scheduler currently implements an extremely trivial algorithm to
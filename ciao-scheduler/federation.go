@@ -0,0 +1,464 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// Federated schedulers share their node and controller registries, so that
+// launchers, controllers and CNCI agents can connect to any of them.
+//
+// Each scheduler dials all its peers as an SSNTP SCHEDULER client, with its
+// own server UUID. The SSNTP server sessions of those clients form the
+// peerGroup, and a scheduler sends all its federation frames down to them:
+//
+//   - NodeConnected and NodeDisconnected events, with a ComputeNode,
+//     NetworkNode or ControllerNode type, for each locally connected client.
+//   - A STATUS frame with a READY payload for each local node status update.
+//   - The COMMAND and EVENT frames for nodes and CNCIs owned by the peer.
+//   - The frames for Controllers, which the peer relays to its own ones.
+//
+// Entries learnt from a peer are owned by it, and dropped when the link to
+// it goes down so that their clients can fail over to another scheduler.
+const peerGroup = "schedulers"
+
+// publishNodeConnection tells all the peer schedulers about a locally
+// connected or disconnected node or controller.
+func (sched *ssntpSchedulerServer) publishNodeConnection(nodeUUID string, nodeType payloads.Resource, connected bool) {
+	for _, peer := range sched.ssntp.GroupMembers(peerGroup) {
+		sched.sendNodeConnectionEvent(nodeUUID, peer, nodeType, connected)
+	}
+}
+
+// Send a READY formatted status for the referenced locked nodeStat object
+func (sched *ssntpSchedulerServer) sendNodeStatus(node *nodeStat, peer string) {
+	ready := payloads.Ready{
+		NodeUUID:       node.uuid,
+		MemTotalMB:     node.memTotalMB,
+		MemAvailableMB: node.memAvailMB,
+		Load:           node.load,
+		CpusOnline:     node.cpus,
+	}
+
+	payload, err := yaml.Marshal(&ready)
+	if err != nil {
+		glog.Errorf("Unable to Marshall Status %v", err)
+		return
+	}
+
+	sched.ssntp.SendStatus(peer, node.status, payload)
+}
+
+// publishNodeStatus tells all the peer schedulers about a local node
+// status update.
+func (sched *ssntpSchedulerServer) publishNodeStatus(node *nodeStat) {
+	peers := sched.ssntp.GroupMembers(peerGroup)
+	if len(peers) == 0 {
+		return
+	}
+
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	if node.owner != "" {
+		return
+	}
+
+	for _, peer := range peers {
+		sched.sendNodeStatus(node, peer)
+	}
+}
+
+// takeOverNode records that an already known node is now connected to
+// another scheduler, e.g. after failing over. It returns false if the
+// node owner did not change.
+func (sched *ssntpSchedulerServer) takeOverNode(node *nodeStat, owner string, nodeType payloads.Resource) bool {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	if node.owner == owner {
+		return false
+	}
+
+	glog.Infof("Node %s moved from scheduler %q to %q\n", node.uuid, node.owner, owner)
+	node.owner = owner
+	node.status = ssntp.CONNECTED
+
+	if owner == "" {
+		sched.publishNodeConnection(node.uuid, nodeType, true)
+	}
+
+	return true
+}
+
+func nodeOwnedBy(node *nodeStat, owner string) bool {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	if node.owner != owner {
+		glog.V(2).Infof("Node %s moved to scheduler %q\n", node.uuid, node.owner)
+		return false
+	}
+
+	return true
+}
+
+// nodeOwner returns the UUID of the peer scheduler a node is connected to,
+// or an empty string for local and unknown nodes.
+func (sched *ssntpSchedulerServer) nodeOwner(uuid string) string {
+	var node *nodeStat
+
+	sched.cnMutex.RLock()
+	node = sched.cnMap[uuid]
+	sched.cnMutex.RUnlock()
+
+	if node == nil {
+		sched.nnMutex.RLock()
+		node = sched.nnMap[uuid]
+		sched.nnMutex.RUnlock()
+	}
+
+	if node == nil {
+		return ""
+	}
+
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	return node.owner
+}
+
+// Add a newly connected peer scheduler to the federation and send it
+// our registry.
+func connectPeer(sched *ssntpSchedulerServer, uuid string) {
+	if uuid == sched.ssntp.UUID() {
+		glog.Warningf("Ignoring federation with ourselves\n")
+		return
+	}
+
+	sched.ssntp.AddGroupMember(peerGroup, uuid)
+
+	sched.controllerMutex.RLock()
+	for _, c := range sched.controllerMap {
+		if c.owner == "" {
+			sched.sendNodeConnectionEvent(c.uuid, uuid, payloads.ControllerNode, true)
+		}
+	}
+	sched.controllerMutex.RUnlock()
+
+	sendNodes := func(nodes map[string]*nodeStat, nodeType payloads.Resource) {
+		for _, node := range nodes {
+			node.mutex.Lock()
+			if node.owner == "" {
+				sched.sendNodeConnectionEvent(node.uuid, uuid, nodeType, true)
+				sched.sendNodeStatus(node, uuid)
+			}
+			node.mutex.Unlock()
+		}
+	}
+
+	sched.cnMutex.RLock()
+	sendNodes(sched.cnMap, payloads.ComputeNode)
+	sched.cnMutex.RUnlock()
+
+	sched.nnMutex.RLock()
+	sendNodes(sched.nnMap, payloads.NetworkNode)
+	sched.nnMutex.RUnlock()
+}
+
+// Undo previous state additions for departed peer scheduler
+// This function is symmetric with connectPeer().
+func disconnectPeer(sched *ssntpSchedulerServer, uuid string) {
+	sched.ssntp.RemoveGroupMember(peerGroup, uuid)
+}
+
+// removePeerEntries drops all the nodes and controllers owned by a peer
+// scheduler we lost contact with.
+func removePeerEntries(sched *ssntpSchedulerServer, owner string) {
+	var controllers, computeNodes, networkNodes []string
+
+	owned := func(nodes map[string]*nodeStat) (uuids []string) {
+		for uuid, node := range nodes {
+			node.mutex.Lock()
+			if node.owner == owner {
+				uuids = append(uuids, uuid)
+			}
+			node.mutex.Unlock()
+		}
+		return
+	}
+
+	sched.controllerMutex.RLock()
+	for uuid, c := range sched.controllerMap {
+		c.mutex.Lock()
+		if c.owner == owner {
+			controllers = append(controllers, uuid)
+		}
+		c.mutex.Unlock()
+	}
+	sched.controllerMutex.RUnlock()
+
+	sched.cnMutex.RLock()
+	computeNodes = owned(sched.cnMap)
+	sched.cnMutex.RUnlock()
+
+	sched.nnMutex.RLock()
+	networkNodes = owned(sched.nnMap)
+	sched.nnMutex.RUnlock()
+
+	for _, uuid := range controllers {
+		removeController(sched, uuid, owner)
+	}
+	for _, uuid := range computeNodes {
+		removeComputeNode(sched, uuid, owner)
+	}
+	for _, uuid := range networkNodes {
+		removeNetworkNode(sched, uuid, owner)
+	}
+}
+
+// updatePeerNodeStat updates a node owned by a peer scheduler from the
+// READY formatted status the peer sent us.
+func (sched *ssntpSchedulerServer) updatePeerNodeStat(owner string, status ssntp.Status, frame *ssntp.Frame) {
+	var ready payloads.Ready
+	err := yaml.Unmarshal(frame.Payload, &ready)
+	if err != nil {
+		glog.Errorf("Bad %s status yaml from scheduler %s\n", status, owner)
+		return
+	}
+
+	update := func(nodes map[string]*nodeStat) bool {
+		node := nodes[ready.NodeUUID]
+		if node == nil {
+			return false
+		}
+
+		if nodeOwnedBy(node, owner) {
+			sched.updateNodeStat(node, status, frame)
+		}
+		return true
+	}
+
+	sched.cnMutex.RLock()
+	found := update(sched.cnMap)
+	sched.cnMutex.RUnlock()
+	if found {
+		return
+	}
+
+	sched.nnMutex.RLock()
+	update(sched.nnMap)
+	sched.nnMutex.RUnlock()
+}
+
+// relayToControllers sends a frame received from a peer scheduler to
+// all the Controllers connected to this scheduler.
+func (sched *ssntpSchedulerServer) relayToControllers(frame *ssntp.Frame) {
+	var controllers []string
+
+	sched.controllerMutex.RLock()
+	for _, c := range sched.controllerMap {
+		if c.owner == "" {
+			controllers = append(controllers, c.uuid)
+		}
+	}
+	sched.controllerMutex.RUnlock()
+
+	for _, uuid := range controllers {
+		switch frame.Type {
+		case ssntp.COMMAND:
+			sched.ssntp.SendCommand(uuid, ssntp.Command(frame.Operand), frame.Payload)
+		case ssntp.STATUS:
+			sched.ssntp.SendStatus(uuid, ssntp.Status(frame.Operand), frame.Payload)
+		case ssntp.EVENT:
+			sched.ssntp.SendEvent(uuid, ssntp.Event(frame.Operand), frame.Payload)
+		case ssntp.ERROR:
+			sched.ssntp.SendError(uuid, ssntp.Error(frame.Operand), frame.Payload)
+		}
+	}
+}
+
+// schedulerPeer is our SSNTP client connection to a peer scheduler.
+// The peer sends us its registry updates and the frames we need to
+// handle through it.
+type schedulerPeer struct {
+	sched *ssntpSchedulerServer
+	uri   string
+	ssntp ssntp.Client
+
+	mutex sync.Mutex
+	uuid  string // learnt from the peer registry updates
+}
+
+func (peer *schedulerPeer) setUUID(frame *ssntp.Frame) string {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	peer.uuid = frame.Origin.String()
+
+	return peer.uuid
+}
+
+func (peer *schedulerPeer) peerUUID() string {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	return peer.uuid
+}
+
+func (peer *schedulerPeer) ConnectNotify() {
+	glog.Infof("Federated with scheduler %s\n", peer.uri)
+}
+
+func (peer *schedulerPeer) DisconnectNotify() {
+	glog.Warningf("Lost contact with scheduler %s\n", peer.uri)
+
+	if owner := peer.peerUUID(); owner != "" {
+		removePeerEntries(peer.sched, owner)
+	}
+}
+
+func (peer *schedulerPeer) StatusNotify(status ssntp.Status, frame *ssntp.Frame) {
+	peer.sched.updatePeerNodeStat(peer.setUUID(frame), status, frame)
+}
+
+func (peer *schedulerPeer) CommandNotify(command ssntp.Command, frame *ssntp.Frame) {
+	sched := peer.sched
+
+	switch command {
+	case ssntp.STATS:
+		sched.relayToControllers(frame)
+	case ssntp.START:
+		dest, instanceUUID := scheduleWorkload(sched, peer.peerUUID(), frame.Payload, true)
+		for _, node := range dest.Recipients() {
+			glog.V(2).Infof("Starting instance %s on %s for scheduler %s\n", instanceUUID, node, peer.uri)
+			sched.ssntp.SendCommand(node, command, frame.Payload)
+		}
+	case ssntp.RESTART, ssntp.STOP, ssntp.DELETE, ssntp.EVACUATE,
+		ssntp.REBOOT, ssntp.PAUSE, ssntp.UNPAUSE, ssntp.SUSPEND,
+		ssntp.RESUME, ssntp.REBUILD, ssntp.RESIZE:
+		_, agentUUID, err := getWorkloadAgentUUID(sched, command, frame.Payload)
+		if err != nil || agentUUID == "" || sched.nodeOwner(agentUUID) != "" {
+			glog.Errorf("Ignoring %s command for %s from scheduler %s\n", command, agentUUID, peer.uri)
+			return
+		}
+		sched.ssntp.SendCommand(agentUUID, command, frame.Payload)
+	}
+}
+
+func (peer *schedulerPeer) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
+	sched := peer.sched
+
+	switch event {
+	case ssntp.NodeConnected:
+		var ev payloads.NodeConnected
+		if err := yaml.Unmarshal(frame.Payload, &ev); err != nil {
+			glog.Errorf("Bad %s event yaml from scheduler %s\n", event, peer.uri)
+			return
+		}
+
+		owner := peer.setUUID(frame)
+		switch ev.Connected.NodeType {
+		case payloads.ComputeNode:
+			addComputeNode(sched, ev.Connected.NodeUUID, owner)
+		case payloads.NetworkNode:
+			addNetworkNode(sched, ev.Connected.NodeUUID, owner)
+		case payloads.ControllerNode:
+			addController(sched, ev.Connected.NodeUUID, owner)
+		}
+	case ssntp.NodeDisconnected:
+		var ev payloads.NodeDisconnected
+		if err := yaml.Unmarshal(frame.Payload, &ev); err != nil {
+			glog.Errorf("Bad %s event yaml from scheduler %s\n", event, peer.uri)
+			return
+		}
+
+		owner := peer.setUUID(frame)
+		switch ev.Disconnected.NodeType {
+		case payloads.ComputeNode:
+			removeComputeNode(sched, ev.Disconnected.NodeUUID, owner)
+		case payloads.NetworkNode:
+			removeNetworkNode(sched, ev.Disconnected.NodeUUID, owner)
+		case payloads.ControllerNode:
+			removeController(sched, ev.Disconnected.NodeUUID, owner)
+		}
+	case ssntp.TenantAdded, ssntp.TenantRemoved, ssntp.PublicIPAssigned:
+		concentratorUUID, err := sched.getConcentratorUUID(event, frame.Payload)
+		if err != nil {
+			glog.Errorf("Bad %s event yaml from scheduler %s\n", event, peer.uri)
+			return
+		}
+
+		// Only deliver to our own CNCIs, our peer sent it to all schedulers
+		if _, err := sched.ssntp.ClientRole(concentratorUUID); err == nil {
+			sched.ssntp.SendEvent(concentratorUUID, event, frame.Payload)
+		}
+	default:
+		sched.relayToControllers(frame)
+	}
+}
+
+func (peer *schedulerPeer) ErrorNotify(error ssntp.Error, frame *ssntp.Frame) {
+	peer.sched.relayToControllers(frame)
+}
+
+func (peer *schedulerPeer) dial() {
+	config := &ssntp.Config{
+		URI:    peer.uri,
+		CAcert: *cacert,
+		Cert:   *cert,
+		UUID:   peer.sched.ssntp.UUID(),
+
+		KeepaliveInterval: *keepalive,
+		CRL:               *crl,
+		DenyList:          *denyList,
+	}
+
+	err := peer.ssntp.Dial(config, peer)
+	if err != nil {
+		glog.Errorf("Unable to federate with scheduler %s: %v", peer.uri, err)
+	}
+}
+
+// dialPeers connects to all the peer schedulers once our SSNTP server
+// is up and running.
+func dialPeers(sched *ssntpSchedulerServer, uris []string) {
+	if err := <-sched.config.SyncChannel; err != nil {
+		return
+	}
+
+	for _, uri := range uris {
+		uri = strings.TrimSpace(uri)
+		if uri == "" {
+			continue
+		}
+
+		peer := &schedulerPeer{
+			sched: sched,
+			uri:   uri,
+		}
+
+		go peer.dial()
+	}
+}
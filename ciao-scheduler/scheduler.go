@@ -20,8 +20,11 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"runtime/pprof"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -39,6 +42,18 @@ var heartbeat = flag.Bool("heartbeat", false, "Emit status heartbeat text")
 var logDir = "/var/lib/ciao/logs/scheduler"
 var configURI = flag.String("configuration-uri", "file:///etc/ciao/configuration.yaml",
 	"Cluster configuration URI")
+var keepalive = flag.Duration("keepalive", 0, "SSNTP keepalive interval, 0 to disable")
+var capture = flag.String("capture", "", "Record all SSNTP frames to this capture file")
+var crl = flag.String("crl", "", "Certificate revocation list")
+var denyList = flag.String("deny-list", "", "List of revoked certificate serial numbers")
+var authorize = flag.Bool("authorize", true, "Only accept the SSNTP frames each client role is expected to send")
+var metrics = flag.String("metrics", "", "Serve SSNTP metrics on this HTTP address, e.g. :9100")
+var peers = flag.String("peers", "", "Comma separated list of peer scheduler URIs to federate with")
+var maxFrameSize = flag.Int("max-frame-size", 0, "Maximum SSNTP frame size in bytes, 0 for no limit")
+var maxPayloadSize = flag.Int("max-payload-size", 0, "Maximum SSNTP frame payload size in bytes, 0 for no limit")
+var maxHandshakes = flag.Int("max-handshakes", 0, "Maximum concurrent SSNTP handshakes per client IP, 0 for no limit")
+var handshakeTimeout = flag.Duration("handshake-timeout", 30*time.Second, "SSNTP client handshake timeout")
+var compressionThreshold = flag.Int("compression-threshold", 0, "Compress SSNTP payloads of at least this many bytes, 0 to disable")
 
 type ssntpSchedulerServer struct {
 	// user config overrides ------------------------------------------
@@ -83,6 +98,7 @@ type nodeStat struct {
 	mutex      sync.Mutex
 	status     ssntp.Status
 	uuid       string
+	owner      string // peer scheduler UUID, empty for local nodes
 	memTotalMB int
 	memAvailMB int
 	load       int
@@ -111,6 +127,7 @@ type controllerStat struct {
 	mutex  sync.Mutex
 	status controllerStatus
 	uuid   string
+	owner  string // peer scheduler UUID, empty for local controllers
 }
 
 func (sched *ssntpSchedulerServer) sendNodeConnectionEvent(nodeUUID, controllerUUID string, nodeType payloads.Resource, connected bool) (int, error) {
@@ -152,6 +169,10 @@ func (sched *ssntpSchedulerServer) sendNodeConnectedEvents(nodeUUID string, node
 	defer sched.controllerMutex.RUnlock()
 
 	for _, c := range sched.controllerMap {
+		if c.owner != "" {
+			continue
+		}
+
 		sched.sendNodeConnectionEvent(nodeUUID, c.uuid, nodeType, true)
 	}
 }
@@ -161,6 +182,10 @@ func (sched *ssntpSchedulerServer) sendNodeDisconnectedEvents(nodeUUID string, n
 	defer sched.controllerMutex.RUnlock()
 
 	for _, c := range sched.controllerMap {
+		if c.owner != "" {
+			continue
+		}
+
 		sched.sendNodeConnectionEvent(nodeUUID, c.uuid, nodeType, false)
 	}
 }
@@ -168,16 +193,35 @@ func (sched *ssntpSchedulerServer) sendNodeDisconnectedEvents(nodeUUID string, n
 // Add state for newly connected Controller
 // This function is symmetric with disconnectController().
 func connectController(sched *ssntpSchedulerServer, uuid string) {
+	addController(sched, uuid, "")
+}
+
+// Add state for a Controller connected to this scheduler (empty owner)
+// or to the owner peer scheduler.
+func addController(sched *ssntpSchedulerServer, uuid string, owner string) {
 	sched.controllerMutex.Lock()
 	defer sched.controllerMutex.Unlock()
 
-	if sched.controllerMap[uuid] != nil {
-		glog.Warningf("Unexpected reconnect from controller %s\n", uuid)
+	if c := sched.controllerMap[uuid]; c != nil {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if c.owner == owner {
+			glog.Warningf("Unexpected reconnect from controller %s\n", uuid)
+			return
+		}
+
+		// the controller failed over to another scheduler
+		c.owner = owner
+		if owner == "" {
+			sched.publishNodeConnection(uuid, payloads.ControllerNode, true)
+		}
 		return
 	}
 
 	var controller controllerStat
 	controller.uuid = uuid
+	controller.owner = owner
 
 	// TODO: smarter clustering than "assume master, unless another is master"
 	if len(sched.controllerList) == 0 || sched.controllerList[0].status == controllerBackup {
@@ -191,11 +235,20 @@ func connectController(sched *ssntpSchedulerServer, uuid string) {
 	}
 
 	sched.controllerMap[controller.uuid] = &controller
+
+	if owner == "" {
+		sched.publishNodeConnection(uuid, payloads.ControllerNode, true)
+	}
 }
 
 // Undo previous state additions for departed Controller
 // This function is symmetric with connectController().
 func disconnectController(sched *ssntpSchedulerServer, uuid string) {
+	removeController(sched, uuid, "")
+}
+
+// Remove a Controller, unless it is now owned by another scheduler.
+func removeController(sched *ssntpSchedulerServer, uuid string, owner string) {
 	sched.controllerMutex.Lock()
 	defer sched.controllerMutex.Unlock()
 
@@ -205,6 +258,18 @@ func disconnectController(sched *ssntpSchedulerServer, uuid string) {
 		return
 	}
 
+	controller.mutex.Lock()
+	current := controller.owner
+	controller.mutex.Unlock()
+	if current != owner {
+		glog.V(2).Infof("Controller %s moved to scheduler %s\n", uuid, current)
+		return
+	}
+
+	if owner == "" {
+		sched.publishNodeConnection(uuid, payloads.ControllerNode, false)
+	}
+
 	// delete from map, remove from list
 	delete(sched.controllerMap, uuid)
 	for i, c := range sched.controllerList {
@@ -240,26 +305,43 @@ func disconnectController(sched *ssntpSchedulerServer, uuid string) {
 // Add state for newly connected Compute Node
 // This function is symmetric with disconnectComputeNode().
 func connectComputeNode(sched *ssntpSchedulerServer, uuid string) {
+	addComputeNode(sched, uuid, "")
+}
+
+// Add state for a Compute Node connected to this scheduler (empty owner)
+// or to the owner peer scheduler.
+func addComputeNode(sched *ssntpSchedulerServer, uuid string, owner string) {
 	sched.cnMutex.Lock()
 	defer sched.cnMutex.Unlock()
 
-	if sched.cnMap[uuid] != nil {
-		glog.Warningf("Unexpected reconnect from compute node %s\n", uuid)
+	if node := sched.cnMap[uuid]; node != nil {
+		if !sched.takeOverNode(node, owner, payloads.ComputeNode) {
+			glog.Warningf("Unexpected reconnect from compute node %s\n", uuid)
+		}
 		return
 	}
 
 	var node nodeStat
 	node.status = ssntp.CONNECTED
 	node.uuid = uuid
+	node.owner = owner
 	sched.cnList = append(sched.cnList, &node)
 	sched.cnMap[uuid] = &node
 
 	sched.sendNodeConnectedEvents(uuid, payloads.ComputeNode)
+	if owner == "" {
+		sched.publishNodeConnection(uuid, payloads.ComputeNode, true)
+	}
 }
 
 // Undo previous state additions for departed Compute Node
 // This function is symmetric with connectComputeNode().
 func disconnectComputeNode(sched *ssntpSchedulerServer, uuid string) {
+	removeComputeNode(sched, uuid, "")
+}
+
+// Remove a Compute Node, unless it is now owned by another scheduler.
+func removeComputeNode(sched *ssntpSchedulerServer, uuid string, owner string) {
 	sched.cnMutex.Lock()
 	defer sched.cnMutex.Unlock()
 
@@ -269,6 +351,10 @@ func disconnectComputeNode(sched *ssntpSchedulerServer, uuid string) {
 		return
 	}
 
+	if !nodeOwnedBy(node, owner) {
+		return
+	}
+
 	//TODO: consider moving to cnInactiveMap?
 	delete(sched.cnMap, uuid)
 
@@ -286,44 +372,75 @@ func disconnectComputeNode(sched *ssntpSchedulerServer, uuid string) {
 	}
 
 	sched.sendNodeDisconnectedEvents(uuid, payloads.ComputeNode)
+	if owner == "" {
+		sched.publishNodeConnection(uuid, payloads.ComputeNode, false)
+	}
 }
 
 // Add state for newly connected Network Node
 // This function is symmetric with disconnectNetworkNode().
 func connectNetworkNode(sched *ssntpSchedulerServer, uuid string) {
+	addNetworkNode(sched, uuid, "")
+}
+
+// Add state for a Network Node connected to this scheduler (empty owner)
+// or to the owner peer scheduler.
+func addNetworkNode(sched *ssntpSchedulerServer, uuid string, owner string) {
 	sched.nnMutex.Lock()
 	defer sched.nnMutex.Unlock()
 
-	if sched.nnMap[uuid] != nil {
-		glog.Warningf("Unexpected reconnect from network compute node %s\n", uuid)
+	if node := sched.nnMap[uuid]; node != nil {
+		if !sched.takeOverNode(node, owner, payloads.NetworkNode) {
+			glog.Warningf("Unexpected reconnect from network compute node %s\n", uuid)
+		}
 		return
 	}
 
 	var node nodeStat
 	node.status = ssntp.CONNECTED
 	node.uuid = uuid
+	node.owner = owner
 	sched.nnMap[uuid] = &node
 
 	sched.sendNodeConnectedEvents(uuid, payloads.NetworkNode)
+	if owner == "" {
+		sched.publishNodeConnection(uuid, payloads.NetworkNode, true)
+	}
 }
 
 // Undo previous state additions for departed Network Node
 // This function is symmetric with connectNetworkNode().
 func disconnectNetworkNode(sched *ssntpSchedulerServer, uuid string) {
+	removeNetworkNode(sched, uuid, "")
+}
+
+// Remove a Network Node, unless it is now owned by another scheduler.
+func removeNetworkNode(sched *ssntpSchedulerServer, uuid string, owner string) {
 	sched.nnMutex.Lock()
 	defer sched.nnMutex.Unlock()
 
-	if sched.nnMap[uuid] == nil {
+	node := sched.nnMap[uuid]
+	if node == nil {
 		glog.Warningf("Unexpected disconnect from network compute node %s\n", uuid)
 		return
 	}
 
+	if !nodeOwnedBy(node, owner) {
+		return
+	}
+
 	//TODO: consider moving to nnInactiveMap?
 	delete(sched.nnMap, uuid)
 
 	sched.sendNodeDisconnectedEvents(uuid, payloads.NetworkNode)
+	if owner == "" {
+		sched.publishNodeConnection(uuid, payloads.NetworkNode, false)
+	}
 }
 func (sched *ssntpSchedulerServer) ConnectNotify(uuid string, role ssntp.Role) {
+	if role.IsScheduler() {
+		connectPeer(sched, uuid)
+	}
 	if role.IsController() {
 		connectController(sched, uuid)
 	}
@@ -338,6 +455,9 @@ func (sched *ssntpSchedulerServer) ConnectNotify(uuid string, role ssntp.Role) {
 }
 
 func (sched *ssntpSchedulerServer) DisconnectNotify(uuid string, role ssntp.Role) {
+	if role.IsScheduler() {
+		disconnectPeer(sched, uuid)
+	}
 	if role.IsController() {
 		disconnectController(sched, uuid)
 	}
@@ -393,6 +513,7 @@ func (sched *ssntpSchedulerServer) StatusNotify(uuid string, status ssntp.Status
 		if sched.cnMap[uuid] != nil {
 			cn = sched.cnMap[uuid]
 			sched.updateNodeStat(cn, status, frame)
+			sched.publishNodeStatus(cn)
 		}
 	}
 
@@ -403,6 +524,7 @@ func (sched *ssntpSchedulerServer) StatusNotify(uuid string, status ssntp.Status
 		if sched.nnMap[uuid] != nil {
 			nn = sched.nnMap[uuid]
 			sched.updateNodeStat(nn, status, frame)
+			sched.publishNodeStatus(nn)
 		}
 	}
 }
@@ -411,6 +533,7 @@ type workResources struct {
 	instanceUUID string
 	memReqMB     int
 	networkNode  int
+	localOnly    bool // only fit on nodes connected to this scheduler
 }
 
 func (sched *ssntpSchedulerServer) getWorkloadResources(work *payloads.Start) (workload workResources, err error) {
@@ -445,6 +568,10 @@ func (sched *ssntpSchedulerServer) getWorkloadResources(work *payloads.Start) (w
 
 // Check resource demands are satisfiable by the referenced, locked nodeStat object
 func (sched *ssntpSchedulerServer) workloadFits(node *nodeStat, workload *workResources) bool {
+	if workload.localOnly && node.owner != "" {
+		return false
+	}
+
 	// simple scheduling policy == first memory fit
 	if node.memAvailMB >= workload.memReqMB &&
 		node.status == ssntp.READY {
@@ -498,6 +625,13 @@ func (sched *ssntpSchedulerServer) fwdEventToCNCI(event ssntp.Event, payload []b
 		return
 	}
 
+	if _, err := sched.ssntp.ClientRole(concentratorUUID); err != nil && len(sched.ssntp.GroupMembers(peerGroup)) > 0 {
+		// the CNCI may be connected to one of our peers
+		glog.V(2).Infof("Forwarding %s for %s to peer schedulers\n", event.String(), concentratorUUID)
+		dest.AddGroupRecipient(peerGroup)
+		return dest
+	}
+
 	glog.V(2).Infof("Forwarding %s to %s\n", event.String(), concentratorUUID)
 	dest.AddRecipient(concentratorUUID)
 
@@ -524,6 +658,34 @@ func getWorkloadAgentUUID(sched *ssntpSchedulerServer, command ssntp.Command, pa
 		var cmd payloads.Evacuate
 		err := yaml.Unmarshal(payload, &cmd)
 		return "", cmd.Evacuate.WorkloadAgentUUID, err
+	case ssntp.REBOOT:
+		var cmd payloads.Reboot
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Reboot.InstanceUUID, cmd.Reboot.WorkloadAgentUUID, err
+	case ssntp.PAUSE:
+		var cmd payloads.Pause
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Pause.InstanceUUID, cmd.Pause.WorkloadAgentUUID, err
+	case ssntp.UNPAUSE:
+		var cmd payloads.Unpause
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Unpause.InstanceUUID, cmd.Unpause.WorkloadAgentUUID, err
+	case ssntp.SUSPEND:
+		var cmd payloads.Suspend
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Suspend.InstanceUUID, cmd.Suspend.WorkloadAgentUUID, err
+	case ssntp.RESUME:
+		var cmd payloads.Resume
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Resume.InstanceUUID, cmd.Resume.WorkloadAgentUUID, err
+	case ssntp.REBUILD:
+		var cmd payloads.Rebuild
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Rebuild.InstanceUUID, cmd.Rebuild.WorkloadAgentUUID, err
+	case ssntp.RESIZE:
+		var cmd payloads.Resize
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Resize.InstanceUUID, cmd.Resize.WorkloadAgentUUID, err
 	}
 }
 
@@ -537,6 +699,12 @@ func (sched *ssntpSchedulerServer) fwdCmdToComputeNode(command ssntp.Command, pa
 		return
 	}
 
+	if owner := sched.nodeOwner(cnDestUUID); owner != "" {
+		glog.V(2).Infof("Forwarding controller %s command for %s to scheduler %s\n", command.String(), cnDestUUID, owner)
+		dest.AddRecipient(owner)
+		return
+	}
+
 	glog.V(2).Infof("Forwarding controller %s command to %s\n", command.String(), cnDestUUID)
 	dest.AddRecipient(cnDestUUID)
 
@@ -616,6 +784,12 @@ func (sched *ssntpSchedulerServer) pickNetworkNode(controllerUUID string, worklo
 }
 
 func startWorkload(sched *ssntpSchedulerServer, controllerUUID string, payload []byte) (dest ssntp.ForwardDestination, instanceUUID string) {
+	return scheduleWorkload(sched, controllerUUID, payload, false)
+}
+
+// scheduleWorkload picks a node for a START payload. Workloads forwarded
+// by a peer scheduler are only scheduled on locally connected nodes.
+func scheduleWorkload(sched *ssntpSchedulerServer, controllerUUID string, payload []byte, localOnly bool) (dest ssntp.ForwardDestination, instanceUUID string) {
 	var work payloads.Start
 	err := yaml.Unmarshal(payload, &work)
 	if err != nil {
@@ -632,6 +806,7 @@ func startWorkload(sched *ssntpSchedulerServer, controllerUUID string, payload [
 	}
 
 	instanceUUID = workload.instanceUUID
+	workload.localOnly = localOnly
 
 	var targetNode *nodeStat
 
@@ -649,7 +824,12 @@ func startWorkload(sched *ssntpSchedulerServer, controllerUUID string, payload [
 		//	hopefully not queue when all nodes have just started a workload.
 		sched.decrementResourceUsage(targetNode, &workload)
 
-		dest.AddRecipient(targetNode.uuid)
+		if targetNode.owner != "" {
+			// the owner scheduler makes the final placement
+			dest.AddRecipient(targetNode.owner)
+		} else {
+			dest.AddRecipient(targetNode.uuid)
+		}
 		targetNode.mutex.Unlock()
 	} else {
 		// TODO Queue the frame ?
@@ -695,6 +875,9 @@ func (sched *ssntpSchedulerServer) CommandForward(controllerUUID string, command
 	case ssntp.DELETE:
 		fallthrough
 	case ssntp.EVACUATE:
+		fallthrough
+	case ssntp.REBOOT, ssntp.PAUSE, ssntp.UNPAUSE, ssntp.SUSPEND,
+		ssntp.RESUME, ssntp.REBUILD, ssntp.RESIZE:
 		dest, instanceUUID = sched.fwdCmdToComputeNode(command, payload)
 	default:
 		dest.SetDecision(ssntp.Discard)
@@ -922,35 +1105,49 @@ func toggleDebug(sched *ssntpSchedulerServer) {
 	}
 }
 
+// Frames going to all Controllers also go to the peer schedulers, which
+// relay them to their own Controllers.
 func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 	sched.config.ForwardRules = []ssntp.FrameForwardRule{
 		{ // all STATS commands go to all Controllers
-			Operand: ssntp.STATS,
-			Dest:    ssntp.Controller,
+			Operand:   ssntp.STATS,
+			Dest:      ssntp.Controller,
+			DestGroup: peerGroup,
 		},
 		{ // all TraceReport events go to all Controllers
-			Operand: ssntp.TraceReport,
-			Dest:    ssntp.Controller,
+			Operand:   ssntp.TraceReport,
+			Dest:      ssntp.Controller,
+			DestGroup: peerGroup,
 		},
 		{ // all InstanceDeleted events go to all Controllers
-			Operand: ssntp.InstanceDeleted,
-			Dest:    ssntp.Controller,
+			Operand:   ssntp.InstanceDeleted,
+			Dest:      ssntp.Controller,
+			DestGroup: peerGroup,
 		},
 		{ // all ConcentratorInstanceAdded events go to all Controllers
-			Operand: ssntp.ConcentratorInstanceAdded,
-			Dest:    ssntp.Controller,
+			Operand:   ssntp.ConcentratorInstanceAdded,
+			Dest:      ssntp.Controller,
+			DestGroup: peerGroup,
 		},
 		{ // all StartFailure events go to all Controllers
-			Operand: ssntp.StartFailure,
-			Dest:    ssntp.Controller,
+			Operand:   ssntp.StartFailure,
+			Dest:      ssntp.Controller,
+			DestGroup: peerGroup,
 		},
 		{ // all StopFailure events go to all Controllers
-			Operand: ssntp.StopFailure,
-			Dest:    ssntp.Controller,
+			Operand:   ssntp.StopFailure,
+			Dest:      ssntp.Controller,
+			DestGroup: peerGroup,
 		},
 		{ // all RestartFailure events go to all Controllers
-			Operand: ssntp.RestartFailure,
-			Dest:    ssntp.Controller,
+			Operand:   ssntp.RestartFailure,
+			Dest:      ssntp.Controller,
+			DestGroup: peerGroup,
+		},
+		{ // all InstanceActionFailure events go to all Controllers
+			Operand:   ssntp.InstanceActionFailure,
+			Dest:      ssntp.Controller,
+			DestGroup: peerGroup,
 		},
 		{ // all START command are processed by the Command forwarder
 			Operand:        ssntp.START,
@@ -972,6 +1169,34 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand:        ssntp.EVACUATE,
 			CommandForward: sched,
 		},
+		{ // all REBOOT command are processed by the Command forwarder
+			Operand:        ssntp.REBOOT,
+			CommandForward: sched,
+		},
+		{ // all PAUSE command are processed by the Command forwarder
+			Operand:        ssntp.PAUSE,
+			CommandForward: sched,
+		},
+		{ // all UNPAUSE command are processed by the Command forwarder
+			Operand:        ssntp.UNPAUSE,
+			CommandForward: sched,
+		},
+		{ // all SUSPEND command are processed by the Command forwarder
+			Operand:        ssntp.SUSPEND,
+			CommandForward: sched,
+		},
+		{ // all RESUME command are processed by the Command forwarder
+			Operand:        ssntp.RESUME,
+			CommandForward: sched,
+		},
+		{ // all REBUILD command are processed by the Command forwarder
+			Operand:        ssntp.REBUILD,
+			CommandForward: sched,
+		},
+		{ // all RESIZE command are processed by the Command forwarder
+			Operand:        ssntp.RESIZE,
+			CommandForward: sched,
+		},
 		{ // all TenantAdded events are processed by the Event forwarder
 			Operand:      ssntp.TenantAdded,
 			EventForward: sched,
@@ -987,6 +1212,32 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 	}
 }
 
+// schedulerAuthorization returns the frames each SSNTP client role is
+// allowed to send to the scheduler.
+func schedulerAuthorization() []ssntp.AuthorizationRule {
+	var rules []ssntp.AuthorizationRule
+
+	controller := []interface{}{ssntp.COMMAND, ssntp.TraceReport, ssntp.ERROR}
+	for _, op := range controller {
+		rules = append(rules, ssntp.AuthorizationRule{Role: ssntp.Controller, Operand: op})
+	}
+
+	agent := []interface{}{ssntp.STATUS, ssntp.STATS, ssntp.TenantAdded, ssntp.TenantRemoved,
+		ssntp.InstanceDeleted, ssntp.TraceReport, ssntp.ERROR}
+	for _, op := range agent {
+		rules = append(rules, ssntp.AuthorizationRule{Role: ssntp.AGENT, Operand: op})
+		rules = append(rules, ssntp.AuthorizationRule{Role: ssntp.NETAGENT, Operand: op})
+	}
+
+	cnciAgent := []interface{}{ssntp.STATUS, ssntp.ConcentratorInstanceAdded, ssntp.PublicIPAssigned,
+		ssntp.TraceReport, ssntp.ERROR}
+	for _, op := range cnciAgent {
+		rules = append(rules, ssntp.AuthorizationRule{Role: ssntp.CNCIAGENT, Operand: op})
+	}
+
+	return rules
+}
+
 func configSchedulerServer() (sched *ssntpSchedulerServer) {
 	logDirFlag := flag.Lookup("log_dir")
 	if logDirFlag == nil {
@@ -1011,6 +1262,29 @@ func configSchedulerServer() (sched *ssntpSchedulerServer) {
 		CAcert:    *cacert,
 		Cert:      *cert,
 		ConfigURI: *configURI,
+
+		KeepaliveInterval:  *keepalive,
+		CRL:                *crl,
+		DenyList:           *denyList,
+		MaxFrameSize:       *maxFrameSize,
+		MaxPayloadSize:     *maxPayloadSize,
+		MaxHandshakesPerIP: *maxHandshakes,
+		HandshakeTimeout:   *handshakeTimeout,
+
+		CompressionThreshold: *compressionThreshold,
+	}
+
+	if *capture != "" {
+		f, err := os.Create(*capture)
+		if err != nil {
+			glog.Errorf("Unable to create capture file (%s) %v", *capture, err)
+		} else {
+			sched.config.Capture = f
+		}
+	}
+
+	if *authorize {
+		sched.config.Authorization = schedulerAuthorization()
 	}
 
 	setSSNTPForwardRules(sched)
@@ -1018,6 +1292,37 @@ func configSchedulerServer() (sched *ssntpSchedulerServer) {
 	return sched
 }
 
+// reloadOnSignal reloads the scheduler certificates and revocation
+// lists on SIGHUP, without dropping the established SSNTP connections.
+func reloadOnSignal(sched *ssntpSchedulerServer) {
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGHUP)
+
+	for range signalCh {
+		glog.Info("Received SIGHUP, reloading certificates")
+
+		if err := sched.ssntp.ReloadCertificates(); err != nil {
+			glog.Errorf("Unable to reload certificates: %v", err)
+		}
+
+		if err := sched.ssntp.ReloadRevocationList(); err != nil {
+			glog.Errorf("Unable to reload revocation list: %v", err)
+		}
+	}
+}
+
+// serveMetrics exposes the SSNTP sessions metrics in the
+// Prometheus text format on the /metrics path.
+func serveMetrics(sched *ssntpSchedulerServer) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", sched.ssntp.MetricsHandler())
+
+	err := http.ListenAndServe(*metrics, mux)
+	if err != nil {
+		glog.Errorf("Unable to serve metrics on %s: %v", *metrics, err)
+	}
+}
+
 func main() {
 	flag.Parse()
 
@@ -1027,5 +1332,16 @@ func main() {
 		return
 	}
 
+	go reloadOnSignal(sched)
+
+	if *peers != "" {
+		sched.config.SyncChannel = make(chan error, 1)
+		go dialPeers(sched, strings.Split(*peers, ","))
+	}
+
+	if *metrics != "" {
+		go serveMetrics(sched)
+	}
+
 	sched.ssntp.Serve(sched.config, sched)
 }
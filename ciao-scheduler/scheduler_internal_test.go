@@ -26,6 +26,7 @@ import (
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
 	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
 )
 
 var sched *ssntpSchedulerServer
@@ -628,6 +629,10 @@ func TestGetWorkloadAgentUUID(t *testing.T) {
 		{ssntp.STOP, []byte(testutil.StopYaml), "3390740c-dce9-48d6-b83a-a717417072ce", "59460b8a-5f53-4e3e-b5ce-b71fed8c7e64"},
 		{ssntp.DELETE, []byte(testutil.DeleteYaml), "3390740c-dce9-48d6-b83a-a717417072ce", "59460b8a-5f53-4e3e-b5ce-b71fed8c7e64"},
 		{ssntp.EVACUATE, []byte(testutil.EvacuateYaml), "", "64803ffa-fb47-49fa-8191-15d2c34e4dd3"},
+		{ssntp.REBOOT, []byte(testutil.RebootYaml), "3390740c-dce9-48d6-b83a-a717417072ce", "59460b8a-5f53-4e3e-b5ce-b71fed8c7e64"},
+		{ssntp.PAUSE, []byte(testutil.PauseYaml), "3390740c-dce9-48d6-b83a-a717417072ce", "59460b8a-5f53-4e3e-b5ce-b71fed8c7e64"},
+		{ssntp.REBUILD, []byte(testutil.RebuildYaml), "3390740c-dce9-48d6-b83a-a717417072ce", "59460b8a-5f53-4e3e-b5ce-b71fed8c7e64"},
+		{ssntp.RESIZE, []byte(testutil.ResizeYaml), "3390740c-dce9-48d6-b83a-a717417072ce", "59460b8a-5f53-4e3e-b5ce-b71fed8c7e64"},
 	}
 	for _, test := range stringTests {
 		instanceUUID, agentUUID, _ := GetWorkloadAgentUUID(sched, test.cmd, test.yaml)
@@ -639,3 +644,64 @@ func TestGetWorkloadAgentUUID(t *testing.T) {
 		}
 	}
 }
+
+func TestFederatedRegistry(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+	spinUpController(sched, 1, controllerMaster)
+	var controllerUUID = fmt.Sprintf("%08d", 1)
+
+	peerUUID := "d5b5a5c4-3f9c-4b7e-9d2a-5a0e4c6f1b23"
+	nodeUUID := "59460b8a-5f53-4e3e-b5ce-b71fed8c7e64"
+
+	// a compute node connected to a peer scheduler
+	addComputeNode(sched, nodeUUID, peerUUID)
+	ready := payloads.Ready{
+		NodeUUID:       nodeUUID,
+		MemTotalMB:     16384,
+		MemAvailableMB: 16384,
+		CpusOnline:     4,
+	}
+	payload, err := yaml.Marshal(&ready)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched.updatePeerNodeStat(peerUUID, ssntp.READY, &ssntp.Frame{Payload: payload})
+
+	// workloads fitting on it go to its scheduler
+	fwd, _ := startWorkload(sched, controllerUUID, []byte(testutil.StartYaml))
+	recipients := fwd.Recipients()
+	if fwd.Decision() != ssntp.Forward || len(recipients) != 1 || recipients[0] != peerUUID {
+		t.Errorf("workload not forwarded to peer scheduler, got decision=0x%x, recipients=%v", fwd.Decision(), recipients)
+	}
+
+	// workloads forwarded by a peer only go to local nodes
+	fwd, _ = scheduleWorkload(sched, peerUUID, []byte(testutil.StartYaml), true)
+	if fwd.Decision() != ssntp.Discard {
+		t.Errorf("peer workload scheduled on a remote node %v", fwd.Recipients())
+	}
+
+	// commands for its instances go to its scheduler
+	fwd, _ = sched.fwdCmdToComputeNode(ssntp.STOP, []byte(testutil.StopYaml))
+	recipients = fwd.Recipients()
+	if len(recipients) != 1 || recipients[0] != peerUUID {
+		t.Errorf("STOP not forwarded to peer scheduler, got recipients=%v", recipients)
+	}
+
+	// the node fails over to us and outlives its former scheduler
+	ConnectComputeNode(sched, nodeUUID)
+	removePeerEntries(sched, peerUUID)
+	if sched.cnMap[nodeUUID] == nil || sched.nodeOwner(nodeUUID) != "" {
+		t.Errorf("failed over compute node not owned by the scheduler")
+	}
+
+	// the peer entries go away with the peer
+	addController(sched, "remote-controller", peerUUID)
+	addNetworkNode(sched, "remote-network-node", peerUUID)
+	removePeerEntries(sched, peerUUID)
+	if sched.controllerMap["remote-controller"] != nil || sched.nnMap["remote-network-node"] != nil {
+		t.Errorf("peer scheduler entries not removed")
+	}
+}
@@ -69,42 +69,80 @@ const (
 	// ComputeStatusStopped is a filter that used to select exited
 	// instances in requests to the controller.
 	ComputeStatusStopped = "exited"
+
+	// ComputeStatusPaused is a filter that used to select paused
+	// instances in requests to the controller.
+	ComputeStatusPaused = "paused"
+
+	// ComputeStatusSuspended is a filter that used to select suspended
+	// instances in requests to the controller.
+	ComputeStatusSuspended = "suspended"
+
+	// ComputeStatusRebooting is the status reported by the controller
+	// for an instance to which a REBOOT command has been sent.
+	ComputeStatusRebooting = "rebooting"
+
+	// ComputeStatusRebuilding is the status reported by the controller
+	// for an instance to which a REBUILD command has been sent.
+	ComputeStatusRebuilding = "rebuilding"
+
+	// ComputeStatusResizing is the status reported by the controller
+	// for an instance to which a RESIZE command has been sent.
+	ComputeStatusResizing = "resizing"
+
+	// ComputeStatusPausing is the status reported by the controller
+	// for an instance to which a PAUSE command has been sent.
+	ComputeStatusPausing = "pausing"
+
+	// ComputeStatusUnpausing is the status reported by the controller
+	// for an instance to which an UNPAUSE command has been sent.
+	ComputeStatusUnpausing = "unpausing"
+
+	// ComputeStatusSuspending is the status reported by the controller
+	// for an instance to which a SUSPEND command has been sent.
+	ComputeStatusSuspending = "suspending"
+
+	// ComputeStatusResuming is the status reported by the controller
+	// for an instance to which a RESUME command has been sent.
+	ComputeStatusResuming = "resuming"
 )
 
 // Server contains information about a specific instance within a ciao cluster.
 type Server struct {
-	Addresses                        Addresses       `json:"addresses"`
-	Created                          time.Time       `json:"created"`
-	Flavor                           Flavor          `json:"flavor"`
-	HostID                           string          `json:"hostId"`
-	ID                               string          `json:"id"`
-	Image                            Image           `json:"image"`
-	KeyName                          string          `json:"key_name"`
-	Links                            []Link          `json:"links"`
-	Name                             string          `json:"name"`
-	AccessIPv4                       string          `json:"accessIPv4"`
-	AccessIPv6                       string          `json:"accessIPv6"`
-	ConfigDrive                      string          `json:"config_drive"`
-	OSDCFDiskConfig                  string          `json:"OS-DCF:diskConfig"`
-	OSEXTAZAvailabilityZone          string          `json:"OS-EXT-AZ:availability_zone"`
-	OSEXTSRVATTRHost                 string          `json:"OS-EXT-SRV-ATTR:host"`
-	OSEXTSRVATTRHypervisorHostname   string          `json:"OS-EXT-SRV-ATTR:hypervisor_hostname"`
-	OSEXTSRVATTRInstanceName         string          `json:"OS-EXT-SRV-ATTR:instance_name"`
-	OSEXTSTSPowerState               int             `json:"OS-EXT-STS:power_state"`
-	OSEXTSTSTaskState                string          `json:"OS-EXT-STS:task_state"`
-	OSEXTSTSVMState                  string          `json:"OS-EXT-STS:vm_state"`
-	OsExtendedVolumesVolumesAttached []string        `json:"os-extended-volumes:volumes_attached"`
-	OSSRVUSGLaunchedAt               time.Time       `json:"OS-SRV-USG:launched_at"`
-	OSSRVUSGTerminatedAt             time.Time       `json:"OS-SRV-USG:terminated_at"`
-	Progress                         int             `json:"progress"`
-	SecurityGroups                   []SecurityGroup `json:"security_groups"`
-	Status                           string          `json:"status"`
-	HostStatus                       string          `json:"host_status"`
-	TenantID                         string          `json:"tenant_id"`
-	Updated                          time.Time       `json:"updated"`
-	UserID                           string          `json:"user_id"`
-	SSHIP                            string          `json:"ssh_ip"`
-	SSHPort                          int             `json:"ssh_port"`
+	Addresses                        Addresses         `json:"addresses"`
+	Created                          time.Time         `json:"created"`
+	Flavor                           Flavor            `json:"flavor"`
+	HostID                           string            `json:"hostId"`
+	ID                               string            `json:"id"`
+	Image                            Image             `json:"image"`
+	KeyName                          string            `json:"key_name"`
+	Links                            []Link            `json:"links"`
+	Metadata                         map[string]string `json:"metadata"`
+	Name                             string            `json:"name"`
+	AccessIPv4                       string            `json:"accessIPv4"`
+	AccessIPv6                       string            `json:"accessIPv6"`
+	ConfigDrive                      string            `json:"config_drive"`
+	OSDCFDiskConfig                  string            `json:"OS-DCF:diskConfig"`
+	OSEXTAZAvailabilityZone          string            `json:"OS-EXT-AZ:availability_zone"`
+	OSEXTSRVATTRHost                 string            `json:"OS-EXT-SRV-ATTR:host"`
+	OSEXTSRVATTRHypervisorHostname   string            `json:"OS-EXT-SRV-ATTR:hypervisor_hostname"`
+	OSEXTSRVATTRInstanceName         string            `json:"OS-EXT-SRV-ATTR:instance_name"`
+	OSEXTSTSPowerState               int               `json:"OS-EXT-STS:power_state"`
+	OSEXTSTSTaskState                string            `json:"OS-EXT-STS:task_state"`
+	OSEXTSTSVMState                  string            `json:"OS-EXT-STS:vm_state"`
+	OsExtendedVolumesVolumesAttached []string          `json:"os-extended-volumes:volumes_attached"`
+	OSSRVUSGLaunchedAt               time.Time         `json:"OS-SRV-USG:launched_at"`
+	OSSRVUSGTerminatedAt             time.Time         `json:"OS-SRV-USG:terminated_at"`
+	Progress                         int               `json:"progress"`
+	SecurityGroups                   []SecurityGroup   `json:"security_groups"`
+	Status                           string            `json:"status"`
+	Tags                             []string          `json:"tags"`
+	HostStatus                       string            `json:"host_status"`
+	TenantID                         string            `json:"tenant_id"`
+	Updated                          time.Time         `json:"updated"`
+	UserID                           string            `json:"user_id"`
+	SSHIP                            string            `json:"ssh_ip"`
+	SSHPort                          int               `json:"ssh_port"`
 }
 
 // ComputeServers represents the unmarshalled version of the contents of a
@@ -192,19 +230,104 @@ func NewComputeFlavorsDetails() (flavors ComputeFlavorsDetails) {
 	return
 }
 
+// CiaoFlavorResource specifies the default value of a resource assigned to
+// instances of a flavor, e.g., the number of VCPUs.
+type CiaoFlavorResource struct {
+	Type  Resource `json:"type"`
+	Value int      `json:"value"`
+}
+
+// CiaoFlavor contains the information needed to create or update a flavor,
+// i.e., a ciao workload.
+type CiaoFlavor struct {
+	ID        string               `json:"id"`
+	Name      string               `json:"name"`
+	FWType    string               `json:"fw_type"`
+	VMType    Hypervisor           `json:"vm_type"`
+	ImageID   string               `json:"image_id"`
+	ImageName string               `json:"image_name"`
+	Config    string               `json:"config"`
+	Resources []CiaoFlavorResource `json:"resources"`
+}
+
+// CiaoCreateFlavor represents the unmarshalled version of the contents of a
+// /v2.1/flavors POST request or a /v2.1/flavors/{flavor} PUT request.  It
+// contains the cloud-init configuration and the default resources of the
+// flavor.
+type CiaoCreateFlavor struct {
+	Flavor CiaoFlavor `json:"flavor"`
+}
+
 // ComputeCreateServer represents the unmarshalled version of the contents of a
 // /v2.1/{tenant}/servers request.  It contains the information needed to start
 // one or more instances.
 type ComputeCreateServer struct {
 	Server struct {
-		Name         string `json:"name"`
-		Image        string `json:"imageRef"`
-		Workload     string `json:"flavorRef"`
-		MaxInstances int    `json:"max_count"`
-		MinInstances int    `json:"min_count"`
+		Name         string            `json:"name"`
+		Image        string            `json:"imageRef"`
+		Workload     string            `json:"flavorRef"`
+		MaxInstances int               `json:"max_count"`
+		MinInstances int               `json:"min_count"`
+		KeyName      string            `json:"key_name,omitempty"`
+		Metadata     map[string]string `json:"metadata,omitempty"`
+		Tags         []string          `json:"tags,omitempty"`
+		TraceLabel   string            `json:"trace_label,omitempty"`
 	} `json:"server"`
 }
 
+// ComputeServerMetadata represents the unmarshalled version of the contents
+// of a /v2.1/{tenant}/servers/{server}/metadata request or response.
+type ComputeServerMetadata struct {
+	Metadata map[string]string `json:"metadata"`
+}
+
+// ComputeServerMetadataItem represents the unmarshalled version of the
+// contents of a /v2.1/{tenant}/servers/{server}/metadata/{key} request or
+// response.  It contains a single key/value pair.
+type ComputeServerMetadataItem struct {
+	Meta map[string]string `json:"meta"`
+}
+
+// ComputeServerTags represents the unmarshalled version of the contents
+// of a /v2.1/{tenant}/servers/{server}/tags request or response.
+type ComputeServerTags struct {
+	Tags []string `json:"tags"`
+}
+
+// KeyPair contains information about an SSH key pair.  PrivateKey is
+// only set in the response to a request creating a new key pair.
+type KeyPair struct {
+	Name        string    `json:"name"`
+	PublicKey   string    `json:"public_key"`
+	Fingerprint string    `json:"fingerprint"`
+	PrivateKey  string    `json:"private_key,omitempty"`
+	UserID      string    `json:"user_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ComputeCreateKeyPair represents the unmarshalled version of the contents
+// of a /v2.1/{tenant}/os-keypairs POST request.  A new key pair is generated
+// if PublicKey is empty, otherwise the public key is imported.
+type ComputeCreateKeyPair struct {
+	KeyPair struct {
+		Name      string `json:"name"`
+		PublicKey string `json:"public_key,omitempty"`
+	} `json:"keypair"`
+}
+
+// ComputeKeyPair represents the marshalled version of the contents of a
+// /v2.1/{tenant}/os-keypairs POST or /v2.1/{tenant}/os-keypairs/{keypair}
+// GET response.
+type ComputeKeyPair struct {
+	KeyPair KeyPair `json:"keypair"`
+}
+
+// ComputeKeyPairs represents the marshalled version of the contents of a
+// /v2.1/{tenant}/os-keypairs GET response.
+type ComputeKeyPairs struct {
+	KeyPairs []ComputeKeyPair `json:"keypairs"`
+}
+
 // CiaoComputeTenants represents the unmarshalled version of the contents of a
 // /v2.1/tenants response.  It contains information about the tenants in a ciao
 // cluster.
@@ -384,6 +507,27 @@ type CiaoServersAction struct {
 	ServerIDs []string `json:"servers"`
 }
 
+// ServerActionReboot represents the unmarshalled version of the reboot
+// member of a v2.1/{tenant}/servers/{server}/action request.
+type ServerActionReboot struct {
+	// Type is either SOFT or HARD.
+	Type string `json:"type"`
+}
+
+// ServerActionRebuild represents the unmarshalled version of the rebuild
+// member of a v2.1/{tenant}/servers/{server}/action request.
+type ServerActionRebuild struct {
+	// ImageRef is the UUID of the image from which the server is rebuilt.
+	ImageRef string `json:"imageRef"`
+}
+
+// ServerActionResize represents the unmarshalled version of the resize
+// member of a v2.1/{tenant}/servers/{server}/action request.
+type ServerActionResize struct {
+	// FlavorRef is the ID of the flavor the server is resized to.
+	FlavorRef string `json:"flavorRef"`
+}
+
 // CiaoTraceSummary contains information about a specific SSNTP Trace label.
 type CiaoTraceSummary struct {
 	Label     string `json:"label"`
@@ -23,7 +23,7 @@ type ServiceType string
 type StorageType string
 
 const (
-	// Glance is reserved for future use.
+	// Glance is the OpenStack image service, served by ciao-controller.
 	Glance ServiceType = "glance"
 
 	// Keystone is reserved for future use.
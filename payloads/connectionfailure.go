@@ -0,0 +1,64 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// ConnectionFailureReason denotes the underlying error that prevented
+// an SSNTP client from connecting to an SSNTP server.
+type ConnectionFailureReason string
+
+const (
+	// ConnectionInvalidFrame indicates that the SSNTP CONNECT or
+	// CONNECTED frame was corrupt or unexpected.
+	ConnectionInvalidFrame ConnectionFailureReason = "invalid_frame"
+
+	// IncompatibleVersion indicates that the SSNTP peers major
+	// protocol versions differ.
+	IncompatibleVersion = "incompatible_version"
+
+	// MissingCapabilities is returned when the peer does not support
+	// all of the SSNTP capabilities the rejecting entity requires.
+	MissingCapabilities = "missing_capabilities"
+)
+
+// ErrorConnectionFailure represents the unmarshalled version of the contents
+// of a SSNTP ERROR frame whose type is set to ssntp.ConnectionFailure.
+type ErrorConnectionFailure struct {
+	// Reason provides the reason for the connection failure, e.g.,
+	// IncompatibleVersion.
+	Reason ConnectionFailureReason `yaml:"reason"`
+
+	// Version is the SSNTP protocol version, formatted as major.minor,
+	// of the entity that rejected the connection.
+	Version string `yaml:"version"`
+
+	// MissingCapabilities is the list of required SSNTP capabilities
+	// that the rejected peer does not support.
+	MissingCapabilities []string `yaml:"missing_capabilities,omitempty"`
+}
+
+func (r ConnectionFailureReason) String() string {
+	switch r {
+	case ConnectionInvalidFrame:
+		return "Invalid connection frame"
+	case IncompatibleVersion:
+		return "Incompatible SSNTP version"
+	case MissingCapabilities:
+		return "Missing required SSNTP capabilities"
+	}
+
+	return ""
+}
@@ -0,0 +1,82 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConnectionFailureUnmarshal(t *testing.T) {
+	connectionFailureYaml := `reason: missing_capabilities
+version: "0.2"
+missing_capabilities:
+- correlation
+`
+	var error ErrorConnectionFailure
+	err := yaml.Unmarshal([]byte(connectionFailureYaml), &error)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if error.Reason != MissingCapabilities {
+		t.Error("Wrong Error field")
+	}
+
+	if error.Version != "0.2" {
+		t.Error("Wrong Version field")
+	}
+
+	if len(error.MissingCapabilities) != 1 || error.MissingCapabilities[0] != "correlation" {
+		t.Error("Wrong MissingCapabilities field")
+	}
+}
+
+func TestConnectionFailureMarshal(t *testing.T) {
+	error := ErrorConnectionFailure{
+		Reason:  IncompatibleVersion,
+		Version: "0.2",
+	}
+
+	y, err := yaml.Marshal(&error)
+	if err != nil {
+		t.Error(err)
+	}
+	fmt.Println(string(y))
+}
+
+func TestConnectionFailureString(t *testing.T) {
+	var stringTests = []struct {
+		r        ConnectionFailureReason
+		expected string
+	}{
+		{ConnectionInvalidFrame, "Invalid connection frame"},
+		{IncompatibleVersion, "Incompatible SSNTP version"},
+		{MissingCapabilities, "Missing required SSNTP capabilities"},
+	}
+	error := ErrorConnectionFailure{}
+	for _, test := range stringTests {
+		error.Reason = test.r
+		s := error.Reason.String()
+		if s != test.expected {
+			t.Errorf("expected \"%s\", got \"%s\"", test.expected, s)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+import (
+	"time"
+)
+
+// ImageStatus is the state of an image in the image service.
+type ImageStatus string
+
+// DiskFormat is the format of the disk image data.
+type DiskFormat string
+
+// ContainerFormat is the format of the container wrapping the image data.
+type ContainerFormat string
+
+// ImageVisibility controls who may use an image.
+type ImageVisibility string
+
+const (
+	// ImageQueued means the image has been created but has no data yet.
+	ImageQueued ImageStatus = "queued"
+
+	// ImageSaving means the image data is being uploaded.
+	ImageSaving = "saving"
+
+	// ImageActive means the image data is available for download.
+	ImageActive = "active"
+)
+
+const (
+	// Raw is an unstructured disk image.
+	Raw DiskFormat = "raw"
+
+	// QCow2 is a disk image supported by the QEMU emulator.
+	QCow2 = "qcow2"
+
+	// ISO is an archive format for the data contents of an optical disc.
+	ISO = "iso"
+
+	// VHD is a disk image used by VMware, Xen, Microsoft and VirtualBox.
+	VHD = "vhd"
+
+	// VMDK is a disk image used by VMware.
+	VMDK = "vmdk"
+
+	// VDI is a disk image used by VirtualBox.
+	VDI = "vdi"
+)
+
+const (
+	// Bare means the image data is not wrapped in a container.
+	Bare ContainerFormat = "bare"
+
+	// OVF is the Open Virtualization Format.
+	OVF = "ovf"
+
+	// OVA is an Open Virtualization Format tar archive.
+	OVA = "ova"
+
+	// DockerContainer is a Docker tar archive of the container filesystem.
+	DockerContainer = "docker"
+)
+
+const (
+	// ImagePublic images are available to all tenants.
+	ImagePublic ImageVisibility = "public"
+
+	// ImagePrivate images are only available to administrators.
+	ImagePrivate = "private"
+)
+
+// ImageDetails contains the Glance v2 compatible information
+// about an image.
+type ImageDetails struct {
+	ID              string          `json:"id"`
+	Name            string          `json:"name"`
+	Status          ImageStatus     `json:"status"`
+	Visibility      ImageVisibility `json:"visibility"`
+	Owner           string          `json:"owner,omitempty"`
+	Checksum        string          `json:"checksum,omitempty"`
+	Size            int64           `json:"size,omitempty"`
+	DiskFormat      DiskFormat      `json:"disk_format,omitempty"`
+	ContainerFormat ContainerFormat `json:"container_format,omitempty"`
+	MinDisk         int             `json:"min_disk"`
+	MinRAM          int             `json:"min_ram"`
+	Protected       bool            `json:"protected"`
+	Tags            []string        `json:"tags"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	Self            string          `json:"self"`
+	File            string          `json:"file"`
+	Schema          string          `json:"schema"`
+}
+
+// ImageCreateRequest represents the unmarshalled version of the
+// contents of a /v2/images POST request.
+type ImageCreateRequest struct {
+	ID              string          `json:"id,omitempty"`
+	Name            string          `json:"name,omitempty"`
+	Visibility      ImageVisibility `json:"visibility,omitempty"`
+	DiskFormat      DiskFormat      `json:"disk_format,omitempty"`
+	ContainerFormat ContainerFormat `json:"container_format,omitempty"`
+	MinDisk         int             `json:"min_disk,omitempty"`
+	MinRAM          int             `json:"min_ram,omitempty"`
+	Protected       bool            `json:"protected,omitempty"`
+	Tags            []string        `json:"tags,omitempty"`
+}
+
+// ImageList represents the marshalled version of the contents of a
+// /v2/images GET response.
+type ImageList struct {
+	Images []ImageDetails `json:"images"`
+	Schema string         `json:"schema"`
+	First  string         `json:"first"`
+}
@@ -0,0 +1,125 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// RebootType indicates how an instance should be rebooted.
+type RebootType string
+
+const (
+	// SoftReboot asks the guest to shut itself down cleanly before the
+	// instance is booted again.
+	SoftReboot RebootType = "soft"
+
+	// HardReboot power cycles the instance without consulting the guest.
+	HardReboot = "hard"
+)
+
+// RebootCmd contains the information needed to reboot an instance.
+type RebootCmd struct {
+	// InstanceUUID is the UUID of the instance to reboot
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN/NN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// Type specifies whether a soft or a hard reboot is requested.
+	Type RebootType `yaml:"type"`
+}
+
+// Reboot represents the unmarshalled version of the contents of a SSNTP
+// REBOOT payload.
+type Reboot struct {
+	// Reboot contains information about the instance to reboot.
+	Reboot RebootCmd `yaml:"reboot"`
+}
+
+// Pause represents the unmarshalled version of the contents of a SSNTP PAUSE
+// payload.  The structure contains enough information to pause a running
+// instance.
+type Pause struct {
+	// Pause contains information about the instance to pause.
+	Pause StopCmd `yaml:"pause"`
+}
+
+// Unpause represents the unmarshalled version of the contents of a SSNTP
+// UNPAUSE payload.  The structure contains enough information to unpause a
+// paused instance.
+type Unpause struct {
+	// Unpause contains information about the instance to unpause.
+	Unpause StopCmd `yaml:"unpause"`
+}
+
+// Suspend represents the unmarshalled version of the contents of a SSNTP
+// SUSPEND payload.  The structure contains enough information to suspend a
+// running instance.
+type Suspend struct {
+	// Suspend contains information about the instance to suspend.
+	Suspend StopCmd `yaml:"suspend"`
+}
+
+// Resume represents the unmarshalled version of the contents of a SSNTP
+// RESUME payload.  The structure contains enough information to resume a
+// suspended instance.
+type Resume struct {
+	// Resume contains information about the instance to resume.
+	Resume StopCmd `yaml:"resume"`
+}
+
+// RebuildCmd contains the information needed to rebuild an instance from
+// a new image.
+type RebuildCmd struct {
+	// InstanceUUID is the UUID of the instance to rebuild
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// ImageUUID is the UUID of the image from which the root disk of
+	// the instance will be recreated.
+	ImageUUID string `yaml:"image_uuid"`
+}
+
+// Rebuild represents the unmarshalled version of the contents of a SSNTP
+// REBUILD payload.
+type Rebuild struct {
+	// Rebuild contains information about the instance to rebuild.
+	Rebuild RebuildCmd `yaml:"rebuild"`
+}
+
+// ResizeCmd contains the information needed to resize an instance to
+// a new flavor.
+type ResizeCmd struct {
+	// InstanceUUID is the UUID of the instance to resize
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// RequestedResources contains the resources of the new flavor.
+	RequestedResources []RequestedResource `yaml:"requested_resources"`
+}
+
+// Resize represents the unmarshalled version of the contents of a SSNTP
+// RESIZE payload.
+type Resize struct {
+	// Resize contains information about the instance to resize.
+	Resize ResizeCmd `yaml:"resize"`
+}
@@ -0,0 +1,93 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+const rebuildImageUUID = "b265f62b-e957-47fd-a0a2-6dc261c7315c"
+
+func TestRebootMarshal(t *testing.T) {
+	var reboot Reboot
+	reboot.Reboot.InstanceUUID = instanceUUID
+	reboot.Reboot.WorkloadAgentUUID = agentUUID
+	reboot.Reboot.Type = HardReboot
+
+	y, err := yaml.Marshal(&reboot)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.RebootYaml {
+		t.Errorf("REBOOT marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.RebootYaml)
+	}
+}
+
+func TestPauseUnmarshal(t *testing.T) {
+	var pause Pause
+	err := yaml.Unmarshal([]byte(testutil.PauseYaml), &pause)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if pause.Pause.InstanceUUID != instanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", pause.Pause.InstanceUUID)
+	}
+
+	if pause.Pause.WorkloadAgentUUID != agentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", pause.Pause.WorkloadAgentUUID)
+	}
+}
+
+func TestRebuildMarshal(t *testing.T) {
+	var rebuild Rebuild
+	rebuild.Rebuild.InstanceUUID = instanceUUID
+	rebuild.Rebuild.WorkloadAgentUUID = agentUUID
+	rebuild.Rebuild.ImageUUID = rebuildImageUUID
+
+	y, err := yaml.Marshal(&rebuild)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.RebuildYaml {
+		t.Errorf("REBUILD marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.RebuildYaml)
+	}
+}
+
+func TestResizeUnmarshal(t *testing.T) {
+	var resize Resize
+	err := yaml.Unmarshal([]byte(testutil.ResizeYaml), &resize)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if resize.Resize.InstanceUUID != instanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", resize.Resize.InstanceUUID)
+	}
+
+	rr := resize.Resize.RequestedResources
+	if len(rr) != 2 || rr[0].Type != VCPUs || rr[0].Value != 4 ||
+		rr[1].Type != MemMB || rr[1].Value != 8192 {
+		t.Errorf("Wrong requested resources %v", rr)
+	}
+}
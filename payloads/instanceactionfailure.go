@@ -0,0 +1,90 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// InstanceActionFailureReason denotes the underlying error that prevented
+// one of the SSNTP instance action commands, e.g., REBOOT or PAUSE, from
+// completing on a CN or a NN.
+type InstanceActionFailureReason string
+
+const (
+	// ActionNoInstance indicates that the action could not be performed
+	// as the instance does not exist on the node to which the command
+	// was sent.
+	ActionNoInstance InstanceActionFailureReason = "no_instance"
+
+	// ActionInvalidPayload indicates that the payload of the SSNTP
+	// command was corrupt and could not be unmarshalled.
+	ActionInvalidPayload = "invalid_payload"
+
+	// ActionInvalidData is returned by ciao-launcher if the contents
+	// of the payload are incorrect, e.g., the instance_uuid is missing.
+	ActionInvalidData = "invalid_data"
+
+	// ActionInvalidState indicates that the instance is not in a state
+	// in which the action can be performed, e.g., an attempt was made
+	// to unpause an instance that is not paused.
+	ActionInvalidState = "invalid_state"
+
+	// ActionImageFailure indicates that the new image of an instance
+	// being rebuilt could not be prepared.
+	ActionImageFailure = "image_failure"
+
+	// ActionFullComputeNode indicates that the node does not have enough
+	// resources to resize the instance.
+	ActionFullComputeNode = "full_cn"
+
+	// ActionFailure indicates that the hypervisor failed to carry out
+	// the action.
+	ActionFailure = "action_failure"
+)
+
+// ErrorInstanceActionFailure represents the unmarshalled version of the
+// contents of a SSNTP ERROR frame whose type is set to
+// ssntp.InstanceActionFailure.
+type ErrorInstanceActionFailure struct {
+	// InstanceUUID is the UUID of the instance on which the action failed.
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// Action is the name of the SSNTP command that failed, e.g., PAUSE.
+	Action string `yaml:"action"`
+
+	// Reason provides the reason for the failure, e.g.,
+	// ActionInvalidState.
+	Reason InstanceActionFailureReason `yaml:"reason"`
+}
+
+func (r InstanceActionFailureReason) String() string {
+	switch r {
+	case ActionNoInstance:
+		return "Instance does not exist"
+	case ActionInvalidPayload:
+		return "YAML payload is corrupt"
+	case ActionInvalidData:
+		return "Command section of YAML payload is corrupt or missing required information"
+	case ActionInvalidState:
+		return "Instance is not in a valid state for this action"
+	case ActionImageFailure:
+		return "Failed to prepare new image"
+	case ActionFullComputeNode:
+		return "Node does not have enough resources"
+	case ActionFailure:
+		return "Hypervisor failed to perform action"
+	}
+
+	return ""
+}
@@ -0,0 +1,89 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/docker/distribution/uuid"
+	"gopkg.in/yaml.v2"
+)
+
+func TestInstanceActionFailureUnmarshal(t *testing.T) {
+	actionFailureYaml := `instance_uuid: 2400bce6-ccc8-4a45-b2aa-b5cc3790077b
+action: PAUSE
+reason: invalid_state
+`
+	var error ErrorInstanceActionFailure
+	err := yaml.Unmarshal([]byte(actionFailureYaml), &error)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if error.InstanceUUID != "2400bce6-ccc8-4a45-b2aa-b5cc3790077b" {
+		t.Error("Wrong UUID field")
+	}
+
+	if error.Action != "PAUSE" {
+		t.Error("Wrong Action field")
+	}
+
+	if error.Reason != ActionInvalidState {
+		t.Error("Wrong Error field")
+	}
+}
+
+func TestInstanceActionFailureMarshal(t *testing.T) {
+	error := ErrorInstanceActionFailure{
+		InstanceUUID: uuid.Generate().String(),
+		Action:       "RESIZE",
+		Reason:       ActionFullComputeNode,
+	}
+
+	y, err := yaml.Marshal(&error)
+	if err != nil {
+		t.Error(err)
+	}
+	fmt.Println(string(y))
+}
+
+func TestInstanceActionFailureString(t *testing.T) {
+	var stringTests = []struct {
+		r        InstanceActionFailureReason
+		expected string
+	}{
+		{ActionNoInstance, "Instance does not exist"},
+		{ActionInvalidPayload, "YAML payload is corrupt"},
+		{ActionInvalidData, "Command section of YAML payload is corrupt or missing required information"},
+		{ActionInvalidState, "Instance is not in a valid state for this action"},
+		{ActionImageFailure, "Failed to prepare new image"},
+		{ActionFullComputeNode, "Node does not have enough resources"},
+		{ActionFailure, "Hypervisor failed to perform action"},
+	}
+	error := ErrorInstanceActionFailure{
+		InstanceUUID: uuid.Generate().String(),
+	}
+	for _, test := range stringTests {
+		error.Reason = test.r
+		s := error.Reason.String()
+		if s != test.expected {
+			t.Errorf("expected \"%s\", got \"%s\"", test.expected, s)
+		}
+	}
+}
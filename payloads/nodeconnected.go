@@ -16,13 +16,18 @@
 
 package payloads
 
+// ControllerNode indicates that a NodeConnected or NodeDisconnected event
+// refers to a ciao-controller instance. Federated schedulers use it to
+// share their Controllers registry with each other.
+const ControllerNode Resource = "controller"
+
 // NodeConnectedEvent contains information about a node that has either
 // just connected or disconnected.
 type NodeConnectedEvent struct {
 	// SSNTP UUID of the agent running on that node.
 	NodeUUID string `yaml:"node_uuid"`
 
-	// The type of the node, e.g., NetworkNode or ComputeNode.
+	// The type of the node, e.g., NetworkNode, ComputeNode or ControllerNode.
 	NodeType Resource `yaml:"node_type"`
 }
 
@@ -116,6 +116,16 @@ const (
 	// is not currently running, either because it failed to start or was
 	// explicitly stopped by a STOP command or perhaps by a CN reboot.
 	Exited = "exited"
+
+	// Paused indicates that the execution of an instance has been frozen
+	// by a PAUSE command.  The instance remains resident in memory.
+	Paused = "paused"
+
+	// Suspended indicates that the state of an instance has been saved
+	// to disk by a SUSPEND command and that the instance is no longer
+	// running.
+	Suspended = "suspended"
+
 	// ExitFailed is not currently used
 	ExitFailed = "exit_failed"
 	// ExitPaused is not currently used
@@ -0,0 +1,101 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"fmt"
+)
+
+// AuthorizationRule allows SSNTP clients with a given role to send
+// some frames to an SSNTP server.
+type AuthorizationRule struct {
+	// Role is the SSNTP client role the rule applies to. Clients
+	// with several roles are allowed to send a frame if any of
+	// their roles is.
+	Role Role
+
+	// Operand is the allowed frame operand, i.e. a Command, a Status,
+	// an Event or an Error. All frames of a given type are allowed
+	// when Operand is a Type, e.g. STATUS.
+	Operand interface{}
+}
+
+type authorizationKey struct {
+	frameType  Type
+	operand    uint8
+	anyOperand bool
+}
+
+// authorization is the set of frames each SSNTP role is allowed to
+// send. A nil authorization allows all frames.
+type authorization map[Role]map[authorizationKey]bool
+
+func newAuthorization(rules []AuthorizationRule) (authorization, error) {
+	if rules == nil {
+		return nil, nil
+	}
+
+	auth := make(authorization)
+
+	for _, r := range rules {
+		var key authorizationKey
+
+		switch op := r.Operand.(type) {
+		case Type:
+			key = authorizationKey{frameType: op, anyOperand: true}
+		case Command:
+			key = authorizationKey{frameType: COMMAND, operand: (uint8)(op)}
+		case Status:
+			key = authorizationKey{frameType: STATUS, operand: (uint8)(op)}
+		case Event:
+			key = authorizationKey{frameType: EVENT, operand: (uint8)(op)}
+		case Error:
+			key = authorizationKey{frameType: ERROR, operand: (uint8)(op)}
+		default:
+			return nil, fmt.Errorf("Invalid authorization rule operand %v", r.Operand)
+		}
+
+		if auth[r.Role] == nil {
+			auth[r.Role] = make(map[authorizationKey]bool)
+		}
+
+		auth[r.Role][key] = true
+	}
+
+	return auth, nil
+}
+
+// allowed tells if an SSNTP client with the given role is allowed
+// to send a frame.
+func (auth authorization) allowed(role Role, frame *Frame) bool {
+	if auth == nil {
+		return true
+	}
+
+	for r, keys := range auth {
+		if !role.HasRole(r) {
+			continue
+		}
+
+		if keys[authorizationKey{frameType: frame.Type, anyOperand: true}] ||
+			keys[authorizationKey{frameType: frame.Type, operand: frame.Operand}] {
+			return true
+		}
+	}
+
+	return false
+}
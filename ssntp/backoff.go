@@ -0,0 +1,105 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultBackoffInitialDelay = 5 * time.Second
+	defaultBackoffMaxDelay     = 40 * time.Second
+	defaultBackoffMultiplier   = 2
+	defaultBackoffJitter       = 0.5
+)
+
+// BackoffConfig describes how long an SSNTP client waits between
+// two rounds of connection attempts to its list of server URIs.
+// The delay grows exponentially from InitialDelay up to MaxDelay
+// and is randomized by Jitter, so that many clients losing the same
+// server do not all try to reconnect at the same time.
+type BackoffConfig struct {
+	// InitialDelay is the delay before the second round of connection
+	// attempts. The default is 5 seconds.
+	InitialDelay time.Duration
+
+	// MaxDelay is the upper bound for the delay between two rounds of
+	// connection attempts. The default is 40 seconds.
+	MaxDelay time.Duration
+
+	// Multiplier is the factor the delay is multiplied by after each
+	// failed round. The default is 2.
+	Multiplier float64
+
+	// Jitter is the fraction, between 0 and 1, of each delay that is
+	// randomized. A delay d is picked from [d * (1 - Jitter), d].
+	// A Jitter of 0 disables randomization.
+	Jitter float64
+}
+
+var defaultBackoff = BackoffConfig{
+	InitialDelay: defaultBackoffInitialDelay,
+	MaxDelay:     defaultBackoffMaxDelay,
+	Multiplier:   defaultBackoffMultiplier,
+	Jitter:       defaultBackoffJitter,
+}
+
+func newBackoffConfig(config *BackoffConfig) BackoffConfig {
+	if config == nil {
+		return defaultBackoff
+	}
+
+	backoff := *config
+
+	if backoff.InitialDelay <= 0 {
+		backoff.InitialDelay = defaultBackoffInitialDelay
+	}
+
+	if backoff.MaxDelay < backoff.InitialDelay {
+		backoff.MaxDelay = backoff.InitialDelay
+	}
+
+	if backoff.Multiplier < 1 {
+		backoff.Multiplier = defaultBackoffMultiplier
+	}
+
+	if backoff.Jitter < 0 {
+		backoff.Jitter = 0
+	} else if backoff.Jitter > 1 {
+		backoff.Jitter = 1
+	}
+
+	return backoff
+}
+
+// delay returns how long to wait after the attempt-th failed round
+// of connection attempts, attempt starting at 0.
+func (backoff BackoffConfig) delay(attempt int, r *rand.Rand) time.Duration {
+	d := float64(backoff.InitialDelay)
+	for i := 0; i < attempt && d < float64(backoff.MaxDelay); i++ {
+		d *= backoff.Multiplier
+	}
+
+	if d > float64(backoff.MaxDelay) {
+		d = float64(backoff.MaxDelay)
+	}
+
+	d -= d * backoff.Jitter * r.Float64()
+
+	return time.Duration(d)
+}
@@ -0,0 +1,133 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"fmt"
+
+	"github.com/01org/ciao/payloads"
+	"gopkg.in/yaml.v2"
+)
+
+// Capability is a named, optional SSNTP protocol feature.
+// SSNTP clients advertise the capabilities they support in their CONNECT
+// frame, and SSNTP servers reply with the subset of those that they also
+// support in the CONNECTED frame. Both ends of an SSNTP connection can then
+// only use the features from that negotiated subset.
+type Capability string
+
+// supportedCapabilities is the list of capabilities this SSNTP
+// implementation advertises when Config.Capabilities is not set.
+var supportedCapabilities = []Capability{
+	CorrelationCapability,
+	KeepaliveCapability,
+	CompressionCapability,
+}
+
+func hasCapability(caps []Capability, c Capability) bool {
+	for _, capability := range caps {
+		if capability == c {
+			return true
+		}
+	}
+
+	return false
+}
+
+// negotiateCapabilities returns the local capabilities that the peer
+// also supports.
+func negotiateCapabilities(local, peer []Capability) []Capability {
+	var caps []Capability
+
+	for _, c := range local {
+		if hasCapability(peer, c) {
+			caps = append(caps, c)
+		}
+	}
+
+	return caps
+}
+
+// missingCapabilities returns the required capabilities that are not
+// part of caps, or nil if caps has all of them.
+func missingCapabilities(required, caps []Capability) []Capability {
+	var missing []Capability
+
+	for _, c := range required {
+		if !hasCapability(caps, c) {
+			missing = append(missing, c)
+		}
+	}
+
+	return missing
+}
+
+// checkVersion verifies that a peer speaks a compatible SSNTP version.
+// Peers are compatible as long as they share the same major number.
+func checkVersion(peerMajor uint8) error {
+	if peerMajor&majorMask != major {
+		return fmt.Errorf("Incompatible SSNTP major version %d (expected %d)", peerMajor&majorMask, major)
+	}
+
+	return nil
+}
+
+func connectionFailurePayload(reason payloads.ConnectionFailureReason, missing []Capability) []byte {
+	failure := payloads.ErrorConnectionFailure{
+		Reason:  reason,
+		Version: fmt.Sprintf("%d.%d", major, minor),
+	}
+
+	for _, c := range missing {
+		failure.MissingCapabilities = append(failure.MissingCapabilities, string(c))
+	}
+
+	payload, err := yaml.Marshal(&failure)
+	if err != nil {
+		return nil
+	}
+
+	return payload
+}
+
+// connectionFailureError builds an error out of a ConnectionFailure
+// payload, and tells if connecting again may succeed. Incompatible
+// versions and missing capabilities are permanent failures.
+func connectionFailureError(payload []byte) (bool, error) {
+	var failure payloads.ErrorConnectionFailure
+
+	if len(payload) == 0 {
+		return true, fmt.Errorf("SSNTP Client: Connection error %s", ConnectionFailure)
+	}
+
+	err := yaml.Unmarshal(payload, &failure)
+	if err != nil {
+		return true, fmt.Errorf("SSNTP Client: Connection error %s", ConnectionFailure)
+	}
+
+	switch failure.Reason {
+	case payloads.MissingCapabilities:
+		return false, fmt.Errorf("SSNTP Client: Connection error %s: %s %v (server version %s)",
+			ConnectionFailure, failure.Reason, failure.MissingCapabilities, failure.Version)
+	case payloads.IncompatibleVersion:
+		return false, fmt.Errorf("SSNTP Client: Connection error %s: %s (server version %s)",
+			ConnectionFailure, failure.Reason, failure.Version)
+	}
+
+	return true, fmt.Errorf("SSNTP Client: Connection error %s: %s (server version %s)",
+		ConnectionFailure, failure.Reason, failure.Version)
+}
@@ -0,0 +1,191 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// CaptureDirection tells if a captured frame was sent or received.
+type CaptureDirection string
+
+const (
+	// CaptureRx is the direction of frames received from a peer.
+	CaptureRx CaptureDirection = "rx"
+
+	// CaptureTx is the direction of frames sent to a peer.
+	CaptureTx CaptureDirection = "tx"
+)
+
+// CaptureRecord is a captured SSNTP frame.
+// SSNTP clients and servers configured with a Capture writer record
+// each frame they send or receive as a JSON encoded CaptureRecord,
+// one per line.
+type CaptureRecord struct {
+	// Timestamp is the time at which the frame was sent or received.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Direction tells if the frame was sent or received.
+	Direction CaptureDirection `json:"direction"`
+
+	// UUID is the UUID of the SSNTP entity that captured the frame.
+	UUID string `json:"uuid"`
+
+	// Peer is the UUID of the SSNTP peer the frame was exchanged with.
+	Peer string `json:"peer"`
+
+	// PeerRole is the SSNTP role of the peer.
+	PeerRole string `json:"peer_role"`
+
+	// Type is the frame type.
+	Type Type `json:"type"`
+
+	// Operand is the frame operand.
+	Operand uint8 `json:"operand"`
+
+	// Name is the frame type and operand name, e.g. "COMMAND START".
+	Name string `json:"name"`
+
+	// Origin is the UUID of the SSNTP entity that created the frame.
+	Origin string `json:"origin"`
+
+	// CorrelationID is the frame correlation ID, if any.
+	CorrelationID uint64 `json:"correlation_id,omitempty"`
+
+	// Payload is the frame YAML payload.
+	Payload string `json:"payload,omitempty"`
+}
+
+type capture struct {
+	sync.Mutex
+	encoder *json.Encoder
+	log     Logger
+}
+
+func newCapture(w io.Writer, log Logger) *capture {
+	if w == nil {
+		return nil
+	}
+
+	return &capture{
+		encoder: json.NewEncoder(w),
+		log:     log,
+	}
+}
+
+func operandString(t Type, operand uint8) string {
+	switch t {
+	case COMMAND:
+		return (Command)(operand).String()
+	case STATUS:
+		return (Status)(operand).String()
+	case EVENT:
+		return (Event)(operand).String()
+	case ERROR:
+		return (Error)(operand).String()
+	}
+
+	return fmt.Sprintf("%d", operand)
+}
+
+func (c *capture) record(session *session, direction CaptureDirection, frame *Frame) {
+	if c == nil {
+		return
+	}
+
+	record := CaptureRecord{
+		Timestamp:     time.Now(),
+		Direction:     direction,
+		UUID:          session.src.String(),
+		Peer:          session.dest.String(),
+		PeerRole:      session.destRole.String(),
+		Type:          frame.Type,
+		Operand:       frame.Operand,
+		Name:          frame.Type.String() + " " + operandString(frame.Type, frame.Operand),
+		Origin:        frame.Origin.String(),
+		CorrelationID: frame.CorrelationID,
+		Payload:       string(frame.Payload),
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	err := c.encoder.Encode(&record)
+	if err != nil {
+		c.log.Errorf("Could not capture frame: %s\n", err)
+	}
+}
+
+// ReadCapture reads all the frames recorded in an SSNTP capture.
+func ReadCapture(r io.Reader) ([]CaptureRecord, error) {
+	var records []CaptureRecord
+
+	decoder := json.NewDecoder(r)
+	for {
+		var record CaptureRecord
+
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, fmt.Errorf("Invalid capture record #%d: %s", len(records)+1, err)
+		}
+
+		records = append(records, record)
+	}
+}
+
+// Replay sends the captured frames to the SSNTP server.
+// Keepalive frames and frames not matching direction are skipped.
+// If speed is 0 frames are sent as fast as possible, otherwise the
+// delays between them are replayed, divided by speed.
+// The client must be connected.
+func (client *Client) Replay(records []CaptureRecord, direction CaptureDirection, speed float64) error {
+	var last time.Time
+
+	for i, record := range records {
+		if record.Direction != direction || isKeepaliveFrame(record.Type, record.Operand) {
+			continue
+		}
+
+		if speed > 0 && !last.IsZero() {
+			delay := record.Timestamp.Sub(last)
+			if delay > 0 {
+				time.Sleep(time.Duration(float64(delay) / speed))
+			}
+		}
+		last = record.Timestamp
+
+		var payload []byte
+		if record.Payload != "" {
+			payload = []byte(record.Payload)
+		}
+
+		_, err := client.send(context.Background(), outboundFrame{record.Type, record.Operand, payload, client.trace, 0})
+		if err != nil {
+			return fmt.Errorf("Could not replay %s record #%d: %s", record.Name, i+1, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// certificates is the TLS configuration built from the SSNTP CA and
+// certificate files. It can be reloaded at runtime, e.g. when the
+// certificates are renewed, and every new TLS handshake uses the
+// latest loaded configuration.
+type certificates struct {
+	sync.RWMutex
+	caPath   string
+	certPath string
+	role     Role
+	server   bool
+	config   *tls.Config
+}
+
+func newCertificates(config *Config, role Role, server bool) *certificates {
+	return &certificates{
+		caPath:   config.CAcert,
+		certPath: config.Cert,
+		role:     role,
+		server:   server,
+		config:   prepareTLSConfig(config, server),
+	}
+}
+
+func (c *certificates) tlsConfig() *tls.Config {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.config
+}
+
+// reload reads the CA and certificate files again. The current
+// configuration is kept if they can not be loaded, or if the new
+// certificate role does not match the current one.
+func (c *certificates) reload() error {
+	caPEM, err := ioutil.ReadFile(c.caPath)
+	if err != nil {
+		return fmt.Errorf("Could not load CA certificate: %s", err)
+	}
+
+	certPEM, err := ioutil.ReadFile(c.certPath)
+	if err != nil {
+		return fmt.Errorf("Could not load certificate: %s", err)
+	}
+
+	role, err := certificateRole(certPEM, c.certPath)
+	if err != nil {
+		return err
+	}
+
+	if role != c.role {
+		return fmt.Errorf("Certificate role %s does not match %s", role.String(), c.role.String())
+	}
+
+	config := prepareTLS(caPEM, certPEM, c.server)
+	if config == nil {
+		return fmt.Errorf("Invalid certificates %s %s", c.caPath, c.certPath)
+	}
+
+	c.Lock()
+	c.config = config
+	c.Unlock()
+
+	return nil
+}
@@ -17,7 +17,7 @@
 package ssntp
 
 import (
-	"crypto/tls"
+	"errors"
 	"fmt"
 	"math/rand"
 	"sync"
@@ -25,6 +25,7 @@ import (
 
 	"github.com/01org/ciao/payloads"
 	"github.com/docker/distribution/uuid"
+	"golang.org/x/net/context"
 	"gopkg.in/yaml.v2"
 )
 
@@ -57,6 +58,17 @@ type ClientNotifier interface {
 	ErrorNotify(error Error, frame *Frame)
 }
 
+var (
+	// ErrNotConnected is returned when sending a frame while the
+	// SSNTP client is not connected to any SSNTP server, and frames
+	// are not queued.
+	ErrNotConnected = errors.New("SSNTP Client: Not connected")
+
+	// ErrOutboundQueueFull is returned when sending a frame while the
+	// SSNTP client is disconnected and its outbound queue is full.
+	ErrOutboundQueueFull = errors.New("SSNTP Client: Outbound queue full")
+)
+
 // Client is the SSNTP client structure.
 // This is an SSNTP client handle to connect to and
 // disconnect from an SSNTP server, and send SSNTP
@@ -64,26 +76,45 @@ type ClientNotifier interface {
 // It is an entirely opaque structure, only accessible through
 // its public methods.
 type Client struct {
-	uuid      uuid.UUID
-	lUUID     lockedUUID
-	uris      []string
-	role      Role
-	tls       *tls.Config
-	ntf       ClientNotifier
-	transport string
-	port      uint32
-	session   *session
-	status    connectionStatus
-	closed    chan struct{}
+	uuid         uuid.UUID
+	uuidProvider UUIDProvider
+	uris         []string
+	role         Role
+	certs        *certificates
+	ntf          ClientNotifier
+	transport    string
+	skipTLS      bool
+	port         uint32
+	session      *session
+	status       connectionStatus
+	closed       chan struct{}
 
 	frameWg              sync.WaitGroup
 	frameRoutinesChannel chan struct{}
 
-	log Logger
+	log fieldLogger
 
 	trace *TraceConfig
 
 	configuration clusterConfiguration
+
+	capabilities         []Capability
+	requiredCapabilities []Capability
+	codecs               []Codec
+	keepalive            keepaliveConfig
+	bulkQueueSize        int
+	compressionThreshold int
+	capture              *capture
+	revocations          *revocationList
+
+	backoff BackoffConfig
+
+	// queue holds the frames sent while disconnected.
+	// It is protected by the status lock.
+	queue     []outboundFrame
+	queueSize int
+
+	correlator correlator
 }
 
 func (client *Client) processSSNTPFrame(frame *Frame) {
@@ -96,10 +127,12 @@ func (client *Client) processSSNTPFrame(frame *Frame) {
 		}
 		client.ntf.CommandNotify((Command)(frame.Operand), frame)
 	case STATUS:
+		client.correlator.deliver(frame)
 		client.ntf.StatusNotify((Status)(frame.Operand), frame)
 	case EVENT:
 		client.ntf.EventNotify((Event)(frame.Operand), frame)
 	case ERROR:
+		client.correlator.deliver(frame)
 		client.ntf.ErrorNotify((Error)(frame.Operand), frame)
 	default:
 		client.SendError(InvalidFrameType, nil)
@@ -123,18 +156,26 @@ func (client *Client) handleSSNTPServer() {
 					client.status.Unlock()
 					return
 				}
+				client.status.status = ssntpConnecting
+				client.session.stopKeepalive()
+				client.session.stopLanes()
+				client.session.conn.Close()
 				client.status.Unlock()
 
-				client.log.Errorf("Read error: %s\n", err)
+				client.session.log.Errorf("Read error: %s\n", err)
 				client.ntf.DisconnectNotify()
 				break
 			}
 
+			if client.session.handleKeepalive(&frame) {
+				continue
+			}
+
 			client.frameWg.Add(1)
 			go client.processSSNTPFrame(&frame)
 		}
 
-		err := client.attemptDial()
+		err := client.attemptDial(context.Background())
 		if err != nil {
 			client.log.Errorf("%s", err)
 			return
@@ -142,18 +183,22 @@ func (client *Client) handleSSNTPServer() {
 	}
 }
 
-func (client *Client) sendConnect() (bool, error) {
+func (client *Client) sendConnect(ctx context.Context) (bool, error) {
 	var connected ConnectedFrame
 	client.log.Infof("Sending CONNECT\n")
 
-	connect := client.session.connectFrame()
-	_, err := client.session.Write(connect)
+	connect := client.session.connectFrame(client.capabilities)
+	_, err := client.session.WriteContext(ctx, connect)
 	if err != nil {
 		return true, err
 	}
 
 	client.log.Infof("Waiting for CONNECTED\n")
+	if deadline, ok := ctx.Deadline(); ok {
+		client.session.conn.SetReadDeadline(deadline)
+	}
 	err = client.session.Read(&connected)
+	clearReadTimeout(client.session.conn)
 	if err != nil {
 		return true, err
 	}
@@ -170,24 +215,50 @@ func (client *Client) sendConnect() (bool, error) {
 			return false, fmt.Errorf("SSNTP Client: Connection failure")
 		}
 
-		return true, fmt.Errorf("SSNTP Client: Connection error %s\n", (Error)(connected.Operand))
+		return connectionFailureError(connected.Payload)
 
 	default:
 		return true, fmt.Errorf("SSNTP Client: Unknown frame type %d", connected.Type)
 	}
 
 	client.session.setDest(connected.Source[:16])
+	client.session.destRole = connected.Role
+	client.session.log = client.log.with(sessionFields(client.session)...)
 
 	oidFound, err := verifyRole(client.session.conn, connected.Role)
 	if oidFound == false {
 		client.log.Errorf("%s\n", err)
-		client.SendError(ConnectionFailure, nil)
+		client.sendConnectionFailure(nil)
 		return false, fmt.Errorf("SSNTP Client: Connection failure")
 	}
 
-	client.status.Lock()
-	client.status.status = ssntpConnected
-	client.status.Unlock()
+	err = client.revocations.check(client.session.conn)
+	if err != nil {
+		client.sendConnectionAborted()
+		return true, fmt.Errorf("SSNTP Client: %s", err)
+	}
+
+	err = checkVersion(connected.Major)
+	if err != nil {
+		client.sendConnectionFailure(connectionFailurePayload(payloads.IncompatibleVersion, nil))
+		return false, err
+	}
+
+	capabilities := negotiateCapabilities(client.capabilities, connected.Capabilities)
+	missing := missingCapabilities(client.requiredCapabilities, capabilities)
+	if missing != nil {
+		client.sendConnectionFailure(connectionFailurePayload(payloads.MissingCapabilities, missing))
+		return false, fmt.Errorf("SSNTP Client: Server is missing required capabilities %v", missing)
+	}
+
+	client.session.capabilities = capabilities
+
+	// Use the server preferred codec, as the server does.
+	codec := selectCodec(connected.Capabilities, client.codecs)
+	if codec != nil {
+		client.log.Infof("Switching to %s codec\n", codec.Name())
+		client.session.setCodec(codec)
+	}
 
 	client.configuration.setConfiguration(connected.Payload)
 
@@ -196,92 +267,199 @@ func (client *Client) sendConnect() (bool, error) {
 	return true, nil
 }
 
-func (client *Client) attemptDial() error {
-	delays := []int64{5, 10, 20, 40}
+// sendConnectionFailure writes a ConnectionFailure error frame on the
+// current session. It does not go through the regular sending path as
+// the client is not connected yet.
+func (client *Client) sendConnectionFailure(payload []byte) {
+	session := client.session
+	session.Write(session.errorFrame(ConnectionFailure, payload, client.trace))
+}
+
+func (client *Client) sendConnectionAborted() {
+	session := client.session
+	session.Write(session.errorFrame(ConnectionAborted, nil, client.trace))
+}
+
+// ReloadCertificates reloads the client CA and certificate files.
+// The current connection to the server is kept, and the reloaded
+// certificates are used the next time the client connects.
+// If the certificates can not be loaded, the current ones are kept.
+func (client *Client) ReloadCertificates() error {
+	if client.certs == nil {
+		return ErrNotConnected
+	}
+
+	return client.certs.reload()
+}
 
+// ReloadRevocationList reloads the client CRL and certificate deny-list.
+// If the server certificate has been revoked, the client sends it a
+// ConnectionAborted error and closes the connection. The client will
+// then try to connect to the other configured servers.
+// If any of the lists can not be loaded, the current one is kept.
+func (client *Client) ReloadRevocationList() error {
+	if client.revocations == nil {
+		return nil
+	}
+
+	err := client.revocations.load()
+	if err != nil {
+		return err
+	}
+
+	client.status.Lock()
+	if client.status.status != ssntpConnected {
+		client.status.Unlock()
+		return nil
+	}
+	session := client.session
+	client.status.Unlock()
+
+	err = client.revocations.check(session.conn)
+	if err != nil {
+		client.log.Errorf("Dropping server connection: %s\n", err)
+		session.Write(session.errorFrame(ConnectionAborted, nil, client.trace))
+		session.conn.Close()
+	}
+
+	return nil
+}
+
+// flushOutboundQueue sends all frames queued while the client was
+// disconnected and then marks the client as connected.
+// Frames sent while flushing keep being queued, so that the frames
+// order is preserved.
+func (client *Client) flushOutboundQueue() {
+	for {
+		client.status.Lock()
+		if client.status.status == ssntpClosed {
+			client.status.Unlock()
+			return
+		}
+
+		if len(client.queue) == 0 {
+			client.status.status = ssntpConnected
+			client.status.Unlock()
+			return
+		}
+
+		queue := client.queue
+		client.queue = nil
+		session := client.session
+		client.status.Unlock()
+
+		client.log.Infof("Sending %d queued frames\n", len(queue))
+
+		for i := range queue {
+			_, err := session.Write(queue[i].build(session))
+			if err != nil {
+				client.log.Errorf("Could not send queued frame: %s\n", err)
+			}
+		}
+	}
+}
+
+func (client *Client) attemptDial(ctx context.Context) error {
 	if len(client.uris) == 0 {
 		return fmt.Errorf("No servers to connect to")
 	}
 
 	client.status.Lock()
+	if client.status.status == ssntpClosed {
+		client.status.Unlock()
+		return fmt.Errorf("Connection closed")
+	}
 	client.closed = make(chan struct{})
 	client.status.Unlock()
 
 	source := rand.NewSource(time.Now().UnixNano())
 	r := rand.New(source)
 
-	for {
-	URILoop:
-		for d := 0; ; d++ {
-			for _, uri := range client.uris {
-				client.log.Infof("%s connecting to %s\n", client.uuid, uri)
-				conn, err := tls.Dial(client.transport, uri, client.tls)
+	for attempt := 0; ; attempt++ {
+		for _, uri := range client.uris {
+			client.log.Infof("%s connecting to %s\n", client.uuid, uri)
+			conn, err := dial(ctx, client.transport, uri, client.certs.tlsConfig(), client.skipTLS)
 
-				client.status.Lock()
-				if client.status.status == ssntpClosed {
-					client.status.Unlock()
-					return fmt.Errorf("Connection closed")
-				}
+			client.status.Lock()
+			if client.status.status == ssntpClosed {
 				client.status.Unlock()
-
 				if err == nil {
-					client.log.Infof("Connected\n")
-					session := newSession(&client.uuid, client.role, 0, conn)
-					client.session = session
-
-					break URILoop
+					conn.Close()
 				}
-
-				client.log.Errorf("Could not connect to %s (%s)\n", uri, err)
+				return fmt.Errorf("Connection closed")
 			}
 
-			delay := r.Int63n(delays[d%len(delays)])
-			delay++ // Avoid waiting for 0 seconds
-			client.log.Errorf("All server URIs failed - retrying in %d seconds\n", delay)
+			if err == nil {
+				client.session = newSession(&client.uuid, client.role, 0, conn)
+				client.session.capture = client.capture
+				client.session.compressionThreshold = client.compressionThreshold
+				client.session.log = client.log
+			}
+			client.status.Unlock()
 
-			// Wait for delay before reconnecting or return if the client is closed
-			select {
-			case <-client.closed:
-				return fmt.Errorf("Connection closed")
-			case <-time.After(time.Duration(delay) * time.Second):
-				break
+			if err != nil {
+				client.log.Errorf("Could not connect to %s (%s)\n", uri, err)
+				continue
 			}
 
-			continue
-		}
+			client.log.Infof("Connected\n")
 
-		if client.session == nil {
-			continue
-		}
+			reconnect, err := client.sendConnect(ctx)
+			if err == nil {
+				// Dialed and connected, we can proceed
+				client.session.startLanes(client.bulkQueueSize)
+				client.session.startKeepalive(client.keepalive)
+				client.flushOutboundQueue()
+				return nil
+			}
 
-		reconnect, err := client.sendConnect()
-		if err != nil {
-			// Dialed but could not connect, try again
-			client.log.Errorf("%s", err)
-			client.Close()
-			if reconnect == true {
-				continue
-			} else {
+			// Dialed but could not connect
+			client.log.Errorf("%s\n", err)
+			conn.Close()
+			if reconnect == false {
+				client.Close()
 				client.ntf.DisconnectNotify()
 				return err
 			}
 		}
 
-		// Dialed and connected, we can proceed
-		break
+		delay := client.backoff.delay(attempt, r)
+		client.log.Errorf("All server URIs failed - retrying in %v\n", delay)
+
+		// Wait for delay before reconnecting or return if the client is closed
+		select {
+		case <-client.closed:
+			return fmt.Errorf("Connection closed")
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+			break
+		}
 	}
-
-	return nil
 }
 
 // Dial attempts to connect to a SSNTP server, as specified by the config argument.
-// Dial will try and retry to connect to this server and will wait for it to show
-// up if it's temporarily unavailable. A client can be closed while it's still
+// Dial will try and retry to connect to the configured servers, waiting between
+// each round of attempts as specified by config.Backoff, until one of them shows
+// up. A client can be closed while it's still
 // trying to connect to the SSNTP server, so that one can properly kill a client if
 // e.g. no server will ever come alive.
 // Once connected a separate routine will listen for server commands, statuses or
 // errors and report them back through the SSNTP client notifier interface.
+// When the connection to the server drops, the client calls DisconnectNotify and
+// automatically reconnects to one of the configured servers, calling ConnectNotify
+// once it's connected again. Frames sent while disconnected are either queued or
+// rejected, depending on config.OutboundQueueSize.
 func (client *Client) Dial(config *Config, ntf ClientNotifier) error {
+	return client.DialContext(context.Background(), config, ntf)
+}
+
+// DialContext is like Dial, but stops trying to connect when ctx is done.
+// Each connection attempt, including the TLS and SSNTP handshakes, must
+// also complete before the ctx deadline. If ctx is done before the client
+// connects, DialContext returns ctx.Err() and the client can be dialed again.
+// Once connected, ctx no longer affects the client and its reconnections.
+func (client *Client) DialContext(ctx context.Context, config *Config, ntf ClientNotifier) error {
 	if config == nil {
 		return fmt.Errorf("SSNTP config missing")
 	}
@@ -315,18 +493,43 @@ func (client *Client) Dial(config *Config, ntf ClientNotifier) error {
 		return err
 	}
 	client.role = role
-	client.lUUID, client.uuid = config.configUUID(client.role)
+	client.uuidProvider, client.uuid = config.configUUID(client.role)
+	client.log = client.log.with(localFields(client.uuid.String(), client.role)...)
 	client.port = config.port()
 	client.transport = config.transport()
+	client.skipTLS = config.SkipTLS
 	client.uris = config.configURIs(client.uris, client.port)
 
 	client.trace = config.Trace
+	client.capabilities = config.capabilities()
+	client.codecs = config.Codecs
+	client.keepalive = config.keepalive()
+	client.bulkQueueSize = config.bulkQueueSize()
+	client.compressionThreshold = config.CompressionThreshold
+	client.capture = newCapture(config.Capture, client.log)
+	client.requiredCapabilities = config.RequiredCapabilities
+	client.backoff = newBackoffConfig(config.Backoff)
+	client.queueSize = config.OutboundQueueSize
 	client.ntf = ntf
-	client.tls = prepareTLSConfig(config, false)
+	client.certs = newCertificates(config, client.role, false)
+
+	client.revocations, err = newRevocationList(config)
+	if err != nil {
+		client.log.Errorf("Could not load revocation list: %s", err)
+		config.pushToSyncChannel(err)
+		return err
+	}
 
-	err = client.attemptDial()
+	err = client.attemptDial(ctx)
 	if err != nil {
 		client.log.Errorf("%s", err)
+		if ctx.Err() != nil {
+			client.status.Lock()
+			if client.status.status == ssntpConnecting {
+				client.status.status = ssntpIdle
+			}
+			client.status.Unlock()
+		}
 		config.pushToSyncChannel(err)
 		return err
 	}
@@ -346,9 +549,12 @@ func (client *Client) Close() {
 	}
 
 	if client.session != nil {
+		client.session.stopKeepalive()
+		client.session.stopLanes()
 		client.session.conn.Close()
 	}
 	client.status.status = ssntpClosed
+	client.queue = nil
 	if client.closed != nil {
 		close(client.closed)
 	}
@@ -367,103 +573,126 @@ func (client *Client) Close() {
 		break
 	}
 
-	freeUUID(client.lUUID)
-}
-
-func (client *Client) sendCommand(cmd Command, payload []byte, trace *TraceConfig) (int, error) {
-	client.status.Lock()
-	if client.status.status == ssntpClosed {
-		client.status.Unlock()
-		return -1, fmt.Errorf("Client not connected")
+	if client.uuidProvider != nil {
+		client.uuidProvider.Release(client.uuid.String())
 	}
-	client.status.Unlock()
-
-	session := client.session
-	frame := session.commandFrame(cmd, payload, trace)
-
-	return session.Write(frame)
 }
 
-func (client *Client) sendStatus(status Status, payload []byte, trace *TraceConfig) (int, error) {
+func (client *Client) send(ctx context.Context, f outboundFrame) (int, error) {
 	client.status.Lock()
-	if client.status.status == ssntpClosed {
+
+	switch client.status.status {
+	case ssntpConnected:
+		session := client.session
 		client.status.Unlock()
-		return -1, fmt.Errorf("Client not connected")
-	}
-	client.status.Unlock()
 
-	session := client.session
-	frame := session.statusFrame(status, payload, trace)
+		return session.WriteContext(ctx, f.build(session))
 
-	return session.Write(frame)
-}
+	case ssntpConnecting:
+		if client.queueSize == 0 {
+			break
+		}
 
-func (client *Client) sendEvent(event Event, payload []byte, trace *TraceConfig) (int, error) {
-	client.status.Lock()
-	if client.status.status == ssntpClosed {
+		if len(client.queue) >= client.queueSize {
+			client.status.Unlock()
+			return -1, ErrOutboundQueueFull
+		}
+
+		client.queue = append(client.queue, f)
 		client.status.Unlock()
-		return -1, fmt.Errorf("Client not connected")
+
+		return 0, nil
 	}
+
 	client.status.Unlock()
 
-	session := client.session
-	frame := session.eventFrame(event, payload, trace)
+	return -1, ErrNotConnected
+}
 
-	return session.Write(frame)
+func (client *Client) sendCommand(ctx context.Context, cmd Command, payload []byte, trace *TraceConfig) (int, error) {
+	return client.send(ctx, outboundFrame{COMMAND, (uint8)(cmd), payload, trace, 0})
 }
 
-func (client *Client) sendError(error Error, payload []byte, trace *TraceConfig) (int, error) {
-	client.status.Lock()
-	if client.status.status == ssntpClosed {
-		client.status.Unlock()
-		return -1, fmt.Errorf("Client not connected")
-	}
-	client.status.Unlock()
+func (client *Client) sendStatus(ctx context.Context, status Status, payload []byte, trace *TraceConfig) (int, error) {
+	return client.send(ctx, outboundFrame{STATUS, (uint8)(status), payload, trace, 0})
+}
 
-	session := client.session
-	frame := session.errorFrame(error, payload, trace)
+func (client *Client) sendEvent(ctx context.Context, event Event, payload []byte, trace *TraceConfig) (int, error) {
+	return client.send(ctx, outboundFrame{EVENT, (uint8)(event), payload, trace, 0})
+}
 
-	return session.Write(frame)
+func (client *Client) sendError(ctx context.Context, error Error, payload []byte, trace *TraceConfig) (int, error) {
+	return client.send(ctx, outboundFrame{ERROR, (uint8)(error), payload, trace, 0})
 }
 
 // SendCommand sends a specific command and its payload to the SSNTP server.
 func (client *Client) SendCommand(cmd Command, payload []byte) (int, error) {
-	return client.sendCommand(cmd, payload, client.trace)
+	return client.sendCommand(context.Background(), cmd, payload, client.trace)
 }
 
 // SendStatus sends a specific status and its payload to the SSNTP server.
 func (client *Client) SendStatus(status Status, payload []byte) (int, error) {
-	return client.sendStatus(status, payload, client.trace)
+	return client.sendStatus(context.Background(), status, payload, client.trace)
 }
 
 // SendEvent sends a specific status and its payload to the SSNTP server.
 func (client *Client) SendEvent(event Event, payload []byte) (int, error) {
-	return client.sendEvent(event, payload, client.trace)
+	return client.sendEvent(context.Background(), event, payload, client.trace)
 }
 
 // SendError sends an error back to the SSNTP server.
 // This is just for notification purposes, to let e.g. the server know that
 // it sent an unexpected frame.
 func (client *Client) SendError(error Error, payload []byte) (int, error) {
-	return client.sendError(error, payload, client.trace)
+	return client.sendError(context.Background(), error, payload, client.trace)
+}
+
+// SendCommandContext is like SendCommand, but gives up if ctx is done
+// before the frame is written. The write must complete before the ctx deadline.
+func (client *Client) SendCommandContext(ctx context.Context, cmd Command, payload []byte) (int, error) {
+	return client.sendCommand(ctx, cmd, payload, client.trace)
+}
+
+// SendStatusContext is like SendStatus, but gives up if ctx is done
+// before the frame is written. The write must complete before the ctx deadline.
+func (client *Client) SendStatusContext(ctx context.Context, status Status, payload []byte) (int, error) {
+	return client.sendStatus(ctx, status, payload, client.trace)
+}
+
+// SendEventContext is like SendEvent, but gives up if ctx is done
+// before the frame is written. The write must complete before the ctx deadline.
+func (client *Client) SendEventContext(ctx context.Context, event Event, payload []byte) (int, error) {
+	return client.sendEvent(ctx, event, payload, client.trace)
+}
+
+// SendErrorContext is like SendError, but gives up if ctx is done
+// before the frame is written. The write must complete before the ctx deadline.
+func (client *Client) SendErrorContext(ctx context.Context, error Error, payload []byte) (int, error) {
+	return client.sendError(ctx, error, payload, client.trace)
+}
+
+// SendTracedCommandContext is like SendTracedCommand, but gives up if ctx is
+// done before the frame is written. The write must complete before the ctx deadline.
+func (client *Client) SendTracedCommandContext(ctx context.Context, cmd Command, payload []byte, trace *TraceConfig) (int, error) {
+	return client.sendCommand(ctx, cmd, payload, trace)
 }
 
 // SendTracedCommand sends a specific command and its payload to the SSNTP server.
 // The SSNTP command frame will be traced according to the trace argument.
 func (client *Client) SendTracedCommand(cmd Command, payload []byte, trace *TraceConfig) (int, error) {
-	return client.sendCommand(cmd, payload, trace)
+	return client.sendCommand(context.Background(), cmd, payload, trace)
 }
 
 // SendTracedStatus sends a specific status and its payload to the SSNTP server.
 // The SSNTP status frame will be traced according to the trace argument.
 func (client *Client) SendTracedStatus(status Status, payload []byte, trace *TraceConfig) (int, error) {
-	return client.sendStatus(status, payload, trace)
+	return client.sendStatus(context.Background(), status, payload, trace)
 }
 
 // SendTracedEvent sends a specific status and its payload to the SSNTP server.
 // The SSNTP event frame will be traced according to the trace argument.
 func (client *Client) SendTracedEvent(event Event, payload []byte, trace *TraceConfig) (int, error) {
-	return client.sendEvent(event, payload, trace)
+	return client.sendEvent(context.Background(), event, payload, trace)
 }
 
 // SendTracedError sends an error back to the SSNTP server.
@@ -471,7 +700,43 @@ func (client *Client) SendTracedEvent(event Event, payload []byte, trace *TraceC
 // it sent an unexpected frame.
 // The SSNTP error frame will be traced according to the trace argument.
 func (client *Client) SendTracedError(error Error, payload []byte, trace *TraceConfig) (int, error) {
-	return client.sendError(error, payload, trace)
+	return client.sendError(context.Background(), error, payload, trace)
+}
+
+// SendCommandAndWait sends a specific command and its payload to the SSNTP
+// server, and waits for the STATUS or ERROR frame replying to it.
+// It returns the reply frame, or ctx.Err() if ctx is done before the reply
+// comes in. The reply frame is also notified through the ClientNotifier
+// interface.
+// The command receiver must reply with SendStatusReply or SendErrorReply, and
+// the SSNTP server must support the CorrelationCapability.
+func (client *Client) SendCommandAndWait(ctx context.Context, cmd Command, payload []byte) (*Frame, error) {
+	err := checkCorrelation(client.Capabilities())
+	if err != nil {
+		return nil, err
+	}
+
+	id, reply := client.correlator.register()
+
+	_, err = client.send(ctx, outboundFrame{COMMAND, (uint8)(cmd), payload, client.trace, id})
+	if err != nil {
+		client.correlator.unregister(id)
+		return nil, err
+	}
+
+	return client.correlator.wait(ctx, id, reply)
+}
+
+// SendStatusReply sends a specific status and its payload to the SSNTP server,
+// as a reply to the request frame.
+func (client *Client) SendStatusReply(request *Frame, status Status, payload []byte) (int, error) {
+	return client.send(context.Background(), outboundFrame{STATUS, (uint8)(status), payload, client.trace, request.CorrelationID})
+}
+
+// SendErrorReply sends an error back to the SSNTP server, as a reply to the
+// request frame.
+func (client *Client) SendErrorReply(request *Frame, error Error, payload []byte) (int, error) {
+	return client.send(context.Background(), outboundFrame{ERROR, (uint8)(error), payload, client.trace, request.CorrelationID})
 }
 
 // UUID exports the SSNTP client Universally Unique ID.
@@ -479,6 +744,19 @@ func (client *Client) UUID() string {
 	return client.uuid.String()
 }
 
+// Capabilities returns the SSNTP capabilities negotiated with the
+// SSNTP server the client is connected to.
+func (client *Client) Capabilities() []Capability {
+	client.status.Lock()
+	defer client.status.Unlock()
+
+	if client.session == nil {
+		return nil
+	}
+
+	return client.session.capabilities
+}
+
 // ClusterConfiguration returns the latest cluster configuration
 // payload a client received. Clients should use that payload to
 // configure themselves based on the information provided to them
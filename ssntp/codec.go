@@ -0,0 +1,228 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/01org/ciao/ssntp/decoder"
+)
+
+// Encoder writes SSNTP frames to an SSNTP connection.
+type Encoder interface {
+	Encode(frame interface{}) error
+}
+
+// Decoder reads SSNTP frames from an SSNTP connection.
+type Decoder interface {
+	Decode(frame interface{}) error
+}
+
+// Codec defines how SSNTP frames are encoded on the wire.
+// The SSNTP connection handshake, i.e. the CONNECT and CONNECTED frames,
+// always uses the GobCodec. SSNTP clients and servers then switch to the
+// first negotiated codec, if any.
+type Codec interface {
+	// Name is the codec name. Codecs are negotiated through the
+	// "codec:<Name>" capability.
+	Name() string
+
+	// NewEncoder returns an encoder writing frames to w.
+	NewEncoder(w io.Writer) Encoder
+
+	// NewDecoder returns a decoder reading frames from r.
+	NewDecoder(r io.Reader) Decoder
+}
+
+type gobCodec struct{}
+
+func (c gobCodec) Name() string {
+	return "gob"
+}
+
+func (c gobCodec) NewEncoder(w io.Writer) Encoder {
+	return gob.NewEncoder(w)
+}
+
+func (c gobCodec) NewDecoder(r io.Reader) Decoder {
+	return gob.NewDecoder(r)
+}
+
+// GobCodec is the default SSNTP codec. It encodes frames with
+// the encoding/gob package and can thus only be used by Go peers.
+var GobCodec Codec = gobCodec{}
+
+// BinaryCodec encodes SSNTP frames with a language independent,
+// length prefixed binary format. The format is described in the
+// github.com/01org/ciao/ssntp/decoder package.
+var BinaryCodec Codec = binaryCodec{}
+
+type binaryCodec struct{}
+
+func (c binaryCodec) Name() string {
+	return "binary"
+}
+
+func (c binaryCodec) NewEncoder(w io.Writer) Encoder {
+	return &binaryEncoder{w: w}
+}
+
+func (c binaryCodec) NewDecoder(r io.Reader) Decoder {
+	return &binaryDecoder{d: decoder.NewDecoder(r)}
+}
+
+type binaryEncoder struct {
+	w io.Writer
+}
+
+func putTimestamp(buf *bytes.Buffer, t time.Time) {
+	var ns uint64
+
+	if !t.IsZero() {
+		ns = uint64(t.UnixNano())
+	}
+
+	binary.Write(buf, binary.BigEndian, ns)
+}
+
+func marshalTrace(trace *FrameTrace) []byte {
+	var buf bytes.Buffer
+	var uuid [16]byte
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(trace.Label)))
+	buf.Write(trace.Label)
+	putTimestamp(&buf, trace.StartTimestamp)
+	putTimestamp(&buf, trace.EndTimestamp)
+	buf.WriteByte(uint8(len(trace.Path)))
+
+	for _, n := range trace.Path {
+		copy(uuid[:], n.UUID)
+		buf.Write(uuid[:])
+		binary.Write(&buf, binary.BigEndian, uint32(n.Role))
+		putTimestamp(&buf, n.TxTimestamp)
+		putTimestamp(&buf, n.RxTimestamp)
+	}
+
+	return buf.Bytes()
+}
+
+func (e *binaryEncoder) Encode(frame interface{}) error {
+	var buf bytes.Buffer
+	var trace []byte
+
+	f, ok := frame.(*Frame)
+	if !ok {
+		return fmt.Errorf("SSNTP binary codec: Can not encode %T", frame)
+	}
+
+	if f.Trace != nil {
+		trace = marshalTrace(f.Trace)
+	}
+
+	length := decoder.HeaderLength + len(trace) + len(f.Payload)
+
+	binary.Write(&buf, binary.BigEndian, uint32(length))
+	buf.Write([]byte{f.Major, f.Minor, uint8(f.Type), f.Operand})
+	buf.Write(f.Origin[:])
+	binary.Write(&buf, binary.BigEndian, f.CorrelationID)
+	binary.Write(&buf, binary.BigEndian, uint32(len(trace)))
+	buf.Write(trace)
+	binary.Write(&buf, binary.BigEndian, uint32(len(f.Payload)))
+	buf.Write(f.Payload)
+
+	// A single write per frame, frames can be sent concurrently.
+	_, err := e.w.Write(buf.Bytes())
+
+	return err
+}
+
+type binaryDecoder struct {
+	d *decoder.Decoder
+}
+
+// setMaxFrameSize makes the decoder reject frames larger than max
+// bytes before allocating them.
+func (d *binaryDecoder) setMaxFrameSize(max int) {
+	d.d.SetMaxLength(max)
+}
+
+func (d *binaryDecoder) Decode(frame interface{}) error {
+	f, ok := frame.(*Frame)
+	if !ok {
+		return fmt.Errorf("SSNTP binary codec: Can not decode %T", frame)
+	}
+
+	decoded, err := d.d.Decode()
+	if err == decoder.ErrFrameTooLarge {
+		return errFrameTooLarge
+	} else if err != nil {
+		return err
+	}
+
+	f.Major = decoded.Major
+	f.Minor = decoded.Minor
+	f.Type = (Type)(decoded.Type)
+	f.Operand = decoded.Operand
+	copy(f.Origin[:], decoded.Origin[:])
+	f.CorrelationID = decoded.CorrelationID
+	f.PayloadLength = (uint32)(len(decoded.Payload))
+	f.Payload = decoded.Payload
+
+	if decoded.Trace != nil {
+		f.Trace = &FrameTrace{
+			Label:          decoded.Trace.Label,
+			StartTimestamp: decoded.Trace.StartTimestamp,
+			EndTimestamp:   decoded.Trace.EndTimestamp,
+			PathLength:     (uint8)(len(decoded.Trace.Path)),
+		}
+
+		for _, n := range decoded.Trace.Path {
+			uuid := n.UUID
+			f.Trace.Path = append(f.Trace.Path, Node{
+				UUID:        uuid[:],
+				Role:        (Role)(n.Role),
+				TxTimestamp: n.TxTimestamp,
+				RxTimestamp: n.RxTimestamp,
+			})
+		}
+	}
+
+	return nil
+}
+
+func codecCapability(codec Codec) Capability {
+	return Capability("codec:" + codec.Name())
+}
+
+// selectCodec returns the first codec from codecs that is part of
+// the negotiated capabilities, in the capabilities order.
+func selectCodec(capabilities []Capability, codecs []Codec) Codec {
+	for _, c := range capabilities {
+		for _, codec := range codecs {
+			if codecCapability(codec) == c {
+				return codec
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+)
+
+// CompressionCapability is the SSNTP capability for compressed payloads.
+// SSNTP entities negotiating it may send frames with a DEFLATE compressed
+// payload, flagged by the bit 6 of the frame Major field.
+const CompressionCapability Capability = "compression"
+
+const payloadCompressed = 1 << 6
+
+func (f Frame) compressed() bool {
+	return (f.Major & payloadCompressed) == payloadCompressed
+}
+
+func compressPayload(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompressPayload inflates a compressed payload. It fails with
+// errFrameTooLarge if the payload inflates to more than max bytes,
+// unless max is 0.
+func decompressPayload(payload []byte, max int) ([]byte, error) {
+	var r io.Reader
+
+	r = flate.NewReader(bytes.NewReader(payload))
+	if max > 0 {
+		r = io.LimitReader(r, int64(max)+1)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if max > 0 && len(data) > max {
+		return nil, errFrameTooLarge
+	}
+
+	return data, nil
+}
+
+// compress returns a copy of frame with a compressed payload, if the
+// session peer negotiated compression and the payload is at least
+// compressionThreshold bytes long. Otherwise, or if compressing does
+// not shrink the payload, it returns frame itself.
+// frame is never modified as the same frame may be forwarded to
+// several sessions.
+func (session *session) compress(frame interface{}) interface{} {
+	f, ok := frame.(*Frame)
+	if !ok || session.compressionThreshold <= 0 ||
+		len(f.Payload) < session.compressionThreshold ||
+		!session.hasCapability(CompressionCapability) {
+		return frame
+	}
+
+	payload, err := compressPayload(f.Payload)
+	if err != nil || len(payload) >= len(f.Payload) {
+		return frame
+	}
+
+	compressed := *f
+	compressed.Major |= payloadCompressed
+	compressed.PayloadLength = (uint32)(len(payload))
+	compressed.Payload = payload
+
+	return &compressed
+}
+
+// decompress inflates the frame payload in place, if it is compressed.
+func (session *session) decompress(frame interface{}) error {
+	f, ok := frame.(*Frame)
+	if !ok || !f.compressed() {
+		return nil
+	}
+
+	payload, err := decompressPayload(f.Payload, session.maxPayloadSize)
+	if err != nil {
+		return err
+	}
+
+	f.Major &^= payloadCompressed
+	f.PayloadLength = (uint32)(len(payload))
+	f.Payload = payload
+
+	return nil
+}
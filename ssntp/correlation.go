@@ -0,0 +1,110 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// CorrelationCapability is the SSNTP capability for frame correlation IDs.
+// SSNTP entities negotiating it carry the Frame CorrelationID field
+// across, including when forwarding frames, and thus allow for waiting on
+// a command reply with SendCommandAndWait.
+const CorrelationCapability Capability = "correlation-id"
+
+// correlator tracks the commands waiting for a reply.
+type correlator struct {
+	sync.Mutex
+	next    uint64
+	waiters map[uint64]chan *Frame
+}
+
+// register allocates a new correlation ID and the channel its reply
+// will be delivered on.
+// IDs start from a random value so that replies forwarded to several
+// SSNTP entities are unlikely to match another entity's pending command.
+func (c *correlator) register() (uint64, chan *Frame) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.waiters == nil {
+		c.waiters = make(map[uint64]chan *Frame)
+		c.next = uint64(rand.New(rand.NewSource(time.Now().UnixNano())).Int63())
+	}
+
+	c.next++
+	if c.next == 0 {
+		c.next++
+	}
+
+	reply := make(chan *Frame, 1)
+	c.waiters[c.next] = reply
+
+	return c.next, reply
+}
+
+func (c *correlator) unregister(id uint64) {
+	c.Lock()
+	delete(c.waiters, id)
+	c.Unlock()
+}
+
+// deliver hands a STATUS or ERROR reply frame over to the command
+// waiting for it, if any.
+func (c *correlator) deliver(frame *Frame) bool {
+	if frame.CorrelationID == 0 || (frame.Type != STATUS && frame.Type != ERROR) {
+		return false
+	}
+
+	c.Lock()
+	reply, ok := c.waiters[frame.CorrelationID]
+	delete(c.waiters, frame.CorrelationID)
+	c.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	reply <- frame
+
+	return true
+}
+
+// wait blocks until the reply for a command comes in, or until ctx is done.
+func (c *correlator) wait(ctx context.Context, id uint64, reply chan *Frame) (*Frame, error) {
+	defer c.unregister(id)
+
+	select {
+	case frame := <-reply:
+		return frame, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func checkCorrelation(capabilities []Capability) error {
+	if !hasCapability(capabilities, CorrelationCapability) {
+		return fmt.Errorf("SSNTP peer does not support %s", CorrelationCapability)
+	}
+
+	return nil
+}
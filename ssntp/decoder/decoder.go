@@ -0,0 +1,290 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package decoder decodes SSNTP frames encoded with the SSNTP binary
+// codec. It does not depend on the ssntp package and can be used by
+// tools dissecting captured SSNTP traffic.
+//
+// Each binary frame is made of a 4 bytes frame length followed by the
+// frame itself. All integers are big endian and all timestamps are
+// signed 64 bits nanoseconds since the Unix epoch, 0 meaning unset:
+//
+//	+--------------------------------------------------------------+
+//	| Length | Major | Minor | Type | Operand | Origin | Correlation |
+//	|  (4)   |  (1)  |  (1)  | (1)  |   (1)   |  (16)  |   ID (8)    |
+//	+--------------------------------------------------------------+
+//	| Trace Length (4) | Trace | Payload Length (4) | Payload        |
+//	+--------------------------------------------------------------+
+//
+// Length is the number of bytes following the Length field. The bit 6
+// of Major is set when the Payload is DEFLATE compressed. The Trace
+// is only present when Trace Length is not 0, and is made of:
+//
+//	+--------------------------------------------------------------+
+//	| Label Length (4) | Label | Start (8) | End (8) | Nodes (1)     |
+//	+--------------------------------------------------------------+
+//	| Node UUID (16) | Role (4) | Tx (8) | Rx (8) |  ... x Nodes     |
+//	+--------------------------------------------------------------+
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// HeaderLength is the length of the fixed part of a frame,
+	// i.e. the frame without its trace and payload.
+	HeaderLength = 4 + 16 + 8 + 4 + 4
+
+	traceHeaderLength = 4 + 8 + 8 + 1
+	nodeLength        = 16 + 4 + 8 + 8
+)
+
+// ErrShortFrame is returned when a frame is shorter than its
+// length fields claim.
+var ErrShortFrame = errors.New("SSNTP binary frame too short")
+
+// ErrFrameTooLarge is returned when a frame is longer than the
+// decoder maximum frame length.
+var ErrFrameTooLarge = errors.New("SSNTP binary frame too large")
+
+// Node is a frame trace path node.
+type Node struct {
+	UUID        [16]byte
+	Role        uint32
+	TxTimestamp time.Time
+	RxTimestamp time.Time
+}
+
+// Trace is the frame tracing information.
+type Trace struct {
+	Label          []byte
+	StartTimestamp time.Time
+	EndTimestamp   time.Time
+	Path           []Node
+}
+
+// Frame is a decoded SSNTP binary frame.
+type Frame struct {
+	Major         uint8
+	Minor         uint8
+	Type          uint8
+	Operand       uint8
+	Origin        [16]byte
+	CorrelationID uint64
+	Trace         *Trace
+	Payload       []byte
+}
+
+// Compressed tells if the frame payload is DEFLATE compressed.
+func (f *Frame) Compressed() bool {
+	return f.Major&(1<<6) != 0
+}
+
+var typeNames = []string{"COMMAND", "STATUS", "ERROR", "EVENT"}
+
+// TypeName returns the SSNTP frame type name.
+func (f *Frame) TypeName() string {
+	if int(f.Type) < len(typeNames) {
+		return typeNames[f.Type]
+	}
+
+	return fmt.Sprintf("Unknown (%d)", f.Type)
+}
+
+func uuidString(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+func (f *Frame) String() string {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "\tMajor %d\n\tMinor %d\n\tType %s\n\tOp %d\n\tOrigin %s\n",
+		f.Major&0x3f, f.Minor, f.TypeName(), f.Operand, uuidString(f.Origin))
+
+	if f.CorrelationID != 0 {
+		fmt.Fprintf(&buf, "\tCorrelation ID %d\n", f.CorrelationID)
+	}
+
+	fmt.Fprintf(&buf, "\tPayload len %d\n", len(f.Payload))
+	if f.Compressed() {
+		fmt.Fprintf(&buf, "\tPayload compressed\n")
+	}
+
+	if f.Trace != nil {
+		fmt.Fprintf(&buf, "\tLabel %q\n", f.Trace.Label)
+		for i, n := range f.Trace.Path {
+			fmt.Fprintf(&buf, "\t\tNode #%d\n\t\tUUID %s\n", i, uuidString(n.UUID))
+			if !n.RxTimestamp.IsZero() {
+				fmt.Fprintf(&buf, "\t\tRx %q\n", n.RxTimestamp.Format(time.StampNano))
+			}
+			if !n.TxTimestamp.IsZero() {
+				fmt.Fprintf(&buf, "\t\tTx %q\n", n.TxTimestamp.Format(time.StampNano))
+			}
+		}
+	}
+
+	return buf.String()
+}
+
+// Decoder reads and decodes SSNTP binary frames from an input stream.
+type Decoder struct {
+	r         io.Reader
+	maxLength int
+}
+
+// NewDecoder returns a new decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// SetMaxLength sets the maximum length of the frames the decoder
+// accepts, the frame length field included. Longer frames are rejected
+// with ErrFrameTooLarge before their content is read. 0, the default,
+// means no limit.
+func (d *Decoder) SetMaxLength(max int) {
+	d.maxLength = max
+}
+
+// Decode reads the next binary frame from the decoder input stream.
+// It returns io.EOF when there are no more frames to read.
+func (d *Decoder) Decode() (*Frame, error) {
+	var length [4]byte
+
+	_, err := io.ReadFull(d.r, length[:])
+	if err != nil {
+		return nil, err
+	}
+
+	frameLength := binary.BigEndian.Uint32(length[:])
+	if d.maxLength > 0 && uint64(frameLength)+uint64(len(length)) > uint64(d.maxLength) {
+		return nil, ErrFrameTooLarge
+	}
+
+	data := make([]byte, frameLength)
+	_, err = io.ReadFull(d.r, data)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	return Unmarshal(data)
+}
+
+// Unmarshal decodes a binary frame, without its leading length field.
+func Unmarshal(data []byte) (*Frame, error) {
+	var f Frame
+
+	if len(data) < HeaderLength {
+		return nil, ErrShortFrame
+	}
+
+	f.Major = data[0]
+	f.Minor = data[1]
+	f.Type = data[2]
+	f.Operand = data[3]
+	copy(f.Origin[:], data[4:20])
+	f.CorrelationID = binary.BigEndian.Uint64(data[20:28])
+	data = data[28:]
+
+	traceLength := binary.BigEndian.Uint32(data[0:4])
+	data = data[4:]
+	if uint64(traceLength) > uint64(len(data)) {
+		return nil, ErrShortFrame
+	}
+
+	if traceLength > 0 {
+		trace, err := unmarshalTrace(data[:traceLength])
+		if err != nil {
+			return nil, err
+		}
+		f.Trace = trace
+		data = data[traceLength:]
+	}
+
+	if len(data) < 4 {
+		return nil, ErrShortFrame
+	}
+
+	payloadLength := binary.BigEndian.Uint32(data[0:4])
+	data = data[4:]
+	if uint64(payloadLength) != uint64(len(data)) {
+		return nil, fmt.Errorf("Wrong SSNTP binary frame payload length %d (%d bytes left)", payloadLength, len(data))
+	}
+
+	if payloadLength > 0 {
+		f.Payload = data
+	}
+
+	return &f, nil
+}
+
+func timestamp(data []byte) time.Time {
+	ns := int64(binary.BigEndian.Uint64(data))
+	if ns == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, ns)
+}
+
+func unmarshalTrace(data []byte) (*Trace, error) {
+	var t Trace
+
+	if len(data) < 4 {
+		return nil, ErrShortFrame
+	}
+
+	labelLength := binary.BigEndian.Uint32(data[0:4])
+	data = data[4:]
+	if uint64(labelLength)+traceHeaderLength-4 > uint64(len(data)) {
+		return nil, ErrShortFrame
+	}
+
+	if labelLength > 0 {
+		t.Label = data[:labelLength]
+	}
+	data = data[labelLength:]
+
+	t.StartTimestamp = timestamp(data[0:8])
+	t.EndTimestamp = timestamp(data[8:16])
+	nodes := int(data[16])
+	data = data[17:]
+
+	if nodes*nodeLength != len(data) {
+		return nil, fmt.Errorf("Wrong SSNTP binary frame trace length")
+	}
+
+	for i := 0; i < nodes; i++ {
+		var n Node
+
+		copy(n.UUID[:], data[0:16])
+		n.Role = binary.BigEndian.Uint32(data[16:20])
+		n.TxTimestamp = timestamp(data[20:28])
+		n.RxTimestamp = timestamp(data[28:36])
+		t.Path = append(t.Path, n)
+
+		data = data[nodeLength:]
+	}
+
+	return &t, nil
+}
@@ -0,0 +1,118 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package decoder_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	. "github.com/01org/ciao/ssntp/decoder"
+)
+
+func binaryFrame(payload []byte, correlationID uint64) []byte {
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.BigEndian, uint32(HeaderLength+len(payload)))
+	buf.Write([]byte{0, 2, 2, 3})
+	buf.Write(bytes.Repeat([]byte{0xab}, 16))
+	binary.Write(&buf, binary.BigEndian, correlationID)
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	binary.Write(&buf, binary.BigEndian, uint32(len(payload)))
+	buf.Write(payload)
+
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	stream := append(binaryFrame([]byte("foo"), 42), binaryFrame(nil, 0)...)
+	d := NewDecoder(bytes.NewReader(stream))
+
+	f, err := d.Decode()
+	if err != nil {
+		t.Fatalf("Could not decode frame: %s", err)
+	}
+
+	if f.Major != 0 || f.Minor != 2 || f.TypeName() != "ERROR" || f.Operand != 3 {
+		t.Fatalf("Wrong frame header %s", f)
+	}
+
+	if f.CorrelationID != 42 || string(f.Payload) != "foo" || f.Trace != nil {
+		t.Fatalf("Wrong frame content %s", f)
+	}
+
+	f, err = d.Decode()
+	if err != nil {
+		t.Fatalf("Could not decode frame: %s", err)
+	}
+
+	if f.Payload != nil {
+		t.Fatalf("Unexpected payload %v", f.Payload)
+	}
+
+	_, err = d.Decode()
+	if err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	frame := binaryFrame([]byte("foo"), 0)
+	d := NewDecoder(bytes.NewReader(frame[:len(frame)-1]))
+
+	_, err := d.Decode()
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected unexpected EOF, got %v", err)
+	}
+}
+
+func TestDecodeTooLarge(t *testing.T) {
+	var length [4]byte
+
+	binary.BigEndian.PutUint32(length[:], 0xfffffff0)
+	d := NewDecoder(bytes.NewReader(length[:]))
+	d.SetMaxLength(4096)
+
+	_, err := d.Decode()
+	if err != ErrFrameTooLarge {
+		t.Fatalf("Expected frame too large error, got %v", err)
+	}
+
+	frame := binaryFrame([]byte("foo"), 0)
+	d = NewDecoder(bytes.NewReader(frame))
+	d.SetMaxLength(len(frame))
+
+	_, err = d.Decode()
+	if err != nil {
+		t.Fatalf("Could not decode frame: %s", err)
+	}
+}
+
+func TestUnmarshalShortFrame(t *testing.T) {
+	_, err := Unmarshal(make([]byte, HeaderLength-1))
+	if err != ErrShortFrame {
+		t.Fatalf("Expected short frame error, got %v", err)
+	}
+
+	frame := binaryFrame([]byte("foo"), 0)
+	binary.BigEndian.PutUint32(frame[32:36], 64)
+	_, err = Unmarshal(frame[4:])
+	if err != ErrShortFrame {
+		t.Fatalf("Expected short frame error, got %v", err)
+	}
+}
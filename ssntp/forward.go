@@ -17,6 +17,7 @@
 package ssntp
 
 import (
+	"fmt"
 	"sync"
 )
 
@@ -44,10 +45,13 @@ const (
 // The interface implementer needs to specify if the frame
 // should be forwarded, discarded or queued (Decision).
 // If the implementer decision is to forward the frame, it
-// should also provide a list of recipients to forward it to (UUIDs)
+// should also provide a list of recipients to forward it to (UUIDs),
+// and/or SSNTP roles and named groups to broadcast it to.
 type ForwardDestination struct {
-	decision       ForwardDecision
-	recipientUUIDs []string
+	decision        ForwardDecision
+	recipientUUIDs  []string
+	recipientRoles  []Role
+	recipientGroups []string
 }
 
 // Decision is a simple accessor for the ForwardDecision.decision field
@@ -68,6 +72,36 @@ func (d *ForwardDestination) AddRecipient(uuid string) {
 	d.recipientUUIDs = append(d.recipientUUIDs, uuid)
 }
 
+// AddRoleRecipient adds all the SSNTP clients playing a given role,
+// except the frame sender, to the ForwardDestination recipients.
+// AddRoleRecipient implicitly sets the forwarding decision to Forward.
+func (d *ForwardDestination) AddRoleRecipient(role Role) {
+	d.decision = Forward
+	d.recipientRoles = append(d.recipientRoles, role)
+}
+
+// RoleRecipients is a simple accessor for the ForwardDecision.recipientRoles field
+func (d *ForwardDestination) RoleRecipients() []Role {
+	return d.recipientRoles
+}
+
+// AddGroupRecipient adds all the members of a named SSNTP server group,
+// except the frame sender, to the ForwardDestination recipients.
+// AddGroupRecipient implicitly sets the forwarding decision to Forward.
+func (d *ForwardDestination) AddGroupRecipient(group string) {
+	d.decision = Forward
+	d.recipientGroups = append(d.recipientGroups, group)
+}
+
+// GroupRecipients is a simple accessor for the ForwardDecision.recipientGroups field
+func (d *ForwardDestination) GroupRecipients() []string {
+	return d.recipientGroups
+}
+
+func (d *ForwardDestination) hasRecipients() bool {
+	return d.recipientUUIDs != nil || d.recipientRoles != nil || d.recipientGroups != nil
+}
+
 // SetDecision is a helper for setting the ForwardDestination Decision field.
 func (d *ForwardDestination) SetDecision(decision ForwardDecision) {
 	d.decision = decision
@@ -97,12 +131,21 @@ type EventForwarder interface {
 	EventForward(uuid string, event Event, frame *Frame) ForwardDestination
 }
 
+// DeliveryFailureNotifier is an optional interface for frame forwarders.
+// If a CommandForwarder, StatusForwarder, ErrorForwarder or EventForwarder
+// also implements it, SSNTP notifies it of each recipient the frame
+// could not be forwarded to, e.g. because it is not connected.
+type DeliveryFailureNotifier interface {
+	DeliveryFailure(uuid string, frame *Frame, err error)
+}
+
 // FrameForwardRule defines a forwarding rule for a SSNTP frame.
 // The rule creator can either choose to forward this frame to
-// all clients playing a specified SSNTP role (Dest), or can return
-// a forwarding decision back to SSNTP depending on the frame payload (*Forwarder).
+// all clients playing a specified SSNTP role (Dest) and/or to all
+// members of a named group (DestGroup), or can return a forwarding
+// decision back to SSNTP depending on the frame payload (*Forwarder).
 // If a frame forwarder interface implementation is provided, the
-// Dest field will be ignored.
+// Dest and DestGroup fields will be ignored.
 type FrameForwardRule struct {
 	// Operand is the SSNTP frame operand to which this rule applies.
 	Operand interface{}
@@ -112,6 +155,11 @@ type FrameForwardRule struct {
 	// This field is ignored if a forwarding interface is provided.
 	Dest Role
 
+	// A frame which operand is Operand will be forwarded to all
+	// members of the DestGroup SSNTP server group.
+	// This field is ignored if a forwarding interface is provided.
+	DestGroup string
+
 	// The SSNTP Command forwarding interface implementation for this SSNTP frame.
 	CommandForward CommandForwarder
 
@@ -137,6 +185,9 @@ type frameForward struct {
 	forwardStatusFunc  map[Status]StatusForwarder
 	forwardErrorFunc   map[Error]ErrorForwarder
 	forwardEventFunc   map[Event]EventForwarder
+
+	// forwardGroup maps operands to their static destination group.
+	forwardGroup map[interface{}]string
 }
 
 func (f *frameForward) init(rules []FrameForwardRule) {
@@ -149,10 +200,15 @@ func (f *frameForward) init(rules []FrameForwardRule) {
 	f.forwardStatusFunc = make(map[Status]StatusForwarder)
 	f.forwardErrorFunc = make(map[Error]ErrorForwarder)
 	f.forwardEventFunc = make(map[Event]EventForwarder)
+	f.forwardGroup = make(map[interface{}]string)
 
 	f.forwardMutex.Lock()
 
 	for _, r := range rules {
+		if r.DestGroup != "" {
+			f.forwardGroup[r.Operand] = r.DestGroup
+		}
+
 		switch op := r.Operand.(type) {
 		case Command:
 			if r.CommandForward != nil {
@@ -260,51 +316,117 @@ func (f *frameForward) deleteForwardDestination(dest *session) {
 	f.forwardMutex.Unlock()
 }
 
-func forwardDestination(destination ForwardDestination, server *Server, frame *Frame) {
-	/* TODO Handle queueing */
-	if destination.decision == Discard || destination.recipientUUIDs == nil {
-		return
+// forwardRecipients returns the UUIDs of all the destination recipients.
+// The sender is excluded from the role and group recipients.
+func (server *Server) forwardRecipients(source *session, destination *ForwardDestination) []string {
+	var uuids []string
+	seen := make(map[string]bool)
+	src := source.dest.String()
+
+	add := func(uuid string) {
+		if seen[uuid] {
+			return
+		}
+
+		seen[uuid] = true
+		uuids = append(uuids, uuid)
 	}
 
-	server.sessionMutex.RLock()
 	for _, uuid := range destination.recipientUUIDs {
-		session := server.sessions[uuid]
+		add(uuid)
+	}
+
+	if destination.recipientRoles != nil {
+		server.sessionMutex.RLock()
+		for uuid, session := range server.sessions {
+			if uuid == src {
+				continue
+			}
+
+			for _, role := range destination.recipientRoles {
+				if session.destRole.HasRole(role) {
+					add(uuid)
+					break
+				}
+			}
+		}
+		server.sessionMutex.RUnlock()
+	}
+
+	for _, group := range destination.recipientGroups {
+		for _, uuid := range server.groups.members(group) {
+			if uuid != src {
+				add(uuid)
+			}
+		}
+	}
+
+	return uuids
+}
+
+// deliver writes a frame to all recipients, and notifies the forwarder
+// about the recipients it could not be delivered to.
+func (server *Server) deliver(uuids []string, frame *Frame, forwarder interface{}) {
+	notifier, _ := forwarder.(DeliveryFailureNotifier)
+
+	for _, uuid := range uuids {
+		var err error
+
+		session := server.getSession(uuid)
 		if session == nil {
+			err = fmt.Errorf("Unknown UUID %s", uuid)
+		} else {
+			_, err = session.Write(frame)
+		}
+
+		if err == nil {
 			continue
 		}
 
-		session.Write(frame)
+		server.log.with(frameFields(frame)...).Warningf("Could not forward %s frame to %s: %s\n", frame.Type, uuid, err)
+		if notifier != nil {
+			notifier.DeliveryFailure(uuid, frame, err)
+		}
 	}
-	server.sessionMutex.RUnlock()
 }
 
-func commandForward(uuid string, f CommandForwarder, cmd Command, server *Server, frame *Frame) {
-	dest := f.CommandForward(uuid, cmd, frame)
+func forwardDestination(destination ForwardDestination, server *Server, source *session, forwarder interface{}, frame *Frame) {
+	source.metrics.forwardDecision(destination.decision)
 
-	forwardDestination(dest, server, frame)
+	/* TODO Handle queueing */
+	if destination.decision == Discard || !destination.hasRecipients() {
+		return
+	}
+
+	server.deliver(server.forwardRecipients(source, &destination), frame, forwarder)
+}
+
+func commandForward(source *session, f CommandForwarder, cmd Command, server *Server, frame *Frame) {
+	dest := f.CommandForward(source.dest.String(), cmd, frame)
+
+	forwardDestination(dest, server, source, f, frame)
 }
 
-func statusForward(uuid string, f StatusForwarder, status Status, server *Server, frame *Frame) {
-	dest := f.StatusForward(uuid, status, frame)
+func statusForward(source *session, f StatusForwarder, status Status, server *Server, frame *Frame) {
+	dest := f.StatusForward(source.dest.String(), status, frame)
 
-	forwardDestination(dest, server, frame)
+	forwardDestination(dest, server, source, f, frame)
 }
 
-func errorForward(uuid string, f ErrorForwarder, error Error, server *Server, frame *Frame) {
-	dest := f.ErrorForward(uuid, error, frame)
+func errorForward(source *session, f ErrorForwarder, error Error, server *Server, frame *Frame) {
+	dest := f.ErrorForward(source.dest.String(), error, frame)
 
-	forwardDestination(dest, server, frame)
+	forwardDestination(dest, server, source, f, frame)
 }
 
-func eventForward(uuid string, f EventForwarder, event Event, server *Server, frame *Frame) {
-	dest := f.EventForward(uuid, event, frame)
+func eventForward(source *session, f EventForwarder, event Event, server *Server, frame *Frame) {
+	dest := f.EventForward(source.dest.String(), event, frame)
 
-	forwardDestination(dest, server, frame)
+	forwardDestination(dest, server, source, f, frame)
 }
 
 func (f *frameForward) forwardFrame(server *Server, source *session, operand interface{}, frame *Frame) {
 	var sessions []*session
-	src := source.dest.String()
 
 	f.forwardMutex.RLock()
 	defer f.forwardMutex.RUnlock()
@@ -313,7 +435,7 @@ func (f *frameForward) forwardFrame(server *Server, source *session, operand int
 	case Command:
 		forwarder := f.forwardCommandFunc[op]
 		if forwarder != nil {
-			go commandForward(src, forwarder, op, server, frame)
+			go commandForward(source, forwarder, op, server, frame)
 			return
 		}
 
@@ -321,7 +443,7 @@ func (f *frameForward) forwardFrame(server *Server, source *session, operand int
 	case Status:
 		forwarder := f.forwardStatusFunc[op]
 		if forwarder != nil {
-			go statusForward(src, forwarder, op, server, frame)
+			go statusForward(source, forwarder, op, server, frame)
 			return
 		}
 
@@ -329,7 +451,7 @@ func (f *frameForward) forwardFrame(server *Server, source *session, operand int
 	case Error:
 		forwarder := f.forwardErrorFunc[op]
 		if forwarder != nil {
-			go errorForward(src, forwarder, op, server, frame)
+			go errorForward(source, forwarder, op, server, frame)
 			return
 		}
 
@@ -337,7 +459,7 @@ func (f *frameForward) forwardFrame(server *Server, source *session, operand int
 	case Event:
 		forwarder := f.forwardEventFunc[op]
 		if forwarder != nil {
-			go eventForward(src, forwarder, op, server, frame)
+			go eventForward(source, forwarder, op, server, frame)
 			return
 		}
 
@@ -346,14 +468,32 @@ func (f *frameForward) forwardFrame(server *Server, source *session, operand int
 		sessions = nil
 	}
 
-	if sessions == nil {
+	group := f.forwardGroup[operand]
+	if sessions == nil && group == "" {
 		return
 	}
 
+	source.metrics.forwardDecision(Forward)
+
+	sent := make(map[string]bool)
 	for _, s := range sessions {
 		if s == source {
 			continue
 		}
+		sent[s.dest.String()] = true
 		s.Write(frame)
 	}
+
+	if group == "" {
+		return
+	}
+
+	var uuids []string
+	for _, uuid := range server.groups.members(group) {
+		if !sent[uuid] && uuid != source.dest.String() {
+			uuids = append(uuids, uuid)
+		}
+	}
+
+	server.deliver(uuids, frame, nil)
 }
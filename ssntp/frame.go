@@ -76,6 +76,10 @@ type Frame struct {
 	PayloadLength uint32
 	Trace         *FrameTrace
 	Payload       []byte
+
+	// CorrelationID identifies a command frame and the STATUS or
+	// ERROR frame replying to it. It is 0 for uncorrelated frames.
+	CorrelationID uint64
 }
 
 // ConnectFrame is the SSNTP connection frame structure.
@@ -87,6 +91,10 @@ type ConnectFrame struct {
 	Role        Role
 	Source      []byte
 	Destination []byte
+
+	// Capabilities is the list of SSNTP capabilities
+	// the client supports.
+	Capabilities []Capability
 }
 
 // ConnectedFrame is the SSNTP connected frame structure.
@@ -100,9 +108,13 @@ type ConnectedFrame struct {
 	Destination   []byte
 	PayloadLength uint32
 	Payload       []byte
+
+	// Capabilities is the list of SSNTP capabilities
+	// negotiated for this connection.
+	Capabilities []Capability
 }
 
-const majorMask = 0x7f
+const majorMask = 0x3f
 const pathTraceEnabled = 1 << 7
 
 // PathTrace tells if an SSNTP frames contains tracing information or not.
@@ -190,8 +202,8 @@ func (f ConnectFrame) String() string {
 	copy(src[:], f.Source[:16])
 	copy(dest[:], f.Destination[:16])
 
-	return fmt.Sprintf("\tMajor %d\n\tMinor %d\n\tType %s\n\tOp %s\n\tRole %s\n\tSource %s\n\tDestination %s\n",
-		f.Major, f.Minor, (Type)(f.Type), op, &f.Role, src, dest)
+	return fmt.Sprintf("\tMajor %d\n\tMinor %d\n\tType %s\n\tOp %s\n\tRole %s\n\tSource %s\n\tDestination %s\n\tCapabilities %v\n",
+		f.Major, f.Minor, (Type)(f.Type), op, &f.Role, src, dest, f.Capabilities)
 }
 
 func (f ConnectedFrame) String() string {
@@ -211,8 +223,8 @@ func (f ConnectedFrame) String() string {
 	copy(src[:], f.Source[:16])
 	copy(dest[:], f.Destination[:16])
 
-	return fmt.Sprintf("\tMajor %d\n\tMinor %d\n\tType %s\n\tOp %s\n\tRole %s\n\tSource %s\n\tDestination %s\n",
-		f.Major, f.Minor, (Type)(f.Type), op, &f.Role, src, dest)
+	return fmt.Sprintf("\tMajor %d\n\tMinor %d\n\tType %s\n\tOp %s\n\tRole %s\n\tSource %s\n\tDestination %s\n\tCapabilities %v\n",
+		f.Major, f.Minor, (Type)(f.Type), op, &f.Role, src, dest, f.Capabilities)
 }
 
 func (f *Frame) addPathNode(session *session) {
@@ -0,0 +1,86 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"sort"
+	"sync"
+)
+
+// serverGroups are the SSNTP server named groups of client UUIDs.
+type serverGroups struct {
+	sync.RWMutex
+	groups map[string]map[string]bool
+}
+
+func (g *serverGroups) add(group string, uuid string) {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.groups == nil {
+		g.groups = make(map[string]map[string]bool)
+	}
+
+	if g.groups[group] == nil {
+		g.groups[group] = make(map[string]bool)
+	}
+
+	g.groups[group][uuid] = true
+}
+
+func (g *serverGroups) remove(group string, uuid string) {
+	g.Lock()
+	defer g.Unlock()
+
+	delete(g.groups[group], uuid)
+	if len(g.groups[group]) == 0 {
+		delete(g.groups, group)
+	}
+}
+
+func (g *serverGroups) members(group string) []string {
+	var uuids []string
+
+	g.RLock()
+	for uuid := range g.groups[group] {
+		uuids = append(uuids, uuid)
+	}
+	g.RUnlock()
+
+	sort.Strings(uuids)
+
+	return uuids
+}
+
+// AddGroupMember adds an SSNTP client to a named group of the server.
+// Frames can then be forwarded to all the group members, either through
+// a FrameForwardRule DestGroup or through ForwardDestination.AddGroupRecipient.
+// Clients are not removed from their groups when they disconnect, so
+// that forwarders can be notified about the failed deliveries.
+func (server *Server) AddGroupMember(group string, uuid string) {
+	server.groups.add(group, uuid)
+}
+
+// RemoveGroupMember removes an SSNTP client from a named group of the server.
+func (server *Server) RemoveGroupMember(group string, uuid string) {
+	server.groups.remove(group, uuid)
+}
+
+// GroupMembers returns the UUIDs of a server group members.
+func (server *Server) GroupMembers(group string) []string {
+	return server.groups.members(group)
+}
@@ -0,0 +1,90 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"time"
+)
+
+// KeepaliveCapability is the SSNTP capability for keepalive frames.
+// SSNTP entities negotiating it reply to PING commands with PONG statuses.
+const KeepaliveCapability Capability = "keepalive"
+
+const defaultKeepaliveMissedBeats = 3
+
+type keepaliveConfig struct {
+	interval    time.Duration
+	missedBeats int
+}
+
+// startKeepalive starts sending PING frames to the session peer, and
+// sets a read timeout after which the peer is considered dead.
+// It is a no-op if keepalive is disabled or was not negotiated.
+func (session *session) startKeepalive(keepalive keepaliveConfig) {
+	if keepalive.interval <= 0 || !session.hasCapability(KeepaliveCapability) {
+		return
+	}
+
+	session.keepaliveTimeout = keepalive.interval * time.Duration(keepalive.missedBeats)
+	session.keepaliveStop = make(chan struct{})
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(keepalive.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				_, err := session.Write(session.commandFrame(PING, nil, nil))
+				if err != nil {
+					return
+				}
+			}
+		}
+	}(session.keepaliveStop)
+}
+
+func (session *session) stopKeepalive() {
+	if session.keepaliveStop == nil {
+		return
+	}
+
+	session.keepaliveOnce.Do(func() {
+		close(session.keepaliveStop)
+	})
+}
+
+// handleKeepalive replies to PING frames and consumes PONG ones.
+// It returns true if frame is a keepalive frame.
+func (session *session) handleKeepalive(frame *Frame) bool {
+	if !isKeepaliveFrame(frame.Type, frame.Operand) {
+		return false
+	}
+
+	if frame.Type == COMMAND {
+		session.Write(session.statusFrame(PONG, nil, nil))
+	}
+
+	return true
+}
+
+func isKeepaliveFrame(t Type, operand uint8) bool {
+	return (t == COMMAND && (Command)(operand) == PING) ||
+		(t == STATUS && (Status)(operand) == PONG)
+}
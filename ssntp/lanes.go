@@ -0,0 +1,242 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Established sessions send their frames through outbound lanes, so that
+// control frames are not delayed by large statistics and trace frames.
+type lane int
+
+const (
+	// controlLane carries the commands, except STATS, the errors
+	// and the keepalive frames.
+	controlLane lane = iota
+
+	// normalLane carries the statuses and events.
+	normalLane
+
+	// bulkLane carries the STATS commands and TraceReport events.
+	bulkLane
+
+	laneCount
+)
+
+const defaultBulkQueueSize = 16
+
+var errSessionClosed = errors.New("SSNTP session closed")
+
+func frameLane(frame interface{}) lane {
+	f, ok := frame.(*Frame)
+	if !ok {
+		return controlLane
+	}
+
+	switch f.Type {
+	case COMMAND:
+		if (Command)(f.Operand) == STATS {
+			return bulkLane
+		}
+	case STATUS:
+		if (Status)(f.Operand) != PONG {
+			return normalLane
+		}
+	case EVENT:
+		if (Event)(f.Operand) == TraceReport {
+			return bulkLane
+		}
+		return normalLane
+	}
+
+	return controlLane
+}
+
+// isStats returns true if frame is a STATS command.
+func isStats(frame interface{}) bool {
+	f, ok := frame.(*Frame)
+
+	return ok && f.Type == COMMAND && (Command)(f.Operand) == STATS
+}
+
+type outboundRequest struct {
+	ctx   context.Context
+	frame interface{}
+
+	// done gets the frame write result. It is nil for bulk
+	// frames, as their senders do not wait for them to be sent.
+	done chan error
+}
+
+func (r *outboundRequest) complete(err error) {
+	if r.done != nil {
+		r.done <- err
+	}
+}
+
+type outboundLanes struct {
+	sync.Mutex
+	queues        [laneCount][]*outboundRequest
+	bulkQueueSize int
+	stopped       bool
+	wake          chan struct{}
+	stop          chan struct{}
+}
+
+// push queues r on l. A STATS frame replaces any queued STATS frame
+// from the same origin, and the oldest bulk frame is dropped when the
+// bulk lane is full. push returns the number of dropped frames.
+func (l *outboundLanes) push(r *outboundRequest, ln lane) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.stopped {
+		return 0, errSessionClosed
+	}
+
+	if ln == bulkLane && isStats(r.frame) {
+		origin := r.frame.(*Frame).Origin
+		for i, q := range l.queues[ln] {
+			if isStats(q.frame) && q.frame.(*Frame).Origin == origin {
+				l.queues[ln][i] = r
+				return 1, nil
+			}
+		}
+	}
+
+	dropped := 0
+	if ln == bulkLane && len(l.queues[ln]) >= l.bulkQueueSize {
+		l.queues[ln] = l.queues[ln][1:]
+		dropped = 1
+	}
+
+	l.queues[ln] = append(l.queues[ln], r)
+
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+
+	return dropped, nil
+}
+
+// pop returns the next frame to send, by order of lane priority.
+func (l *outboundLanes) pop() *outboundRequest {
+	l.Lock()
+	defer l.Unlock()
+
+	for ln := range l.queues {
+		if len(l.queues[ln]) > 0 {
+			r := l.queues[ln][0]
+			l.queues[ln] = l.queues[ln][1:]
+			return r
+		}
+	}
+
+	return nil
+}
+
+func (l *outboundLanes) close() {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.stopped {
+		return
+	}
+
+	l.stopped = true
+	close(l.stop)
+
+	for ln := range l.queues {
+		for _, r := range l.queues[ln] {
+			r.complete(errSessionClosed)
+		}
+		l.queues[ln] = nil
+	}
+}
+
+// send queues a frame on its outbound lane. Senders of control and
+// normal frames wait for them to be sent, while bulk frames are
+// sent asynchronously.
+func (l *outboundLanes) send(ctx context.Context, frame interface{}, metrics *sessionMetrics) (int, error) {
+	ln := frameLane(frame)
+	r := &outboundRequest{ctx: ctx, frame: frame}
+	if ln != bulkLane {
+		r.done = make(chan error, 1)
+	}
+
+	dropped, err := l.push(r, ln)
+	if err != nil {
+		return 0, err
+	}
+	metrics.framesDropped(dropped)
+
+	if r.done == nil {
+		return 0, nil
+	}
+
+	select {
+	case err = <-r.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return 0, err
+}
+
+// startLanes starts sending the session frames through outbound lanes.
+func (session *session) startLanes(bulkQueueSize int) {
+	lanes := &outboundLanes{
+		bulkQueueSize: bulkQueueSize,
+		wake:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			r := lanes.pop()
+			if r == nil {
+				select {
+				case <-lanes.wake:
+					continue
+				case <-lanes.stop:
+					return
+				}
+			}
+
+			err := r.ctx.Err()
+			if err == nil {
+				_, err = session.write(r.ctx, r.frame)
+			}
+			r.complete(err)
+		}
+	}()
+
+	session.lanes = lanes
+}
+
+func (session *session) stopLanes() {
+	if session.lanes == nil {
+		return
+	}
+
+	session.lanes.close()
+}
@@ -0,0 +1,171 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var errFrameTooLarge = errors.New("SSNTP frame too large")
+
+type limitsConfig struct {
+	maxFrameSize       int
+	maxPayloadSize     int
+	maxHandshakesPerIP int
+	handshakeTimeout   time.Duration
+}
+
+// frameReader limits the number of bytes decoders can read for a
+// single frame. It implements io.ByteReader so that gob decoders
+// do not buffer reads on top of it.
+type frameReader struct {
+	r        *bufio.Reader
+	max      int
+	count    int
+	tooLarge bool
+}
+
+func (r *frameReader) reset() {
+	r.count = 0
+	r.tooLarge = false
+}
+
+func (r *frameReader) remaining() bool {
+	if r.max > 0 && r.count >= r.max {
+		r.tooLarge = true
+		return false
+	}
+
+	return true
+}
+
+func (r *frameReader) Read(p []byte) (int, error) {
+	if !r.remaining() {
+		return 0, errFrameTooLarge
+	}
+
+	if r.max > 0 && len(p) > r.max-r.count {
+		p = p[:r.max-r.count]
+	}
+
+	n, err := r.r.Read(p)
+	r.count += n
+
+	return n, err
+}
+
+func (r *frameReader) ReadByte() (byte, error) {
+	if !r.remaining() {
+		return 0, errFrameTooLarge
+	}
+
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.count++
+	}
+
+	return b, err
+}
+
+// frameSizeLimiter is implemented by decoders reading a frame length
+// before the frame itself, so that they can reject large frames without
+// allocating them.
+type frameSizeLimiter interface {
+	setMaxFrameSize(max int)
+}
+
+// handshakeLimiter keeps track of the connection handshakes
+// running for each remote IP address.
+type handshakeLimiter struct {
+	sync.Mutex
+	max        int
+	handshakes map[string]int
+}
+
+func newHandshakeLimiter(max int) *handshakeLimiter {
+	return &handshakeLimiter{
+		max:        max,
+		handshakes: make(map[string]int),
+	}
+}
+
+func remoteHost(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+// start registers a new handshake from host. It returns false if
+// there already are too many handshakes running for host.
+func (l *handshakeLimiter) start(host string) bool {
+	if l.max <= 0 {
+		return true
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.handshakes[host] >= l.max {
+		return false
+	}
+
+	l.handshakes[host]++
+
+	return true
+}
+
+func (l *handshakeLimiter) done(host string) {
+	if l.max <= 0 {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.handshakes[host]--
+	if l.handshakes[host] <= 0 {
+		delete(l.handshakes, host)
+	}
+}
+
+// checkPayloadSize returns errFrameTooLarge if frame carries
+// a payload larger than max bytes.
+func checkPayloadSize(frame interface{}, max int) error {
+	if max <= 0 {
+		return nil
+	}
+
+	f, ok := frame.(*Frame)
+	if !ok {
+		return nil
+	}
+
+	if len(f.Payload) > max || int(f.PayloadLength) > max {
+		return errFrameTooLarge
+	}
+
+	return nil
+}
@@ -0,0 +1,335 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Level is the verbosity level of an SSNTP log message.
+type Level int
+
+const (
+	// ErrorLevel messages report errors.
+	ErrorLevel Level = iota
+
+	// WarningLevel messages report unexpected but recoverable events.
+	WarningLevel
+
+	// InfoLevel messages report the SSNTP connections life cycle.
+	InfoLevel
+
+	// DebugLevel messages report each frame sent and received.
+	DebugLevel
+)
+
+func (level Level) String() string {
+	switch level {
+	case ErrorLevel:
+		return "error"
+	case WarningLevel:
+		return "warning"
+	case InfoLevel:
+		return "info"
+	case DebugLevel:
+		return "debug"
+	}
+
+	return fmt.Sprintf("%d", level)
+}
+
+// Field is a key/value pair attached to an SSNTP log message.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Log message field keys set by the SSNTP package.
+const (
+	// UUIDField is the UUID of the SSNTP client or server logging
+	// the message.
+	UUIDField = "uuid"
+
+	// RoleField is the role of the SSNTP client or server logging
+	// the message.
+	RoleField = "role"
+
+	// PeerUUIDField is the UUID of the SSNTP session peer.
+	PeerUUIDField = "peer_uuid"
+
+	// PeerRoleField is the role of the SSNTP session peer.
+	PeerRoleField = "peer_role"
+
+	// FrameTypeField is the type of the frame the message is about.
+	FrameTypeField = "frame_type"
+
+	// OperandField is the operand of the frame the message is about.
+	OperandField = "operand"
+
+	// TraceLabelField is the trace label of the frame the message
+	// is about, if any.
+	TraceLabelField = "trace_label"
+)
+
+// StructuredLogger is an optional interface for SSNTP loggers. When the
+// Config Log logger implements it, SSNTP clients and servers log their
+// messages through it, together with fields describing the session and
+// the frame each message is about.
+type StructuredLogger interface {
+	// Enabled tells if messages of the given level are logged.
+	Enabled(level Level) bool
+
+	// Log logs a message with its fields.
+	Log(level Level, msg string, fields ...Field)
+}
+
+func formatFields(fields []Field) string {
+	var buf bytes.Buffer
+
+	for _, f := range fields {
+		fmt.Fprintf(&buf, " %s=%v", f.Key, f.Value)
+	}
+
+	return buf.String()
+}
+
+// Enabled tells if glog logs messages of the given level.
+func (l glogLog) Enabled(level Level) bool {
+	switch level {
+	case ErrorLevel:
+		return true
+	case WarningLevel:
+		return bool(glog.V(1))
+	case InfoLevel:
+		return bool(glog.V(2))
+	}
+
+	return bool(glog.V(3))
+}
+
+// Log logs a message through glog, followed by its fields as
+// key=value pairs. Debug messages are logged if glog's V >= 3.
+func (l glogLog) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	msg = msg + formatFields(fields)
+
+	switch level {
+	case ErrorLevel:
+		glog.Error("SSNTP Error: " + msg)
+	case WarningLevel:
+		glog.Warning("SSNTP Warning: " + msg)
+	case InfoLevel:
+		glog.Info("SSNTP Info: " + msg)
+	default:
+		glog.Info("SSNTP Debug: " + msg)
+	}
+}
+
+// JSONLogger is an SSNTP logger writing one JSON object per message.
+// Each object contains the message time, level, host name and text,
+// and all its fields.
+type JSONLogger struct {
+	sync.Mutex
+	w     io.Writer
+	level Level
+	host  string
+}
+
+// NewJSONLogger returns a JSONLogger writing messages up to level to w.
+func NewJSONLogger(w io.Writer, level Level) *JSONLogger {
+	host, _ := os.Hostname()
+
+	return &JSONLogger{
+		w:     w,
+		level: level,
+		host:  host,
+	}
+}
+
+// Enabled tells if messages of the given level are logged.
+func (l *JSONLogger) Enabled(level Level) bool {
+	return level <= l.level
+}
+
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}) {
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+
+	k, _ := json.Marshal(key)
+	buf.WriteByte(',')
+	buf.Write(k)
+	buf.WriteByte(':')
+	buf.Write(v)
+}
+
+// Log writes a message and its fields as a JSON object line.
+func (l *JSONLogger) Log(level Level, msg string, fields ...Field) {
+	var buf bytes.Buffer
+
+	if !l.Enabled(level) {
+		return
+	}
+
+	t, _ := json.Marshal(time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`{"time":`)
+	buf.Write(t)
+	writeJSONField(&buf, "level", level.String())
+	writeJSONField(&buf, "host", l.host)
+	writeJSONField(&buf, "msg", msg)
+	for _, f := range fields {
+		writeJSONField(&buf, f.Key, f.Value)
+	}
+	buf.WriteString("}\n")
+
+	l.Lock()
+	l.w.Write(buf.Bytes())
+	l.Unlock()
+}
+
+// Errorf logs an error message.
+func (l *JSONLogger) Errorf(format string, args ...interface{}) {
+	l.Log(ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+// Warningf logs a warning message.
+func (l *JSONLogger) Warningf(format string, args ...interface{}) {
+	l.Log(WarningLevel, fmt.Sprintf(format, args...))
+}
+
+// Infof logs an info message.
+func (l *JSONLogger) Infof(format string, args ...interface{}) {
+	l.Log(InfoLevel, fmt.Sprintf(format, args...))
+}
+
+// legacyLogger logs structured messages through a Logger, with
+// their fields appended to the message as key=value pairs.
+type legacyLogger struct {
+	log Logger
+}
+
+// Enabled tells if messages of the given level are logged.
+// Logger does not support debug messages.
+func (l legacyLogger) Enabled(level Level) bool {
+	return level != DebugLevel
+}
+
+func (l legacyLogger) Log(level Level, msg string, fields ...Field) {
+	msg = msg + formatFields(fields)
+
+	switch level {
+	case ErrorLevel:
+		l.log.Errorf("%s", msg)
+	case WarningLevel:
+		l.log.Warningf("%s", msg)
+	default:
+		l.log.Infof("%s", msg)
+	}
+}
+
+// fieldLogger is the SSNTP internal logger. It attaches its fields
+// to every message it logs.
+type fieldLogger struct {
+	sink   StructuredLogger
+	fields []Field
+}
+
+func newLogger(log Logger) fieldLogger {
+	if sink, ok := log.(StructuredLogger); ok {
+		return fieldLogger{sink: sink}
+	}
+
+	return fieldLogger{sink: legacyLogger{log}}
+}
+
+// with returns a fieldLogger adding fields to the l ones.
+func (l fieldLogger) with(fields ...Field) fieldLogger {
+	all := make([]Field, 0, len(l.fields)+len(fields))
+	all = append(all, l.fields...)
+	all = append(all, fields...)
+
+	return fieldLogger{l.sink, all}
+}
+
+func (l fieldLogger) enabled(level Level) bool {
+	return l.sink != nil && l.sink.Enabled(level)
+}
+
+func (l fieldLogger) logf(level Level, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	l.sink.Log(level, msg, l.fields...)
+}
+
+func (l fieldLogger) Errorf(format string, args ...interface{}) {
+	l.logf(ErrorLevel, format, args...)
+}
+
+func (l fieldLogger) Warningf(format string, args ...interface{}) {
+	l.logf(WarningLevel, format, args...)
+}
+
+func (l fieldLogger) Infof(format string, args ...interface{}) {
+	l.logf(InfoLevel, format, args...)
+}
+
+func (l fieldLogger) Debugf(format string, args ...interface{}) {
+	l.logf(DebugLevel, format, args...)
+}
+
+func localFields(uuid string, role Role) []Field {
+	return []Field{
+		{UUIDField, uuid},
+		{RoleField, (&role).String()},
+	}
+}
+
+func sessionFields(session *session) []Field {
+	return []Field{
+		{PeerUUIDField, session.dest.String()},
+		{PeerRoleField, (&session.destRole).String()},
+	}
+}
+
+func frameFields(frame *Frame) []Field {
+	fields := []Field{
+		{FrameTypeField, frame.Type.String()},
+		{OperandField, operandString(frame.Type, frame.Operand)},
+	}
+
+	if frame.Trace != nil && len(frame.Trace.Label) > 0 {
+		fields = append(fields, Field{TraceLabelField, string(frame.Trace.Label)})
+	}
+
+	return fields
+}
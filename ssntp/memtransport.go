@@ -0,0 +1,203 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// memTransport is the in-process SSNTP transport. SSNTP clients and
+// servers using it must run in the same process, and are connected
+// through net.Pipe connections instead of sockets.
+const memTransport = "mem"
+
+// memConn is an in-process connection that is not TLS protected.
+// Peers connected through such connections are trusted to advertise
+// their actual roles.
+type memConn struct {
+	net.Conn
+}
+
+type memAddr string
+
+func (addr memAddr) Network() string {
+	return memTransport
+}
+
+func (addr memAddr) String() string {
+	return string(addr)
+}
+
+type memListener struct {
+	addr   string
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+var memListeners = struct {
+	sync.Mutex
+	listeners map[string]*memListener
+}{
+	listeners: make(map[string]*memListener),
+}
+
+func memListen(addr string) (*memListener, error) {
+	memListeners.Lock()
+	defer memListeners.Unlock()
+
+	if _, ok := memListeners.listeners[addr]; ok {
+		return nil, fmt.Errorf("%s address %s already in use", memTransport, addr)
+	}
+
+	l := &memListener{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	memListeners.listeners[addr] = l
+
+	return l, nil
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("%s listener %s closed", memTransport, l.addr)
+	}
+}
+
+func (l *memListener) Close() error {
+	l.once.Do(func() {
+		memListeners.Lock()
+		delete(memListeners.listeners, l.addr)
+		memListeners.Unlock()
+
+		close(l.closed)
+	})
+
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return memAddr(l.addr)
+}
+
+// lookupMemListener finds the listener for addr. Listeners with an
+// empty host, i.e. listening on all interfaces, match any host.
+func lookupMemListener(addr string) *memListener {
+	memListeners.Lock()
+	defer memListeners.Unlock()
+
+	l, ok := memListeners.listeners[addr]
+	if ok {
+		return l
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+
+	return memListeners.listeners[":"+port]
+}
+
+func memDial(addr string, config *tls.Config, skipTLS bool) (net.Conn, error) {
+	l := lookupMemListener(addr)
+	if l == nil {
+		return nil, fmt.Errorf("No %s listener on %s", memTransport, addr)
+	}
+
+	client, server := net.Pipe()
+	if skipTLS {
+		client, server = &memConn{client}, &memConn{server}
+	}
+
+	select {
+	case l.conns <- server:
+	case <-l.closed:
+		return nil, fmt.Errorf("%s listener %s closed", memTransport, addr)
+	}
+
+	if skipTLS {
+		return client, nil
+	}
+
+	// Like tls.Dial, verify the server certificate against the
+	// address host name.
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	tlsConn := tls.Client(client, &tls.Config{
+		Certificates: config.Certificates,
+		RootCAs:      config.RootCAs,
+		ServerName:   host,
+	})
+
+	err = tlsConn.Handshake()
+	if err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// listen returns a listener for raw transport connections. The server
+// wraps accepted connections with TLS itself, so that each handshake uses
+// its current TLS configuration.
+func listen(transport string, addr string) (net.Listener, error) {
+	if transport != memTransport {
+		return net.Listen(transport, addr)
+	}
+
+	return memListen(addr)
+}
+
+// useTLS tells if connections over transport are TLS protected.
+func useTLS(transport string, skipTLS bool) bool {
+	return transport != memTransport || !skipTLS
+}
+
+// dial connects to addr, giving up when ctx is done. Both the connection
+// and the TLS handshake must complete before the ctx deadline.
+func dial(ctx context.Context, transport string, addr string, config *tls.Config, skipTLS bool) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if transport != memTransport {
+		dialer := &net.Dialer{Cancel: ctx.Done()}
+		if deadline, ok := ctx.Deadline(); ok {
+			dialer.Deadline = deadline
+		}
+
+		return tls.DialWithDialer(dialer, transport, addr, config)
+	}
+
+	return memDial(addr, config, skipTLS)
+}
@@ -0,0 +1,357 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// writeLatencyBuckets are the upper bounds, in seconds, of the frame
+// write latency histogram buckets.
+var writeLatencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+type frameMetricKey struct {
+	frameType Type
+	operand   uint8
+}
+
+// sessionMetrics are the counters of an SSNTP session.
+type sessionMetrics struct {
+	sync.Mutex
+	framesSent     map[frameMetricKey]uint64
+	framesReceived map[frameMetricKey]uint64
+	bytesSent      uint64
+	bytesReceived  uint64
+	forwards       map[ForwardDecision]uint64
+	encodeErrors   uint64
+	decodeErrors   uint64
+	dropped        uint64
+
+	// latencyBuckets counts the writes per bucket, the last
+	// entry being the +Inf bucket.
+	latencyBuckets []uint64
+	latencySum     float64
+	latencyCount   uint64
+}
+
+func newSessionMetrics() *sessionMetrics {
+	return &sessionMetrics{
+		framesSent:     make(map[frameMetricKey]uint64),
+		framesReceived: make(map[frameMetricKey]uint64),
+		forwards:       make(map[ForwardDecision]uint64),
+		latencyBuckets: make([]uint64, len(writeLatencyBuckets)+1),
+	}
+}
+
+func frameKey(frame interface{}) (frameMetricKey, bool) {
+	switch f := frame.(type) {
+	case *Frame:
+		return frameMetricKey{f.Type, f.Operand}, true
+	case *ConnectFrame:
+		return frameMetricKey{f.Type, f.Operand}, true
+	case *ConnectedFrame:
+		return frameMetricKey{f.Type, f.Operand}, true
+	}
+
+	return frameMetricKey{}, false
+}
+
+func (m *sessionMetrics) frameSent(frame interface{}, latency time.Duration, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if err != nil {
+		m.encodeErrors++
+		return
+	}
+
+	if key, ok := frameKey(frame); ok {
+		m.framesSent[key]++
+	}
+
+	seconds := latency.Seconds()
+	i := sort.SearchFloat64s(writeLatencyBuckets, seconds)
+	m.latencyBuckets[i]++
+	m.latencySum += seconds
+	m.latencyCount++
+}
+
+func (m *sessionMetrics) frameReceived(frame interface{}, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if err != nil {
+		// Connection errors are disconnections, not decoding errors.
+		if _, ok := err.(net.Error); !ok && err != io.EOF {
+			m.decodeErrors++
+		}
+		return
+	}
+
+	if key, ok := frameKey(frame); ok {
+		m.framesReceived[key]++
+	}
+}
+
+func (m *sessionMetrics) framesDropped(n int) {
+	m.Lock()
+	m.dropped += uint64(n)
+	m.Unlock()
+}
+
+func (m *sessionMetrics) forwardDecision(decision ForwardDecision) {
+	m.Lock()
+	m.forwards[decision]++
+	m.Unlock()
+}
+
+func (m *sessionMetrics) addBytesSent(n int) {
+	m.Lock()
+	m.bytesSent += uint64(n)
+	m.Unlock()
+}
+
+func (m *sessionMetrics) addBytesReceived(n int) {
+	m.Lock()
+	m.bytesReceived += uint64(n)
+	m.Unlock()
+}
+
+// meteredWriter counts the bytes written to a session connection.
+type meteredWriter struct {
+	w       io.Writer
+	metrics *sessionMetrics
+}
+
+func (m meteredWriter) Write(p []byte) (int, error) {
+	n, err := m.w.Write(p)
+	m.metrics.addBytesSent(n)
+
+	return n, err
+}
+
+// meteredReader counts the bytes read from a session connection.
+type meteredReader struct {
+	r       io.Reader
+	metrics *sessionMetrics
+}
+
+func (m meteredReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.metrics.addBytesReceived(n)
+
+	return n, err
+}
+
+func (decision ForwardDecision) String() string {
+	switch decision {
+	case Forward:
+		return "forward"
+	case Discard:
+		return "discard"
+	case Queue:
+		return "queue"
+	}
+
+	return fmt.Sprintf("%d", decision)
+}
+
+func escapeLabel(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, "\n", `\n`, -1)
+}
+
+type metricSession struct {
+	labels  string
+	metrics *sessionMetrics
+}
+
+// metricSessions returns the label set and metrics of each session,
+// sorted by peer UUID.
+func metricSessions(sessions []*session) []metricSession {
+	var ms []metricSession
+
+	for _, s := range sessions {
+		labels := fmt.Sprintf(`peer="%s",role="%s"`,
+			escapeLabel(s.dest.String()), escapeLabel(s.destRole.String()))
+		ms = append(ms, metricSession{labels, s.metrics})
+	}
+
+	sort.Sort(metricSessionsByLabels(ms))
+
+	return ms
+}
+
+type metricSessionsByLabels []metricSession
+
+func (ms metricSessionsByLabels) Len() int           { return len(ms) }
+func (ms metricSessionsByLabels) Swap(i, j int)      { ms[i], ms[j] = ms[j], ms[i] }
+func (ms metricSessionsByLabels) Less(i, j int) bool { return ms[i].labels < ms[j].labels }
+
+type frameMetricKeys []frameMetricKey
+
+func (k frameMetricKeys) Len() int      { return len(k) }
+func (k frameMetricKeys) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k frameMetricKeys) Less(i, j int) bool {
+	if k[i].frameType != k[j].frameType {
+		return k[i].frameType < k[j].frameType
+	}
+
+	return k[i].operand < k[j].operand
+}
+
+func writeMetricHeader(w io.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+func writeFrameMetrics(w io.Writer, name string, labels string, frames map[frameMetricKey]uint64) {
+	var keys frameMetricKeys
+
+	for k := range frames {
+		keys = append(keys, k)
+	}
+	sort.Sort(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s,type=\"%s\",operand=\"%s\"} %d\n", name, labels,
+			k.frameType, operandString(k.frameType, k.operand), frames[k])
+	}
+}
+
+func writeSessionMetrics(w io.Writer, ms metricSession, family string) {
+	m := ms.metrics
+
+	switch family {
+	case "ssntp_frames_sent_total":
+		writeFrameMetrics(w, family, ms.labels, m.framesSent)
+	case "ssntp_frames_received_total":
+		writeFrameMetrics(w, family, ms.labels, m.framesReceived)
+	case "ssntp_bytes_sent_total":
+		fmt.Fprintf(w, "%s{%s} %d\n", family, ms.labels, m.bytesSent)
+	case "ssntp_bytes_received_total":
+		fmt.Fprintf(w, "%s{%s} %d\n", family, ms.labels, m.bytesReceived)
+	case "ssntp_forward_decisions_total":
+		for _, d := range []ForwardDecision{Forward, Discard, Queue} {
+			fmt.Fprintf(w, "%s{%s,decision=\"%s\"} %d\n", family, ms.labels, d, m.forwards[d])
+		}
+	case "ssntp_encode_errors_total":
+		fmt.Fprintf(w, "%s{%s} %d\n", family, ms.labels, m.encodeErrors)
+	case "ssntp_decode_errors_total":
+		fmt.Fprintf(w, "%s{%s} %d\n", family, ms.labels, m.decodeErrors)
+	case "ssntp_frames_dropped_total":
+		fmt.Fprintf(w, "%s{%s} %d\n", family, ms.labels, m.dropped)
+	case "ssntp_write_latency_seconds":
+		var cumulative uint64
+		for i, bound := range writeLatencyBuckets {
+			cumulative += m.latencyBuckets[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", family, ms.labels, bound, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", family, ms.labels, m.latencyCount)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", family, ms.labels, m.latencySum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", family, ms.labels, m.latencyCount)
+	}
+}
+
+var metricFamilies = []struct {
+	name       string
+	help       string
+	metricType string
+}{
+	{"ssntp_frames_sent_total", "Number of SSNTP frames sent.", "counter"},
+	{"ssntp_frames_received_total", "Number of SSNTP frames received.", "counter"},
+	{"ssntp_bytes_sent_total", "Number of bytes sent.", "counter"},
+	{"ssntp_bytes_received_total", "Number of bytes received.", "counter"},
+	{"ssntp_forward_decisions_total", "Number of forwarding decisions for the frames received.", "counter"},
+	{"ssntp_encode_errors_total", "Number of SSNTP frames that could not be encoded and sent.", "counter"},
+	{"ssntp_decode_errors_total", "Number of SSNTP frames that could not be received and decoded.", "counter"},
+	{"ssntp_frames_dropped_total", "Number of stale STATS and TraceReport frames dropped before being sent.", "counter"},
+	{"ssntp_write_latency_seconds", "SSNTP frames write latency.", "histogram"},
+}
+
+// writeMetrics writes the metrics of all sessions in the Prometheus
+// text exposition format.
+func writeMetrics(w io.Writer, sessions []*session) {
+	ms := metricSessions(sessions)
+
+	writeMetricHeader(w, "ssntp_sessions", "Number of established SSNTP sessions.", "gauge")
+	fmt.Fprintf(w, "ssntp_sessions %d\n", len(ms))
+
+	for _, family := range metricFamilies {
+		writeMetricHeader(w, family.name, family.help, family.metricType)
+
+		for _, s := range ms {
+			s.metrics.Lock()
+			writeSessionMetrics(w, s, family.name)
+			s.metrics.Unlock()
+		}
+	}
+}
+
+// metricsHandler serves the metrics of the sessions returned by
+// sessions in the Prometheus text exposition format.
+type metricsHandler func() []*session
+
+func (h metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	buf := bufio.NewWriter(w)
+	writeMetrics(buf, h())
+	buf.Flush()
+}
+
+// MetricsHandler returns an HTTP handler exposing the server sessions
+// metrics in the Prometheus text format. The metrics of a session are
+// dropped when the client disconnects.
+func (server *Server) MetricsHandler() http.Handler {
+	return metricsHandler(func() []*session {
+		var sessions []*session
+
+		server.sessionMutex.RLock()
+		for _, s := range server.sessions {
+			sessions = append(sessions, s)
+		}
+		server.sessionMutex.RUnlock()
+
+		return sessions
+	})
+}
+
+// MetricsHandler returns an HTTP handler exposing the client session
+// metrics in the Prometheus text format. The session metrics are reset
+// every time the client reconnects.
+func (client *Client) MetricsHandler() http.Handler {
+	return metricsHandler(func() []*session {
+		client.status.Lock()
+		defer client.status.Unlock()
+
+		if client.status.status != ssntpConnected || client.session == nil {
+			return nil
+		}
+
+		return []*session{client.session}
+	})
+}
@@ -0,0 +1,236 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// revocationList is the set of revoked SSNTP peer certificates serial
+// numbers. It is built from a certificate revocation list signed by the
+// SSNTP CA, and from a local deny-list of certificate serial numbers.
+type revocationList struct {
+	sync.RWMutex
+	caPath       string
+	crlPath      string
+	denyListPath string
+	serials      map[string]bool
+}
+
+func newRevocationList(config *Config) (*revocationList, error) {
+	if config.CRL == "" && config.DenyList == "" {
+		return nil, nil
+	}
+
+	r := &revocationList{
+		caPath:       config.CAcert,
+		crlPath:      config.CRL,
+		denyListPath: config.DenyList,
+	}
+
+	err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func loadCACertificates(path string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	caPEM, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var block *pem.Block
+
+		block, caPEM = pem.Decode(caPEM)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
+
+// loadCRL returns the serial numbers of all the certificates revoked by
+// a PEM or DER encoded CRL. The CRL must be signed by one of the CAs.
+func loadCRL(crlPath string, caPath string) ([]*big.Int, error) {
+	crlBytes, err := ioutil.ReadFile(crlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	crl, err := x509.ParseCRL(crlBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse CRL %s: %s", crlPath, err)
+	}
+
+	cas, err := loadCACertificates(caPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ca := range cas {
+		if ca.CheckCRLSignature(crl) != nil {
+			continue
+		}
+
+		var serials []*big.Int
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			serials = append(serials, revoked.SerialNumber)
+		}
+
+		return serials, nil
+	}
+
+	return nil, fmt.Errorf("CRL %s is not signed by the SSNTP CA", crlPath)
+}
+
+func parseSerial(s string) (*big.Int, bool) {
+	base := 10
+
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s = s[2:]
+		base = 16
+	} else if strings.Contains(s, ":") {
+		s = strings.Replace(s, ":", "", -1)
+		base = 16
+	}
+
+	return new(big.Int).SetString(s, base)
+}
+
+// loadDenyList returns the serial numbers listed in a deny-list file.
+// Serial numbers are listed one per line, either in decimal, in
+// hexadecimal with a 0x prefix, or as colon separated hexadecimal bytes.
+// Empty lines and lines starting with # are ignored.
+func loadDenyList(path string) ([]*big.Int, error) {
+	var serials []*big.Int
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		serial, ok := parseSerial(text)
+		if !ok {
+			return nil, fmt.Errorf("Invalid serial number %s at %s:%d", text, path, line)
+		}
+
+		serials = append(serials, serial)
+	}
+
+	return serials, scanner.Err()
+}
+
+// load (re)loads the CRL and the deny-list. The current list is
+// left untouched if any of them can not be loaded.
+func (r *revocationList) load() error {
+	var serials []*big.Int
+
+	if r.crlPath != "" {
+		crlSerials, err := loadCRL(r.crlPath, r.caPath)
+		if err != nil {
+			return err
+		}
+
+		serials = append(serials, crlSerials...)
+	}
+
+	if r.denyListPath != "" {
+		denied, err := loadDenyList(r.denyListPath)
+		if err != nil {
+			return err
+		}
+
+		serials = append(serials, denied...)
+	}
+
+	revoked := make(map[string]bool)
+	for _, serial := range serials {
+		revoked[serial.String()] = true
+	}
+
+	r.Lock()
+	r.serials = revoked
+	r.Unlock()
+
+	return nil
+}
+
+// check returns an error if the peer certificate of a TLS connection
+// has been revoked.
+func (r *revocationList) check(conn net.Conn) error {
+	if r == nil {
+		return nil
+	}
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+
+	serial := state.PeerCertificates[0].SerialNumber
+
+	r.RLock()
+	revoked := r.serials[serial.String()]
+	r.RUnlock()
+
+	if revoked {
+		return fmt.Errorf("Peer certificate %s has been revoked", serial)
+	}
+
+	return nil
+}
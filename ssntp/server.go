@@ -17,6 +17,7 @@
 package ssntp
 
 import (
+	"bufio"
 	"crypto/tls"
 	"encoding/gob"
 	"fmt"
@@ -25,7 +26,9 @@ import (
 	"time"
 
 	"github.com/01org/ciao/configuration"
+	"github.com/01org/ciao/payloads"
 	"github.com/docker/distribution/uuid"
+	"golang.org/x/net/context"
 )
 
 // ServerNotifier is the SSNTP server notification interface.
@@ -62,8 +65,9 @@ type ServerNotifier interface {
 // its public methods.
 type Server struct {
 	uuid          uuid.UUID
-	lUUID         lockedUUID
-	tls           *tls.Config
+	uuidProvider  UUIDProvider
+	certs         *certificates
+	useTLS        bool
 	ntf           ServerNotifier
 	sessionMutex  sync.RWMutex
 	sessions      map[string]*session
@@ -77,18 +81,33 @@ type Server struct {
 
 	forwardRules frameForward
 
-	log Logger
+	log fieldLogger
 
 	trace *TraceConfig
 
 	configuration clusterConfiguration
+
+	capabilities         []Capability
+	requiredCapabilities []Capability
+	codecs               []Codec
+	keepalive            keepaliveConfig
+	capture              *capture
+	revocations          *revocationList
+	authorization        authorization
+	groups               serverGroups
+	limits               limitsConfig
+	bulkQueueSize        int
+	compressionThreshold int
+	handshakes           *handshakeLimiter
+
+	correlator correlator
 }
 
-func sendConnectionFailure(conn net.Conn) *session {
+func sendConnectionFailure(conn net.Conn, payload []byte) *session {
 	var session session
 	encoder := gob.NewEncoder(conn)
 
-	frame := session.errorFrame(ConnectionFailure, nil, nil)
+	frame := session.errorFrame(ConnectionFailure, payload, nil)
 	encoder.Encode(frame)
 
 	return nil
@@ -104,18 +123,32 @@ func sendConnectionAborted(conn net.Conn) *session {
 	return nil
 }
 
+func sendFrameTooLarge(conn net.Conn) *session {
+	var session session
+	encoder := gob.NewEncoder(conn)
+
+	frame := session.errorFrame(FrameTooLarge, nil, nil)
+	encoder.Encode(frame)
+
+	return nil
+}
+
 func handleClientConnect(server *Server, conn net.Conn) *session {
 	var connect ConnectFrame
 
-	decoder := gob.NewDecoder(conn)
+	reader := &frameReader{r: bufio.NewReader(conn), max: server.limits.maxFrameSize}
+	decoder := gob.NewDecoder(reader)
 
 	server.log.Infof("Waiting for CONNECT\n")
-	setReadTimeout(conn)
+	conn.SetReadDeadline(time.Now().Add(server.limits.handshakeTimeout))
 	readErr := decoder.Decode(&connect)
 	clearReadTimeout(conn)
-	if readErr != nil {
+	if reader.tooLarge {
+		server.log.Errorf("Connect error: %s\n", errFrameTooLarge)
+		return sendFrameTooLarge(conn)
+	} else if readErr != nil {
 		server.log.Errorf("Connect error: %s\n", readErr)
-		return sendConnectionFailure(conn)
+		return sendConnectionFailure(conn, nil)
 	}
 
 	server.log.Infof("Received CONNECT frame:\n%s\n", connect)
@@ -127,26 +160,56 @@ func handleClientConnect(server *Server, conn net.Conn) *session {
 			server.log.Errorf("%s\n", err)
 			return sendConnectionAborted(conn)
 		}
+
+		err = server.revocations.check(tlscon)
+		if err != nil {
+			server.log.Errorf("%s\n", err)
+			return sendConnectionAborted(conn)
+		}
 	}
 
 	if connect.Type != COMMAND || connect.Operand != (uint8)(CONNECT) {
 		server.log.Errorf("Invalid Connect frame")
-		return sendConnectionFailure(conn)
+		return sendConnectionFailure(conn, connectionFailurePayload(payloads.ConnectionInvalidFrame, nil))
+	}
+
+	err := checkVersion(connect.Major)
+	if err != nil {
+		server.log.Errorf("%s\n", err)
+		return sendConnectionFailure(conn, connectionFailurePayload(payloads.IncompatibleVersion, nil))
+	}
+
+	capabilities := negotiateCapabilities(server.capabilities, connect.Capabilities)
+	missing := missingCapabilities(server.requiredCapabilities, capabilities)
+	if missing != nil {
+		server.log.Errorf("Client is missing required capabilities %v\n", missing)
+		return sendConnectionFailure(conn, connectionFailurePayload(payloads.MissingCapabilities, missing))
 	}
 
 	session := newSession(&server.uuid, server.role, connect.Role, conn)
 	session.setDest(connect.Source[:16])
+	session.log = server.log.with(sessionFields(session)...)
+	session.capabilities = capabilities
+	session.capture = server.capture
+	session.setLimits(server.limits)
+	session.compressionThreshold = server.compressionThreshold
 
 	/* TODO Get the CONFIGURE payload from the config package */
 	server.configuration.RLock()
 	connected := session.connectedFrame(server.role, server.configuration.configuration)
 	server.configuration.RUnlock()
 
-	server.log.Infof("Sending CONNECTED\n")
+	session.log.Infof("Sending CONNECTED\n")
 	_, writeErr := session.Write(connected)
 	if writeErr != nil {
-		server.log.Errorf("Connected error: %s\n", writeErr)
-		return sendConnectionFailure(conn)
+		session.log.Errorf("Connected error: %s\n", writeErr)
+		return sendConnectionFailure(conn, nil)
+	}
+
+	codec := selectCodec(session.capabilities, server.codecs)
+	if codec != nil {
+		session.log.Infof("Switching to %s codec\n", codec.Name())
+		session.setCodec(codec)
 	}
 
 	return session
@@ -157,11 +220,24 @@ func handleSSNTPClient(server *Server, conn net.Conn) {
 	defer conn.Close()
 
 	server.log.Infof("New client connection\n")
+	host := remoteHost(conn)
+	if !server.handshakes.start(host) {
+		server.log.Errorf("Too many concurrent handshakes from %s\n", host)
+		return
+	}
+
 	session := handleClientConnect(server, conn)
+	server.handshakes.done(host)
 	if session == nil {
 		return
 	}
 
+	session.startLanes(server.bulkQueueSize)
+	defer session.stopLanes()
+
+	session.startKeepalive(server.keepalive)
+	defer session.stopKeepalive()
+
 	uuidString := session.dest.String()
 	server.addSession(session, uuidString)
 	server.forwardRules.addForwardDestination(session)
@@ -170,14 +246,30 @@ func handleSSNTPClient(server *Server, conn net.Conn) {
 	for {
 		var frame Frame
 		err := session.Read(&frame)
+		if err == errFrameTooLarge {
+			session.log.Errorf("Oversized frame from %s (%s)\n", uuidString, session.destRole.String())
+			session.Write(session.errorFrame(FrameTooLarge, nil, nil))
+		}
+
 		if err != nil {
-			server.log.Infof("Client disconnection: %s %d\n", err)
+			session.log.Infof("Client disconnection: %s\n", err)
 			server.ntf.DisconnectNotify(uuidString, session.destRole)
 			server.forwardRules.deleteForwardDestination(session)
-			server.removeSession(uuidString)
+			server.removeSession(session, uuidString)
 			break
 		}
 
+		if session.handleKeepalive(&frame) {
+			continue
+		}
+
+		if !server.authorization.allowed(session.destRole, &frame) {
+			session.log.with(frameFields(&frame)...).Errorf("Unauthorized %s %s frame from %s (%s)\n", frame.Type,
+				operandString(frame.Type, frame.Operand), uuidString, session.destRole.String())
+			server.SendErrorReply(uuidString, &frame, InvalidFrameType, nil)
+			continue
+		}
+
 		switch frame.Type {
 		case COMMAND:
 			if (Command)(frame.Operand) == CONFIGURE && session.destRole.IsController() {
@@ -187,12 +279,14 @@ func handleSSNTPClient(server *Server, conn net.Conn) {
 			server.forwardRules.forwardFrame(server, session, (Command)(frame.Operand), &frame)
 			server.ntf.CommandNotify(uuidString, (Command)(frame.Operand), &frame)
 		case STATUS:
+			server.correlator.deliver(&frame)
 			server.forwardRules.forwardFrame(server, session, (Status)(frame.Operand), &frame)
 			server.ntf.StatusNotify(uuidString, (Status)(frame.Operand), &frame)
 		case EVENT:
 			server.forwardRules.forwardFrame(server, session, (Event)(frame.Operand), &frame)
 			server.ntf.EventNotify(uuidString, (Event)(frame.Operand), &frame)
 		case ERROR:
+			server.correlator.deliver(&frame)
 			server.forwardRules.forwardFrame(server, session, (Error)(frame.Operand), &frame)
 			server.ntf.ErrorNotify(uuidString, (Error)(frame.Operand), &frame)
 		default:
@@ -210,9 +304,13 @@ func (server *Server) addSession(session *session, uuid string) {
 	server.sessionMutex.Unlock()
 }
 
-func (server *Server) removeSession(uuid string) {
+func (server *Server) removeSession(session *session, uuid string) {
 	server.sessionMutex.Lock()
-	delete(server.sessions, uuid)
+	// A reconnecting client may have already replaced its
+	// previous session with a new one.
+	if server.sessions[uuid] == session {
+		delete(server.sessions, uuid)
+	}
 	server.sessionMutex.Unlock()
 }
 
@@ -228,6 +326,12 @@ func (server *Server) getSession(uuid string) *session {
 // connections. Notifiers will be called when new clients connect and
 // disconnect. And also when statuses, payloads and errors are received.
 func (server *Server) Serve(config *Config, ntf ServerNotifier) error {
+	return server.ServeContext(context.Background(), config, ntf)
+}
+
+// ServeContext is like Serve, but stops the server when ctx is done.
+// It then returns ctx.Err().
+func (server *Server) ServeContext(ctx context.Context, config *Config, ntf ServerNotifier) error {
 	var uri string
 	var serverPort uint32
 
@@ -245,7 +349,8 @@ func (server *Server) Serve(config *Config, ntf ServerNotifier) error {
 	}
 	server.role = role
 
-	server.lUUID, server.uuid = config.configUUID(server.role)
+	server.uuidProvider, server.uuid = config.configUUID(server.role)
+	server.log = server.log.with(localFields(server.uuid.String(), server.role)...)
 	serverPort = config.port()
 	transport := config.transport()
 	uri = config.URI
@@ -258,16 +363,40 @@ func (server *Server) Serve(config *Config, ntf ServerNotifier) error {
 		server.configuration.setConfiguration(payload)
 	}
 
+	server.revocations, err = newRevocationList(config)
+	if err != nil {
+		server.log.Errorf("Could not load revocation list: %s", err)
+		config.pushToSyncChannel(err)
+		return err
+	}
+
+	server.authorization, err = newAuthorization(config.Authorization)
+	if err != nil {
+		server.log.Errorf("Invalid authorization policy: %s", err)
+		config.pushToSyncChannel(err)
+		return err
+	}
+
 	server.ntf = ntf
 	server.sessions = make(map[string]*session)
 	server.forwardRules.init(config.ForwardRules)
-	server.tls = prepareTLSConfig(config, true)
+	server.certs = newCertificates(config, server.role, true)
+	server.useTLS = useTLS(transport, config.SkipTLS)
 	server.forwardRules.forwardRules = config.ForwardRules
 	server.trace = config.Trace
+	server.capabilities = config.capabilities()
+	server.codecs = config.Codecs
+	server.keepalive = config.keepalive()
+	server.capture = newCapture(config.Capture, server.log)
+	server.requiredCapabilities = config.RequiredCapabilities
+	server.limits = config.limits()
+	server.bulkQueueSize = config.bulkQueueSize()
+	server.compressionThreshold = config.CompressionThreshold
+	server.handshakes = newHandshakeLimiter(server.limits.maxHandshakesPerIP)
 	server.stoppedChan = make(chan struct{})
 
 	service := fmt.Sprintf("%s:%d", uri, serverPort)
-	listener, err := tls.Listen(transport, service, server.tls)
+	listener, err := listen(transport, service)
 	if err != nil {
 		server.log.Errorf("Failed to start listener (err=%s) on %s\n", err, service)
 		config.pushToSyncChannel(err)
@@ -282,6 +411,17 @@ func (server *Server) Serve(config *Config, ntf ServerNotifier) error {
 
 	config.pushToSyncChannel(nil)
 
+	if ctx.Done() != nil {
+		stoppedChan := server.stoppedChan
+		go func() {
+			select {
+			case <-ctx.Done():
+				server.Stop()
+			case <-stoppedChan:
+			}
+		}()
+	}
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -294,6 +434,10 @@ func (server *Server) Serve(config *Config, ntf ServerNotifier) error {
 			continue
 		}
 
+		if server.useTLS {
+			conn = tls.Server(conn, server.certs.tlsConfig())
+		}
+
 		server.clientWg.Add(1)
 		go handleSSNTPClient(server, conn)
 	}
@@ -302,7 +446,7 @@ func (server *Server) Serve(config *Config, ntf ServerNotifier) error {
 		close(server.stoppedChan)
 	}
 
-	return nil
+	return ctx.Err()
 }
 
 // ServeThreadSync is a helper that start Serve() in a
@@ -325,6 +469,52 @@ func (server *Server) ServeThreadSync(config *Config, ntf ServerNotifier) error
 	}
 }
 
+// ReloadCertificates reloads the server CA and certificate files.
+// New client connections are authenticated with the reloaded certificates,
+// while the established ones are kept until the clients reconnect.
+// If the certificates can not be loaded, the current ones are kept.
+func (server *Server) ReloadCertificates() error {
+	if server.certs == nil {
+		return fmt.Errorf("SSNTP server not started")
+	}
+
+	return server.certs.reload()
+}
+
+// ReloadRevocationList reloads the server CRL and certificate deny-list.
+// Clients whose certificate has been revoked get a ConnectionAborted
+// error, and their connection is closed.
+// If any of the lists can not be loaded, the current one is kept.
+func (server *Server) ReloadRevocationList() error {
+	if server.revocations == nil {
+		return nil
+	}
+
+	err := server.revocations.load()
+	if err != nil {
+		return err
+	}
+
+	var revoked []*session
+
+	server.sessionMutex.RLock()
+	for uuid, session := range server.sessions {
+		err := server.revocations.check(session.conn)
+		if err != nil {
+			server.log.Errorf("Dropping %s: %s\n", uuid, err)
+			revoked = append(revoked, session)
+		}
+	}
+	server.sessionMutex.RUnlock()
+
+	for _, session := range revoked {
+		session.Write(session.errorFrame(ConnectionAborted, nil, nil))
+		session.conn.Close()
+	}
+
+	return nil
+}
+
 // Stop terminates the server listening operation
 // and closes all client connections.
 func (server *Server) Stop() {
@@ -354,99 +544,178 @@ func (server *Server) Stop() {
 		server.log.Errorf("Timeout waiting for main server thread\n")
 	}
 
-	freeUUID(server.lUUID)
+	if server.uuidProvider != nil {
+		server.uuidProvider.Release(server.uuid.String())
+	}
 }
 
-func (server *Server) sendCommand(uuid string, cmd Command, payload []byte, trace *TraceConfig) (int, error) {
+func (server *Server) sendCommand(ctx context.Context, uuid string, cmd Command, payload []byte, trace *TraceConfig) (int, error) {
 	session := server.getSession(uuid)
 	if session == nil {
 		return -1, fmt.Errorf("Unknown UUID %s", uuid)
 	}
 
 	frame := session.commandFrame(cmd, payload, trace)
-	return session.Write(frame)
+	return session.WriteContext(ctx, frame)
 }
 
-func (server *Server) sendStatus(uuid string, status Status, payload []byte, trace *TraceConfig) (int, error) {
+func (server *Server) sendStatus(ctx context.Context, uuid string, status Status, payload []byte, trace *TraceConfig) (int, error) {
 	session := server.getSession(uuid)
 	if session == nil {
 		return -1, fmt.Errorf("Unknown UUID %s", uuid)
 	}
 
 	frame := session.statusFrame(status, payload, trace)
-	return session.Write(frame)
+	return session.WriteContext(ctx, frame)
 }
 
-func (server *Server) sendEvent(uuid string, event Event, payload []byte, trace *TraceConfig) (int, error) {
+func (server *Server) sendEvent(ctx context.Context, uuid string, event Event, payload []byte, trace *TraceConfig) (int, error) {
 	session := server.getSession(uuid)
 	if session == nil {
 		return -1, fmt.Errorf("Unknown UUID %s", uuid)
 	}
 
 	frame := session.eventFrame(event, payload, trace)
-	return session.Write(frame)
+	return session.WriteContext(ctx, frame)
 }
 
-func (server *Server) sendError(uuid string, error Error, payload []byte, trace *TraceConfig) (int, error) {
+func (server *Server) sendError(ctx context.Context, uuid string, error Error, payload []byte, trace *TraceConfig) (int, error) {
 	session := server.getSession(uuid)
 	if session == nil {
 		return -1, fmt.Errorf("Unknown UUID %s", uuid)
 	}
 
 	frame := session.errorFrame(error, payload, trace)
-	return session.Write(frame)
+	return session.WriteContext(ctx, frame)
+}
+
+func (server *Server) sendCorrelated(ctx context.Context, uuid string, frameType Type, operand uint8, payload []byte, correlationID uint64) (int, error) {
+	session := server.getSession(uuid)
+	if session == nil {
+		return -1, fmt.Errorf("Unknown UUID %s", uuid)
+	}
+
+	f := outboundFrame{frameType, operand, payload, server.trace, correlationID}
+	return session.WriteContext(ctx, f.build(session))
 }
 
 // SendCommand sends a specific command and its payload to a client.
 // The client is specified by its uuid
 func (server *Server) SendCommand(uuid string, cmd Command, payload []byte) (int, error) {
-	return server.sendCommand(uuid, cmd, payload, server.trace)
+	return server.sendCommand(context.Background(), uuid, cmd, payload, server.trace)
 }
 
 // SendStatus sends a specific status and its payload to a client.
 // The client is specified by its uuid
 func (server *Server) SendStatus(uuid string, status Status, payload []byte) (int, error) {
-	return server.sendStatus(uuid, status, payload, server.trace)
+	return server.sendStatus(context.Background(), uuid, status, payload, server.trace)
 }
 
 // SendEvent sends a specific status and its payload to a client.
 // The client is specified by its uuid
 func (server *Server) SendEvent(uuid string, event Event, payload []byte) (int, error) {
-	return server.sendEvent(uuid, event, payload, server.trace)
+	return server.sendEvent(context.Background(), uuid, event, payload, server.trace)
 }
 
 // SendError sends an error back to a client.
 // The client is specified by its uuid
 func (server *Server) SendError(uuid string, error Error, payload []byte) (int, error) {
-	return server.sendError(uuid, error, payload, server.trace)
+	return server.sendError(context.Background(), uuid, error, payload, server.trace)
+}
+
+// SendCommandContext is like SendCommand, but gives up if ctx is done
+// before the frame is written. The write must complete before the ctx deadline.
+func (server *Server) SendCommandContext(ctx context.Context, uuid string, cmd Command, payload []byte) (int, error) {
+	return server.sendCommand(ctx, uuid, cmd, payload, server.trace)
+}
+
+// SendStatusContext is like SendStatus, but gives up if ctx is done
+// before the frame is written. The write must complete before the ctx deadline.
+func (server *Server) SendStatusContext(ctx context.Context, uuid string, status Status, payload []byte) (int, error) {
+	return server.sendStatus(ctx, uuid, status, payload, server.trace)
+}
+
+// SendEventContext is like SendEvent, but gives up if ctx is done
+// before the frame is written. The write must complete before the ctx deadline.
+func (server *Server) SendEventContext(ctx context.Context, uuid string, event Event, payload []byte) (int, error) {
+	return server.sendEvent(ctx, uuid, event, payload, server.trace)
+}
+
+// SendErrorContext is like SendError, but gives up if ctx is done
+// before the frame is written. The write must complete before the ctx deadline.
+func (server *Server) SendErrorContext(ctx context.Context, uuid string, error Error, payload []byte) (int, error) {
+	return server.sendError(ctx, uuid, error, payload, server.trace)
 }
 
 // SendTracedCommand sends a specific command and its payload to a client.
 // The SSNTP command frame will be traced according to the trace argument.
 // The client is specified by its uuid
 func (server *Server) SendTracedCommand(uuid string, cmd Command, payload []byte, trace *TraceConfig) (int, error) {
-	return server.sendCommand(uuid, cmd, payload, trace)
+	return server.sendCommand(context.Background(), uuid, cmd, payload, trace)
 }
 
 // SendTracedStatus sends a specific status and its payload to a client.
 // The SSNTP status frame will be traced according to the trace argument.
 // The client is specified by its uuid
 func (server *Server) SendTracedStatus(uuid string, status Status, payload []byte, trace *TraceConfig) (int, error) {
-	return server.sendStatus(uuid, status, payload, trace)
+	return server.sendStatus(context.Background(), uuid, status, payload, trace)
 }
 
 // SendTracedEvent sends a specific event and its payload to a client.
 // The SSNTP event frame will be traced according to the trace argument.
 // The client is specified by its uuid
 func (server *Server) SendTracedEvent(uuid string, event Event, payload []byte, trace *TraceConfig) (int, error) {
-	return server.sendEvent(uuid, event, payload, trace)
+	return server.sendEvent(context.Background(), uuid, event, payload, trace)
 }
 
 // SendTracedError sends an error back to a client.
 // The SSNTP error frame will be traced according to the trace argument.
 // The client is specified by its uuid
 func (server *Server) SendTracedError(uuid string, error Error, payload []byte, trace *TraceConfig) (int, error) {
-	return server.sendError(uuid, error, payload, trace)
+	return server.sendError(context.Background(), uuid, error, payload, trace)
+}
+
+// SendCommandAndWait sends a specific command and its payload to a client,
+// and waits for the STATUS or ERROR frame replying to it.
+// It returns the reply frame, or ctx.Err() if ctx is done before the reply
+// comes in. The reply frame is also notified through the ServerNotifier
+// interface.
+// The client is specified by its uuid, and must support the
+// CorrelationCapability.
+func (server *Server) SendCommandAndWait(ctx context.Context, uuid string, cmd Command, payload []byte) (*Frame, error) {
+	capabilities, err := server.ClientCapabilities(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkCorrelation(capabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	id, reply := server.correlator.register()
+
+	_, err = server.sendCorrelated(ctx, uuid, COMMAND, (uint8)(cmd), payload, id)
+	if err != nil {
+		server.correlator.unregister(id)
+		return nil, err
+	}
+
+	return server.correlator.wait(ctx, id, reply)
+}
+
+// SendStatusReply sends a specific status and its payload to a client,
+// as a reply to the request frame.
+// The client is specified by its uuid
+func (server *Server) SendStatusReply(uuid string, request *Frame, status Status, payload []byte) (int, error) {
+	return server.sendCorrelated(context.Background(), uuid, STATUS, (uint8)(status), payload, request.CorrelationID)
+}
+
+// SendErrorReply sends an error back to a client, as a reply to the request
+// frame.
+// The client is specified by its uuid
+func (server *Server) SendErrorReply(uuid string, request *Frame, error Error, payload []byte) (int, error) {
+	return server.sendCorrelated(context.Background(), uuid, ERROR, (uint8)(error), payload, request.CorrelationID)
 }
 
 // UUID exports the SSNTP server Universally Unique ID.
@@ -464,3 +733,15 @@ func (server *Server) ClientRole(uuid string) (Role, error) {
 	}
 	return session.destRole, nil
 }
+
+// ClientCapabilities returns the SSNTP capabilities negotiated with the
+// ssntp session peer with the specified uuid.
+func (server *Server) ClientCapabilities(uuid string) ([]Capability, error) {
+	server.sessionMutex.RLock()
+	session := server.sessions[uuid]
+	defer server.sessionMutex.RUnlock()
+	if session == nil {
+		return nil, fmt.Errorf("SSNTP session missing for uuid %s", uuid)
+	}
+	return session.capabilities, nil
+}
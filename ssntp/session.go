@@ -17,23 +17,30 @@
 package ssntp
 
 import (
+	"bufio"
 	"encoding/gob"
+	"io"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/docker/distribution/uuid"
+	"golang.org/x/net/context"
 )
 
-func setReadTimeout(conn net.Conn) {
-	conn.SetReadDeadline(time.Now().Add(readTimeout * time.Second))
-}
-
 func clearReadTimeout(conn net.Conn) {
 	conn.SetReadDeadline(time.Time{})
 }
 
-func setWriteTimeout(conn net.Conn) {
-	conn.SetWriteDeadline(time.Now().Add(readTimeout * time.Second))
+// setWriteTimeout bounds a write by the default write timeout,
+// or by the ctx deadline if it comes first.
+func setWriteTimeout(ctx context.Context, conn net.Conn) {
+	deadline := time.Now().Add(readTimeout * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	conn.SetWriteDeadline(deadline)
 }
 
 func clearWriteTimeout(conn net.Conn) {
@@ -47,8 +54,45 @@ type session struct {
 	destRole Role
 	conn     net.Conn
 
-	encoder *gob.Encoder
-	decoder *gob.Decoder
+	// capabilities is the list of SSNTP capabilities
+	// negotiated for this session.
+	capabilities []Capability
+
+	// reader buffers the connection reads, so that switching codecs
+	// does not lose any data already read from the connection.
+	reader *bufio.Reader
+
+	// frames limits the size of the frames decoders read from reader.
+	frames *frameReader
+
+	// maxPayloadSize is the maximum received frames payload length,
+	// or 0 for no limit.
+	maxPayloadSize int
+
+	// compressionThreshold is the minimum length of the sent frames
+	// payloads to compress, or 0 to never compress them.
+	compressionThreshold int
+
+	encoder Encoder
+	decoder Decoder
+
+	// keepaliveTimeout is the maximum time to wait for the
+	// next frame from the peer, if keepalive is enabled.
+	keepaliveTimeout time.Duration
+	keepaliveStop    chan struct{}
+	keepaliveOnce    sync.Once
+
+	// lanes are the session outbound lanes. Frames are written
+	// directly to the connection until they are started.
+	lanes *outboundLanes
+
+	// capture records the session frames, if not nil.
+	capture *capture
+
+	// log logs the session messages, with the session fields.
+	log fieldLogger
+
+	metrics *sessionMetrics
 }
 
 /*
@@ -65,8 +109,11 @@ func newSession(src *uuid.UUID, srcRole Role, destRole Role, netConn net.Conn) *
 	session.destRole = destRole
 
 	session.conn = netConn
-	session.encoder = gob.NewEncoder(netConn)
-	session.decoder = gob.NewDecoder(netConn)
+	session.metrics = newSessionMetrics()
+	session.reader = bufio.NewReader(meteredReader{netConn, session.metrics})
+	session.frames = &frameReader{r: session.reader}
+	session.encoder = gob.NewEncoder(session.writer())
+	session.decoder = gob.NewDecoder(session.frames)
 
 	return &session
 }
@@ -75,6 +122,40 @@ func (session *session) setDest(uuid []byte) {
 	copy(session.dest[:], uuid[:16])
 }
 
+// setCodec switches the session to a new codec. This must only be called
+// once the connection handshake is done.
+func (session *session) setCodec(codec Codec) {
+	session.encoder = codec.NewEncoder(session.writer())
+	session.decoder = codec.NewDecoder(session.frames)
+	session.setDecoderLimit()
+}
+
+// setLimits applies the frame and payload size limits to the frames
+// read from the session.
+func (session *session) setLimits(limits limitsConfig) {
+	session.frames.max = limits.maxFrameSize
+	session.maxPayloadSize = limits.maxPayloadSize
+	session.setDecoderLimit()
+}
+
+// setDecoderLimit passes the maximum frame size to decoders that
+// can check it before reading a frame.
+func (session *session) setDecoderLimit() {
+	if d, ok := session.decoder.(frameSizeLimiter); ok {
+		d.setMaxFrameSize(session.frames.max)
+	}
+}
+
+// writer returns the session connection writer, counting the bytes
+// sent for the session metrics.
+func (session *session) writer() io.Writer {
+	return meteredWriter{session.conn, session.metrics}
+}
+
+func (session *session) hasCapability(c Capability) bool {
+	return hasCapability(session.capabilities, c)
+}
+
 func (session *session) connectedFrame(serverRole Role, payload []byte) (f *ConnectedFrame) {
 	f = &ConnectedFrame{
 		Major:         major,
@@ -86,20 +167,22 @@ func (session *session) connectedFrame(serverRole Role, payload []byte) (f *Conn
 		Destination:   session.dest[:],
 		PayloadLength: (uint32)(len(payload)),
 		Payload:       payload,
+		Capabilities:  session.capabilities,
 	}
 
 	return
 }
 
-func (session *session) connectFrame() (f *ConnectFrame) {
+func (session *session) connectFrame(capabilities []Capability) (f *ConnectFrame) {
 	f = &ConnectFrame{
-		Major:       major,
-		Minor:       minor,
-		Type:        COMMAND,
-		Operand:     byte(CONNECT),
-		Role:        session.srcRole,
-		Source:      session.src[:],
-		Destination: session.dest[:],
+		Major:        major,
+		Minor:        minor,
+		Type:         COMMAND,
+		Operand:      byte(CONNECT),
+		Role:         session.srcRole,
+		Source:       session.src[:],
+		Destination:  session.dest[:],
+		Capabilities: capabilities,
 	}
 
 	return
@@ -173,7 +256,55 @@ func (session *session) errorFrame(error Error, payload []byte, trace *TraceConf
 	return
 }
 
+// outboundFrame describes a frame to be sent. The actual frame is
+// only built when sending it, as e.g. the server a client sends it to
+// may change while the client is reconnecting.
+type outboundFrame struct {
+	frameType     Type
+	operand       uint8
+	payload       []byte
+	trace         *TraceConfig
+	correlationID uint64
+}
+
+func (f *outboundFrame) build(session *session) *Frame {
+	var frame *Frame
+
+	switch f.frameType {
+	case COMMAND:
+		frame = session.commandFrame((Command)(f.operand), f.payload, f.trace)
+	case STATUS:
+		frame = session.statusFrame((Status)(f.operand), f.payload, f.trace)
+	case EVENT:
+		frame = session.eventFrame((Event)(f.operand), f.payload, f.trace)
+	default:
+		frame = session.errorFrame((Error)(f.operand), f.payload, f.trace)
+	}
+
+	frame.CorrelationID = f.correlationID
+
+	return frame
+}
+
 func (session *session) Write(frame interface{}) (int, error) {
+	return session.WriteContext(context.Background(), frame)
+}
+
+// WriteContext writes a frame, unless ctx is already done.
+// The write fails if it does not complete before the ctx deadline.
+func (session *session) WriteContext(ctx context.Context, frame interface{}) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if session.lanes != nil {
+		return session.lanes.send(ctx, frame, session.metrics)
+	}
+
+	return session.write(ctx, frame)
+}
+
+func (session *session) write(ctx context.Context, frame interface{}) (int, error) {
 	switch f := frame.(type) {
 	case *Frame:
 		if f.PathTrace() == false {
@@ -183,15 +314,38 @@ func (session *session) Write(frame interface{}) (int, error) {
 		f.Trace.Path[f.Trace.PathLength-1].TxTimestamp = time.Now()
 	}
 
-	setWriteTimeout(session.conn)
-	err := session.encoder.Encode(frame)
+	start := time.Now()
+	setWriteTimeout(ctx, session.conn)
+	err := session.encoder.Encode(session.compress(frame))
 	clearWriteTimeout(session.conn)
+	session.metrics.frameSent(frame, time.Since(start), err)
+
+	if f, ok := frame.(*Frame); ok && err == nil {
+		session.capture.record(session, CaptureTx, f)
+		if session.log.enabled(DebugLevel) {
+			session.log.with(frameFields(f)...).Debugf("Sent frame")
+		}
+	}
 
 	return 0, err
 }
 
 func (session *session) Read(frame interface{}) error {
+	if session.keepaliveTimeout > 0 {
+		session.conn.SetReadDeadline(time.Now().Add(session.keepaliveTimeout))
+	}
+
+	session.frames.reset()
 	err := session.decoder.Decode(frame)
+	if session.frames.tooLarge {
+		err = errFrameTooLarge
+	} else if err == nil {
+		err = checkPayloadSize(frame, session.maxPayloadSize)
+	}
+	if err == nil {
+		err = session.decompress(frame)
+	}
+	session.metrics.frameReceived(frame, err)
 
 	switch f := frame.(type) {
 	case *Frame:
@@ -209,6 +363,13 @@ func (session *session) Read(frame interface{}) error {
 		f.Trace.PathLength++
 	}
 
+	if f, ok := frame.(*Frame); ok && err == nil {
+		session.capture.record(session, CaptureRx, f)
+		if session.log.enabled(DebugLevel) {
+			session.log.with(frameFields(f)...).Debugf("Received frame")
+		}
+	}
+
 	return err
 
 }
@@ -0,0 +1,412 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/01org/ciao/payloads"
+)
+
+// SpanFormat is the JSON format SSNTP frame trace spans are exported in.
+type SpanFormat string
+
+const (
+	// ZipkinFormat is the Zipkin v2 JSON spans format, as accepted by
+	// the Zipkin /api/v2/spans HTTP endpoint.
+	ZipkinFormat SpanFormat = "zipkin"
+
+	// OTLPFormat is the OpenTelemetry OTLP/JSON traces format, as
+	// accepted by the OTLP/HTTP /v1/traces endpoint.
+	OTLPFormat SpanFormat = "otlp"
+)
+
+// Span is a timed operation from an SSNTP frame trace.
+type Span struct {
+	// TraceID identifies all the spans of a frame trace.
+	TraceID string
+
+	// ID identifies the span within its trace.
+	ID string
+
+	// ParentID is the parent span ID, or "" for the root span.
+	ParentID string
+
+	Name string
+
+	// Service is the role of the SSNTP node the span belongs to.
+	Service string
+
+	// RemoteService is the role of the SSNTP node the frame is sent
+	// to, for the spans covering a single hop.
+	RemoteService string
+
+	Start time.Time
+	End   time.Time
+
+	Tags map[string]string
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	io.ReadFull(crand.Reader, id)
+
+	return hex.EncodeToString(id)
+}
+
+func parseTimestamp(timestamp string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+// TraceSpans converts an SSNTP frame trace into spans. The root span
+// covers the whole frame path, from the trace start timestamp if any
+// to its end timestamp if any, and each hop between two SSNTP nodes
+// is one of its child spans.
+func TraceSpans(trace payloads.FrameTrace) ([]Span, error) {
+	nodes := trace.Nodes
+	if len(nodes) < 2 {
+		return nil, fmt.Errorf("Frame trace has %d nodes", len(nodes))
+	}
+
+	root := Span{
+		TraceID: randomID(16),
+		ID:      randomID(8),
+		Name:    trace.Type + " " + trace.Operand,
+		Service: nodes[0].SSNTPRole,
+		Start:   parseTimestamp(trace.StartTimestamp),
+		End:     parseTimestamp(trace.EndTimestamp),
+		Tags: map[string]string{
+			"ssntp.type":    trace.Type,
+			"ssntp.operand": trace.Operand,
+		},
+	}
+
+	if trace.Label != "" {
+		root.Tags["ssntp.label"] = trace.Label
+	}
+
+	if root.Start.IsZero() {
+		root.Start = parseTimestamp(nodes[0].TxTimestamp)
+	}
+
+	if root.End.IsZero() {
+		root.End = parseTimestamp(nodes[len(nodes)-1].RxTimestamp)
+	}
+
+	spans := []Span{root}
+
+	for i := 0; i < len(nodes)-1; i++ {
+		sender := nodes[i]
+		receiver := nodes[i+1]
+
+		spans = append(spans, Span{
+			TraceID:       root.TraceID,
+			ID:            randomID(8),
+			ParentID:      root.ID,
+			Name:          fmt.Sprintf("%s %s to %s", trace.Operand, sender.SSNTPRole, receiver.SSNTPRole),
+			Service:       sender.SSNTPRole,
+			RemoteService: receiver.SSNTPRole,
+			Start:         parseTimestamp(sender.TxTimestamp),
+			End:           parseTimestamp(receiver.RxTimestamp),
+			Tags: map[string]string{
+				"ssntp.sender":   sender.SSNTPUUID,
+				"ssntp.receiver": receiver.SSNTPUUID,
+			},
+		})
+	}
+
+	return spans, nil
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type zipkinSpan struct {
+	TraceID        string            `json:"traceId"`
+	ID             string            `json:"id"`
+	ParentID       string            `json:"parentId,omitempty"`
+	Name           string            `json:"name"`
+	Kind           string            `json:"kind,omitempty"`
+	Timestamp      int64             `json:"timestamp"`
+	Duration       int64             `json:"duration"`
+	LocalEndpoint  zipkinEndpoint    `json:"localEndpoint"`
+	RemoteEndpoint *zipkinEndpoint   `json:"remoteEndpoint,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+}
+
+func marshalZipkin(spans []Span) ([]byte, error) {
+	zspans := []zipkinSpan{}
+
+	for _, s := range spans {
+		zs := zipkinSpan{
+			TraceID:       s.TraceID,
+			ID:            s.ID,
+			ParentID:      s.ParentID,
+			Name:          s.Name,
+			Timestamp:     s.Start.UnixNano() / int64(time.Microsecond),
+			Duration:      int64(s.End.Sub(s.Start) / time.Microsecond),
+			LocalEndpoint: zipkinEndpoint{s.Service},
+			Tags:          s.Tags,
+		}
+
+		if s.RemoteService != "" {
+			zs.Kind = "PRODUCER"
+			zs.RemoteEndpoint = &zipkinEndpoint{s.RemoteService}
+		}
+
+		zspans = append(zspans, zs)
+	}
+
+	return json.Marshal(zspans)
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// OTLP span kinds.
+const (
+	otlpKindInternal = 1
+	otlpKindProducer = 4
+)
+
+func otlpAttributes(tags map[string]string) []otlpAttribute {
+	var keys []string
+	var attributes []otlpAttribute
+
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		attributes = append(attributes, otlpAttribute{k, otlpValue{tags[k]}})
+	}
+
+	return attributes
+}
+
+// marshalOTLP groups spans by service, in order of appearance, as
+// each OTLP resource is a single service.
+func marshalOTLP(spans []Span) ([]byte, error) {
+	traces := otlpTraces{ResourceSpans: []otlpResourceSpans{}}
+	services := make(map[string]int)
+
+	for _, s := range spans {
+		i, ok := services[s.Service]
+		if !ok {
+			i = len(traces.ResourceSpans)
+			services[s.Service] = i
+			traces.ResourceSpans = append(traces.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{
+					Attributes: otlpAttributes(map[string]string{"service.name": s.Service}),
+				},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{"ssntp"}}},
+			})
+		}
+
+		span := otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.ID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Tags),
+		}
+
+		if s.RemoteService != "" {
+			span.Kind = otlpKindProducer
+			span.Attributes = append(span.Attributes, otlpAttribute{"peer.service", otlpValue{s.RemoteService}})
+		}
+
+		scope := &traces.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	return json.Marshal(traces)
+}
+
+// MarshalSpans encodes spans in the given JSON format.
+func MarshalSpans(spans []Span, format SpanFormat) ([]byte, error) {
+	switch format {
+	case ZipkinFormat:
+		return marshalZipkin(spans)
+	case OTLPFormat:
+		return marshalOTLP(spans)
+	}
+
+	return nil, fmt.Errorf("Unknown span format %s", format)
+}
+
+// SpanExporter exports SSNTP frame traces as spans, either to a file
+// or to an HTTP collector.
+type SpanExporter struct {
+	sync.Mutex
+	format SpanFormat
+	url    string
+	file   *os.File
+	client http.Client
+}
+
+const spanExportTimeout = 10 * time.Second
+
+// NewSpanExporter returns a span exporter for the given format.
+// If destination is an http:// or https:// URL, e.g.
+// http://localhost:9411/api/v2/spans for a local Zipkin collector or
+// http://localhost:4318/v1/traces for a local OTLP one, spans are
+// POSTed to it. Otherwise spans are appended to the destination file,
+// one JSON document per line.
+func NewSpanExporter(format SpanFormat, destination string) (*SpanExporter, error) {
+	if format != ZipkinFormat && format != OTLPFormat {
+		return nil, fmt.Errorf("Unknown span format %s", format)
+	}
+
+	exporter := &SpanExporter{
+		format: format,
+		client: http.Client{Timeout: spanExportTimeout},
+	}
+
+	if strings.HasPrefix(destination, "http://") || strings.HasPrefix(destination, "https://") {
+		exporter.url = destination
+		return exporter, nil
+	}
+
+	file, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	exporter.file = file
+
+	return exporter, nil
+}
+
+// Export exports the spans of all the frame traces from a TraceReport
+// event payload. Frame traces that can not be converted into spans are
+// skipped.
+func (e *SpanExporter) Export(trace payloads.Trace) error {
+	var spans []Span
+
+	for _, f := range trace.Frames {
+		frameSpans, err := TraceSpans(f)
+		if err != nil {
+			continue
+		}
+
+		spans = append(spans, frameSpans...)
+	}
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	data, err := MarshalSpans(spans, e.format)
+	if err != nil {
+		return err
+	}
+
+	if e.url != "" {
+		return e.post(data)
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	_, err = e.file.Write(append(data, '\n'))
+
+	return err
+}
+
+func (e *SpanExporter) post(data []byte) error {
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Span collector %s replied %s", e.url, resp.Status)
+	}
+
+	return nil
+}
+
+// Close closes the exporter destination file, if any.
+func (e *SpanExporter) Close() error {
+	if e.file == nil {
+		return nil
+	}
+
+	return e.file.Close()
+}
@@ -24,12 +24,13 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
-	"os"
+	"net"
 	"strings"
 	"sync"
-	"syscall"
+	"time"
 
 	"github.com/docker/distribution/uuid"
 	"github.com/golang/glog"
@@ -41,11 +42,12 @@ type Type uint8
 
 // Command is the SSNTP Command operand.
 // It can be CONNECT, START, STOP, STATS, EVACUATE, DELETE, RESTART,
-// AssignPublicIP, ReleasePublicIP or CONFIGURE.
+// AssignPublicIP, ReleasePublicIP, CONFIGURE, PING, REBOOT, PAUSE,
+// UNPAUSE, SUSPEND, RESUME, REBUILD or RESIZE.
 type Command uint8
 
 // Status is the SSNTP Status operand.
-// It can be CONNECTED, READY, FULL, OFFLINE or MAINTENANCE
+// It can be CONNECTED, READY, FULL, OFFLINE, MAINTENANCE or PONG
 type Status uint8
 
 // Role describes the SSNTP role for the frame sender.
@@ -55,7 +57,8 @@ type Role uint32
 // Error is the SSNTP Error operand.
 // It can be InvalidFrameType Error, StartFailure,
 // StopFailure, ConnectionFailure, RestartFailure,
-// DeleteFailure, ConnectionAborted or InvalidConfiguration.
+// DeleteFailure, ConnectionAborted, InvalidConfiguration,
+// FrameTooLarge or InstanceActionFailure.
 type Error uint8
 
 // Event is the SSNTP Event operand.
@@ -228,6 +231,102 @@ const (
 	//	|       |       | (0x0) |  (0x9)  |                 |                         |
 	//	+-----------------------------------------------------------------------------+
 	CONFIGURE
+
+	// PING is a keepalive command periodically sent by SSNTP clients and
+	// servers that negotiated the keepalive capability. The receiver must
+	// reply with a PONG status frame.
+	// PING frames are handled by the SSNTP package itself, they are neither
+	// forwarded nor notified.
+	//
+	//                                       SSNTP PING Command frame
+	//	+---------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length |
+	//	|       |       | (0x0) |  (0xa)  |       (0x0)     |
+	//	+---------------------------------------------------+
+	PING
+
+	// REBOOT is a command sent to CIAO CN Agents for rebooting a running instance.
+	// A soft reboot lets the guest shut itself down cleanly while a hard
+	// reboot power cycles the instance.
+	// The REBOOT command payload contains an instance and an agent UUID
+	// together with the reboot type.
+	//
+	//                                         SSNTP REBOOT Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0xb)  |                 | UUIDs and reboot type    |
+	//	+------------------------------------------------------------------------------+
+	REBOOT
+
+	// PAUSE is a command sent to CIAO CN Agents for freezing the execution of a
+	// running instance. A paused instance keeps its resources on the CN.
+	// The PAUSE command payload uses the same YAML schema as the STOP command one.
+	//
+	//                                         SSNTP PAUSE Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0xc)  |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	PAUSE
+
+	// UNPAUSE is a command sent to CIAO CN Agents for resuming the execution of
+	// a PAUSEd instance.
+	// The UNPAUSE command payload uses the same YAML schema as the STOP command one.
+	//
+	//                                         SSNTP UNPAUSE Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0xd)  |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	UNPAUSE
+
+	// SUSPEND is a command sent to CIAO CN Agents for saving the state of a
+	// running instance to disk and stopping it.
+	// The SUSPEND command payload uses the same YAML schema as the STOP command one.
+	//
+	//                                         SSNTP SUSPEND Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0xe)  |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	SUSPEND
+
+	// RESUME is a command sent to CIAO CN Agents for restoring a SUSPENDed
+	// instance from its saved state.
+	// The RESUME command payload uses the same YAML schema as the STOP command one.
+	//
+	//                                         SSNTP RESUME Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0xf)  |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	RESUME
+
+	// REBUILD is a command sent to CIAO CN Agents for recreating the root disk
+	// of an instance from a new image and booting it again. The instance keeps
+	// its UUID and networking configuration.
+	// The REBUILD command payload contains an instance and an agent UUID
+	// together with the new image UUID.
+	//
+	//                                         SSNTP REBUILD Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0x10) |                 | UUIDs and new image      |
+	//	+------------------------------------------------------------------------------+
+	REBUILD
+
+	// RESIZE is a command sent to CIAO CN Agents for changing the resources,
+	// i.e. the flavor, of an instance. The instance is rebooted with its
+	// new resources.
+	// The RESIZE command payload contains an instance and an agent UUID
+	// together with the new requested resources.
+	//
+	//                                         SSNTP RESIZE Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0x11) |                 | UUIDs and resources      |
+	//	+------------------------------------------------------------------------------+
+	RESIZE
 )
 
 const (
@@ -292,6 +391,18 @@ const (
 	//	|       |       | (0x1) |  (0x4)  |       (0x0)     |
 	//	+---------------------------------------------------+
 	MAINTENANCE
+
+	// PONG is the reply to a PING keepalive command.
+	// PONG frames are handled by the SSNTP package itself, they are neither
+	// forwarded nor notified.
+	//
+	//					 SSNTP PONG Status frame
+	//
+	//	+---------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length |
+	//	|       |       | (0x1) |  (0x5)  |       (0x0)     |
+	//	+---------------------------------------------------+
+	PONG
 )
 
 const (
@@ -492,10 +603,20 @@ const (
 	// When the scheduler receives such error back from any client it should revert
 	// back to the previous valid configuration.
 	InvalidConfiguration
+
+	// FrameTooLarge is sent by SSNTP servers to clients sending a frame or
+	// a frame payload larger than the server limits. The server closes the
+	// connection right after sending it.
+	FrameTooLarge
+
+	// InstanceActionFailure is sent by launcher agents to report a failure
+	// to REBOOT, PAUSE, UNPAUSE, SUSPEND, RESUME, REBUILD or RESIZE an
+	// instance. The payload names the failed command.
+	InstanceActionFailure
 )
 
 const major = 0
-const minor = 1
+const minor = 2
 const defaultURL = "localhost"
 const port = 8888
 const readTimeout = 30
@@ -504,8 +625,6 @@ const writeTimeout = 30
 const defaultCA = "/etc/pki/ciao/ca_cert.crt"
 const defaultServerCert = "/etc/pki/ciao/server.pem"
 const defaultClientCert = "/etc/pki/ciao/client.pem"
-const uuidPrefix = "/var/lib/ciao/local/uuid-storage/role"
-const uuidLockPrefix = "/tmp/lock/ciao"
 
 func (t Type) String() string {
 	switch t {
@@ -544,6 +663,22 @@ func (command Command) String() string {
 		return "Release public IP"
 	case CONFIGURE:
 		return "CONFIGURE"
+	case PING:
+		return "PING"
+	case REBOOT:
+		return "REBOOT"
+	case PAUSE:
+		return "PAUSE"
+	case UNPAUSE:
+		return "UNPAUSE"
+	case SUSPEND:
+		return "SUSPEND"
+	case RESUME:
+		return "RESUME"
+	case REBUILD:
+		return "REBUILD"
+	case RESIZE:
+		return "RESIZE"
 	}
 
 	return ""
@@ -561,6 +696,8 @@ func (status Status) String() string {
 		return "OFFLINE"
 	case MAINTENANCE:
 		return "MAINTENANCE"
+	case PONG:
+		return "PONG"
 	}
 
 	return ""
@@ -607,6 +744,10 @@ func (error Error) String() string {
 		return "SSNTP Connection aborted"
 	case InvalidConfiguration:
 		return "Cluster configuration is invalid"
+	case FrameTooLarge:
+		return "SSNTP frame too large"
+	case InstanceActionFailure:
+		return "Could not perform instance action"
 	}
 
 	return ""
@@ -728,9 +869,14 @@ func (role *Role) Set(value string) error {
 // an SSNTP server or when connecting to one as a client.
 type Config struct {
 	// UUID is the client or server UUID string. If set to "",
-	// the SSNTP package will generate a random one.
+	// the UUID is fetched from UUIDProvider.
 	UUID string
 
+	// UUIDProvider provides the client or server UUID, when UUID
+	// is not set. If nil, UUIDs are stored on the local file system
+	// by the NewFileUUIDProvider provider.
+	UUIDProvider UUIDProvider
+
 	// URI semantic differs between servers and clients.
 	// For clients it represents the the SSNTP server URI
 	// they want to connect to.
@@ -740,6 +886,28 @@ type Config struct {
 	// and IPs on the running host.
 	URI string
 
+	// URIs is an optional list of SSNTP server URIs for clients to
+	// connect to. URIs are tried in order, before URI and the
+	// addresses found in the CA certificate. An URI without a port
+	// gets Port appended to it.
+	// When the connection to a server drops, SSNTP clients go through
+	// the same list again to reconnect, possibly to another server.
+	// This is not used by SSNTP servers.
+	URIs []string
+
+	// Backoff configures the delay SSNTP clients wait between two
+	// rounds of connection attempts to all server URIs.
+	// If nil, the default BackoffConfig values will be used.
+	Backoff *BackoffConfig
+
+	// OutboundQueueSize is the maximum number of frames an SSNTP
+	// client will queue while it is disconnected from its server.
+	// Queued frames are sent once the client reconnects. When the
+	// queue is full or if OutboundQueueSize is 0, the default, sending
+	// a frame while disconnected fails with ErrNotConnected or
+	// ErrOutboundQueueFull.
+	OutboundQueueSize int
+
 	// CACert is the Certification Authority certificate path
 	// to use when verifiying the peer identity.
 	// If set to "", /etc/pki/ciao/ciao_ca_cert.crt will be used.
@@ -750,10 +918,18 @@ type Config struct {
 	// will be used for SSNTP clients and server, respectively.
 	Cert string
 
-	// Transport is the underlying transport protocol. Only "tcp" and "unix"
-	// transports are supported. The default is "tcp".
+	// Transport is the underlying transport protocol. Only "tcp", "unix"
+	// and "mem" transports are supported. The default is "tcp".
+	// The "mem" transport connects SSNTP clients and servers running in
+	// the same process, without using any socket.
 	Transport string
 
+	// SkipTLS disables TLS for the "mem" transport. SSNTP peers roles
+	// are then trusted from their CONNECT and CONNECTED frames, and
+	// are not verified.
+	// SkipTLS is ignored by all other transports.
+	SkipTLS bool
+
 	// ForwardRules is optional and contains a list of frame forwarding rules.
 	ForwardRules []FrameForwardRule
 
@@ -784,6 +960,111 @@ type Config struct {
 	// Configuration driver (e.g: 'file' or 'etcd') will be determinated
 	// from the URI scheme.
 	ConfigURI string
+
+	// Capabilities is the list of optional SSNTP features this client
+	// or server is willing to use. The features actually used on a
+	// given connection are the ones both peers advertised.
+	// If nil, all capabilities supported by the SSNTP package are
+	// advertised.
+	Capabilities []Capability
+
+	// RequiredCapabilities is the list of capabilities that peers
+	// must support. Peers that do not support all of them will be
+	// rejected at connection time with a ConnectionFailure error.
+	RequiredCapabilities []Capability
+
+	// KeepaliveInterval is the interval at which SSNTP clients and servers
+	// send PING frames to their peers, when both negotiated the keepalive
+	// capability. A peer that did not send any frame for
+	// KeepaliveMissedBeats intervals is considered dead and its
+	// connection is closed.
+	// Keepalive is disabled when KeepaliveInterval is 0, the default.
+	KeepaliveInterval time.Duration
+
+	// KeepaliveMissedBeats is the number of keepalive intervals without
+	// receiving any frame after which a peer is considered dead.
+	// The default is 3.
+	KeepaliveMissedBeats int
+
+	// Codecs is an optional list of SSNTP codecs to use instead of
+	// the default GobCodec, by order of preference. Each codec is
+	// advertised as a "codec:<name>" capability and the first one
+	// both peers support is used once the connection is established.
+	Codecs []Codec
+
+	// Capture is an optional writer SSNTP clients and servers record
+	// all the frames they send and receive to, as JSON encoded
+	// CaptureRecord. Captures can be read back with ReadCapture.
+	Capture io.Writer
+
+	// CRL is the path to an optional PEM or DER encoded certificate
+	// revocation list, signed by the CAcert CA. SSNTP peers presenting
+	// a revoked certificate are rejected with a ConnectionAborted error.
+	CRL string
+
+	// DenyList is the path to an optional local list of revoked
+	// certificate serial numbers, one per line. Serial numbers are
+	// either decimal, hexadecimal with a 0x prefix or colon separated
+	// hexadecimal bytes. SSNTP peers presenting a certificate from
+	// that list are rejected with a ConnectionAborted error.
+	DenyList string
+
+	// Authorization is an optional SSNTP server authorization policy.
+	// When set, SSNTP clients are only allowed to send the frames
+	// their role is authorized to send by one of the rules, and any
+	// other frame is rejected with an InvalidFrameType error.
+	// Keepalive frames are always allowed.
+	// Authorization is ignored by SSNTP clients.
+	Authorization []AuthorizationRule
+
+	// MaxFrameSize is the maximum number of bytes an SSNTP server
+	// reads for a single frame, including the CONNECT one. Clients
+	// sending larger frames get a FrameTooLarge error and are
+	// disconnected.
+	// Frame sizes are not limited when MaxFrameSize is 0, the default.
+	// MaxFrameSize is ignored by SSNTP clients.
+	MaxFrameSize int
+
+	// MaxPayloadSize is the maximum frame payload length an SSNTP
+	// server accepts. Clients sending frames with a larger payload
+	// get a FrameTooLarge error and are disconnected.
+	// Payload lengths are not limited when MaxPayloadSize is 0, the
+	// default.
+	// MaxPayloadSize is ignored by SSNTP clients.
+	MaxPayloadSize int
+
+	// MaxHandshakesPerIP is the maximum number of connection
+	// handshakes an SSNTP server runs concurrently for a given
+	// remote IP address. Connections going over that limit are
+	// closed right away.
+	// Handshakes are not limited when MaxHandshakesPerIP is 0, the
+	// default.
+	// MaxHandshakesPerIP is ignored by SSNTP clients.
+	MaxHandshakesPerIP int
+
+	// HandshakeTimeout is the maximum time an SSNTP server waits for
+	// a new client to complete its TLS handshake and send its CONNECT
+	// frame. The default is 30 seconds.
+	// HandshakeTimeout is ignored by SSNTP clients.
+	HandshakeTimeout time.Duration
+
+	// BulkQueueSize is the maximum number of STATS and TraceReport
+	// frames an SSNTP client or server queues for a given peer. Those
+	// frames are only sent once no other frame is waiting to be sent
+	// to the peer. A queued STATS frame is replaced by any newer STATS
+	// frame from the same origin, and the oldest queued frame is dropped
+	// when the queue is full.
+	// The default is 16.
+	BulkQueueSize int
+
+	// CompressionThreshold is the payload length, in bytes, from which
+	// SSNTP clients and servers compress the frames they send to peers
+	// that negotiated the compression capability. Compression is
+	// transparent to the SSNTP notifiers, which always get uncompressed
+	// payloads.
+	// Frames are not compressed when CompressionThreshold is 0, the
+	// default.
+	CompressionThreshold int
 }
 
 // Logger is an interface for SSNTP users to define their own
@@ -832,6 +1113,8 @@ func (l glogLog) Warningf(format string, args ...interface{}) {
 // Error message will be logged unconditionally.
 // Warnings are logged if glog's V >= 1.
 // Info messages are logged if glog's V >= 2.
+// Debug messages are logged if glog's V >= 3.
+// Log implements StructuredLogger.
 var Log glogLog
 
 type boolFlag struct {
@@ -969,6 +1252,8 @@ func getOIDsFromRole(role Role) ([]asn1.ObjectIdentifier, error) {
 func verifyRole(conn interface{}, role Role) (bool, error) {
 	var oidError = fmt.Errorf("**** TEMPORARY WARNING ****\n*** Wrong certificate or missing/mismatched role OID ***\nIn order to fix this, use the -role option when generating your certificates with the ciao-cert tool.\n")
 	switch tlsConn := conn.(type) {
+	case *memConn:
+		return true, nil
 	case *tls.Conn:
 		state := tlsConn.ConnectionState()
 		certRole := getRoleFromOIDs(state.PeerCertificates[0].UnknownExtKeyUsage)
@@ -1024,9 +1309,13 @@ func (config *Config) parseCertificate() (Role, error) {
 		log.Fatalf("SSNTP: Load certificate [%s]: %s", config.Cert, err)
 	}
 
+	return certificateRole(certPEM, config.Cert)
+}
+
+func certificateRole(certPEM []byte, certPath string) (Role, error) {
 	certBlock, _ := pem.Decode(certPEM)
 	if certBlock == nil {
-		return 0, fmt.Errorf("Could not decode PEM for %s", config.CAcert)
+		return 0, fmt.Errorf("Could not decode PEM for %s", certPath)
 	}
 
 	cert, err := x509.ParseCertificates(certBlock.Bytes)
@@ -1044,20 +1333,34 @@ func (config *Config) parseCertificate() (Role, error) {
 	return role, nil
 }
 
-func (config *Config) configUUID(role Role) (lockedUUID, uuid.UUID) {
-	if config.UUID == "" {
-		var err error
-		lUUID, err := newUUID("client", role)
-		if err != nil {
-			fmt.Printf("SSNTP ERROR: Client: Could not fetch a UUID, generating a random one (%s)\n", err)
-			return lUUID, uuid.Generate()
-		}
+func (config *Config) uuidProvider() UUIDProvider {
+	if config.UUID != "" {
+		return StaticUUIDProvider(config.UUID)
+	}
+
+	if config.UUIDProvider != nil {
+		return config.UUIDProvider
+	}
+
+	return NewFileUUIDProvider("", "")
+}
+
+func (config *Config) configUUID(role Role) (UUIDProvider, uuid.UUID) {
+	provider := config.uuidProvider()
+
+	id, err := provider.UUID(role)
+	if err == nil {
+		var u uuid.UUID
 
-		return lUUID, lUUID.uuid
+		u, err = uuid.Parse(id)
+		if err == nil {
+			return provider, u
+		}
 	}
 
-	uuid, _ := uuid.Parse(config.UUID)
-	return lockedUUID{}, uuid
+	config.log().Errorf("Could not fetch a UUID, generating a random one (%s)\n", err)
+
+	return provider, uuid.Generate()
 }
 
 func (config *Config) transport() string {
@@ -1065,7 +1368,7 @@ func (config *Config) transport() string {
 		return "tcp"
 	}
 
-	if config.Transport != "tcp" && config.Transport != "unix" {
+	if config.Transport != "tcp" && config.Transport != "unix" && config.Transport != memTransport {
 		return "tcp"
 	}
 
@@ -1073,6 +1376,14 @@ func (config *Config) transport() string {
 }
 
 func (config *Config) configURIs(uris []string, port uint32) []string {
+	/* Explicit list of servers first */
+	for _, uri := range config.URIs {
+		if _, _, err := net.SplitHostPort(uri); err != nil {
+			uri = fmt.Sprintf("%s:%d", uri, port)
+		}
+		uris = append(uris, uri)
+	}
+
 	/* First we add the configured server URI */
 	if config.URI != "" {
 		uris = append(uris, fmt.Sprintf("%s:%d", config.URI, port))
@@ -1124,124 +1435,57 @@ func (config *Config) setCerts() {
 	}
 }
 
-func (config *Config) log() Logger {
-	if config.Log == nil {
-		return errLog
-	}
+func (config *Config) capabilities() []Capability {
+	var capabilities []Capability
 
-	return config.Log
-}
-
-const nullUUID = "00000000-0000-0000-0000-000000000000"
-
-type lockedUUID struct {
-	lockFd int
-	uuid   uuid.UUID
-}
-
-func newUUID(prefix string, role Role) (lockedUUID, error) {
-	uuidFile := fmt.Sprintf("%s/%s/0x%x", uuidPrefix, prefix, (uint32)(role))
-	uuidLockFile := fmt.Sprintf("%s/%s-role-0x%x", uuidLockPrefix, prefix, (uint32)(role))
-	_nUUID, _ := uuid.Parse(nullUUID)
-	nUUID := lockedUUID{
-		uuid:   _nUUID,
-		lockFd: -1,
+	if config.Capabilities == nil {
+		capabilities = append(capabilities, supportedCapabilities...)
+	} else {
+		capabilities = append(capabilities, config.Capabilities...)
 	}
 
-	randomUUID := lockedUUID{
-		uuid:   uuid.Generate(),
-		lockFd: -1,
+	for _, codec := range config.Codecs {
+		capabilities = append(capabilities, codecCapability(codec))
 	}
 
-	/* Create UUID directory if necessary */
-	err := os.MkdirAll(uuidPrefix+"/"+prefix, 0755)
-	if err != nil {
-		fmt.Printf("Unable to create %s %v\n", uuidPrefix, err)
-	}
-
-	/* Create CIAO lock directory if necessary */
-	err = os.MkdirAll(uuidLockPrefix, 0777)
-	if err != nil {
-		fmt.Printf("Unable to create %s %v\n", uuidLockPrefix, err)
-		return nUUID, err
-	}
+	return capabilities
+}
 
-	fd, err := syscall.Open(uuidFile, syscall.O_CREAT|syscall.O_RDWR, syscall.S_IWUSR|syscall.S_IRUSR)
-	if err != nil {
-		fmt.Printf("Unable to open UUID file %s %v\n", uuidFile, err)
-		return nUUID, err
+func (config *Config) keepalive() keepaliveConfig {
+	if config.KeepaliveMissedBeats <= 0 {
+		return keepaliveConfig{config.KeepaliveInterval, defaultKeepaliveMissedBeats}
 	}
 
-	defer func() { _ = syscall.Close(fd) }()
+	return keepaliveConfig{config.KeepaliveInterval, config.KeepaliveMissedBeats}
+}
 
-	lockFd, err := syscall.Open(uuidLockFile, syscall.O_CREAT, syscall.S_IWUSR|syscall.S_IRUSR)
-	if err != nil {
-		fmt.Printf("Unable to open UUID lock file %s %v\n", uuidLockFile, err)
-		return nUUID, err
+func (config *Config) bulkQueueSize() int {
+	if config.BulkQueueSize <= 0 {
+		return defaultBulkQueueSize
 	}
 
-	if syscall.Flock(lockFd, syscall.LOCK_EX|syscall.LOCK_NB) != nil {
-		/* File is already locked, we need to generate a random UUID */
-		syscall.Close(lockFd)
-		return randomUUID, nil
-	}
+	return config.BulkQueueSize
+}
 
-	uuidArray := make([]byte, 36)
-	n, err := syscall.Read(fd, uuidArray)
-	if err != nil {
-		fmt.Printf("Could not read %s\n", uuidFile)
-		syscall.Close(lockFd)
-		return nUUID, err
+func (config *Config) limits() limitsConfig {
+	limits := limitsConfig{
+		maxFrameSize:       config.MaxFrameSize,
+		maxPayloadSize:     config.MaxPayloadSize,
+		maxHandshakesPerIP: config.MaxHandshakesPerIP,
+		handshakeTimeout:   config.HandshakeTimeout,
 	}
 
-	if n == 0 || n != 36 {
-		/* 2 cases: */
-		/* 1) File was just created or is empty: Write a new UUID */
-		/* Or */
-		/* 2) File contains garbage - Overwrite with a new UUID */
-		newUUID := uuid.Generate()
-		_, err := syscall.Write(fd, []byte(newUUID.String()))
-		if err != nil {
-			fmt.Printf("Could not write %s on %s (%s)\n", newUUID.String(), uuidFile, err)
-			syscall.Close(lockFd)
-			return nUUID, err
-		}
-
-		newLockedUUID := lockedUUID{
-			uuid:   newUUID,
-			lockFd: lockFd,
-		}
-
-		return newLockedUUID, nil
-	} else if n == 36 {
-		newUUID, err := uuid.Parse(string(uuidArray[:36]))
-		if err != nil {
-			fmt.Printf("Could not parse UUID\n")
-			syscall.Close(lockFd)
-			return nUUID, err
-		}
-
-		newLockedUUID := lockedUUID{
-			uuid:   newUUID,
-			lockFd: lockFd,
-		}
-
-		return newLockedUUID, nil
+	if limits.handshakeTimeout <= 0 {
+		limits.handshakeTimeout = readTimeout * time.Second
 	}
 
-	return nUUID, err
+	return limits
 }
 
-func freeUUID(uuid lockedUUID) error {
-	if uuid.lockFd == -1 {
-		return nil
-	}
-
-	err := syscall.Flock(uuid.lockFd, syscall.LOCK_UN)
-	if err != nil {
-		fmt.Printf("Unable to unlock UUID %v\n", err)
-		return err
+func (config *Config) log() fieldLogger {
+	if config.Log == nil {
+		return newLogger(errLog)
 	}
 
-	return nil
+	return newLogger(config.Log)
 }
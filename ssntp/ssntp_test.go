@@ -17,16 +17,38 @@
 package ssntp
 
 import (
+	"bufio"
 	"bytes"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/docker/distribution/uuid"
+	"golang.org/x/net/context"
 )
 
 const tempCertPath = "/tmp/ssntp-test-certs"
@@ -827,6 +849,3016 @@ func TestMajor(t *testing.T) {
 	server.ssntp.Stop()
 }
 
+// Test SSNTP version checking
+//
+// Test that peers with the same major version are
+// compatible and that peers with a different one are not.
+//
+// Test is expected to pass.
+func TestCheckVersion(t *testing.T) {
+	err := checkVersion(major)
+	if err != nil {
+		t.Fatalf("Same major version should be compatible: %s", err)
+	}
+
+	err = checkVersion(major | pathTraceEnabled)
+	if err != nil {
+		t.Fatalf("Path trace bit should be ignored: %s", err)
+	}
+
+	err = checkVersion(major + 1)
+	if err == nil {
+		t.Fatalf("Different major version should not be compatible")
+	}
+}
+
+// Test SSNTP capabilities negotiation helpers
+//
+// Test that the negotiated capabilities are the intersection
+// of both peers capabilities, and that missing required
+// capabilities are detected.
+//
+// Test is expected to pass.
+func TestNegotiateCapabilities(t *testing.T) {
+	local := []Capability{"foo", "bar", "baz"}
+	peer := []Capability{"baz", "foo", "qux"}
+
+	caps := negotiateCapabilities(local, peer)
+	if len(caps) != 2 || caps[0] != "foo" || caps[1] != "baz" {
+		t.Fatalf("Wrong negotiated capabilities %v", caps)
+	}
+
+	missing := missingCapabilities([]Capability{"foo"}, caps)
+	if missing != nil {
+		t.Fatalf("Unexpected missing capabilities %v", missing)
+	}
+
+	missing = missingCapabilities([]Capability{"bar", "baz"}, caps)
+	if len(missing) != 1 || missing[0] != "bar" {
+		t.Fatalf("Wrong missing capabilities %v", missing)
+	}
+}
+
+func testConnectCapabilities(t *testing.T, serverCaps, serverRequired, clientCaps, clientRequired []Capability) ([]Capability, error) {
+	var server ssntpEchoServer
+	var client ssntpClient
+
+	server.t = t
+	client.t = t
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.Capabilities = serverCaps
+	serverConfig.RequiredCapabilities = serverRequired
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.Capabilities = clientCaps
+	clientConfig.RequiredCapabilities = clientRequired
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	defer server.ssntp.Stop()
+
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err != nil {
+		return nil, err
+	}
+
+	caps := client.ssntp.Capabilities()
+	client.ssntp.Close()
+
+	return caps, nil
+}
+
+// Test SSNTP capabilities negotiation
+//
+// Test that an SSNTP client gets the intersection of its
+// and the server capabilities once connected.
+//
+// Test is expected to pass.
+func TestConnectCapabilities(t *testing.T) {
+	caps, err := testConnectCapabilities(t,
+		[]Capability{"foo", "bar"}, nil,
+		[]Capability{"bar", "baz"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+
+	if len(caps) != 1 || caps[0] != "bar" {
+		t.Fatalf("Wrong negotiated capabilities %v", caps)
+	}
+}
+
+// Test SSNTP server required capabilities
+//
+// Test that an SSNTP server rejects clients that do not
+// support all of its required capabilities.
+//
+// Test is expected to pass.
+func TestConnectServerRequiredCapabilities(t *testing.T) {
+	_, err := testConnectCapabilities(t,
+		[]Capability{"foo", "bar"}, []Capability{"foo"},
+		[]Capability{"bar"}, nil)
+	if err == nil {
+		t.Fatalf("Client without a required capability should not connect")
+	}
+}
+
+// Test SSNTP client required capabilities
+//
+// Test that an SSNTP client does not connect to a server
+// that does not support all of its required capabilities.
+//
+// Test is expected to pass.
+func TestConnectClientRequiredCapabilities(t *testing.T) {
+	_, err := testConnectCapabilities(t,
+		[]Capability{"bar"}, nil,
+		[]Capability{"foo", "bar"}, []Capability{"foo"})
+	if err == nil {
+		t.Fatalf("Client should not connect to a server without a required capability")
+	}
+}
+
+// Test SSNTP client backoff delays
+//
+// Test that the delay between two rounds of connection attempts
+// grows exponentially, is capped and stays within the jitter
+// bounds.
+//
+// Test is expected to pass.
+func TestBackoffDelay(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	backoff := newBackoffConfig(&BackoffConfig{
+		InitialDelay: time.Second,
+		MaxDelay:     5 * time.Second,
+		Multiplier:   2,
+	})
+
+	expected := []time.Duration{1, 2, 4, 5, 5}
+	for i, e := range expected {
+		d := backoff.delay(i, r)
+		if d != e*time.Second {
+			t.Fatalf("Wrong delay for attempt %d: %v, expected %v", i, d, e*time.Second)
+		}
+	}
+
+	backoff.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := backoff.delay(i%4, r)
+		max := expected[i%4] * time.Second
+		if d > max || d < max/2 {
+			t.Fatalf("Delay %v out of jitter bounds [%v, %v]", d, max/2, max)
+		}
+	}
+
+	backoff = newBackoffConfig(nil)
+	if backoff != defaultBackoff {
+		t.Fatalf("Wrong default backoff configuration %v", backoff)
+	}
+}
+
+// Test SSNTP client server URIs
+//
+// Test that the configured list of server URIs comes first,
+// and that URIs without a port get the configured one.
+//
+// Test is expected to pass.
+func TestConfigURIs(t *testing.T) {
+	config, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	config.URIs = []string{"10.0.0.1:9999", "10.0.0.2"}
+	config.URI = "10.0.0.3"
+
+	uris := config.configURIs(nil, 8888)
+	expected := []string{"10.0.0.1:9999", "10.0.0.2:8888", "10.0.0.3:8888"}
+	if len(uris) < len(expected) {
+		t.Fatalf("Missing URIs %v", uris)
+	}
+
+	for i, uri := range expected {
+		if uris[i] != uri {
+			t.Fatalf("Wrong URI %s, expected %s", uris[i], uri)
+		}
+	}
+}
+
+type ssntpReconnectClient struct {
+	ssntp        Client
+	connected    chan struct{}
+	disconnected chan struct{}
+	staChannel   chan []byte
+}
+
+func newSSNTPReconnectClient() *ssntpReconnectClient {
+	return &ssntpReconnectClient{
+		connected:    make(chan struct{}, 4),
+		disconnected: make(chan struct{}, 4),
+		staChannel:   make(chan []byte, 4),
+	}
+}
+
+func (client *ssntpReconnectClient) ConnectNotify() {
+	client.connected <- struct{}{}
+}
+
+func (client *ssntpReconnectClient) DisconnectNotify() {
+	client.disconnected <- struct{}{}
+}
+
+func (client *ssntpReconnectClient) StatusNotify(status Status, frame *Frame) {
+	client.staChannel <- frame.Payload
+}
+
+func (client *ssntpReconnectClient) CommandNotify(command Command, frame *Frame) {
+}
+
+func (client *ssntpReconnectClient) EventNotify(event Event, frame *Frame) {
+}
+
+func (client *ssntpReconnectClient) ErrorNotify(error Error, frame *Frame) {
+}
+
+func waitForNotification(t *testing.T, c chan struct{}, what string) {
+	select {
+	case <-c:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Did not receive %s notification", what)
+	}
+}
+
+func startEchoServer(t *testing.T) *ssntpEchoServer {
+	server := &ssntpEchoServer{t: t}
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	return server
+}
+
+func dialReconnectClient(t *testing.T, queueSize int) *ssntpReconnectClient {
+	client := newSSNTPReconnectClient()
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.Backoff = &BackoffConfig{
+		InitialDelay: 50 * time.Millisecond,
+		MaxDelay:     200 * time.Millisecond,
+	}
+	clientConfig.OutboundQueueSize = queueSize
+
+	err = client.ssntp.Dial(clientConfig, client)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+
+	waitForNotification(t, client.connected, "connect")
+
+	return client
+}
+
+// Test SSNTP client sending while disconnected
+//
+// Test that an SSNTP client without an outbound queue rejects
+// frames while disconnected, and can send them again once it
+// reconnected to the server.
+//
+// Test is expected to pass.
+func TestClientDisconnectedSend(t *testing.T) {
+	server := startEchoServer(t)
+	client := dialReconnectClient(t, 0)
+	defer client.ssntp.Close()
+
+	server.ssntp.Stop()
+	waitForNotification(t, client.disconnected, "disconnect")
+
+	_, err := client.ssntp.SendStatus(READY, []byte("foo"))
+	if err != ErrNotConnected {
+		t.Fatalf("Unexpected error while disconnected: %v", err)
+	}
+
+	server = startEchoServer(t)
+	defer server.ssntp.Stop()
+	waitForNotification(t, client.connected, "reconnect")
+
+	_, err = client.ssntp.SendStatus(READY, []byte("foo"))
+	if err != nil {
+		t.Fatalf("Could not send status after reconnection: %s", err)
+	}
+
+	select {
+	case payload := <-client.staChannel:
+		if string(payload) != "foo" {
+			t.Fatalf("Wrong echoed payload %s", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive echoed status")
+	}
+}
+
+// Test SSNTP client outbound queue
+//
+// Test that an SSNTP client with an outbound queue queues frames
+// while disconnected, rejects them once the queue is full and
+// sends them after reconnecting.
+//
+// Test is expected to pass.
+func TestClientOutboundQueue(t *testing.T) {
+	server := startEchoServer(t)
+	client := dialReconnectClient(t, 2)
+	defer client.ssntp.Close()
+
+	server.ssntp.Stop()
+	waitForNotification(t, client.disconnected, "disconnect")
+
+	for _, p := range []string{"foo", "bar"} {
+		_, err := client.ssntp.SendStatus(READY, []byte(p))
+		if err != nil {
+			t.Fatalf("Could not queue status: %s", err)
+		}
+	}
+
+	_, err := client.ssntp.SendStatus(READY, []byte("baz"))
+	if err != ErrOutboundQueueFull {
+		t.Fatalf("Unexpected error with a full queue: %v", err)
+	}
+
+	server = startEchoServer(t)
+	defer server.ssntp.Stop()
+	waitForNotification(t, client.connected, "reconnect")
+
+	// The client notifies about received frames asynchronously,
+	// so echoed statuses may come back in any order.
+	echoed := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case payload := <-client.staChannel:
+			echoed[string(payload)] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Did not receive queued statuses")
+		}
+	}
+
+	if !echoed["foo"] || !echoed["bar"] {
+		t.Fatalf("Wrong echoed statuses %v", echoed)
+	}
+}
+
+type ssntpReplyServer struct {
+	ssntp Server
+}
+
+func (server *ssntpReplyServer) ConnectNotify(uuid string, role Role) {
+}
+
+func (server *ssntpReplyServer) DisconnectNotify(uuid string, role Role) {
+}
+
+func (server *ssntpReplyServer) StatusNotify(uuid string, status Status, frame *Frame) {
+}
+
+func (server *ssntpReplyServer) CommandNotify(uuid string, command Command, frame *Frame) {
+	if string(frame.Payload) == "fail" {
+		server.ssntp.SendErrorReply(uuid, frame, StartFailure, frame.Payload)
+		return
+	}
+
+	server.ssntp.SendStatusReply(uuid, frame, READY, frame.Payload)
+}
+
+func (server *ssntpReplyServer) EventNotify(uuid string, event Event, frame *Frame) {
+}
+
+func (server *ssntpReplyServer) ErrorNotify(uuid string, error Error, frame *Frame) {
+}
+
+type ssntpReplyClient struct {
+	ssntp Client
+}
+
+func (client *ssntpReplyClient) ConnectNotify() {
+}
+
+func (client *ssntpReplyClient) DisconnectNotify() {
+}
+
+func (client *ssntpReplyClient) StatusNotify(status Status, frame *Frame) {
+}
+
+func (client *ssntpReplyClient) CommandNotify(command Command, frame *Frame) {
+	client.ssntp.SendStatusReply(frame, READY, frame.Payload)
+}
+
+func (client *ssntpReplyClient) EventNotify(event Event, frame *Frame) {
+}
+
+func (client *ssntpReplyClient) ErrorNotify(error Error, frame *Frame) {
+}
+
+func checkReply(t *testing.T, reply *Frame, err error, frameType Type, operand uint8, payload string) {
+	if err != nil {
+		t.Fatalf("Did not get a reply: %s", err)
+	}
+
+	if reply.Type != frameType || reply.Operand != operand {
+		t.Fatalf("Wrong reply %s", reply)
+	}
+
+	if string(reply.Payload) != payload {
+		t.Fatalf("Wrong reply payload %s", reply.Payload)
+	}
+}
+
+// Test SSNTP client command correlation
+//
+// Test that an SSNTP client gets the STATUS or ERROR frames
+// replying to the commands it sends with SendCommandAndWait.
+//
+// Test is expected to pass.
+func TestClientSendCommandAndWait(t *testing.T) {
+	var server ssntpReplyServer
+	var client ssntpClient
+
+	client.t = t
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+	defer client.ssntp.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reply, err := client.ssntp.SendCommandAndWait(ctx, START, []byte("foo"))
+	checkReply(t, reply, err, STATUS, (uint8)(READY), "foo")
+
+	reply, err = client.ssntp.SendCommandAndWait(ctx, START, []byte("fail"))
+	checkReply(t, reply, err, ERROR, (uint8)(StartFailure), "fail")
+}
+
+// Test SSNTP server command correlation
+//
+// Test that an SSNTP server gets the STATUS frame replying
+// to the command it sends with SendCommandAndWait.
+//
+// Test is expected to pass.
+func TestServerSendCommandAndWait(t *testing.T) {
+	var server ssntpEchoServer
+	var client ssntpReplyClient
+
+	server.t = t
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+	defer client.ssntp.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reply, err := server.ssntp.SendCommandAndWait(ctx, client.ssntp.UUID(), STOP, []byte("foo"))
+	checkReply(t, reply, err, STATUS, (uint8)(READY), "foo")
+}
+
+// Test SSNTP command correlation timeout
+//
+// Test that SendCommandAndWait times out when the command
+// receiver does not reply, and fails when the peer does not
+// support correlation IDs.
+//
+// Test is expected to pass.
+func TestSendCommandAndWaitTimeout(t *testing.T) {
+	var server ssntpEchoServer
+	var client ssntpClient
+
+	server.t = t
+	client.t = t
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = client.ssntp.SendCommandAndWait(ctx, START, []byte("foo"))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	client.ssntp.Close()
+
+	var uncorrelated ssntpClient
+	uncorrelated.t = t
+	clientConfig.Capabilities = []Capability{}
+
+	err = uncorrelated.ssntp.Dial(clientConfig, &uncorrelated)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+	defer uncorrelated.ssntp.Close()
+
+	_, err = uncorrelated.ssntp.SendCommandAndWait(context.Background(), START, []byte("foo"))
+	if err == nil {
+		t.Fatalf("SendCommandAndWait should fail without correlation support")
+	}
+}
+
+// Test SSNTP binary codec
+//
+// Test that a frame encoded with the binary codec decodes
+// back to the same frame.
+//
+// Test is expected to pass.
+func TestBinaryCodec(t *testing.T) {
+	var buf bytes.Buffer
+	var decoded Frame
+
+	now := time.Unix(0, time.Now().UnixNano())
+	node := uuid.Generate()
+	frame := Frame{
+		Major:         major | pathTraceEnabled,
+		Minor:         minor,
+		Type:          ERROR,
+		Operand:       (uint8)(StartFailure),
+		Origin:        uuid.Generate(),
+		PayloadLength: 3,
+		Payload:       []byte("foo"),
+		CorrelationID: 42,
+		Trace: &FrameTrace{
+			Label:          []byte("bar"),
+			StartTimestamp: now,
+			PathLength:     1,
+			Path: []Node{
+				{
+					UUID:        node[:],
+					Role:        AGENT,
+					TxTimestamp: now,
+				},
+			},
+		},
+	}
+
+	err := BinaryCodec.NewEncoder(&buf).Encode(&frame)
+	if err != nil {
+		t.Fatalf("Could not encode frame: %s", err)
+	}
+
+	err = BinaryCodec.NewDecoder(&buf).Decode(&decoded)
+	if err != nil {
+		t.Fatalf("Could not decode frame: %s", err)
+	}
+
+	if reflect.DeepEqual(frame, decoded) == false {
+		t.Fatalf("Decoded frame %s differs from %s", decoded, frame)
+	}
+
+	err = BinaryCodec.NewEncoder(&buf).Encode(&ConnectFrame{})
+	if err == nil {
+		t.Fatalf("Binary codec should not encode CONNECT frames")
+	}
+}
+
+// Test SSNTP binary codec frame size limit
+//
+// Test that a session using the binary codec rejects frames
+// claiming a length larger than the session maximum frame size.
+//
+// Test is expected to pass.
+func TestBinaryCodecMaxFrameSize(t *testing.T) {
+	var buf bytes.Buffer
+	var decoded Frame
+
+	binary.Write(&buf, binary.BigEndian, uint32(0xfffffff0))
+
+	session := newSession(nil, SERVER, AGENT, nil)
+	session.frames.r = bufio.NewReader(&buf)
+	session.setLimits(limitsConfig{maxFrameSize: 4096})
+	session.setCodec(BinaryCodec)
+
+	err := session.decoder.Decode(&decoded)
+	if err != errFrameTooLarge {
+		t.Fatalf("Expected frame too large error, got %v", err)
+	}
+}
+
+// Test SSNTP codec negotiation
+//
+// Test that an SSNTP client and server both supporting the
+// binary codec negotiate it and can exchange frames with it.
+//
+// Test is expected to pass.
+func TestConnectBinaryCodec(t *testing.T) {
+	var server ssntpEchoServer
+	var client ssntpClient
+
+	server.t = t
+	client.t = t
+	client.payload = []byte("foo")
+	client.staChannel = make(chan string)
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.Codecs = []Codec{BinaryCodec}
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.Codecs = []Codec{BinaryCodec}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+	defer client.ssntp.Close()
+
+	if hasCapability(client.ssntp.Capabilities(), "codec:binary") == false {
+		t.Fatalf("Binary codec not negotiated: %v", client.ssntp.Capabilities())
+	}
+
+	_, err = client.ssntp.SendStatus(READY, client.payload)
+	if err != nil {
+		t.Fatalf("Could not send status: %s", err)
+	}
+
+	select {
+	case status := <-client.staChannel:
+		if status != READY.String() {
+			t.Fatalf("Wrong echoed status %s", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive echoed status")
+	}
+}
+
+// Test SSNTP keepalive
+//
+// Test that SSNTP clients and servers exchanging keepalive
+// frames stay connected, and that keepalive frames are not
+// notified.
+//
+// Test is expected to pass.
+func TestKeepalive(t *testing.T) {
+	var server ssntpEchoServer
+
+	server.t = t
+	server.roleDisconnectChannel = make(chan string, 1)
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.KeepaliveInterval = 20 * time.Millisecond
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.KeepaliveInterval = 20 * time.Millisecond
+	clientConfig.KeepaliveMissedBeats = 2
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	client := newSSNTPReconnectClient()
+	err = client.ssntp.Dial(clientConfig, client)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+	defer client.ssntp.Close()
+
+	waitForNotification(t, client.connected, "connect")
+
+	select {
+	case <-client.disconnected:
+		t.Fatalf("Client disconnected")
+	case <-server.roleDisconnectChannel:
+		t.Fatalf("Server disconnected client")
+	case <-client.staChannel:
+		t.Fatalf("Keepalive frame notified")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+// Test SSNTP server dead peer detection
+//
+// Test that an SSNTP server with keepalive enabled disconnects
+// clients that no longer reply to its keepalive frames.
+//
+// Test is expected to pass.
+func TestKeepaliveServerDeadPeer(t *testing.T) {
+	var server ssntpEchoServer
+
+	if *transport != "tcp" {
+		t.Skip("Dead client test requires the tcp transport")
+	}
+
+	server.t = t
+	server.roleDisconnectChannel = make(chan string, 1)
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.KeepaliveInterval = 20 * time.Millisecond
+	serverConfig.KeepaliveMissedBeats = 2
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.setCerts()
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	// A client going through the SSNTP handshake and then
+	// never reading anything from the server.
+	conn, err := tls.Dial(*transport, fmt.Sprintf("%s:%d", defaultURL, port), prepareTLSConfig(clientConfig, false))
+	if err != nil {
+		t.Fatalf("Could not dial server: %s", err)
+	}
+	defer conn.Close()
+
+	var connected ConnectedFrame
+	clientUUID := uuid.Generate()
+	session := newSession(&clientUUID, AGENT, 0, conn)
+	_, err = session.Write(session.connectFrame([]Capability{KeepaliveCapability}))
+	if err != nil {
+		t.Fatalf("Could not send CONNECT: %s", err)
+	}
+
+	err = session.Read(&connected)
+	if err != nil {
+		t.Fatalf("Could not read CONNECTED: %s", err)
+	}
+
+	select {
+	case <-server.roleDisconnectChannel:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Server did not detect dead client")
+	}
+}
+
+// Test SSNTP client dead peer detection
+//
+// Test that an SSNTP client with keepalive enabled disconnects
+// from a server that no longer replies to its keepalive frames.
+//
+// Test is expected to pass.
+func TestKeepaliveClientDeadPeer(t *testing.T) {
+	if *transport != "tcp" {
+		t.Skip("Dead server test requires the tcp transport")
+	}
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.setCerts()
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.URIs = []string{fmt.Sprintf("%s:%d", defaultURL, port)}
+	clientConfig.KeepaliveInterval = 20 * time.Millisecond
+	clientConfig.KeepaliveMissedBeats = 2
+
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", port), prepareTLSConfig(serverConfig, true))
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+
+	// A server going through the SSNTP handshake and then
+	// never reading anything from the client.
+	go func() {
+		var connect ConnectFrame
+
+		conn, err := listener.Accept()
+		listener.Close()
+		if err != nil {
+			return
+		}
+
+		serverUUID := uuid.Generate()
+		session := newSession(&serverUUID, SERVER, AGENT, conn)
+		if session.Read(&connect) != nil {
+			return
+		}
+		session.setDest(connect.Source[:16])
+		session.capabilities = []Capability{KeepaliveCapability}
+		session.Write(session.connectedFrame(SERVER, nil))
+	}()
+
+	client := newSSNTPReconnectClient()
+	err = client.ssntp.Dial(clientConfig, client)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+	defer client.ssntp.Close()
+
+	waitForNotification(t, client.connected, "connect")
+	waitForNotification(t, client.disconnected, "disconnect")
+}
+
+func testMemTransport(t *testing.T, skipTLS bool) {
+	var server ssntpEchoServer
+	var clients [10]*ssntpReconnectClient
+
+	server.t = t
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.Transport = memTransport
+	serverConfig.SkipTLS = skipTLS
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	for i := range clients {
+		clientConfig, err := buildTestConfig(AGENT)
+		if err != nil {
+			t.Fatalf("Could not build a test config")
+		}
+		clientConfig.Transport = memTransport
+		clientConfig.SkipTLS = skipTLS
+		clientConfig.UUID = uuid.Generate().String()
+
+		clients[i] = newSSNTPReconnectClient()
+		err = clients[i].ssntp.Dial(clientConfig, clients[i])
+		if err != nil {
+			t.Fatalf("Failed to connect %s", err)
+		}
+		defer clients[i].ssntp.Close()
+	}
+
+	for i, client := range clients {
+		payload := fmt.Sprintf("client %d", i)
+		_, err := client.ssntp.SendStatus(READY, []byte(payload))
+		if err != nil {
+			t.Fatalf("Could not send status: %s", err)
+		}
+
+		select {
+		case echoed := <-client.staChannel:
+			if string(echoed) != payload {
+				t.Fatalf("Wrong echoed payload %s", echoed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Did not receive echoed status")
+		}
+	}
+}
+
+// Test SSNTP in-memory transport
+//
+// Test that several SSNTP clients can connect to and exchange
+// frames with an SSNTP server through the TLS protected
+// in-memory transport.
+//
+// Test is expected to pass.
+func TestMemTransport(t *testing.T) {
+	testMemTransport(t, false)
+}
+
+// Test SSNTP in-memory transport without TLS
+//
+// Test that several SSNTP clients can connect to and exchange
+// frames with an SSNTP server through the in-memory transport,
+// with TLS disabled.
+//
+// Test is expected to pass.
+func TestMemTransportSkipTLS(t *testing.T) {
+	testMemTransport(t, true)
+}
+
+type captureBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *captureBuffer) Write(p []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.buf.Write(p)
+}
+
+func (c *captureBuffer) records(t *testing.T) []CaptureRecord {
+	c.Lock()
+	defer c.Unlock()
+
+	records, err := ReadCapture(bytes.NewReader(c.buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Could not read capture: %s", err)
+	}
+
+	return records
+}
+
+func checkCaptureRecord(t *testing.T, records []CaptureRecord, direction CaptureDirection, peerRole Role, payload string) {
+	for _, r := range records {
+		if r.Direction != direction || r.Type != STATUS || (Status)(r.Operand) != READY {
+			continue
+		}
+
+		if r.PeerRole != peerRole.String() {
+			t.Fatalf("Wrong captured peer role %s, expected %s", r.PeerRole, peerRole.String())
+		}
+
+		if r.Payload != payload {
+			t.Fatalf("Wrong captured payload %s", r.Payload)
+		}
+
+		if r.Name != "STATUS READY" {
+			t.Fatalf("Wrong captured frame name %s", r.Name)
+		}
+
+		return
+	}
+
+	t.Fatalf("Could not find %s READY status in capture", direction)
+}
+
+// Test SSNTP frame capture
+//
+// Test that SSNTP clients and servers configured with a capture
+// writer record the frames they send and receive, with their
+// peer role and payload.
+//
+// Test is expected to pass.
+func TestCapture(t *testing.T) {
+	var server ssntpEchoServer
+	var serverCapture, clientCapture captureBuffer
+	payload := "captured status"
+
+	server.t = t
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.Transport = memTransport
+	serverConfig.Capture = &serverCapture
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.Transport = memTransport
+	clientConfig.Capture = &clientCapture
+
+	client := newSSNTPReconnectClient()
+	err = client.ssntp.Dial(clientConfig, client)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+	waitForNotification(t, client.connected, "connect")
+
+	_, err = client.ssntp.SendStatus(READY, []byte(payload))
+	if err != nil {
+		t.Fatalf("Could not send status: %s", err)
+	}
+
+	select {
+	case <-client.staChannel:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive echoed status")
+	}
+
+	client.ssntp.Close()
+	server.ssntp.Stop()
+
+	records := clientCapture.records(t)
+	checkCaptureRecord(t, records, CaptureTx, SERVER, payload)
+	checkCaptureRecord(t, records, CaptureRx, SERVER, payload)
+	if records[0].Peer != server.ssntp.UUID() || records[0].UUID != client.ssntp.UUID() {
+		t.Fatalf("Wrong captured UUIDs %s %s", records[0].UUID, records[0].Peer)
+	}
+
+	records = serverCapture.records(t)
+	checkCaptureRecord(t, records, CaptureRx, AGENT, payload)
+	checkCaptureRecord(t, records, CaptureTx, AGENT, payload)
+}
+
+// Test SSNTP capture replay
+//
+// Test that an SSNTP client replays the captured frames with
+// the requested direction, and only those.
+//
+// Test is expected to pass.
+func TestCaptureReplay(t *testing.T) {
+	capture := `{"timestamp":"2016-06-01T10:00:00Z","direction":"tx","type":1,"operand":1,"name":"STATUS READY","payload":"replayed status"}
+{"timestamp":"2016-06-01T10:00:00.01Z","direction":"rx","type":1,"operand":1,"name":"STATUS READY","payload":"received status"}
+{"timestamp":"2016-06-01T10:00:00.02Z","direction":"tx","type":0,"operand":10,"name":"COMMAND PING"}
+`
+	records, err := ReadCapture(strings.NewReader(capture))
+	if err != nil {
+		t.Fatalf("Could not read capture: %s", err)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("Wrong number of capture records %d", len(records))
+	}
+
+	server := startEchoServer(t)
+	defer server.ssntp.Stop()
+
+	client := dialReconnectClient(t, 0)
+	defer client.ssntp.Close()
+
+	err = client.ssntp.Replay(records, CaptureTx, 1)
+	if err != nil {
+		t.Fatalf("Could not replay capture: %s", err)
+	}
+
+	select {
+	case echoed := <-client.staChannel:
+		if string(echoed) != "replayed status" {
+			t.Fatalf("Wrong replayed payload %s", echoed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive echoed status")
+	}
+
+	select {
+	case echoed := <-client.staChannel:
+		t.Fatalf("Unexpected replayed payload %s", echoed)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func parseTestCert(t *testing.T, certPEM string) (*x509.Certificate, *rsa.PrivateKey) {
+	var cert *x509.Certificate
+	var key *rsa.PrivateKey
+
+	rest := []byte(certPEM)
+	for {
+		var block *pem.Block
+		var err error
+
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err = x509.ParseCertificate(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		}
+
+		if err != nil {
+			t.Fatalf("Could not parse test certificate: %s", err)
+		}
+	}
+
+	return cert, key
+}
+
+func writeTestFile(t *testing.T, data []byte) string {
+	f, err := ioutil.TempFile("", "ssntp-test")
+	if err != nil {
+		t.Fatalf("Could not create temporary file: %s", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	if err != nil {
+		t.Fatalf("Could not write temporary file: %s", err)
+	}
+
+	return f.Name()
+}
+
+// writeTestCRL writes a CRL signed by signerPEM, revoking all revokedPEM
+// certificates.
+func writeTestCRL(t *testing.T, signerPEM string, revokedPEM ...string) string {
+	var revoked []pkix.RevokedCertificate
+
+	ca, key := parseTestCert(t, signerPEM)
+
+	for _, r := range revokedPEM {
+		cert, _ := parseTestCert(t, r)
+		revoked = append(revoked, pkix.RevokedCertificate{
+			SerialNumber:   cert.SerialNumber,
+			RevocationTime: time.Now(),
+		})
+	}
+
+	crl, err := ca.CreateCRL(crand.Reader, key, revoked, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Could not create CRL: %s", err)
+	}
+
+	return writeTestFile(t, crl)
+}
+
+func testCertSerial(t *testing.T, certPEM string) string {
+	cert, _ := parseTestCert(t, certPEM)
+
+	return fmt.Sprintf("0x%x", cert.SerialNumber)
+}
+
+// Test SSNTP certificate revocation list
+//
+// Test that an SSNTP server rejects clients whose certificate
+// has been revoked by its CRL, and that CRLs not signed by the
+// CA are rejected.
+//
+// Test is expected to pass.
+func TestRevokedClientCRL(t *testing.T) {
+	var server ssntpEchoServer
+	var client ssntpClient
+
+	server.t = t
+	client.t = t
+
+	crl := writeTestCRL(t, testCertScheduler, testCertAgent)
+	defer os.Remove(crl)
+
+	invalidCRL := writeTestCRL(t, testCertAgent, testCertAgent)
+	defer os.Remove(invalidCRL)
+
+	_, err := loadCRL(invalidCRL, path.Join(tempCertPath, "CACert"))
+	if err == nil {
+		t.Fatalf("CRL not signed by the CA should be rejected")
+	}
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.CRL = crl
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err == nil {
+		client.ssntp.Close()
+		t.Fatalf("Client with a revoked certificate should not connect")
+	}
+}
+
+// Test SSNTP server deny-list reload
+//
+// Test that reloading an SSNTP server deny-list drops the
+// connections from clients whose certificate has been revoked.
+//
+// Test is expected to pass.
+func TestRevokedClientDenyListReload(t *testing.T) {
+	denyList := writeTestFile(t, []byte("# Revoked certificates\n"))
+	defer os.Remove(denyList)
+
+	server := &ssntpEchoServer{t: t}
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.DenyList = denyList
+
+	err = server.ssntp.ServeThreadSync(serverConfig, server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	client := dialReconnectClient(t, 0)
+	defer client.ssntp.Close()
+
+	err = ioutil.WriteFile(denyList, []byte(testCertSerial(t, testCertAgent)+"\n"), 0644)
+	if err != nil {
+		t.Fatalf("Could not update deny-list: %s", err)
+	}
+
+	err = server.ssntp.ReloadRevocationList()
+	if err != nil {
+		t.Fatalf("Could not reload deny-list: %s", err)
+	}
+
+	waitForNotification(t, client.disconnected, "disconnect")
+
+	_, err = client.ssntp.SendStatus(READY, []byte("revoked"))
+	if err == nil {
+		t.Fatalf("Revoked client should not be able to send frames")
+	}
+}
+
+// Test SSNTP client deny-list reload
+//
+// Test that reloading an SSNTP client deny-list drops the
+// connection to a server whose certificate has been revoked.
+//
+// Test is expected to pass.
+func TestRevokedServerDenyListReload(t *testing.T) {
+	denyList := writeTestFile(t, nil)
+	defer os.Remove(denyList)
+
+	server := startEchoServer(t)
+	defer server.ssntp.Stop()
+
+	client := newSSNTPReconnectClient()
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.DenyList = denyList
+
+	err = client.ssntp.Dial(clientConfig, client)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+	defer client.ssntp.Close()
+	waitForNotification(t, client.connected, "connect")
+
+	serverCert, _ := parseTestCert(t, testCertServer)
+	serial := fmt.Sprintf("%d\n", serverCert.SerialNumber)
+	err = ioutil.WriteFile(denyList, []byte(serial), 0644)
+	if err != nil {
+		t.Fatalf("Could not update deny-list: %s", err)
+	}
+
+	err = client.ssntp.ReloadRevocationList()
+	if err != nil {
+		t.Fatalf("Could not reload deny-list: %s", err)
+	}
+
+	waitForNotification(t, client.disconnected, "disconnect")
+}
+
+// Test SSNTP deny-list serial numbers parsing
+//
+// Test that deny-list serial numbers can be decimal, hexadecimal
+// or colon separated hexadecimal bytes.
+//
+// Test is expected to pass.
+func TestDenyListSerials(t *testing.T) {
+	denyList := writeTestFile(t, []byte("# comment\n\n4660\n0x1234\n12:34\n"))
+	defer os.Remove(denyList)
+
+	serials, err := loadDenyList(denyList)
+	if err != nil {
+		t.Fatalf("Could not load deny-list: %s", err)
+	}
+
+	if len(serials) != 3 {
+		t.Fatalf("Wrong number of serials %d", len(serials))
+	}
+
+	for _, serial := range serials {
+		if serial.Int64() != 0x1234 {
+			t.Fatalf("Wrong serial %s", serial)
+		}
+	}
+
+	invalid := writeTestFile(t, []byte("0xzz\n"))
+	defer os.Remove(invalid)
+
+	_, err = loadDenyList(invalid)
+	if err == nil {
+		t.Fatalf("Invalid serial should not be accepted")
+	}
+}
+
+// selfSignedServerCert returns a PEM encoded SERVER role certificate and
+// private key, that is not signed by the test CA.
+func selfSignedServerCert(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Could not generate key: %s", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"SSNTP test"}},
+		NotBefore:             time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		UnknownExtKeyUsage:    []asn1.ObjectIdentifier{RoleServerOID},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(crand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Could not create certificate: %s", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	return append(certPEM, keyPEM...)
+}
+
+func testHandshake(t *testing.T) error {
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	conn, err := tls.Dial(*transport, fmt.Sprintf("%s:%d", defaultURL, port), prepareTLSConfig(clientConfig, false))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The server only fails the handshake when verifying the
+	// client certificate, which we notice on the next read.
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return nil
+	}
+
+	return err
+}
+
+// Test SSNTP server certificates reload
+//
+// Test that reloading an SSNTP server certificates applies to new
+// connections only, and that certificates with a different role
+// are rejected.
+//
+// Test is expected to pass.
+func TestServerReloadCertificates(t *testing.T) {
+	if *transport != "tcp" {
+		t.Skip("Certificates reload test requires the tcp transport")
+	}
+
+	server := &ssntpEchoServer{t: t}
+
+	caPath := writeTestFile(t, []byte(testCACert))
+	defer os.Remove(caPath)
+	certPath := writeTestFile(t, []byte(testCertServer))
+	defer os.Remove(certPath)
+
+	serverConfig := &Config{
+		Transport: *transport,
+		CAcert:    caPath,
+		Cert:      certPath,
+	}
+
+	err := server.ssntp.ServeThreadSync(serverConfig, server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	client := dialReconnectClient(t, 0)
+	defer client.ssntp.Close()
+
+	err = testHandshake(t)
+	if err != nil {
+		t.Fatalf("Handshake failed with the initial certificates: %s", err)
+	}
+
+	selfSigned := selfSignedServerCert(t)
+	for _, f := range []string{caPath, certPath} {
+		err = ioutil.WriteFile(f, selfSigned, 0644)
+		if err != nil {
+			t.Fatalf("Could not update certificate: %s", err)
+		}
+	}
+
+	err = server.ssntp.ReloadCertificates()
+	if err != nil {
+		t.Fatalf("Could not reload certificates: %s", err)
+	}
+
+	err = testHandshake(t)
+	if err == nil {
+		t.Fatalf("Handshake should fail with the reloaded certificates")
+	}
+
+	// The established session must not be affected
+	_, err = client.ssntp.SendStatus(READY, []byte("reloaded"))
+	if err != nil {
+		t.Fatalf("Could not send status: %s", err)
+	}
+
+	select {
+	case <-client.staChannel:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive echoed status")
+	}
+
+	err = ioutil.WriteFile(certPath, []byte(testCertAgent), 0644)
+	if err != nil {
+		t.Fatalf("Could not update certificate: %s", err)
+	}
+
+	err = server.ssntp.ReloadCertificates()
+	if err == nil {
+		t.Fatalf("Certificates with a different role should not be reloaded")
+	}
+
+	for f, cert := range map[string]string{caPath: testCACert, certPath: testCertServer} {
+		err = ioutil.WriteFile(f, []byte(cert), 0644)
+		if err != nil {
+			t.Fatalf("Could not update certificate: %s", err)
+		}
+	}
+
+	err = server.ssntp.ReloadCertificates()
+	if err != nil {
+		t.Fatalf("Could not reload certificates: %s", err)
+	}
+
+	err = testHandshake(t)
+	if err != nil {
+		t.Fatalf("Handshake failed with the restored certificates: %s", err)
+	}
+}
+
+// Test SSNTP client certificates reload
+//
+// Test that an SSNTP client reloads certificates with the same
+// role and keeps its connection to the server, and that
+// certificates with a different role are rejected.
+//
+// Test is expected to pass.
+func TestClientReloadCertificates(t *testing.T) {
+	var client Client
+
+	err := client.ReloadCertificates()
+	if err == nil {
+		t.Fatalf("Certificates should not be reloaded before dialing")
+	}
+
+	server := startEchoServer(t)
+	defer server.ssntp.Stop()
+
+	reconnectClient := dialReconnectClient(t, 0)
+	defer reconnectClient.ssntp.Close()
+
+	err = reconnectClient.ssntp.ReloadCertificates()
+	if err != nil {
+		t.Fatalf("Could not reload certificates: %s", err)
+	}
+
+	serverCert := writeTestFile(t, []byte(testCertServer))
+	defer os.Remove(serverCert)
+	reconnectClient.ssntp.certs.certPath = serverCert
+
+	err = reconnectClient.ssntp.ReloadCertificates()
+	if err == nil {
+		t.Fatalf("Certificates with a different role should not be reloaded")
+	}
+
+	_, err = reconnectClient.ssntp.SendStatus(READY, []byte("reloaded"))
+	if err != nil {
+		t.Fatalf("Could not send status: %s", err)
+	}
+
+	select {
+	case <-reconnectClient.staChannel:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive echoed status")
+	}
+}
+
+func scrapeMetrics(t *testing.T, handler http.Handler) string {
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatalf("Could not create metrics request: %s", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Wrong metrics status code %d", recorder.Code)
+	}
+
+	return recorder.Body.String()
+}
+
+func checkMetric(t *testing.T, metrics string, metric string) {
+	if !strings.Contains(metrics, metric) {
+		t.Fatalf("Could not find %s in metrics:\n%s", metric, metrics)
+	}
+}
+
+// Test SSNTP metrics
+//
+// Test that SSNTP servers and clients count the frames they send
+// and receive per session, and expose them in the Prometheus text
+// format.
+//
+// Test is expected to pass.
+func TestMetrics(t *testing.T) {
+	server := startEchoServer(t)
+	defer server.ssntp.Stop()
+
+	client := dialReconnectClient(t, 0)
+	defer client.ssntp.Close()
+
+	_, err := client.ssntp.SendStatus(READY, []byte("metrics"))
+	if err != nil {
+		t.Fatalf("Could not send status: %s", err)
+	}
+
+	select {
+	case <-client.staChannel:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive echoed status")
+	}
+
+	labels := fmt.Sprintf(`peer="%s",role="CNAgent-"`, client.ssntp.UUID())
+	metrics := scrapeMetrics(t, server.ssntp.MetricsHandler())
+	checkMetric(t, metrics, "ssntp_sessions 1\n")
+	checkMetric(t, metrics, "ssntp_frames_received_total{"+labels+`,type="STATUS",operand="READY"} 1`)
+	checkMetric(t, metrics, "ssntp_frames_sent_total{"+labels+`,type="STATUS",operand="READY"} 1`)
+	checkMetric(t, metrics, "ssntp_frames_sent_total{"+labels+`,type="STATUS",operand="CONNECTED"} 1`)
+	checkMetric(t, metrics, "ssntp_decode_errors_total{"+labels+"} 0")
+	checkMetric(t, metrics, "ssntp_forward_decisions_total{"+labels+`,decision="forward"} 0`)
+	checkMetric(t, metrics, "ssntp_write_latency_seconds_count{"+labels+"} 2")
+	checkMetric(t, metrics, "ssntp_write_latency_seconds_bucket{"+labels+`,le="+Inf"} 2`)
+
+	if strings.Contains(metrics, "ssntp_bytes_sent_total{"+labels+"} 0\n") {
+		t.Fatalf("Sent bytes not counted:\n%s", metrics)
+	}
+
+	labels = fmt.Sprintf(`peer="%s",role="Server-"`, server.ssntp.UUID())
+	metrics = scrapeMetrics(t, client.ssntp.MetricsHandler())
+	checkMetric(t, metrics, "ssntp_sessions 1\n")
+	checkMetric(t, metrics, "ssntp_frames_sent_total{"+labels+`,type="STATUS",operand="READY"} 1`)
+	checkMetric(t, metrics, "ssntp_frames_received_total{"+labels+`,type="STATUS",operand="READY"} 1`)
+
+	client.ssntp.Close()
+	waitForSessionCount(t, server, 0)
+
+	metrics = scrapeMetrics(t, server.ssntp.MetricsHandler())
+	checkMetric(t, metrics, "ssntp_sessions 0\n")
+}
+
+func waitForSessionCount(t *testing.T, server *ssntpEchoServer, count int) {
+	for i := 0; i < 100; i++ {
+		server.ssntp.sessionMutex.RLock()
+		n := len(server.ssntp.sessions)
+		server.ssntp.sessionMutex.RUnlock()
+
+		if n == count {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Server did not reach %d sessions", count)
+}
+
+// Test SSNTP server authorization policy
+//
+// Test that an SSNTP server with an authorization policy accepts
+// the frames a client role is allowed to send, and rejects the
+// other ones with an InvalidFrameType error.
+//
+// Test is expected to pass.
+func TestAuthorization(t *testing.T) {
+	server := &ssntpEchoServer{t: t}
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.Authorization = []AuthorizationRule{
+		{Role: AGENT, Operand: STATUS},
+		{Role: AGENT, Operand: STATS},
+		{Role: Controller, Operand: START},
+	}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	client := dialReconnectClient(t, 0)
+	defer client.ssntp.Close()
+
+	_, err = client.ssntp.SendStatus(READY, []byte("authorized"))
+	if err != nil {
+		t.Fatalf("Could not send status: %s", err)
+	}
+
+	select {
+	case echoed := <-client.staChannel:
+		if string(echoed) != "authorized" {
+			t.Fatalf("Wrong echoed payload %s", echoed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive echoed status")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reply, err := client.ssntp.SendCommandAndWait(ctx, START, []byte("unauthorized"))
+	if err != nil {
+		t.Fatalf("Did not get a reply: %s", err)
+	}
+
+	if reply.Type != ERROR || (Error)(reply.Operand) != InvalidFrameType {
+		t.Fatalf("Unauthorized frame should be rejected, got %s %d", reply.Type, reply.Operand)
+	}
+}
+
+// Test SSNTP server invalid authorization policy
+//
+// Test that an SSNTP server does not start with an authorization
+// rule that is not a frame type or operand.
+//
+// Test is expected to pass.
+func TestInvalidAuthorization(t *testing.T) {
+	var server ssntpEchoServer
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.Authorization = []AuthorizationRule{
+		{Role: AGENT, Operand: "START"},
+	}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err == nil {
+		server.ssntp.Stop()
+		t.Fatalf("Server should not start with an invalid authorization policy")
+	}
+}
+
+type ssntpBroadcastServer struct {
+	ssntpServer
+	failures chan string
+}
+
+func (server *ssntpBroadcastServer) EventForward(uuid string, event Event, frame *Frame) (dest ForwardDestination) {
+	switch event {
+	case TenantAdded:
+		dest.AddRoleRecipient(AGENT)
+	case TenantRemoved:
+		dest.AddGroupRecipient("launchers")
+	}
+
+	return
+}
+
+func (server *ssntpBroadcastServer) DeliveryFailure(uuid string, frame *Frame, err error) {
+	server.failures <- uuid
+}
+
+type ssntpEventClient struct {
+	evtChannel chan Event
+}
+
+func (client *ssntpEventClient) ConnectNotify() {
+}
+
+func (client *ssntpEventClient) DisconnectNotify() {
+}
+
+func (client *ssntpEventClient) StatusNotify(status Status, frame *Frame) {
+}
+
+func (client *ssntpEventClient) CommandNotify(command Command, frame *Frame) {
+}
+
+func (client *ssntpEventClient) EventNotify(event Event, frame *Frame) {
+	client.evtChannel <- event
+}
+
+func (client *ssntpEventClient) ErrorNotify(error Error, frame *Frame) {
+}
+
+func dialEventClient(t *testing.T, role Role, uuid string) (*Client, *ssntpEventClient) {
+	var client Client
+	notifier := &ssntpEventClient{evtChannel: make(chan Event, 4)}
+
+	clientConfig, err := buildTestConfig(role)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.UUID = uuid
+
+	err = client.Dial(clientConfig, notifier)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+
+	return &client, notifier
+}
+
+func waitForEvent(t *testing.T, c chan Event, event Event) {
+	select {
+	case e := <-c:
+		if e != event {
+			t.Fatalf("Received %s instead of %s", e, event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive %s", event)
+	}
+}
+
+func newBroadcastServer(t *testing.T) *ssntpBroadcastServer {
+	server := &ssntpBroadcastServer{failures: make(chan string, 4)}
+	server.t = t
+
+	return server
+}
+
+func (server *ssntpBroadcastServer) start(t *testing.T, rules []FrameForwardRule) {
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.ForwardRules = rules
+
+	err = server.ssntp.ServeThreadSync(serverConfig, server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+// Test SSNTP role broadcast forwarding
+//
+// Test that an SSNTP event forwarder can broadcast a frame to
+// all the clients playing a given role, and that the frame is
+// not sent back to its originator.
+//
+// Test is expected to pass.
+func TestForwardRoleBroadcast(t *testing.T) {
+	agentUUIDs := []string{
+		"6a1f3c2e-8d4b-4f7a-9c5e-2b7d1e0f3a94",
+		"a4ba1b0b-1d8a-4b9d-8a3b-0a5a5e1a6b7e",
+	}
+
+	server := newBroadcastServer(t)
+	server.start(t, []FrameForwardRule{
+		{
+			Operand:      TenantAdded,
+			EventForward: server,
+		},
+	})
+	defer server.ssntp.Stop()
+
+	var agents []*ssntpEventClient
+	for _, uuid := range agentUUIDs {
+		client, notifier := dialEventClient(t, AGENT, uuid)
+		defer client.Close()
+		agents = append(agents, notifier)
+	}
+
+	sender, senderNotifier := dialEventClient(t, AGENT, "0f8b6f4c-6b1b-4e3c-9a4a-4b8a2f0c6d51")
+	defer sender.Close()
+
+	_, err := sender.SendEvent(TenantAdded, []byte("tenant"))
+	if err != nil {
+		t.Fatalf("Could not send event %s", err)
+	}
+
+	for _, agent := range agents {
+		waitForEvent(t, agent.evtChannel, TenantAdded)
+	}
+
+	select {
+	case e := <-senderNotifier.evtChannel:
+		t.Fatalf("Sender received its own %s event", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Test SSNTP static group forwarding
+//
+// Test that a FrameForwardRule with a DestGroup forwards frames
+// to all the members of an SSNTP server group, and only to them.
+//
+// Test is expected to pass.
+func TestForwardStaticGroup(t *testing.T) {
+	memberUUID := "6a1f3c2e-8d4b-4f7a-9c5e-2b7d1e0f3a94"
+	otherUUID := "a4ba1b0b-1d8a-4b9d-8a3b-0a5a5e1a6b7e"
+
+	server := newBroadcastServer(t)
+	server.start(t, []FrameForwardRule{
+		{
+			Operand:   NodeConnected,
+			DestGroup: "launchers",
+		},
+	})
+	defer server.ssntp.Stop()
+
+	server.ssntp.AddGroupMember("launchers", memberUUID)
+
+	member, memberNotifier := dialEventClient(t, AGENT, memberUUID)
+	defer member.Close()
+
+	other, otherNotifier := dialEventClient(t, AGENT, otherUUID)
+	defer other.Close()
+
+	sender, _ := dialEventClient(t, Controller, controllerUUID)
+	defer sender.Close()
+
+	_, err := sender.SendEvent(NodeConnected, []byte("node"))
+	if err != nil {
+		t.Fatalf("Could not send event %s", err)
+	}
+
+	waitForEvent(t, memberNotifier.evtChannel, NodeConnected)
+
+	select {
+	case e := <-otherNotifier.evtChannel:
+		t.Fatalf("Non group member received %s event", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Test SSNTP forwarding delivery failures
+//
+// Test that an SSNTP event forwarder implementing DeliveryFailureNotifier
+// is notified about the group members a frame could not be forwarded to,
+// while the connected members still receive it.
+//
+// Test is expected to pass.
+func TestForwardDeliveryFailure(t *testing.T) {
+	memberUUID := "6a1f3c2e-8d4b-4f7a-9c5e-2b7d1e0f3a94"
+	missingUUID := "a4ba1b0b-1d8a-4b9d-8a3b-0a5a5e1a6b7e"
+
+	server := newBroadcastServer(t)
+	server.start(t, []FrameForwardRule{
+		{
+			Operand:      TenantRemoved,
+			EventForward: server,
+		},
+	})
+	defer server.ssntp.Stop()
+
+	server.ssntp.AddGroupMember("launchers", memberUUID)
+	server.ssntp.AddGroupMember("launchers", missingUUID)
+
+	members := server.ssntp.GroupMembers("launchers")
+	if len(members) != 2 {
+		t.Fatalf("Wrong group members %v", members)
+	}
+
+	member, memberNotifier := dialEventClient(t, AGENT, memberUUID)
+	defer member.Close()
+
+	sender, _ := dialEventClient(t, Controller, controllerUUID)
+	defer sender.Close()
+
+	_, err := sender.SendEvent(TenantRemoved, []byte("tenant"))
+	if err != nil {
+		t.Fatalf("Could not send event %s", err)
+	}
+
+	waitForEvent(t, memberNotifier.evtChannel, TenantRemoved)
+
+	select {
+	case uuid := <-server.failures:
+		if uuid != missingUUID {
+			t.Fatalf("Wrong delivery failure UUID %s", uuid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive the delivery failure notification")
+	}
+
+	server.ssntp.RemoveGroupMember("launchers", missingUUID)
+	members = server.ssntp.GroupMembers("launchers")
+	if len(members) != 1 || members[0] != memberUUID {
+		t.Fatalf("Wrong group members %v", members)
+	}
+}
+
+// Test SSNTP client dialing with a context
+//
+// Test that DialContext gives up when its context deadline expires
+// before any server shows up, and that the client can then be dialed
+// again.
+//
+// Test is expected to pass.
+func TestDialContextTimeout(t *testing.T) {
+	client := newSSNTPReconnectClient()
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.Backoff = &BackoffConfig{
+		InitialDelay: 50 * time.Millisecond,
+		MaxDelay:     100 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	err = client.ssntp.DialContext(ctx, clientConfig, client)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected dial error %v", err)
+	}
+
+	server := startEchoServer(t)
+	defer server.ssntp.Stop()
+
+	err = client.ssntp.Dial(clientConfig, client)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+	defer client.ssntp.Close()
+
+	waitForNotification(t, client.connected, "connect")
+}
+
+// Test SSNTP frames sending with a context
+//
+// Test that SSNTP clients and servers do not send frames with
+// an already cancelled context, and send them with a live one.
+//
+// Test is expected to pass.
+func TestSendContext(t *testing.T) {
+	server := startEchoServer(t)
+	defer server.ssntp.Stop()
+
+	client := dialReconnectClient(t, 0)
+	defer client.ssntp.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.ssntp.SendStatusContext(cancelled, READY, []byte("cancelled"))
+	if err != context.Canceled {
+		t.Fatalf("Unexpected client send error %v", err)
+	}
+
+	_, err = server.ssntp.SendStatusContext(cancelled, client.ssntp.UUID(), READY, []byte("cancelled"))
+	if err != context.Canceled {
+		t.Fatalf("Unexpected server send error %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = client.ssntp.SendStatusContext(ctx, READY, []byte("sent"))
+	if err != nil {
+		t.Fatalf("Could not send status %s", err)
+	}
+
+	select {
+	case payload := <-client.staChannel:
+		if string(payload) != "sent" {
+			t.Fatalf("Wrong status payload %s", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive the echoed status")
+	}
+}
+
+// Test SSNTP server stopping with a context
+//
+// Test that ServeContext stops the server and returns when its
+// context is cancelled, disconnecting its clients.
+//
+// Test is expected to pass.
+func TestServeContext(t *testing.T) {
+	server := &ssntpEchoServer{t: t}
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.SyncChannel = make(chan error, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ssntp.ServeContext(ctx, serverConfig, server)
+	}()
+
+	err = <-serverConfig.SyncChannel
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	client := dialReconnectClient(t, 0)
+	defer client.ssntp.Close()
+
+	cancel()
+
+	select {
+	case err := <-served:
+		if err != context.Canceled {
+			t.Fatalf("Unexpected serve error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Server did not stop")
+	}
+
+	waitForNotification(t, client.disconnected, "disconnect")
+}
+
+func startLimitedServer(t *testing.T, limit func(config *Config)) *ssntpEchoServer {
+	server := &ssntpEchoServer{t: t}
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	limit(serverConfig)
+
+	err = server.ssntp.ServeThreadSync(serverConfig, server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	return server
+}
+
+func dialRawTLS(t *testing.T) net.Conn {
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.setCerts()
+
+	conn, err := tls.Dial(*transport, fmt.Sprintf("%s:%d", defaultURL, port), prepareTLSConfig(clientConfig, false))
+	if err != nil {
+		t.Fatalf("Could not dial server: %s", err)
+	}
+
+	return conn
+}
+
+// dialRawSession goes through the SSNTP handshake without an SSNTP
+// client, for tests to send arbitrary frames to a server.
+func dialRawSession(t *testing.T) *session {
+	var connected ConnectedFrame
+
+	conn := dialRawTLS(t)
+	clientUUID := uuid.Generate()
+	session := newSession(&clientUUID, AGENT, 0, conn)
+	_, err := session.Write(session.connectFrame(nil))
+	if err != nil {
+		t.Fatalf("Could not send CONNECT: %s", err)
+	}
+
+	err = session.Read(&connected)
+	if err != nil {
+		t.Fatalf("Could not read CONNECTED: %s", err)
+	}
+
+	return session
+}
+
+func waitForFrameTooLarge(t *testing.T, session *session) {
+	var frame Frame
+
+	session.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	err := session.Read(&frame)
+	if err != nil {
+		t.Fatalf("Could not read error frame: %s", err)
+	}
+
+	if frame.Type != ERROR || (Error)(frame.Operand) != FrameTooLarge {
+		t.Fatalf("Unexpected %s %s frame", frame.Type, operandString(frame.Type, frame.Operand))
+	}
+
+	err = session.Read(&frame)
+	if err == nil {
+		t.Fatalf("Server did not close the connection")
+	}
+}
+
+// Test SSNTP server maximum frame size
+//
+// Test that an SSNTP server accepts frames up to its maximum frame
+// size, and disconnects clients sending larger frames with a
+// FrameTooLarge error.
+//
+// Test is expected to pass.
+func TestServerMaxFrameSize(t *testing.T) {
+	var frame Frame
+
+	if *transport != "tcp" {
+		t.Skip("Frame size test requires the tcp transport")
+	}
+
+	server := startLimitedServer(t, func(config *Config) {
+		config.MaxFrameSize = 4096
+	})
+	defer server.ssntp.Stop()
+
+	session := dialRawSession(t)
+	defer session.conn.Close()
+
+	_, err := session.Write(session.statusFrame(READY, []byte("small"), nil))
+	if err != nil {
+		t.Fatalf("Could not send status: %s", err)
+	}
+
+	session.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	err = session.Read(&frame)
+	if err != nil || string(frame.Payload) != "small" {
+		t.Fatalf("Did not receive the echoed status: %v", err)
+	}
+
+	_, err = session.Write(session.statusFrame(READY, make([]byte, 8192), nil))
+	if err != nil {
+		t.Fatalf("Could not send status: %s", err)
+	}
+
+	waitForFrameTooLarge(t, session)
+}
+
+// Test SSNTP server maximum payload size
+//
+// Test that an SSNTP server disconnects clients sending frames with
+// a payload larger than its maximum payload size, with a FrameTooLarge
+// error.
+//
+// Test is expected to pass.
+func TestServerMaxPayloadSize(t *testing.T) {
+	if *transport != "tcp" {
+		t.Skip("Payload size test requires the tcp transport")
+	}
+
+	server := startLimitedServer(t, func(config *Config) {
+		config.MaxPayloadSize = 16
+	})
+	defer server.ssntp.Stop()
+
+	session := dialRawSession(t)
+	defer session.conn.Close()
+
+	_, err := session.Write(session.eventFrame(TenantAdded, make([]byte, 32), nil))
+	if err != nil {
+		t.Fatalf("Could not send event: %s", err)
+	}
+
+	waitForFrameTooLarge(t, session)
+}
+
+// Test SSNTP server handshake timeout
+//
+// Test that an SSNTP server closes connections that do not send
+// their CONNECT frame within the handshake timeout.
+//
+// Test is expected to pass.
+func TestServerHandshakeTimeout(t *testing.T) {
+	if *transport != "tcp" {
+		t.Skip("Handshake timeout test requires the tcp transport")
+	}
+
+	server := startLimitedServer(t, func(config *Config) {
+		config.HandshakeTimeout = 100 * time.Millisecond
+	})
+	defer server.ssntp.Stop()
+
+	conn := dialRawTLS(t)
+	defer conn.Close()
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := conn.Read(make([]byte, 1024))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatalf("Server did not close the connection")
+	}
+
+	for err == nil {
+		_, err = conn.Read(make([]byte, 1024))
+	}
+
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("Server closed the connection after %s", time.Since(start))
+	}
+}
+
+// Test SSNTP server concurrent handshakes limit
+//
+// Test that an SSNTP server closes connections from a remote address
+// that already has too many handshakes running, and accepts new ones
+// once those handshakes are done.
+//
+// Test is expected to pass.
+func TestServerMaxHandshakesPerIP(t *testing.T) {
+	if *transport != "tcp" {
+		t.Skip("Handshakes limit test requires the tcp transport")
+	}
+
+	server := startLimitedServer(t, func(config *Config) {
+		config.MaxHandshakesPerIP = 1
+	})
+	defer server.ssntp.Stop()
+
+	service := net.JoinHostPort(defaultURL, fmt.Sprintf("%d", port))
+
+	// A client starting a TCP connection and never going
+	// through the TLS and SSNTP handshakes.
+	stalled, err := net.Dial(*transport, service)
+	if err != nil {
+		t.Fatalf("Could not dial server: %s", err)
+	}
+
+	// Give the server some time to start handling it.
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial(*transport, service)
+	if err != nil {
+		t.Fatalf("Could not dial server: %s", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatalf("Server did not close the connection: %v", err)
+	}
+
+	stalled.Close()
+
+	client := dialReconnectClient(t, 0)
+	client.ssntp.Close()
+}
+
+func newTestLanes(bulkQueueSize int) *outboundLanes {
+	return &outboundLanes{
+		bulkQueueSize: bulkQueueSize,
+		wake:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+	}
+}
+
+func pushFrame(t *testing.T, lanes *outboundLanes, frame *Frame) int {
+	dropped, err := lanes.push(&outboundRequest{ctx: context.Background(), frame: frame}, frameLane(frame))
+	if err != nil {
+		t.Fatalf("Could not queue frame: %s", err)
+	}
+
+	return dropped
+}
+
+// Test SSNTP outbound lanes priorities
+//
+// Test that queued control frames are sent before statuses and
+// events, and that STATS and TraceReport frames are sent last.
+//
+// Test is expected to pass.
+func TestLanesPriority(t *testing.T) {
+	agentUUID := uuid.Generate()
+	agent := newSession(&agentUUID, AGENT, SCHEDULER, nil)
+	lanes := newTestLanes(defaultBulkQueueSize)
+
+	frames := []*Frame{
+		agent.commandFrame(STATS, nil, nil),
+		agent.eventFrame(TraceReport, nil, nil),
+		agent.statusFrame(READY, nil, nil),
+		agent.eventFrame(InstanceDeleted, nil, nil),
+		agent.commandFrame(START, nil, nil),
+		agent.errorFrame(StartFailure, nil, nil),
+		agent.statusFrame(PONG, nil, nil),
+	}
+
+	for _, f := range frames {
+		pushFrame(t, lanes, f)
+	}
+
+	expected := []*Frame{frames[4], frames[5], frames[6], frames[2], frames[3], frames[0], frames[1]}
+	for _, e := range expected {
+		r := lanes.pop()
+		if r == nil || r.frame != e {
+			t.Fatalf("Expected %s %s frame", e.Type, operandString(e.Type, e.Operand))
+		}
+	}
+
+	if lanes.pop() != nil {
+		t.Fatalf("Lanes are not empty")
+	}
+}
+
+// Test SSNTP outbound lanes bulk frames dropping
+//
+// Test that a queued STATS frame is replaced by a newer one from
+// the same origin, and that the oldest bulk frame is dropped when
+// the bulk lane is full.
+//
+// Test is expected to pass.
+func TestLanesBulkDrop(t *testing.T) {
+	agent1UUID := uuid.Generate()
+	agent2UUID := uuid.Generate()
+	agent1 := newSession(&agent1UUID, AGENT, SCHEDULER, nil)
+	agent2 := newSession(&agent2UUID, AGENT, SCHEDULER, nil)
+	lanes := newTestLanes(2)
+
+	stale := agent1.commandFrame(STATS, []byte("stale"), nil)
+	other := agent2.commandFrame(STATS, []byte("other"), nil)
+	fresh := agent1.commandFrame(STATS, []byte("fresh"), nil)
+	trace := agent1.eventFrame(TraceReport, nil, nil)
+
+	if pushFrame(t, lanes, stale) != 0 || pushFrame(t, lanes, other) != 0 {
+		t.Fatalf("Unexpected dropped frame")
+	}
+
+	if pushFrame(t, lanes, fresh) != 1 {
+		t.Fatalf("Stale STATS frame was not replaced")
+	}
+
+	if pushFrame(t, lanes, trace) != 1 {
+		t.Fatalf("Oldest bulk frame was not dropped")
+	}
+
+	for _, e := range []*Frame{other, trace} {
+		r := lanes.pop()
+		if r == nil || r.frame != e {
+			t.Fatalf("Unexpected queued frame")
+		}
+	}
+
+	lanes.close()
+	_, err := lanes.push(&outboundRequest{ctx: context.Background(), frame: fresh}, bulkLane)
+	if err != errSessionClosed {
+		t.Fatalf("Unexpected error on closed lanes %v", err)
+	}
+}
+
+func testFrameTrace(start time.Time) payloads.FrameTrace {
+	at := func(ms int) string {
+		return start.Add(time.Duration(ms) * time.Millisecond).Format(time.RFC3339Nano)
+	}
+
+	return payloads.FrameTrace{
+		Label:          "batch",
+		Type:           "COMMAND",
+		Operand:        "START",
+		StartTimestamp: at(0),
+		EndTimestamp:   at(100),
+		Nodes: []payloads.SSNTPNode{
+			{SSNTPUUID: controllerUUID, SSNTPRole: "Controller", TxTimestamp: at(10)},
+			{SSNTPUUID: "8f1d8d5a-3c3e-4d8b-9b0f-5d4a2b1c0e9f", SSNTPRole: "Scheduler", RxTimestamp: at(20), TxTimestamp: at(30)},
+			{SSNTPUUID: "6a1f3c2e-8d4b-4f7a-9c5e-2b7d1e0f3a94", SSNTPRole: "CNAgent", RxTimestamp: at(60)},
+		},
+	}
+}
+
+// Test SSNTP frame trace spans
+//
+// Test that an SSNTP frame trace is converted into a root span
+// covering the whole frame path and one child span per hop.
+//
+// Test is expected to pass.
+func TestTraceSpans(t *testing.T) {
+	start := time.Date(2016, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	spans, err := TraceSpans(testFrameTrace(start))
+	if err != nil {
+		t.Fatalf("Could not convert trace %s", err)
+	}
+
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+
+	root := spans[0]
+	if root.ParentID != "" || root.Name != "COMMAND START" || root.Service != "Controller" ||
+		root.End.Sub(root.Start) != 100*time.Millisecond || root.Tags["ssntp.label"] != "batch" {
+		t.Fatalf("Wrong root span %+v", root)
+	}
+
+	hops := []struct {
+		service  string
+		remote   string
+		duration time.Duration
+	}{
+		{"Controller", "Scheduler", 10 * time.Millisecond},
+		{"Scheduler", "CNAgent", 30 * time.Millisecond},
+	}
+
+	for i, hop := range hops {
+		s := spans[i+1]
+		if s.TraceID != root.TraceID || s.ParentID != root.ID || s.ID == root.ID {
+			t.Fatalf("Span %d is not a child of the root span", i+1)
+		}
+
+		if s.Service != hop.service || s.RemoteService != hop.remote || s.End.Sub(s.Start) != hop.duration {
+			t.Fatalf("Wrong hop span %+v", s)
+		}
+	}
+
+	_, err = TraceSpans(payloads.FrameTrace{})
+	if err == nil {
+		t.Fatalf("Converted a trace without nodes")
+	}
+}
+
+// Test SSNTP spans export to a Zipkin collector
+//
+// Test that a span exporter POSTs Zipkin v2 JSON spans to an
+// HTTP collector.
+//
+// Test is expected to pass.
+func TestSpanExporterZipkin(t *testing.T) {
+	start := time.Date(2016, 9, 1, 12, 0, 0, 0, time.UTC)
+	received := make(chan []zipkinSpan, 1)
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var spans []zipkinSpan
+
+		err := json.NewDecoder(r.Body).Decode(&spans)
+		if err != nil || r.Method != "POST" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		received <- spans
+	}))
+	defer collector.Close()
+
+	exporter, err := NewSpanExporter(ZipkinFormat, collector.URL+"/api/v2/spans")
+	if err != nil {
+		t.Fatalf("Could not create exporter %s", err)
+	}
+	defer exporter.Close()
+
+	err = exporter.Export(payloads.Trace{Frames: []payloads.FrameTrace{testFrameTrace(start)}})
+	if err != nil {
+		t.Fatalf("Could not export spans %s", err)
+	}
+
+	spans := <-received
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+
+	if spans[0].Timestamp != start.UnixNano()/1000 || spans[0].Duration != 100000 {
+		t.Fatalf("Wrong root span timing %+v", spans[0])
+	}
+
+	hop := spans[2]
+	if hop.Kind != "PRODUCER" || hop.LocalEndpoint.ServiceName != "Scheduler" ||
+		hop.RemoteEndpoint == nil || hop.RemoteEndpoint.ServiceName != "CNAgent" || hop.ParentID != spans[0].ID {
+		t.Fatalf("Wrong hop span %+v", hop)
+	}
+}
+
+// Test SSNTP spans export to an OTLP file
+//
+// Test that a span exporter appends OTLP/JSON traces to a file,
+// grouping spans by service.
+//
+// Test is expected to pass.
+func TestSpanExporterOTLP(t *testing.T) {
+	var traces otlpTraces
+	start := time.Date(2016, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	f, err := ioutil.TempFile("", "ssntp-spans")
+	if err != nil {
+		t.Fatalf("Could not create span file %s", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	exporter, err := NewSpanExporter(OTLPFormat, f.Name())
+	if err != nil {
+		t.Fatalf("Could not create exporter %s", err)
+	}
+
+	err = exporter.Export(payloads.Trace{Frames: []payloads.FrameTrace{testFrameTrace(start)}})
+	if err != nil {
+		t.Fatalf("Could not export spans %s", err)
+	}
+	exporter.Close()
+
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("Could not read span file %s", err)
+	}
+
+	err = json.Unmarshal(data, &traces)
+	if err != nil {
+		t.Fatalf("Invalid OTLP JSON %s", err)
+	}
+
+	services := []string{"Controller", "Scheduler"}
+	if len(traces.ResourceSpans) != len(services) {
+		t.Fatalf("Expected %d resources, got %d", len(services), len(traces.ResourceSpans))
+	}
+
+	for i, service := range services {
+		rs := traces.ResourceSpans[i]
+		if rs.Resource.Attributes[0].Key != "service.name" || rs.Resource.Attributes[0].Value.StringValue != service {
+			t.Fatalf("Wrong resource %+v", rs.Resource)
+		}
+	}
+
+	controllerSpans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(controllerSpans) != 2 || controllerSpans[0].StartTimeUnixNano != strconv.FormatInt(start.UnixNano(), 10) ||
+		controllerSpans[1].Kind != otlpKindProducer {
+		t.Fatalf("Wrong controller spans %+v", controllerSpans)
+	}
+}
+
+// Test SSNTP file UUID provider
+//
+// Test that the file UUID provider keeps providing the same UUID
+// for a given role, and a random one while that UUID is in use.
+//
+// Test is expected to pass.
+func TestFileUUIDProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssntp-uuid")
+	if err != nil {
+		t.Fatalf("Could not create UUID directory %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	provider := NewFileUUIDProvider(path.Join(dir, "storage"), path.Join(dir, "lock"))
+
+	stored, err := provider.UUID(AGENT)
+	if err != nil {
+		t.Fatalf("Could not get UUID %s", err)
+	}
+
+	random, err := provider.UUID(AGENT)
+	if err != nil || random == stored {
+		t.Fatalf("Got locked UUID %s (%v)", random, err)
+	}
+
+	other, err := provider.UUID(NETAGENT)
+	if err != nil || other == stored {
+		t.Fatalf("Got the same UUID for 2 roles (%v)", err)
+	}
+
+	provider.Release(stored)
+
+	again, err := provider.UUID(AGENT)
+	if err != nil || again != stored {
+		t.Fatalf("Got %s instead of %s (%v)", again, stored, err)
+	}
+	provider.Release(again)
+}
+
+// Test SSNTP certificate and machine ID UUID providers
+//
+// Test that UUIDs derived from certificates and machine IDs are
+// stable and depend on the role and on the certificate or machine ID.
+//
+// Test is expected to pass.
+func TestDerivedUUIDProviders(t *testing.T) {
+	agentConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	otherCert := writeTestFile(t, selfSignedServerCert(t))
+	defer os.Remove(otherCert)
+
+	machineID := writeTestFile(t, []byte("4b2ce2a3a5c64e0a9bd8e5d9c5c1c5a7\n"))
+	defer os.Remove(machineID)
+
+	otherMachineID := writeTestFile(t, []byte("0d5f3b8e2bde4c52a1f3a4a7b60c1d9e\n"))
+	defer os.Remove(otherMachineID)
+
+	tests := []struct {
+		provider UUIDProvider
+		other    UUIDProvider
+	}{
+		{NewCertificateUUIDProvider(agentConfig.Cert), NewCertificateUUIDProvider(otherCert)},
+		{NewMachineIDUUIDProvider(machineID), NewMachineIDUUIDProvider(otherMachineID)},
+	}
+
+	for _, test := range tests {
+		u1, err := test.provider.UUID(AGENT)
+		if err != nil {
+			t.Fatalf("Could not get UUID %s", err)
+		}
+
+		u2, _ := test.provider.UUID(AGENT)
+		role, _ := test.provider.UUID(NETAGENT)
+		other, _ := test.other.UUID(AGENT)
+
+		if u1 != u2 || u1 == role || u1 == other {
+			t.Fatalf("Wrong derived UUIDs %s %s %s %s", u1, u2, role, other)
+		}
+	}
+
+	_, err = NewMachineIDUUIDProvider(path.Join(tempCertPath, "no-machine-id")).UUID(AGENT)
+	if err == nil {
+		t.Fatalf("Got a UUID without machine ID")
+	}
+}
+
+// Test SSNTP UUID provider configuration
+//
+// Test that an SSNTP client uses the UUID from its configured
+// UUID provider, unless its configuration sets an explicit UUID.
+//
+// Test is expected to pass.
+func TestConfigUUIDProvider(t *testing.T) {
+	providerUUID := "d1c9a9e4-3b9f-4d3c-8f36-0f1e7a5b2c44"
+
+	server := startEchoServer(t)
+	defer server.ssntp.Stop()
+
+	for _, explicit := range []string{"", controllerUUID} {
+		client := newSSNTPReconnectClient()
+
+		clientConfig, err := buildTestConfig(AGENT)
+		if err != nil {
+			t.Fatalf("Could not build a test config")
+		}
+		clientConfig.UUID = explicit
+		clientConfig.UUIDProvider = StaticUUIDProvider(providerUUID)
+
+		err = client.ssntp.Dial(clientConfig, client)
+		if err != nil {
+			t.Fatalf("Failed to connect %s", err)
+		}
+
+		expected := explicit
+		if expected == "" {
+			expected = providerUUID
+		}
+
+		if client.ssntp.UUID() != expected {
+			t.Fatalf("Client UUID %s, expected %s", client.ssntp.UUID(), expected)
+		}
+
+		client.ssntp.Close()
+	}
+}
+
+func readJSONLog(t *testing.T, buf *captureBuffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+
+	buf.Lock()
+	defer buf.Unlock()
+
+	decoder := json.NewDecoder(bytes.NewReader(buf.buf.Bytes()))
+	for decoder.More() {
+		entry := make(map[string]interface{})
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("Invalid JSON log entry: %s", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// Test SSNTP JSON logger
+//
+// Test that the JSON logger writes one JSON object per message,
+// with the message level, text and fields, and that it drops the
+// messages above its level.
+//
+// Test is expected to pass.
+func TestJSONLogger(t *testing.T) {
+	var buf captureBuffer
+
+	l := NewJSONLogger(&buf, InfoLevel)
+	l.Log(InfoLevel, "info message", Field{"key", "value"}, Field{"count", 3})
+	l.Log(DebugLevel, "debug message")
+	l.Errorf("error %d", 1)
+
+	entries := readJSONLog(t, &buf)
+	if len(entries) != 2 {
+		t.Fatalf("Wrong number of log entries %d", len(entries))
+	}
+
+	if entries[0]["level"] != "info" || entries[0]["msg"] != "info message" ||
+		entries[0]["key"] != "value" || entries[0]["count"] != float64(3) {
+		t.Fatalf("Wrong info log entry %v", entries[0])
+	}
+
+	if entries[1]["level"] != "error" || entries[1]["msg"] != "error 1" {
+		t.Fatalf("Wrong error log entry %v", entries[1])
+	}
+
+	if _, ok := entries[0]["time"]; !ok {
+		t.Fatalf("Missing log entry time")
+	}
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "error: "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warningf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "warning: "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.messages = append(l.messages, "info: "+fmt.Sprintf(format, args...))
+}
+
+// Test SSNTP unstructured loggers
+//
+// Test that messages logged through a Logger that does not implement
+// StructuredLogger get their fields appended as key=value pairs, and
+// that debug messages are not logged.
+//
+// Test is expected to pass.
+func TestLegacyLogger(t *testing.T) {
+	var rec recordingLogger
+
+	l := newLogger(&rec).with(Field{UUIDField, "1234"}, Field{RoleField, "AGENT"})
+	l.Warningf("Could not %s\n", "send")
+	l.Debugf("Sent frame")
+
+	if len(rec.messages) != 1 {
+		t.Fatalf("Wrong number of log messages %d", len(rec.messages))
+	}
+
+	if rec.messages[0] != "warning: Could not send uuid=1234 role=AGENT" {
+		t.Fatalf("Wrong log message %q", rec.messages[0])
+	}
+}
+
+// Test SSNTP frame logging
+//
+// Test that an SSNTP server logging at debug level logs the frames it
+// receives with its UUID and role, the peer UUID and role, and the
+// frame type, operand and trace label.
+//
+// Test is expected to pass.
+func TestFrameLogging(t *testing.T) {
+	var server ssntpEchoServer
+	var buf captureBuffer
+	label := "logged"
+
+	server.t = t
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.Log = NewJSONLogger(&buf, DebugLevel)
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	client := dialReconnectClient(t, 0)
+
+	_, err = client.ssntp.SendTracedStatus(READY, nil, &TraceConfig{Label: []byte(label)})
+	if err != nil {
+		t.Fatalf("Could not send status: %s", err)
+	}
+
+	select {
+	case <-client.staChannel:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive echoed status")
+	}
+
+	client.ssntp.Close()
+	server.ssntp.Stop()
+
+	serverRole := Role(SERVER)
+	clientRole := Role(AGENT)
+	for _, e := range readJSONLog(t, &buf) {
+		if e["msg"] != "Received frame" || e[FrameTypeField] != STATUS.String() {
+			continue
+		}
+
+		if e[UUIDField] != server.ssntp.UUID() || e[RoleField] != serverRole.String() ||
+			e[PeerUUIDField] != client.ssntp.UUID() || e[PeerRoleField] != clientRole.String() ||
+			e[OperandField] != READY.String() || e[TraceLabelField] != label {
+			t.Fatalf("Wrong frame log entry %v", e)
+		}
+
+		return
+	}
+
+	t.Fatalf("Received frame not logged")
+}
+
+func echoCompressed(t *testing.T, codecs []Codec, capabilities []Capability, payload []byte) uint64 {
+	var server ssntpEchoServer
+
+	server.t = t
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.Codecs = codecs
+	serverConfig.CompressionThreshold = 1024
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.Codecs = codecs
+	clientConfig.Capabilities = capabilities
+	clientConfig.CompressionThreshold = 1024
+
+	client := newSSNTPReconnectClient()
+	err = client.ssntp.Dial(clientConfig, client)
+	if err != nil {
+		t.Fatalf("Failed to connect %s", err)
+	}
+	defer client.ssntp.Close()
+	waitForNotification(t, client.connected, "connect")
+
+	_, err = client.ssntp.SendStatus(READY, payload)
+	if err != nil {
+		t.Fatalf("Could not send status: %s", err)
+	}
+
+	select {
+	case echoed := <-client.staChannel:
+		if !bytes.Equal(echoed, payload) {
+			t.Fatalf("Wrong echoed payload length %d", len(echoed))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive echoed status")
+	}
+
+	metrics := client.ssntp.session.metrics
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	return metrics.bytesSent
+}
+
+// Test SSNTP payload compression
+//
+// Test that SSNTP clients and servers negotiating compression,
+// with both the gob and binary codecs, send large payloads
+// compressed and that notifiers get them uncompressed.
+//
+// Test is expected to pass.
+func TestCompression(t *testing.T) {
+	payload := bytes.Repeat([]byte("- instance_uuid: 3390740c-dce9-48d6-b83a-a717417072ce\n"), 1024)
+
+	for _, codecs := range [][]Codec{nil, {BinaryCodec}} {
+		sent := echoCompressed(t, codecs, nil, payload)
+		if sent >= uint64(len(payload)) {
+			t.Fatalf("Payload not compressed, %d bytes sent", sent)
+		}
+	}
+}
+
+// Test SSNTP payload compression negotiation
+//
+// Test that SSNTP servers do not compress payloads sent to
+// clients that did not negotiate compression.
+//
+// Test is expected to pass.
+func TestCompressionNotNegotiated(t *testing.T) {
+	payload := bytes.Repeat([]byte("- instance_uuid: 3390740c-dce9-48d6-b83a-a717417072ce\n"), 1024)
+
+	sent := echoCompressed(t, nil, []Capability{CorrelationCapability}, payload)
+	if sent < uint64(len(payload)) {
+		t.Fatalf("Payload compressed without negotiation, %d bytes sent", sent)
+	}
+}
+
+// Test SSNTP compressed payload limit
+//
+// Test that compressed payloads inflating to more than the maximum
+// payload size are rejected as too large.
+//
+// Test is expected to pass.
+func TestCompressedPayloadTooLarge(t *testing.T) {
+	payload := bytes.Repeat([]byte{'a'}, 64*1024)
+
+	compressed, err := compressPayload(payload)
+	if err != nil {
+		t.Fatalf("Could not compress payload: %s", err)
+	}
+
+	_, err = decompressPayload(compressed, 1024)
+	if err != errFrameTooLarge {
+		t.Fatalf("Oversized payload not rejected: %v", err)
+	}
+
+	data, err := decompressPayload(compressed, len(payload))
+	if err != nil || !bytes.Equal(data, payload) {
+		t.Fatalf("Could not decompress payload: %v", err)
+	}
+}
+
 func roleToCert(role Role) string {
 	switch role {
 	case SCHEDULER:
@@ -2494,7 +5526,7 @@ func TestMain(m *testing.M) {
 	/* Create temp certs directory if necessary */
 	err := os.MkdirAll(tempCertPath, 0755)
 	if err != nil {
-		fmt.Printf("Unable to create %s %v\n", uuidPrefix, err)
+		fmt.Printf("Unable to create %s %v\n", tempCertPath, err)
 		os.Exit(1)
 	}
 
@@ -0,0 +1,278 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/docker/distribution/uuid"
+)
+
+// UUIDProvider provides SSNTP clients and servers with their UUID.
+type UUIDProvider interface {
+	// UUID returns the UUID string of an SSNTP client or server
+	// playing role.
+	UUID(role Role) (string, error)
+
+	// Release is called when the SSNTP client or server using
+	// a UUID string returned by UUID is closed.
+	Release(uuid string)
+}
+
+const defaultUUIDDir = "/var/lib/ciao/local/uuid-storage/role/client"
+const defaultUUIDLockDir = "/tmp/lock/ciao"
+const defaultMachineID = "/etc/machine-id"
+
+// ssntpNamespace is the namespace of the name based UUIDs derived
+// from certificates and machine IDs.
+var ssntpNamespace = uuid.UUID{0xce, 0x6f, 0x6e, 0x0a, 0x87, 0x83, 0x42, 0x83, 0x92, 0xbe, 0x63, 0x64, 0x0b, 0x31, 0x01, 0x65}
+
+// nameUUID returns the version 5, SHA-1 name based, UUID for name.
+func nameUUID(name string) uuid.UUID {
+	var u uuid.UUID
+
+	sum := sha1.Sum(append(ssntpNamespace[:], name...))
+	copy(u[:], sum[:16])
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+
+	return u
+}
+
+type fileUUIDProvider struct {
+	sync.Mutex
+	dir     string
+	lockDir string
+	locks   map[string]int
+}
+
+// NewFileUUIDProvider returns a UUIDProvider storing one UUID per role
+// in dir. A UUID is locked through a lock file in lockDir while it is
+// being used, and SSNTP clients or servers of the same role then get
+// a random UUID.
+// If dir or lockDir are "", /var/lib/ciao/local/uuid-storage/role/client
+// and /tmp/lock/ciao are used respectively.
+// This is the default UUIDProvider.
+func NewFileUUIDProvider(dir, lockDir string) UUIDProvider {
+	if dir == "" {
+		dir = defaultUUIDDir
+	}
+
+	if lockDir == "" {
+		lockDir = defaultUUIDLockDir
+	}
+
+	return &fileUUIDProvider{
+		dir:     dir,
+		lockDir: lockDir,
+		locks:   make(map[string]int),
+	}
+}
+
+func (p *fileUUIDProvider) UUID(role Role) (string, error) {
+	uuidFile := fmt.Sprintf("%s/0x%x", p.dir, (uint32)(role))
+	uuidLockFile := fmt.Sprintf("%s/client-role-0x%x", p.lockDir, (uint32)(role))
+
+	/* Create UUID directory if necessary */
+	err := os.MkdirAll(p.dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	/* Create CIAO lock directory if necessary */
+	err = os.MkdirAll(p.lockDir, 0777)
+	if err != nil {
+		return "", err
+	}
+
+	fd, err := syscall.Open(uuidFile, syscall.O_CREAT|syscall.O_RDWR, syscall.S_IWUSR|syscall.S_IRUSR)
+	if err != nil {
+		return "", fmt.Errorf("Unable to open UUID file %s: %s", uuidFile, err)
+	}
+
+	defer func() { _ = syscall.Close(fd) }()
+
+	lockFd, err := syscall.Open(uuidLockFile, syscall.O_CREAT, syscall.S_IWUSR|syscall.S_IRUSR)
+	if err != nil {
+		return "", fmt.Errorf("Unable to open UUID lock file %s: %s", uuidLockFile, err)
+	}
+
+	if syscall.Flock(lockFd, syscall.LOCK_EX|syscall.LOCK_NB) != nil {
+		/* File is already locked, we need to generate a random UUID */
+		syscall.Close(lockFd)
+		return uuid.Generate().String(), nil
+	}
+
+	uuidArray := make([]byte, 36)
+	n, err := syscall.Read(fd, uuidArray)
+	if err != nil {
+		syscall.Close(lockFd)
+		return "", fmt.Errorf("Could not read %s: %s", uuidFile, err)
+	}
+
+	var fileUUID uuid.UUID
+	if n == 36 {
+		fileUUID, err = uuid.Parse(string(uuidArray))
+	}
+
+	if n != 36 || err != nil {
+		/* 2 cases: */
+		/* 1) File was just created or is empty: Write a new UUID */
+		/* Or */
+		/* 2) File contains garbage - Overwrite with a new UUID */
+		fileUUID = uuid.Generate()
+		_, err = syscall.Pwrite(fd, []byte(fileUUID.String()), 0)
+		if err != nil {
+			syscall.Close(lockFd)
+			return "", fmt.Errorf("Could not write %s on %s: %s", fileUUID, uuidFile, err)
+		}
+	}
+
+	p.Lock()
+	p.locks[fileUUID.String()] = lockFd
+	p.Unlock()
+
+	return fileUUID.String(), nil
+}
+
+func (p *fileUUIDProvider) Release(uuid string) {
+	p.Lock()
+	defer p.Unlock()
+
+	lockFd, ok := p.locks[uuid]
+	if !ok {
+		return
+	}
+
+	delete(p.locks, uuid)
+	syscall.Flock(lockFd, syscall.LOCK_UN)
+	syscall.Close(lockFd)
+}
+
+type staticUUIDProvider string
+
+// StaticUUIDProvider returns a UUIDProvider always providing uuid.
+func StaticUUIDProvider(uuid string) UUIDProvider {
+	return staticUUIDProvider(uuid)
+}
+
+func (p staticUUIDProvider) UUID(role Role) (string, error) {
+	_, err := uuid.Parse(string(p))
+	if err != nil {
+		return "", fmt.Errorf("Invalid UUID %s: %s", string(p), err)
+	}
+
+	return string(p), nil
+}
+
+func (p staticUUIDProvider) Release(uuid string) {
+}
+
+type certificateUUIDProvider struct {
+	certPath string
+}
+
+// NewCertificateUUIDProvider returns a UUIDProvider deriving UUIDs from
+// the certificate stored at certPath, usually the same as Config.Cert.
+// If the certificate subject common name or one of its DNS subject
+// alternative names is a UUID, that UUID is provided. Otherwise the
+// provided UUID is a name based UUID built from the certificate subject,
+// subject alternative names and the SSNTP role.
+func NewCertificateUUIDProvider(certPath string) UUIDProvider {
+	return certificateUUIDProvider{certPath}
+}
+
+func (p certificateUUIDProvider) UUID(role Role) (string, error) {
+	certPEM, err := ioutil.ReadFile(p.certPath)
+	if err != nil {
+		return "", err
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return "", fmt.Errorf("Could not decode PEM for %s", p.certPath)
+	}
+
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range append([]string{cert.Subject.CommonName}, cert.DNSNames...) {
+		u, err := uuid.Parse(name)
+		if err == nil {
+			return u.String(), nil
+		}
+	}
+
+	names := []string{
+		cert.Subject.CommonName,
+		strings.Join(cert.Subject.Organization, ","),
+		strings.Join(cert.DNSNames, ","),
+		strings.Join(cert.EmailAddresses, ","),
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, fmt.Sprintf("0x%x", (uint32)(role)))
+
+	return nameUUID(strings.Join(names, "/")).String(), nil
+}
+
+func (p certificateUUIDProvider) Release(uuid string) {
+}
+
+type machineIDUUIDProvider struct {
+	path string
+}
+
+// NewMachineIDUUIDProvider returns a UUIDProvider deriving UUIDs from
+// the machine ID stored at path, and from the SSNTP role. The machine
+// ID itself is never used as a UUID.
+// If path is "", /etc/machine-id is used.
+func NewMachineIDUUIDProvider(path string) UUIDProvider {
+	if path == "" {
+		path = defaultMachineID
+	}
+
+	return machineIDUUIDProvider{path}
+}
+
+func (p machineIDUUIDProvider) UUID(role Role) (string, error) {
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return "", err
+	}
+
+	machineID := strings.TrimSpace(string(data))
+	if machineID == "" {
+		return "", fmt.Errorf("Empty machine ID in %s", p.path)
+	}
+
+	return nameUUID(fmt.Sprintf("%s/0x%x", machineID, (uint32)(role))).String(), nil
+}
+
+func (p machineIDUUIDProvider) Release(uuid string) {
+}
@@ -40,6 +40,8 @@ type SsntpTestClient struct {
 	StopFailReason    payloads.StopFailureReason
 	RestartFail       bool
 	RestartFailReason payloads.RestartFailureReason
+	ActionFail        bool
+	ActionFailReason  payloads.InstanceActionFailureReason
 	traces            []*ssntp.Frame
 
 	CmdChans     map[ssntp.Command]chan CmdResult
@@ -151,6 +153,43 @@ func (client *SsntpTestClient) handleRestart(payload []byte) CmdResult {
 	return result
 }
 
+// instanceActionStates maps each instance action command to the state
+// of the instance once the action has completed.
+var instanceActionStates = map[ssntp.Command]string{
+	ssntp.REBOOT:  payloads.Running,
+	ssntp.PAUSE:   payloads.Paused,
+	ssntp.UNPAUSE: payloads.Running,
+	ssntp.SUSPEND: payloads.Suspended,
+	ssntp.RESUME:  payloads.Running,
+	ssntp.REBUILD: payloads.Running,
+	ssntp.RESIZE:  payloads.Running,
+}
+
+func (client *SsntpTestClient) handleInstanceAction(command ssntp.Command, payload []byte) CmdResult {
+	var result CmdResult
+
+	instanceUUID, _, err := instanceActionUUIDs(command, payload)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.InstanceUUID = instanceUUID
+
+	if !client.ActionFail {
+		for i := range client.instances {
+			istat := client.instances[i]
+			if istat.InstanceUUID == instanceUUID {
+				client.instances[i].State = instanceActionStates[command]
+			}
+		}
+	} else {
+		client.sendInstanceActionFailure(instanceUUID, command, client.ActionFailReason)
+	}
+
+	return result
+}
+
 // CommandNotify implements the SSNTP client CommandNotify callback for SsntpTestClient
 func (client *SsntpTestClient) CommandNotify(command ssntp.Command, frame *ssntp.Frame) {
 	payload := frame.Payload
@@ -175,6 +214,10 @@ func (client *SsntpTestClient) CommandNotify(command ssntp.Command, frame *ssntp
 
 	case ssntp.RESTART:
 		result = client.handleRestart(payload)
+
+	case ssntp.REBOOT, ssntp.PAUSE, ssntp.UNPAUSE, ssntp.SUSPEND,
+		ssntp.RESUME, ssntp.REBUILD, ssntp.RESIZE:
+		result = client.handleInstanceAction(command, payload)
 	}
 
 	if ok {
@@ -345,3 +388,21 @@ func (client *SsntpTestClient) sendRestartFailure(instanceUUID string, reason pa
 		fmt.Println(err)
 	}
 }
+
+func (client *SsntpTestClient) sendInstanceActionFailure(instanceUUID string, command ssntp.Command, reason payloads.InstanceActionFailureReason) {
+	e := payloads.ErrorInstanceActionFailure{
+		InstanceUUID: instanceUUID,
+		Action:       command.String(),
+		Reason:       reason,
+	}
+
+	y, err := yaml.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	_, err = client.Ssntp.SendError(ssntp.InstanceActionFailure, y)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -17,6 +17,7 @@
 package testutil
 
 import (
+	"fmt"
 	"math/rand"
 	"sync"
 
@@ -140,6 +141,17 @@ func (server *SsntpTestServer) CommandNotify(uuid string, command ssntp.Command,
 			server.Ssntp.SendCommand(restartCmd.Restart.WorkloadAgentUUID, command, frame.Payload)
 		}
 
+	case ssntp.REBOOT, ssntp.PAUSE, ssntp.UNPAUSE, ssntp.SUSPEND,
+		ssntp.RESUME, ssntp.REBUILD, ssntp.RESIZE:
+		instanceUUID, agentUUID, err := instanceActionUUIDs(command, payload)
+
+		result.Err = err
+
+		if err == nil {
+			result.InstanceUUID = instanceUUID
+			server.Ssntp.SendCommand(agentUUID, command, frame.Payload)
+		}
+
 	case ssntp.EVACUATE:
 		var evacCmd payloads.Evacuate
 
@@ -207,3 +219,45 @@ func (server *SsntpTestServer) CommandForward(uuid string, command ssntp.Command
 
 	return
 }
+
+// instanceActionUUIDs returns the instance and agent UUIDs carried by the
+// payload of one of the instance action commands, e.g., PAUSE.
+func instanceActionUUIDs(command ssntp.Command, payload []byte) (string, string, error) {
+	var cmd payloads.StopCmd
+	var err error
+
+	switch command {
+	case ssntp.REBOOT:
+		var reboot payloads.Reboot
+		err = yaml.Unmarshal(payload, &reboot)
+		return reboot.Reboot.InstanceUUID, reboot.Reboot.WorkloadAgentUUID, err
+	case ssntp.PAUSE:
+		var pause payloads.Pause
+		err = yaml.Unmarshal(payload, &pause)
+		cmd = pause.Pause
+	case ssntp.UNPAUSE:
+		var unpause payloads.Unpause
+		err = yaml.Unmarshal(payload, &unpause)
+		cmd = unpause.Unpause
+	case ssntp.SUSPEND:
+		var suspend payloads.Suspend
+		err = yaml.Unmarshal(payload, &suspend)
+		cmd = suspend.Suspend
+	case ssntp.RESUME:
+		var resume payloads.Resume
+		err = yaml.Unmarshal(payload, &resume)
+		cmd = resume.Resume
+	case ssntp.REBUILD:
+		var rebuild payloads.Rebuild
+		err = yaml.Unmarshal(payload, &rebuild)
+		return rebuild.Rebuild.InstanceUUID, rebuild.Rebuild.WorkloadAgentUUID, err
+	case ssntp.RESIZE:
+		var resize payloads.Resize
+		err = yaml.Unmarshal(payload, &resize)
+		return resize.Resize.InstanceUUID, resize.Resize.WorkloadAgentUUID, err
+	default:
+		return "", "", fmt.Errorf("unsupported command %s", command)
+	}
+
+	return cmd.InstanceUUID, cmd.WorkloadAgentUUID, err
+}
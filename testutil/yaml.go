@@ -125,6 +125,39 @@ var DeleteYaml = `delete:
   workload_agent_uuid: 59460b8a-5f53-4e3e-b5ce-b71fed8c7e64
 `
 
+// RebootYaml is a sample workload Reboot command payload for test cases
+var RebootYaml = `reboot:
+  instance_uuid: 3390740c-dce9-48d6-b83a-a717417072ce
+  workload_agent_uuid: 59460b8a-5f53-4e3e-b5ce-b71fed8c7e64
+  type: hard
+`
+
+// PauseYaml is a sample workload Pause command payload for test cases
+var PauseYaml = `pause:
+  instance_uuid: 3390740c-dce9-48d6-b83a-a717417072ce
+  workload_agent_uuid: 59460b8a-5f53-4e3e-b5ce-b71fed8c7e64
+`
+
+// RebuildYaml is a sample workload Rebuild command payload for test cases
+var RebuildYaml = `rebuild:
+  instance_uuid: 3390740c-dce9-48d6-b83a-a717417072ce
+  workload_agent_uuid: 59460b8a-5f53-4e3e-b5ce-b71fed8c7e64
+  image_uuid: b265f62b-e957-47fd-a0a2-6dc261c7315c
+`
+
+// ResizeYaml is a sample workload Resize command payload for test cases
+var ResizeYaml = `resize:
+  instance_uuid: 3390740c-dce9-48d6-b83a-a717417072ce
+  workload_agent_uuid: 59460b8a-5f53-4e3e-b5ce-b71fed8c7e64
+  requested_resources:
+  - type: vcpus
+    value: 4
+    mandatory: true
+  - type: mem_mb
+    value: 8192
+    mandatory: true
+`
+
 // EvacuateYaml is a sample node Evacuate command payload for test cases
 var EvacuateYaml = `evacuate:
   workload_agent_uuid: 64803ffa-fb47-49fa-8191-15d2c34e4dd3